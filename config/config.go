@@ -0,0 +1,167 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StorageMemory   = "memory"
+	StorageSQLite   = "sqlite"
+	StoragePostgres = "postgres"
+)
+
+type Config struct {
+	StorageDriver string
+	SQLitePath    string
+	DatabaseURL   string
+
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+	DBQueryTimeout    time.Duration
+	DBAutoMigrate     bool
+
+	WALDir              string
+	WALSnapshotInterval time.Duration
+
+	// Soft-deleted todos and items are purged PurgeRetention after their
+	// deletion. A zero retention turns the background janitor off.
+	PurgeRetention time.Duration
+	PurgeInterval  time.Duration
+
+	// Occurrences of recurring todos and items are created once they start
+	// within RecurrenceHorizon. A zero horizon turns the scheduler off; the
+	// next occurrence is then only created when the last one is completed
+	// or skipped.
+	RecurrenceHorizon  time.Duration
+	RecurrenceInterval time.Duration
+
+	// UserDeletePolicy is what happens to a deleted user's todos unless the
+	// request says otherwise: cascade, block or reassign.
+	UserDeletePolicy string
+
+	// MaxItemDepth is how many levels of sub-items a todo can have, counting
+	// the top level. Zero or less means no limit.
+	MaxItemDepth int
+
+	// Attachments are stored below AttachmentDir. Uploads can be at most
+	// MaxAttachmentSize bytes and must be of one of AttachmentTypes, as
+	// sniffed from their content.
+	AttachmentDir     string
+	MaxAttachmentSize int64
+	AttachmentTypes   []string
+
+	// SearchIndex turns on the in-memory index behind /api/search. It only
+	// sees the writes made through this process, so it is off by default
+	// with Postgres, which several instances can share. Turn it on there
+	// only when a single instance uses the database.
+	SearchIndex bool
+}
+
+// Load reads the application settings from the environment. The .env file is
+// loaded by the packages that need JWT_SECRET, so by the time main runs the
+// values from it are already visible here.
+func Load() Config {
+	driver := getEnv("STORAGE_DRIVER", StorageMemory)
+	return Config{
+		StorageDriver: driver,
+		SQLitePath:    getEnv("SQLITE_PATH", "todoapp.db"),
+		DatabaseURL:   getEnv("DATABASE_URL", ""),
+
+		DBMaxOpenConns:    getEnvInt("DB_MAX_OPEN_CONNS", 10),
+		DBMaxIdleConns:    getEnvInt("DB_MAX_IDLE_CONNS", 5),
+		DBConnMaxLifetime: getEnvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		DBQueryTimeout:    getEnvDuration("DB_QUERY_TIMEOUT", 5*time.Second),
+		DBAutoMigrate:     getEnvBool("DB_AUTO_MIGRATE", true),
+
+		WALDir:              getEnv("WAL_DIR", ""),
+		WALSnapshotInterval: getEnvDuration("WAL_SNAPSHOT_INTERVAL", 5*time.Minute),
+
+		PurgeRetention: getEnvDuration("PURGE_RETENTION", 30*24*time.Hour),
+		PurgeInterval:  getEnvDuration("PURGE_INTERVAL", time.Hour),
+
+		RecurrenceHorizon:  getEnvDuration("RECURRENCE_HORIZON", 7*24*time.Hour),
+		RecurrenceInterval: getEnvDuration("RECURRENCE_INTERVAL", time.Hour),
+
+		UserDeletePolicy: getEnv("USER_DELETE_POLICY", "cascade"),
+
+		MaxItemDepth: getEnvInt("MAX_ITEM_DEPTH", 5),
+
+		AttachmentDir:     getEnv("ATTACHMENT_DIR", "attachments"),
+		MaxAttachmentSize: int64(getEnvInt("MAX_ATTACHMENT_SIZE", 10<<20)),
+		AttachmentTypes: getEnvList("ATTACHMENT_TYPES", []string{
+			"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain",
+		}),
+
+		SearchIndex: getEnvBool("SEARCH_INDEX", driver != StoragePostgres),
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %t", key, value, fallback)
+		return fallback
+	}
+	return b
+}
+
+// getEnvList reads a comma-separated list, ignoring empty entries.
+func getEnvList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	if len(list) == 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, strings.Join(fallback, ","))
+		return fallback
+	}
+	return list
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
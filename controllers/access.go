@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"net/http"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currentWorkspace returns the workspace the request works in and whether
+// the caller is an admin of it, as set by middleware.WorkspaceMiddleware.
+func currentWorkspace(ctx *gin.Context) (id int, admin bool) {
+	workspaceID, _ := ctx.Get("workspace_id")
+	workspaceRole, _ := ctx.Get("workspace_role")
+	id, _ = workspaceID.(int)
+	return id, workspaceRole == entity.WorkspaceRoleAdmin
+}
+
+// todoPermission returns what the caller may do with todo: the owner of
+// record and admins of the workspace anything, collaborators what the todo
+// was shared with them as (see entity/sharing.go).
+func todoPermission(ctx *gin.Context, collaboratorModel entity.CollaboratorStore, todo *entity.Todo) entity.Permission {
+	userID, _ := ctx.Get("user_id")
+	id, _ := userID.(int)
+	_, admin := currentWorkspace(ctx)
+	return entity.TodoPermission(collaboratorModel, todo, id, admin)
+}
+
+// authorizeTodo reports whether the caller has at least permission need on
+// todo. If not it writes a 403 response, or a 404 if the todo belongs to
+// another workspace, whose todos don't exist as far as the caller is
+// concerned.
+func authorizeTodo(ctx *gin.Context, collaboratorModel entity.CollaboratorStore, todo *entity.Todo, need entity.Permission) bool {
+	if workspaceID, _ := currentWorkspace(ctx); todo.WorkspaceID != workspaceID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return false
+	}
+	if todoPermission(ctx, collaboratorModel, todo) < need {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newSharingRouters sets up todo 1 of user 1, shared with user 2 as viewer,
+// user 3 as editor and user 4 as owner, and an invitation for user 5 that
+// hasn't been accepted. Todo 2 of user 1 isn't shared. Each todo has one
+// item. Todo 3 of user 1 is in another workspace. The routers run requests
+// as the user of the same index, in the default workspace.
+func newSharingRouters(t *testing.T) []*gin.Engine {
+	t.Helper()
+	items := entity.NewTodoItemModel()
+	todos := entity.NewTodoModel(items)
+	tags := entity.NewTagModel()
+	comments := entity.NewCommentModel()
+	collaborators := entity.NewCollaboratorModel()
+	workspaces := entity.NewWorkspaceModel()
+	users := entity.NewUserModel(todos, tags, collaborators, workspaces)
+	unitOfWork := entity.NewUnitOfWork(users, todos, items, tags, comments, entity.NewAttachmentModel(), collaborators, workspaces)
+
+	for todoID := 1; todoID <= 2; todoID++ {
+		if err := todos.Create(&entity.Todo{Title: "todo", Description: "shared", UserID: 1, WorkspaceID: entity.DefaultWorkspaceID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := items.Create(&entity.TodoItem{Title: "item", Description: "item", TodoID: todoID, UserID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := todos.Create(&entity.Todo{Title: "todo", Description: "elsewhere", UserID: 1, WorkspaceID: entity.DefaultWorkspaceID + 1}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for userID, role := range map[int]entity.CollaboratorRole{2: entity.RoleViewer, 3: entity.RoleEditor, 4: entity.RoleOwner, 5: entity.RoleOwner} {
+		collaborator := &entity.Collaborator{TodoID: 1, UserID: userID, Role: role, InvitedBy: 1}
+		if userID != 5 {
+			collaborator.AcceptedAt = &now
+		}
+		if err := collaborators.Create(collaborator); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	todoController := NewTodoController(todos, users, comments, collaborators, unitOfWork)
+	itemController := NewTodoItemController(items, todos, users, collaborators, unitOfWork, 5)
+	routers := make([]*gin.Engine, 7)
+	for userID := 1; userID < len(routers); userID++ {
+		r := newTestRouter(userID, "user")
+		r.GET("/todos/:id", todoController.GetByID)
+		r.PUT("/todos/:id", todoController.Update)
+		r.DELETE("/todos/:id", todoController.Delete)
+		r.PUT("/items/:todo_id/:item_id", itemController.Update)
+		routers[userID] = r
+	}
+	return routers
+}
+
+func TestPermissionLevels(t *testing.T) {
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		// want is the status for users 1 to 6: the owner of record, the
+		// viewer, the editor, the owner, the invited user and a stranger.
+		want [6]int
+	}{
+		{"view", http.MethodGet, "/todos/1", "",
+			[6]int{200, 200, 200, 200, 403, 403}},
+		{"edit", http.MethodPut, "/todos/1", `{"title": "changed", "description": "shared"}`,
+			[6]int{200, 403, 200, 200, 403, 403}},
+		{"edit an item", http.MethodPut, "/items/1/1", `{"completed": true}`,
+			[6]int{200, 403, 200, 200, 403, 403}},
+		// Item 2 is on todo 2, which is not shared, so naming todo 1 in the
+		// URL doesn't make it editable.
+		{"edit an item of another todo", http.MethodPut, "/items/1/2", `{"completed": true}`,
+			[6]int{404, 403, 404, 404, 403, 403}},
+		{"delete", http.MethodDelete, "/todos/1", "",
+			[6]int{200, 403, 403, 200, 403, 403}},
+		{"todo in another workspace", http.MethodGet, "/todos/3", "",
+			[6]int{404, 404, 404, 404, 404, 404}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				// Each request gets a fresh fixture, so a delete doesn't
+				// change what the next user sees.
+				routers := newSharingRouters(t)
+				userID := i + 1
+				w := serve(routers[userID], tt.method, tt.path, tt.body)
+				if w.Code != want {
+					t.Errorf("user %d: status = %d, want %d: %s", userID, w.Code, want, w.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAgendaDays = 7
+	maxAgendaDays     = 62
+)
+
+type AgendaController struct {
+	userModel     entity.UserStore
+	todoModel     entity.TodoStore
+	todoItemModel entity.TodoItemStore
+}
+
+func NewAgendaController(userModel entity.UserStore, todoModel entity.TodoStore, todoItemModel entity.TodoItemStore) *AgendaController {
+	return &AgendaController{
+		userModel:     userModel,
+		todoModel:     todoModel,
+		todoItemModel: todoItemModel,
+	}
+}
+
+// Get lays out the caller's unfinished todos and items by day in the
+// caller's time zone, starting with from (a date, today by default) and
+// covering days days. The agenda is personal, so admins only see their own
+// records here too, and like everything else it covers the current
+// workspace.
+func (c *AgendaController) Get(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	loc := callerLocation(ctx, c.userModel)
+	now := time.Now()
+
+	from := now
+	if raw := ctx.Query("from"); raw != "" {
+		t, err := time.ParseInLocation("2006-01-02", raw, loc)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date like 2006-01-02"})
+			return
+		}
+		from = t
+	}
+
+	days := defaultAgendaDays
+	if raw := ctx.Query("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxAgendaDays {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and " + strconv.Itoa(maxAgendaDays)})
+			return
+		}
+		days = n
+	}
+
+	workspaceID, _ := currentWorkspace(ctx)
+	todos, err := c.todoModel.GetByUserID(workspaceID, userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var items []*entity.TodoItem
+	for _, todo := range todos {
+		todoItems, err := c.todoItemModel.GetByTodoID(todo.ID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		items = append(items, todoItems...)
+	}
+
+	ctx.JSON(http.StatusOK, entity.BuildAgenda(todos, items, from, days, now, loc))
+}
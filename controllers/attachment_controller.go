@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is how much larger than the file itself an upload
+// request may be, for the multipart boundaries and headers.
+const multipartOverhead = 1 << 20
+
+type AttachmentController struct {
+	attachmentModel   entity.AttachmentStore
+	blobs             entity.BlobStore
+	todoModel         entity.TodoStore
+	todoItemModel     entity.TodoItemStore
+	collaboratorModel entity.CollaboratorStore
+	unitOfWork        entity.UnitOfWork
+	limits            entity.UploadLimits
+}
+
+func NewAttachmentController(attachmentModel entity.AttachmentStore, blobs entity.BlobStore, todoModel entity.TodoStore, todoItemModel entity.TodoItemStore, collaboratorModel entity.CollaboratorStore, unitOfWork entity.UnitOfWork, limits entity.UploadLimits) *AttachmentController {
+	return &AttachmentController{
+		attachmentModel:   attachmentModel,
+		blobs:             blobs,
+		todoModel:         todoModel,
+		todoItemModel:     todoItemModel,
+		collaboratorModel: collaboratorModel,
+		unitOfWork:        unitOfWork,
+		limits:            limits,
+	}
+}
+
+// loadItem returns the item named in the URL if the caller has at least
+// permission need on its todo, on the same terms as the item handlers:
+// viewers download files, editors upload and delete them. ok is false if
+// not; the error response has then already been written.
+func (c *AttachmentController) loadItem(ctx *gin.Context, need entity.Permission) (item *entity.TodoItem, ok bool) {
+	todoID, err := strconv.Atoi(ctx.Param("todo_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid todo id"})
+		return nil, false
+	}
+
+	itemID, err := strconv.Atoi(ctx.Param("item_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+		return nil, false
+	}
+
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	workspaceID, admin := currentWorkspace(ctx)
+
+	var todo *entity.Todo
+	if admin {
+		todo, err = c.todoModel.GetByIDInWorkspaceWithDeleted(todoID, workspaceID)
+	} else {
+		todo, err = c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return nil, false
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, need) {
+		return nil, false
+	}
+
+	if admin {
+		item, err = c.todoItemModel.GetByIDWithDeleted(itemID)
+	} else {
+		item, err = c.todoItemModel.GetByID(itemID)
+	}
+
+	if err != nil || item.TodoID != todoID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+		return nil, false
+	}
+	return item, true
+}
+
+// loadAttachment returns the attachment named in the URL if it belongs to
+// the item named there and the caller has at least permission need on it.
+func (c *AttachmentController) loadAttachment(ctx *gin.Context, need entity.Permission) (attachment *entity.Attachment, ok bool) {
+	item, ok := c.loadItem(ctx, need)
+	if !ok {
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(ctx.Param("attachment_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid attachment id"})
+		return nil, false
+	}
+
+	attachment, err = c.attachmentModel.GetByID(id)
+	if err != nil || attachment.ItemID != item.ID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return nil, false
+	}
+	return attachment, true
+}
+
+func (c *AttachmentController) GetByItemID(ctx *gin.Context) {
+	item, ok := c.loadItem(ctx, entity.PermissionView)
+	if !ok {
+		return
+	}
+
+	attachments, err := c.attachmentModel.GetByItemID(item.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, attachments)
+}
+
+// Upload stores the "file" part of a multipart/form-data request as an
+// attachment of the item. The part is streamed to the blob store rather
+// than buffered, and its type is sniffed from the content.
+func (c *AttachmentController) Upload(ctx *gin.Context) {
+	item, ok := c.loadItem(ctx, entity.PermissionEdit)
+	if !ok {
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, c.limits.MaxSize+multipartOverhead)
+	reader, err := ctx.Request.MultipartReader()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "expected a multipart/form-data request"})
+		return
+	}
+
+	var attachment *entity.Attachment
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondUploadError(ctx, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		attachment, err = entity.SaveBlob(c.blobs, part, c.limits)
+		part.Close()
+		if err != nil {
+			respondUploadError(ctx, err)
+			return
+		}
+		attachment.FileName = entity.CleanFileName(part.FileName())
+		break
+	}
+	if attachment == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "missing file"})
+		return
+	}
+
+	attachment.TodoID = item.TodoID
+	attachment.ItemID = item.ID
+	attachment.UserID = userID.(int)
+
+	// Check the item in the same transaction, so it can't be purged in the
+	// meantime
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if _, err := tx.Items().GetByIDWithDeleted(item.ID); err != nil {
+			return err
+		}
+		return tx.Attachments().Create(attachment)
+	})
+	if err != nil {
+		c.blobs.Delete(attachment.StorageKey)
+		if errors.Is(err, entity.ErrTodoItemNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, attachment)
+}
+
+// respondUploadError writes the response for a failed upload. Anything that
+// isn't about the limits is most likely a broken request body.
+func respondUploadError(ctx *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, entity.ErrAttachmentTooLarge), errors.As(err, &maxBytesErr):
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": entity.ErrAttachmentTooLarge.Error()})
+	case errors.Is(err, entity.ErrAttachmentType):
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// Download streams the content of an attachment. It is always served as a
+// download, with the sniffed type, so a browser never renders it in place.
+func (c *AttachmentController) Download(ctx *gin.Context) {
+	attachment, ok := c.loadAttachment(ctx, entity.PermissionView)
+	if !ok {
+		return
+	}
+
+	content, err := c.blobs.Open(attachment.StorageKey)
+	if err != nil {
+		if errors.Is(err, entity.ErrBlobNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "attachment content not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	ctx.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, no-cache",
+	})
+}
+
+// Delete removes an attachment and its content. The content goes only once
+// the attachment is gone, so a failure can't leave an attachment without
+// content behind.
+func (c *AttachmentController) Delete(ctx *gin.Context) {
+	attachment, ok := c.loadAttachment(ctx, entity.PermissionEdit)
+	if !ok {
+		return
+	}
+
+	if err := c.attachmentModel.Delete(attachment.ID); err != nil {
+		if errors.Is(err, entity.ErrAttachmentNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.blobs.Delete(attachment.StorageKey); err != nil {
+		log.Printf("attachments: delete blob %s: %v", attachment.StorageKey, err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "attachment deleted"})
+}
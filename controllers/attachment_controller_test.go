@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"todoapp/entity"
+	"todoapp/storage"
+)
+
+func TestUploadLimits(t *testing.T) {
+	items := entity.NewTodoItemModel()
+	todos := entity.NewTodoModel(items)
+	tags := entity.NewTagModel()
+	attachments := entity.NewAttachmentModel()
+	collaborators := entity.NewCollaboratorModel()
+	workspaces := entity.NewWorkspaceModel()
+	users := entity.NewUserModel(todos, tags, collaborators, workspaces)
+	unitOfWork := entity.NewUnitOfWork(users, todos, items, tags, entity.NewCommentModel(), attachments, collaborators, workspaces)
+	if err := todos.Create(&entity.Todo{Title: "todo", UserID: 1, WorkspaceID: entity.DefaultWorkspaceID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := items.Create(&entity.TodoItem{Title: "item", TodoID: 1, UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	blobs, err := storage.NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := NewAttachmentController(attachments, blobs, todos, items, collaborators, unitOfWork, entity.UploadLimits{
+		MaxSize:      1024,
+		AllowedTypes: []string{"image/png", "text/plain"},
+	})
+	r := newTestRouter(1, "user")
+	r.POST("/items/:todo_id/:item_id/attachments", controller.Upload)
+
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 100)
+	tests := []struct {
+		name        string
+		fileName    string
+		contentType string // as the client claims it
+		content     string
+		wantStatus  int
+		wantType    string
+	}{
+		{"png", "photo.png", "image/png", png, http.StatusCreated, "image/png"},
+		{"exactly the limit", "notes.txt", "text/plain", strings.Repeat("a", 1024), http.StatusCreated, "text/plain; charset=utf-8"},
+		{"one byte over", "notes.txt", "text/plain", strings.Repeat("a", 1025), http.StatusRequestEntityTooLarge, ""},
+		{"far over", "notes.txt", "text/plain", strings.Repeat("a", 3<<20), http.StatusRequestEntityTooLarge, ""},
+		{"type not allowed", "doc.pdf", "application/pdf", "%PDF-1.7\n" + strings.Repeat("a", 100), http.StatusUnsupportedMediaType, ""},
+		{"claimed type is ignored", "doc.png", "image/png", "%PDF-1.7\n" + strings.Repeat("a", 100), http.StatusUnsupportedMediaType, ""},
+		{"empty", "empty.txt", "text/plain", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			part, err := form.CreatePart(textproto.MIMEHeader{
+				"Content-Disposition": {`form-data; name="file"; filename="` + tt.fileName + `"`},
+				"Content-Type":        {tt.contentType},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write([]byte(tt.content))
+			form.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/items/1/1/attachments", &body)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantType == "" {
+				return
+			}
+			var attachment entity.Attachment
+			if err := json.Unmarshal(w.Body.Bytes(), &attachment); err != nil {
+				t.Fatal(err)
+			}
+			if attachment.ContentType != tt.wantType || attachment.Size != int64(len(tt.content)) || attachment.FileName != tt.fileName {
+				t.Errorf("attachment = %+v, want %s of %d bytes named %s", attachment, tt.wantType, len(tt.content), tt.fileName)
+			}
+		})
+	}
+
+	// Rejected uploads leave no content behind.
+	var blobCount int
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			blobCount++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := attachments.GetByItemID(1); blobCount != 2 || len(got) != 2 {
+		t.Errorf("%d blobs and %d attachments stored, want 2 of each", blobCount, len(got))
+	}
+}
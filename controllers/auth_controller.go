@@ -15,8 +15,8 @@ import (
 var SecretKey = os.Getenv("JWT_SECRET")
 
 func init() {
-	err := godotenv.Load()
-	if err != nil {
+	// The .env file is optional when the environment already has the secret
+	if err := godotenv.Load(); err != nil && os.Getenv("JWT_SECRET") == "" {
 		panic("Error loading .env file")
 	}
 
@@ -27,10 +27,10 @@ func init() {
 }
 
 type AuthController struct {
-	userModel *entity.UserModel
+	userModel entity.UserStore
 }
 
-func NewAuthController(userModel *entity.UserModel) *AuthController {
+func NewAuthController(userModel entity.UserStore) *AuthController {
 	return &AuthController{
 		userModel: userModel,
 	}
@@ -0,0 +1,525 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxBatchOperations = 100
+
+type BatchController struct {
+	todoModel     entity.TodoStore
+	todoItemModel entity.TodoItemStore
+	unitOfWork    entity.UnitOfWork
+	maxDepth      int
+}
+
+func NewBatchController(todoModel entity.TodoStore, todoItemModel entity.TodoItemStore, unitOfWork entity.UnitOfWork, maxDepth int) *BatchController {
+	return &BatchController{
+		todoModel:     todoModel,
+		todoItemModel: todoItemModel,
+		unitOfWork:    unitOfWork,
+		maxDepth:      maxDepth,
+	}
+}
+
+// BatchRequest runs a list of operations in order. With Atomic set they all
+// run in one transaction and the first failure rolls everything back;
+// otherwise each one commits or fails on its own.
+type BatchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations" binding:"required,min=1,dive"`
+}
+
+// BatchOperation is one step of a batch:
+//
+//   - create: Data holds the body of the matching POST; items also need TodoID
+//   - update: ID names the record and Data is a JSON Merge Patch, as for PATCH
+//   - delete: ID names the record; deleting a todo deletes its items and
+//     deleting an item its sub-items
+//   - complete: ID names an item, or a todo whose items are all completed;
+//     Completed set to false marks them incomplete instead
+//
+// Version, when set, makes update, delete and complete conditional like
+// If-Match does.
+type BatchOperation struct {
+	Op        string          `json:"op" binding:"required,oneof=create update delete complete"`
+	Type      string          `json:"type" binding:"required,oneof=todo item"`
+	ID        int             `json:"id"`
+	TodoID    int             `json:"todo_id"`
+	Version   int             `json:"version"`
+	Completed *bool           `json:"completed"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// BatchResult reports the outcome of one operation with the status code the
+// matching single-record request would have returned.
+type BatchResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// BatchResponse lists the result of every operation and the todos the batch
+// changed, with their completion recomputed once at the end.
+type BatchResponse struct {
+	Results []BatchResult  `json:"results"`
+	Todos   []*entity.Todo `json:"todos"`
+}
+
+// batchError carries the status code an operation failed with.
+type batchError struct {
+	status int
+	err    error
+}
+
+func (e *batchError) Error() string { return e.err.Error() }
+func (e *batchError) Unwrap() error { return e.err }
+
+func failed(status int, err error) error {
+	return &batchError{status: status, err: err}
+}
+
+// storeError maps an error from a store to the status a handler would have
+// answered with.
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, entity.ErrTodoNotFound), errors.Is(err, entity.ErrTodoItemNotFound):
+		return failed(http.StatusNotFound, err)
+	case errors.Is(err, entity.ErrVersionConflict):
+		return failed(http.StatusPreconditionFailed, err)
+	case errors.Is(err, entity.ErrInvalidParentItem), errors.Is(err, entity.ErrItemTooDeep),
+		errors.Is(err, entity.ErrInvalidTag), errors.Is(err, entity.ErrTooManyTags),
+		errors.Is(err, entity.ErrInvalidRecurrence), errors.Is(err, entity.ErrRecurrenceNeedsDate):
+		return failed(http.StatusBadRequest, err)
+	}
+	return failed(http.StatusInternalServerError, err)
+}
+
+func errorStatus(err error) int {
+	var batchErr *batchError
+	if errors.As(err, &batchErr) {
+		return batchErr.status
+	}
+	return http.StatusInternalServerError
+}
+
+// batchRun holds the state of one batch: who is running it, in which
+// workspace, and which todos need their completion recomputed.
+type batchRun struct {
+	userID      int
+	workspaceID int
+	admin       bool
+	maxDepth    int
+	affected    map[int]bool
+}
+
+func (c *BatchController) Run(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	workspaceID, admin := currentWorkspace(ctx)
+
+	var req BatchRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Operations) > maxBatchOperations {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "a batch can hold at most " + strconv.Itoa(maxBatchOperations) + " operations"})
+		return
+	}
+
+	run := &batchRun{
+		userID:      userID.(int),
+		workspaceID: workspaceID,
+		admin:       admin,
+		maxDepth:    c.maxDepth,
+		affected:    make(map[int]bool),
+	}
+	resp := BatchResponse{Results: make([]BatchResult, 0, len(req.Operations))}
+
+	if req.Atomic {
+		failedAt := -1
+		err := c.unitOfWork.Do(func(tx entity.Tx) error {
+			for i, op := range req.Operations {
+				status, data, err := run.apply(tx, op)
+				if err != nil {
+					failedAt = i
+					return err
+				}
+				resp.Results = append(resp.Results, BatchResult{Index: i, Status: status, Data: data})
+			}
+
+			var err error
+			resp.Todos, err = run.recompute(tx)
+			return err
+		})
+		if err != nil {
+			body := gin.H{"error": err.Error()}
+			if failedAt >= 0 {
+				body["index"] = failedAt
+			}
+			ctx.JSON(errorStatus(err), body)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, resp)
+		return
+	}
+
+	for i, op := range req.Operations {
+		result := BatchResult{Index: i}
+		err := c.unitOfWork.Do(func(tx entity.Tx) error {
+			var err error
+			result.Status, result.Data, err = run.apply(tx, op)
+			return err
+		})
+		if err != nil {
+			result.Status = errorStatus(err)
+			result.Error = err.Error()
+			result.Data = nil
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	err := c.unitOfWork.Do(func(tx entity.Tx) error {
+		var err error
+		resp.Todos, err = run.recompute(tx)
+		return err
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// apply runs one operation inside tx and returns its status and the record
+// it produced.
+func (r *batchRun) apply(tx entity.Tx, op BatchOperation) (int, interface{}, error) {
+	if (op.Op == "create" || op.Op == "update") && len(op.Data) == 0 {
+		return 0, nil, failed(http.StatusBadRequest, errors.New("data is required"))
+	}
+
+	switch op.Type + " " + op.Op {
+	case "todo create":
+		var req CreateTodoRequest
+		if err := decodeStrict(op.Data, &req); err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		schedule, err := req.schedule()
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		tags, err := entity.NormalizeTags(req.Tags)
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		recurrence, err := entity.SetRecurrence(entity.Recurrence{}, entity.Schedule{}, schedule, req.RRule)
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		todo := &entity.Todo{
+			Title:       req.Title,
+			Description: req.Description,
+			UserID:      r.userID,
+			WorkspaceID: r.workspaceID,
+			Priority:    entity.Priority(req.Priority),
+			Tags:        tags,
+			Schedule:    schedule,
+			Recurrence:  recurrence,
+		}
+		if err := entity.EnsureTags(tx.Tags(), todo.UserID, todo.Tags); err != nil {
+			return 0, nil, storeError(err)
+		}
+		if err := tx.Todos().Create(todo); err != nil {
+			return 0, nil, storeError(err)
+		}
+		return http.StatusCreated, todo, nil
+
+	case "todo update":
+		todo, err := r.todo(tx, op.ID, entity.PermissionEdit)
+		if err != nil {
+			return 0, nil, err
+		}
+		var req UpdateTodoRequest
+		if err := applyMergePatch(updateTodoRequest(todo), op.Data, &req); err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		schedule, err := req.schedule()
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		tags, err := entity.NormalizeTags(req.Tags)
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		recurrence, err := entity.SetRecurrence(todo.Recurrence, todo.Schedule, schedule, req.RRule)
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		todo.Title = req.Title
+		todo.Description = req.Description
+		todo.Priority = entity.Priority(req.Priority)
+		todo.Tags = tags
+		todo.Schedule = schedule
+		todo.Recurrence = recurrence
+		todo.Version = op.Version
+		if err := entity.EnsureTags(tx.Tags(), todo.UserID, todo.Tags); err != nil {
+			return 0, nil, storeError(err)
+		}
+		if err := tx.Todos().Update(todo); err != nil {
+			return 0, nil, storeError(err)
+		}
+		return http.StatusOK, todo, nil
+
+	case "todo delete":
+		todo, err := r.todo(tx, op.ID, entity.PermissionOwn)
+		if err != nil {
+			return 0, nil, err
+		}
+		if op.Version != 0 && op.Version != todo.Version {
+			return 0, nil, storeError(entity.ErrVersionConflict)
+		}
+		if err := tx.Todos().Delete(todo.ID); err != nil {
+			return 0, nil, storeError(err)
+		}
+		delete(r.affected, todo.ID)
+		return http.StatusNoContent, nil, nil
+
+	case "todo complete":
+		todo, err := r.todo(tx, op.ID, entity.PermissionEdit)
+		if err != nil {
+			return 0, nil, err
+		}
+		if op.Version != 0 && op.Version != todo.Version {
+			return 0, nil, storeError(entity.ErrVersionConflict)
+		}
+		completed := op.Completed == nil || *op.Completed
+		items, err := tx.Items().GetByTodoID(todo.ID)
+		if err != nil {
+			return 0, nil, storeError(err)
+		}
+		for _, item := range items {
+			if item.Completed == completed {
+				continue
+			}
+			item.Completed = completed
+			if err := tx.Items().Update(item); err != nil {
+				return 0, nil, storeError(err)
+			}
+			if _, err := r.completed(tx, item, !completed); err != nil {
+				return 0, nil, err
+			}
+		}
+		r.affected[todo.ID] = true
+		return http.StatusOK, nil, nil
+
+	case "item create":
+		todo, err := r.todo(tx, op.TodoID, entity.PermissionEdit)
+		if err != nil {
+			return 0, nil, err
+		}
+		var req CreateTodoItemRequest
+		if err := decodeStrict(op.Data, &req); err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		schedule, err := req.schedule()
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		tags, err := entity.NormalizeTags(req.Tags)
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		recurrence, err := entity.SetRecurrence(entity.Recurrence{}, entity.Schedule{}, schedule, req.RRule)
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		item := &entity.TodoItem{
+			Title:        req.Title,
+			Description:  req.Description,
+			TodoID:       todo.ID,
+			ParentItemID: req.ParentItemID,
+			UserID:       r.userID,
+			Priority:     entity.Priority(req.Priority),
+			Tags:         tags,
+			Schedule:     schedule,
+			Recurrence:   recurrence,
+		}
+		if err := entity.CheckItemParent(tx.Items(), todo.ID, item.ParentItemID, r.maxDepth); err != nil {
+			return 0, nil, storeError(err)
+		}
+		if err := entity.EnsureTags(tx.Tags(), todo.UserID, item.Tags); err != nil {
+			return 0, nil, storeError(err)
+		}
+		if err := tx.Items().Create(item); err != nil {
+			return 0, nil, storeError(err)
+		}
+		r.affected[todo.ID] = true
+		return http.StatusCreated, item, nil
+
+	case "item update":
+		item, todo, err := r.item(tx, op.ID)
+		if err != nil {
+			return 0, nil, err
+		}
+		var req PatchTodoItemRequest
+		if err := applyMergePatch(patchTodoItemRequest(item), op.Data, &req); err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		schedule, err := req.schedule()
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		tags, err := entity.NormalizeTags(req.Tags)
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		recurrence, err := entity.SetRecurrence(item.Recurrence, item.Schedule, schedule, req.RRule)
+		if err != nil {
+			return 0, nil, failed(http.StatusBadRequest, err)
+		}
+		wasCompleted := item.Completed
+		item.Title = req.Title
+		item.Description = req.Description
+		item.Completed = req.Completed
+		item.Priority = entity.Priority(req.Priority)
+		item.Tags = tags
+		item.Schedule = schedule
+		item.Recurrence = recurrence
+		item.Version = op.Version
+		if err := entity.EnsureTags(tx.Tags(), todo.UserID, item.Tags); err != nil {
+			return 0, nil, storeError(err)
+		}
+		if err := tx.Items().Update(item); err != nil {
+			return 0, nil, storeError(err)
+		}
+		r.affected[item.TodoID] = true
+		item, err = r.completed(tx, item, wasCompleted)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusOK, item, nil
+
+	case "item delete":
+		item, _, err := r.item(tx, op.ID)
+		if err != nil {
+			return 0, nil, err
+		}
+		if op.Version != 0 && op.Version != item.Version {
+			return 0, nil, storeError(entity.ErrVersionConflict)
+		}
+		if err := entity.DeleteTodoItem(tx.Items(), item.ID); err != nil {
+			return 0, nil, storeError(err)
+		}
+		r.affected[item.TodoID] = true
+		return http.StatusNoContent, nil, nil
+
+	case "item complete":
+		item, _, err := r.item(tx, op.ID)
+		if err != nil {
+			return 0, nil, err
+		}
+		wasCompleted := item.Completed
+		item.Completed = op.Completed == nil || *op.Completed
+		item.Version = op.Version
+		if err := tx.Items().Update(item); err != nil {
+			return 0, nil, storeError(err)
+		}
+		r.affected[item.TodoID] = true
+		item, err = r.completed(tx, item, wasCompleted)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusOK, item, nil
+	}
+
+	return 0, nil, failed(http.StatusBadRequest, errors.New("unknown operation"))
+}
+
+// todo loads a live todo on which the caller has at least permission need.
+func (r *batchRun) todo(tx entity.Tx, id int, need entity.Permission) (*entity.Todo, error) {
+	todo, err := tx.Todos().GetByIDInWorkspace(id, r.workspaceID)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	if entity.TodoPermission(tx.Collaborators(), todo, r.userID, r.admin) < need {
+		return nil, failed(http.StatusForbidden, errors.New("forbidden"))
+	}
+	return todo, nil
+}
+
+// item loads a live item whose todo the caller may change, and that todo.
+func (r *batchRun) item(tx entity.Tx, id int) (*entity.TodoItem, *entity.Todo, error) {
+	item, err := tx.Items().GetByID(id)
+	if err != nil {
+		return nil, nil, storeError(err)
+	}
+	todo, err := r.todo(tx, item.TodoID, entity.PermissionEdit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return item, todo, nil
+}
+
+// completed creates the next occurrence of an item that was just completed
+// and heads a series, and returns the item as it is after that.
+func (r *batchRun) completed(tx entity.Tx, item *entity.TodoItem, wasCompleted bool) (*entity.TodoItem, error) {
+	if !item.Completed || wasCompleted || item.RRule == "" {
+		return item, nil
+	}
+	if _, err := entity.AdvanceItem(tx, item.ID, time.Now()); err != nil {
+		return nil, storeError(err)
+	}
+	item, err := tx.Items().GetByID(item.ID)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return item, nil
+}
+
+// recompute updates the completion of every todo the batch touched that is
+// still around and returns them. Todos at the head of a series that this
+// completes are followed by their next occurrence.
+func (r *batchRun) recompute(tx entity.Tx) ([]*entity.Todo, error) {
+	ids := make([]int, 0, len(r.affected))
+	for id := range r.affected {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	todos := make([]*entity.Todo, 0, len(ids))
+	for _, id := range ids {
+		if _, err := tx.Todos().GetByID(id); err != nil {
+			continue
+		}
+		next, err := entity.RecomputeCompletion(tx, id, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		todo, err := tx.Todos().GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+		if next != nil {
+			todos = append(todos, next)
+		}
+	}
+	return todos, nil
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"todoapp/entity"
+)
+
+type batchFixture struct {
+	todos  *entity.TodoModel
+	items  *entity.TodoItemModel
+	router http.Handler
+}
+
+// newBatchFixture sets up todo 1 with item 1, both owned by user 1, who runs
+// the batches.
+func newBatchFixture(t *testing.T) *batchFixture {
+	t.Helper()
+	f := &batchFixture{items: entity.NewTodoItemModel()}
+	f.todos = entity.NewTodoModel(f.items)
+	tags, collaborators, workspaces := entity.NewTagModel(), entity.NewCollaboratorModel(), entity.NewWorkspaceModel()
+	users := entity.NewUserModel(f.todos, tags, collaborators, workspaces)
+	unitOfWork := entity.NewUnitOfWork(users, f.todos, f.items, tags, entity.NewCommentModel(), entity.NewAttachmentModel(), collaborators, workspaces)
+	if err := f.todos.Create(&entity.Todo{Title: "todo", UserID: 1, WorkspaceID: entity.DefaultWorkspaceID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.items.Create(&entity.TodoItem{Title: "item", TodoID: 1, UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	controller := NewBatchController(f.todos, f.items, unitOfWork, 5)
+	r := newTestRouter(1, "user")
+	r.POST("/batch", controller.Run)
+	f.router = r
+	return f
+}
+
+// The last operation fails, so an atomic batch leaves nothing behind while
+// a plain one keeps what the first two did.
+const failingBatch = `"operations": [
+	{"op": "create", "type": "item", "todo_id": 1, "data": {"title": "new", "description": "added by the batch"}},
+	{"op": "complete", "type": "item", "id": 1},
+	{"op": "update", "type": "item", "id": 99, "data": {"title": "missing"}}
+]`
+
+func TestAtomicBatchRollsBack(t *testing.T) {
+	f := newBatchFixture(t)
+
+	w := serve(f.router, http.MethodPost, "/batch", `{"atomic": true, `+failingBatch+`}`)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+	var body struct {
+		Index int `json:"index"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Index != 2 {
+		t.Errorf("body = %s, want the failing index 2", w.Body)
+	}
+
+	if items, _ := f.items.GetByTodoID(1); len(items) != 1 || items[0].Completed {
+		t.Errorf("items after the rollback = %+v, want only item 1, not completed", items)
+	}
+	if todo, _ := f.todos.GetByID(1); todo.CompletionPct != 0 {
+		t.Errorf("completion after the rollback = %v, want 0", todo.CompletionPct)
+	}
+}
+
+func TestBatchWithoutAtomic(t *testing.T) {
+	f := newBatchFixture(t)
+
+	w := serve(f.router, http.MethodPost, "/batch", `{`+failingBatch+`}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var statuses []int
+	for _, result := range resp.Results {
+		statuses = append(statuses, result.Status)
+	}
+	if want := []int{http.StatusCreated, http.StatusOK, http.StatusNotFound}; len(statuses) != 3 || statuses[0] != want[0] || statuses[1] != want[1] || statuses[2] != want[2] {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+
+	if items, _ := f.items.GetByTodoID(1); len(items) != 2 {
+		t.Errorf("got %d items, want the created one kept", len(items))
+	}
+	if todo, _ := f.todos.GetByID(1); todo.CompletionPct != 50 {
+		t.Errorf("completion = %v, want 50", todo.CompletionPct)
+	}
+}
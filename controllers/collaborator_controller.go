@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CollaboratorController struct {
+	collaboratorModel entity.CollaboratorStore
+	todoModel         entity.TodoStore
+	userModel         entity.UserStore
+	unitOfWork        entity.UnitOfWork
+}
+
+func NewCollaboratorController(collaboratorModel entity.CollaboratorStore, todoModel entity.TodoStore, userModel entity.UserStore, unitOfWork entity.UnitOfWork) *CollaboratorController {
+	return &CollaboratorController{
+		collaboratorModel: collaboratorModel,
+		todoModel:         todoModel,
+		userModel:         userModel,
+		unitOfWork:        unitOfWork,
+	}
+}
+
+type InviteCollaboratorRequest struct {
+	Username string `json:"username" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+}
+
+type UpdateCollaboratorRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// CollaboratorResponse is a collaborator with the username of the user it
+// is for, which is what invitations are sent by.
+type CollaboratorResponse struct {
+	*entity.Collaborator
+	Username string `json:"username"`
+}
+
+func (c *CollaboratorController) response(collaborator *entity.Collaborator) *CollaboratorResponse {
+	resp := &CollaboratorResponse{Collaborator: collaborator}
+	if user, err := c.userModel.GetByID(collaborator.UserID); err == nil {
+		resp.Username = user.Username
+	}
+	return resp
+}
+
+// loadTodo returns the todo named in the URL if the caller has at least
+// permission need on it. ok is false if not; the error response has then
+// already been written.
+func (c *CollaboratorController) loadTodo(ctx *gin.Context, need entity.Permission) (todo *entity.Todo, ok bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	workspaceID, admin := currentWorkspace(ctx)
+
+	if admin {
+		todo, err = c.todoModel.GetByIDInWorkspaceWithDeleted(id, workspaceID)
+	} else {
+		todo, err = c.todoModel.GetByIDInWorkspace(id, workspaceID)
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return nil, false
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, need) {
+		return nil, false
+	}
+	return todo, true
+}
+
+// load returns the todo named in the URL and the entry of the user named
+// there on it, if the caller may see the todo.
+func (c *CollaboratorController) load(ctx *gin.Context) (collaborator *entity.Collaborator, todo *entity.Todo, ok bool) {
+	userID, err := strconv.Atoi(ctx.Param("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return nil, nil, false
+	}
+
+	if todo, ok = c.loadTodo(ctx, entity.PermissionView); !ok {
+		return nil, nil, false
+	}
+
+	collaborator, err = c.collaboratorModel.GetByTodoAndUser(todo.ID, userID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "collaborator not found"})
+		return nil, nil, false
+	}
+	return collaborator, todo, true
+}
+
+// GetByTodoID lists who a todo is shared with, pending invitations included.
+// Everyone who can see the todo can see this.
+func (c *CollaboratorController) GetByTodoID(ctx *gin.Context) {
+	todo, ok := c.loadTodo(ctx, entity.PermissionView)
+	if !ok {
+		return
+	}
+
+	collaborators, err := c.collaboratorModel.GetByTodoID(todo.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := make([]*CollaboratorResponse, len(collaborators))
+	for i, collaborator := range collaborators {
+		resp[i] = c.response(collaborator)
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// Invite shares a todo with the user of the given username. The share takes
+// effect once that user accepts the invitation.
+func (c *CollaboratorController) Invite(ctx *gin.Context) {
+	todo, ok := c.loadTodo(ctx, entity.PermissionOwn)
+	if !ok {
+		return
+	}
+
+	var req InviteCollaboratorRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := entity.ParseCollaboratorRole(req.Role)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+
+	var collaborator *entity.Collaborator
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		var err error
+		collaborator, err = entity.InviteCollaborator(tx, todo, req.Username, role, userID.(int))
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, entity.ErrUserNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		case errors.Is(err, entity.ErrInvalidCollaborator), errors.Is(err, entity.ErrNotWorkspaceMember):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, entity.ErrCollaboratorExists):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	setETag(ctx, collaborator.Version)
+	ctx.JSON(http.StatusCreated, c.response(collaborator))
+}
+
+// Update changes the role a todo is shared with a user as.
+func (c *CollaboratorController) Update(ctx *gin.Context) {
+	collaborator, todo, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionOwn) {
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, collaborator.Version)
+	if !ok {
+		return
+	}
+
+	var req UpdateCollaboratorRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := entity.ParseCollaboratorRole(req.Role)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	collaborator.Role = role
+	collaborator.Version = version
+	if err := c.collaboratorModel.Update(collaborator); err != nil {
+		respondCollaboratorError(ctx, err)
+		return
+	}
+
+	setETag(ctx, collaborator.Version)
+	ctx.JSON(http.StatusOK, c.response(collaborator))
+}
+
+// Delete stops sharing a todo with a user, or withdraws the invitation.
+// Users can also remove themselves, whatever their role.
+func (c *CollaboratorController) Delete(ctx *gin.Context) {
+	collaborator, todo, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+	if collaborator.UserID != userID.(int) && !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionOwn) {
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, collaborator.Version); !ok {
+		return
+	}
+
+	if err := c.collaboratorModel.Delete(collaborator.ID); err != nil {
+		respondCollaboratorError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "collaborator removed"})
+}
+
+// GetInvitations lists the invitations the caller hasn't answered yet.
+func (c *CollaboratorController) GetInvitations(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	shares, err := c.collaboratorModel.GetByUserID(userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	invitations := make([]*entity.Collaborator, 0)
+	for _, collaborator := range shares {
+		if collaborator.AcceptedAt == nil {
+			invitations = append(invitations, collaborator)
+		}
+	}
+	ctx.JSON(http.StatusOK, invitations)
+}
+
+// loadInvitation returns the invitation named in the URL if it was sent to
+// the caller and is still pending.
+func (c *CollaboratorController) loadInvitation(ctx *gin.Context) (invitation *entity.Collaborator, ok bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	invitation, err = c.collaboratorModel.GetByID(id)
+	if err != nil || invitation.UserID != userID.(int) || invitation.AcceptedAt != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+		return nil, false
+	}
+	return invitation, true
+}
+
+func (c *CollaboratorController) Accept(ctx *gin.Context) {
+	invitation, ok := c.loadInvitation(ctx)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	invitation.AcceptedAt = &now
+	if err := c.collaboratorModel.Update(invitation); err != nil {
+		respondCollaboratorError(ctx, err)
+		return
+	}
+
+	setETag(ctx, invitation.Version)
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+func (c *CollaboratorController) Decline(ctx *gin.Context) {
+	invitation, ok := c.loadInvitation(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.collaboratorModel.Delete(invitation.ID); err != nil {
+		respondCollaboratorError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "invitation declined"})
+}
+
+func respondCollaboratorError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, entity.ErrCollaboratorNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "collaborator not found"})
+	case errors.Is(err, entity.ErrVersionConflict):
+		ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CommentController struct {
+	commentModel      entity.CommentStore
+	todoModel         entity.TodoStore
+	todoItemModel     entity.TodoItemStore
+	collaboratorModel entity.CollaboratorStore
+	unitOfWork        entity.UnitOfWork
+}
+
+func NewCommentController(commentModel entity.CommentStore, todoModel entity.TodoStore, todoItemModel entity.TodoItemStore, collaboratorModel entity.CollaboratorStore, unitOfWork entity.UnitOfWork) *CommentController {
+	return &CommentController{
+		commentModel:      commentModel,
+		todoModel:         todoModel,
+		todoItemModel:     todoItemModel,
+		collaboratorModel: collaboratorModel,
+		unitOfWork:        unitOfWork,
+	}
+}
+
+// CreateCommentRequest starts a discussion, or with ParentID set answers a
+// comment in the same thread.
+type CreateCommentRequest struct {
+	Body     string `json:"body" binding:"required"`
+	ParentID *int   `json:"parent_id"`
+}
+
+type UpdateCommentRequest struct {
+	Body string `json:"body" binding:"required"`
+}
+
+// setCommentCounts fills in CommentCount on todos that are about to be
+// written to a response.
+func setCommentCounts(commentModel entity.CommentStore, todos ...*entity.Todo) error {
+	ids := make([]int, len(todos))
+	for i, todo := range todos {
+		ids[i] = todo.ID
+	}
+	counts, err := commentModel.CountByTodoIDs(ids)
+	if err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		todo.CommentCount = counts[todo.ID]
+	}
+	return nil
+}
+
+// loadTodo returns the todo with the given ID if the caller has at least
+// permission need on it, on the same terms as the todo handlers: viewers can
+// read the discussion, editors take part in it. ok is false if not; the error
+// response has then already been written.
+func (c *CommentController) loadTodo(ctx *gin.Context, id int, need entity.Permission) (todo *entity.Todo, ok bool) {
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	workspaceID, admin := currentWorkspace(ctx)
+
+	var err error
+	if admin {
+		todo, err = c.todoModel.GetByIDInWorkspaceWithDeleted(id, workspaceID)
+	} else {
+		todo, err = c.todoModel.GetByIDInWorkspace(id, workspaceID)
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return nil, false
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, need) {
+		return nil, false
+	}
+	return todo, true
+}
+
+// todoThread resolves the todo named in the URL of a todo comment route.
+func (c *CommentController) todoThread(ctx *gin.Context, need entity.Permission) (todo *entity.Todo, ok bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	return c.loadTodo(ctx, id, need)
+}
+
+// itemThread resolves the todo and live item named in the URL of an item
+// comment route.
+func (c *CommentController) itemThread(ctx *gin.Context, need entity.Permission) (todo *entity.Todo, itemID *int, ok bool) {
+	todoID, err := strconv.Atoi(ctx.Param("todo_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid todo id"})
+		return nil, nil, false
+	}
+
+	id, err := strconv.Atoi(ctx.Param("item_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+		return nil, nil, false
+	}
+
+	if todo, ok = c.loadTodo(ctx, todoID, need); !ok {
+		return nil, nil, false
+	}
+
+	item, err := c.todoItemModel.GetByID(id)
+	if err != nil || item.TodoID != todoID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+		return nil, nil, false
+	}
+	return todo, &item.ID, true
+}
+
+// GetTodoComments returns the discussion on a todo as a thread: the
+// comments that start a discussion, oldest first, each with its replies.
+func (c *CommentController) GetTodoComments(ctx *gin.Context) {
+	todo, ok := c.todoThread(ctx, entity.PermissionView)
+	if !ok {
+		return
+	}
+
+	comments, err := c.commentModel.GetByTodoID(todo.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, entity.BuildCommentThread(comments, nil))
+}
+
+func (c *CommentController) CreateTodoComment(ctx *gin.Context) {
+	todo, ok := c.todoThread(ctx, entity.PermissionEdit)
+	if !ok {
+		return
+	}
+
+	c.create(ctx, todo, nil)
+}
+
+// GetItemComments returns the discussion on an item, like GetTodoComments.
+func (c *CommentController) GetItemComments(ctx *gin.Context) {
+	todo, itemID, ok := c.itemThread(ctx, entity.PermissionView)
+	if !ok {
+		return
+	}
+
+	comments, err := c.commentModel.GetByTodoID(todo.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, entity.BuildCommentThread(comments, itemID))
+}
+
+func (c *CommentController) CreateItemComment(ctx *gin.Context) {
+	todo, itemID, ok := c.itemThread(ctx, entity.PermissionEdit)
+	if !ok {
+		return
+	}
+
+	c.create(ctx, todo, itemID)
+}
+
+// create adds a comment by the caller to the thread of todo, or of item
+// itemID if that is set.
+func (c *CommentController) create(ctx *gin.Context, todo *entity.Todo, itemID *int) {
+	userID, _ := ctx.Get("user_id")
+
+	var req CreateCommentRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	body, err := entity.NormalizeCommentBody(req.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment := &entity.Comment{
+		TodoID:   todo.ID,
+		ItemID:   itemID,
+		ParentID: req.ParentID,
+		UserID:   userID.(int),
+		Body:     body,
+	}
+
+	// Check the parent in the same transaction, so it can't be deleted in
+	// the meantime
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := entity.CheckCommentParent(tx.Comments(), comment); err != nil {
+			return err
+		}
+		return tx.Comments().Create(comment)
+	})
+	if err != nil {
+		if errors.Is(err, entity.ErrInvalidCommentParent) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(ctx, comment.Version)
+	ctx.JSON(http.StatusCreated, comment)
+}
+
+// load returns the comment named in the URL and its todo if the caller may
+// see the todo. ok is false if not; the error response has then already been
+// written.
+func (c *CommentController) load(ctx *gin.Context) (comment *entity.Comment, todo *entity.Todo, ok bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, nil, false
+	}
+
+	comment, err = c.commentModel.GetByID(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+		return nil, nil, false
+	}
+
+	if todo, ok = c.loadTodo(ctx, comment.TodoID, entity.PermissionView); !ok {
+		return nil, nil, false
+	}
+	return comment, todo, true
+}
+
+func (c *CommentController) GetByID(ctx *gin.Context) {
+	comment, _, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	setETag(ctx, comment.Version)
+	ctx.JSON(http.StatusOK, comment)
+}
+
+// Update replaces the body of a comment. Only the author can edit a
+// comment, admins included.
+func (c *CommentController) Update(ctx *gin.Context) {
+	comment, _, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+	if comment.UserID != userID.(int) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "only the author can edit a comment"})
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, comment.Version)
+	if !ok {
+		return
+	}
+
+	var req UpdateCommentRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	body, err := entity.NormalizeCommentBody(req.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment.Body = body
+	comment.Version = version
+	if err := c.commentModel.Update(comment); err != nil {
+		switch {
+		case errors.Is(err, entity.ErrCommentNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+		case errors.Is(err, entity.ErrVersionConflict):
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	setETag(ctx, comment.Version)
+	ctx.JSON(http.StatusOK, comment)
+}
+
+// Delete clears a comment's body and marks it as deleted. The author and
+// whoever owns the todo, admins included, can delete a comment.
+func (c *CommentController) Delete(ctx *gin.Context) {
+	comment, todo, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+	if comment.UserID != userID.(int) && todoPermission(ctx, c.collaboratorModel, todo) < entity.PermissionOwn {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, comment.Version); !ok {
+		return
+	}
+
+	if err := c.commentModel.Delete(comment.ID); err != nil {
+		if errors.Is(err, entity.ErrCommentNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "comment deleted"})
+}
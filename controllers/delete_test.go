@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// racingUnitOfWork runs before, standing in for another request, ahead of
+// the first transaction it is handed.
+type racingUnitOfWork struct {
+	entity.UnitOfWork
+	before func()
+}
+
+func (u *racingUnitOfWork) Do(fn func(tx entity.Tx) error) error {
+	if u.before != nil {
+		u.before()
+		u.before = nil
+	}
+	return u.UnitOfWork.Do(fn)
+}
+
+type deleteFixture struct {
+	todos      *entity.TodoModel
+	items      *entity.TodoItemModel
+	unitOfWork *racingUnitOfWork
+}
+
+// newDeleteFixture sets up todo 1 of user 1 with item 1, both at version 1.
+func newDeleteFixture(t *testing.T) *deleteFixture {
+	t.Helper()
+	f := &deleteFixture{items: entity.NewTodoItemModel()}
+	f.todos = entity.NewTodoModel(f.items)
+	tags, collaborators, workspaces := entity.NewTagModel(), entity.NewCollaboratorModel(), entity.NewWorkspaceModel()
+	users := entity.NewUserModel(f.todos, tags, collaborators, workspaces)
+	f.unitOfWork = &racingUnitOfWork{UnitOfWork: entity.NewUnitOfWork(users, f.todos, f.items, tags, entity.NewCommentModel(), entity.NewAttachmentModel(), collaborators, workspaces)}
+	if err := f.todos.Create(&entity.Todo{Title: "todo", UserID: 1, WorkspaceID: entity.DefaultWorkspaceID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.items.Create(&entity.TodoItem{Title: "item", TodoID: 1, UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f *deleteFixture) router(role string) *gin.Engine {
+	todoController := NewTodoController(f.todos, nil, entity.NewCommentModel(), entity.NewCollaboratorModel(), f.unitOfWork)
+	itemController := NewTodoItemController(f.items, f.todos, nil, entity.NewCollaboratorModel(), f.unitOfWork, 5)
+	r := newTestRouter(1, role)
+	r.DELETE("/todos/:id", todoController.Delete)
+	r.POST("/todos/:id/restore", todoController.Restore)
+	r.DELETE("/items/:todo_id/:item_id", itemController.Delete)
+	r.POST("/items/:todo_id/:item_id/restore", itemController.Restore)
+	return r
+}
+
+func serveIfMatch(r http.Handler, method, path, etag string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("If-Match", etag)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDeleteAndRestoreIfMatch(t *testing.T) {
+	f := newDeleteFixture(t)
+	user, admin := f.router("user"), f.router("admin")
+
+	steps := []struct {
+		router       http.Handler
+		method, path string
+		etag         string
+		want         int
+	}{
+		{user, http.MethodDelete, "/items/1/1", `"2"`, http.StatusPreconditionFailed},
+		{user, http.MethodDelete, "/items/1/1", `"1"`, http.StatusOK},
+		{admin, http.MethodDelete, "/items/1/1", `*`, http.StatusNotFound},
+		{user, http.MethodPost, "/items/1/1/restore", `"1"`, http.StatusPreconditionFailed},
+		{user, http.MethodPost, "/items/1/1/restore", `"2"`, http.StatusOK},
+		{user, http.MethodDelete, "/todos/1", `"3"`, http.StatusPreconditionFailed},
+		{user, http.MethodDelete, "/todos/1", `*`, http.StatusOK},
+		// Deleting twice used to be a 500.
+		{admin, http.MethodDelete, "/todos/1", `*`, http.StatusNotFound},
+		{user, http.MethodPost, "/todos/1/restore", `"3"`, http.StatusPreconditionFailed},
+	}
+	for _, step := range steps {
+		if w := serveIfMatch(step.router, step.method, step.path, step.etag); w.Code != step.want {
+			t.Fatalf("%s %s with If-Match %s: status = %d, want %d: %s", step.method, step.path, step.etag, w.Code, step.want, w.Body)
+		}
+	}
+}
+
+// A change that lands after the If-Match check but before the delete still
+// makes the delete fail, and nothing of it is kept.
+func TestDeleteLosesRace(t *testing.T) {
+	f := newDeleteFixture(t)
+	r := f.router("user")
+
+	f.unitOfWork.before = func() {
+		if err := f.todos.Update(&entity.Todo{ID: 1, Title: "changed", UserID: 1, WorkspaceID: entity.DefaultWorkspaceID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := serveIfMatch(r, http.MethodDelete, "/todos/1", `"1"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusPreconditionFailed, w.Body)
+	}
+	if todo, err := f.todos.GetByID(1); err != nil || todo.Title != "changed" {
+		t.Errorf("todo after the failed delete = %+v, %v; want it live with the other change", todo, err)
+	}
+	if item, err := f.items.GetByID(1); err != nil {
+		t.Errorf("item after the failed delete: %v, want it live", err)
+	} else if item.Version != 1 {
+		t.Errorf("item version = %d, want 1", item.Version)
+	}
+
+	f.unitOfWork.before = func() {
+		if err := f.items.Update(&entity.TodoItem{ID: 1, Title: "changed", TodoID: 1, UserID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := serveIfMatch(r, http.MethodDelete, "/items/1/1", `"1"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("item: status = %d, want %d: %s", w.Code, http.StatusPreconditionFailed, w.Body)
+	}
+	if item, err := f.items.GetByID(1); err != nil || item.Title != "changed" {
+		t.Errorf("item after the failed delete = %+v, %v; want it live with the other change", item, err)
+	}
+}
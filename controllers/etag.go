@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Records expose their version as a strong ETag, e.g. "3". Clients send it
+// back in If-Match to make a PUT or DELETE conditional.
+
+func setETag(ctx *gin.Context, version int) {
+	ctx.Header("ETag", `"`+strconv.Itoa(version)+`"`)
+}
+
+// ifMatchVersion returns the version named by the request's If-Match header,
+// or 0 when the header is missing or "*". ok is false if the header can't be
+// parsed; a 400 has then already been written.
+func ifMatchVersion(ctx *gin.Context) (version int, ok bool) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+
+	tag := strings.TrimSuffix(strings.TrimPrefix(header, `"`), `"`)
+	version, err := strconv.Atoi(tag)
+	if err != nil || version <= 0 || len(tag)+2 != len(header) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid If-Match header"})
+		return 0, false
+	}
+	return version, true
+}
+
+// checkIfMatch compares the If-Match header against the current version and
+// writes a 412 (or 400) when the request must not go ahead. It returns the
+// version the client expects, 0 meaning unconditional.
+func checkIfMatch(ctx *gin.Context, current int) (version int, ok bool) {
+	version, ok = ifMatchVersion(ctx)
+	if !ok {
+		return 0, false
+	}
+	if version != 0 && version != current {
+		setETag(ctx, current)
+		ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": entity.ErrVersionConflict.Error()})
+		return 0, false
+	}
+	return version, true
+}
+
+// checkChangedOnce runs in a transaction right after a write that bumps a
+// record's version by one, such as a delete or restore, and fails it with
+// ErrVersionConflict unless the record was at the version the client
+// expected. Checking after the write rather than before means the write
+// already holds the record, so no other change can come in between.
+func checkChangedOnce(expected, version int) error {
+	if expected != 0 && version != expected+1 {
+		return entity.ErrVersionConflict
+	}
+	return nil
+}
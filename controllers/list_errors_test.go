@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"todoapp/entity"
+)
+
+var errStoreDown = errors.New("store is down")
+
+// brokenTodoStore fails every listing, the way a SQL store does when its
+// query or its rows fail.
+type brokenTodoStore struct {
+	*entity.TodoModel
+}
+
+func (brokenTodoStore) GetByWorkspaceIDWithDeleted(int) ([]*entity.Todo, error) {
+	return nil, errStoreDown
+}
+
+func (brokenTodoStore) GetByUserID(int, int) ([]*entity.Todo, error) {
+	return nil, errStoreDown
+}
+
+func (brokenTodoStore) GetByUserIDWithDeleted(int, int) ([]*entity.Todo, error) {
+	return nil, errStoreDown
+}
+
+// A listing that fails must not look like an empty one.
+func TestListErrorsAre500s(t *testing.T) {
+	items := entity.NewTodoItemModel()
+	todoModel := entity.NewTodoModel(items)
+	todos := brokenTodoStore{todoModel}
+	tags, collaborators, workspaces := entity.NewTagModel(), entity.NewCollaboratorModel(), entity.NewWorkspaceModel()
+	users := entity.NewUserModel(todoModel, tags, collaborators, workspaces)
+	unitOfWork := entity.NewUnitOfWork(users, todoModel, items, tags, entity.NewCommentModel(), entity.NewAttachmentModel(), collaborators, workspaces)
+	todoController := NewTodoController(todos, users, entity.NewCommentModel(), entity.NewCollaboratorModel(), unitOfWork)
+	agendaController := NewAgendaController(users, todos, items)
+	trashController := NewTrashController(todos, items, nil)
+
+	for _, role := range []string{"user", "admin"} {
+		r := newTestRouter(1, role)
+		r.GET("/todos", todoController.GetAll)
+		r.GET("/agenda", agendaController.Get)
+		r.GET("/trash", trashController.GetAll)
+
+		for _, path := range []string{"/todos", "/agenda", "/trash"} {
+			if w := serve(r, http.MethodGet, path, ""); w.Code != http.StatusInternalServerError {
+				t.Errorf("%s GET %s: status = %d, want %d", role, path, w.Code, http.StatusInternalServerError)
+			}
+		}
+	}
+}
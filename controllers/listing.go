@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Listings are paginated with ?limit=&cursor= and ordered with ?sort=, where
+// a leading "-" reverses the order (e.g. sort=-updated_at). The body stays a
+// plain JSON array; the cursor for the next page, if there is one, comes back
+// in the X-Next-Cursor header. Without limit or cursor the whole listing is
+// returned, as it was before pagination was added, so existing clients keep
+// getting every record.
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// parseListOptions reads limit, cursor and sort from the query string. ok is
+// false if one of them is malformed; a 400 has then already been written.
+func parseListOptions(ctx *gin.Context) (opts entity.ListOptions, ok bool) {
+	opts.Cursor = ctx.Query("cursor")
+	if opts.Cursor != "" {
+		opts.Limit = defaultListLimit
+	}
+	if raw := ctx.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxListLimit)})
+			return opts, false
+		}
+		opts.Limit = limit
+	}
+
+	opts.Sort = ctx.Query("sort")
+	if strings.HasPrefix(opts.Sort, "-") {
+		opts.Sort = opts.Sort[1:]
+		opts.Desc = true
+	}
+	return opts, true
+}
+
+// parseTimeRange reads <name>_after and <name>_before, each either an RFC 3339
+// timestamp or a plain date.
+func parseTimeRange(ctx *gin.Context, name string) (r entity.TimeRange, ok bool) {
+	if r.After, ok = parseTimeParam(ctx, name+"_after"); !ok {
+		return r, false
+	}
+	r.Before, ok = parseTimeParam(ctx, name+"_before")
+	return r, ok
+}
+
+func parseTimeParam(ctx *gin.Context, name string) (time.Time, bool) {
+	return parseTimeParamIn(ctx, name, time.UTC)
+}
+
+// parseTimeParamIn is parseTimeParam with plain dates taken to start at
+// midnight in loc.
+func parseTimeParamIn(ctx *gin.Context, name string, loc *time.Location) (time.Time, bool) {
+	raw := ctx.Query(name)
+	if raw == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", raw, loc); err == nil {
+		return t, true
+	}
+	ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+	return time.Time{}, false
+}
+
+// parseDueFilter reads due_after, due_before, overdue and due_today. Plain
+// dates, all-day due dates and "today" are taken in loc, the caller's time
+// zone.
+func parseDueFilter(ctx *gin.Context, loc *time.Location) (f entity.DueFilter, ok bool) {
+	f.Now = time.Now()
+	f.Location = loc
+	if f.Due.After, ok = parseTimeParamIn(ctx, "due_after", loc); !ok {
+		return f, false
+	}
+	if f.Due.Before, ok = parseTimeParamIn(ctx, "due_before", loc); !ok {
+		return f, false
+	}
+	if f.Overdue, ok = parseBoolParam(ctx, "overdue"); !ok {
+		return f, false
+	}
+	today, ok := parseBoolParam(ctx, "due_today")
+	f.Today = today != nil && *today
+	return f, ok
+}
+
+// parseTagFilter reads tags, a comma-separated list of tag names, and
+// tags_mode: "all" (the default) matches records carrying every one of the
+// tags and "any" those carrying at least one.
+func parseTagFilter(ctx *gin.Context) (f entity.TagFilter, ok bool) {
+	switch ctx.Query("tags_mode") {
+	case "", "all":
+	case "any":
+		f.Any = true
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "tags_mode must be all or any"})
+		return f, false
+	}
+
+	raw := ctx.Query("tags")
+	if raw == "" {
+		return f, true
+	}
+	for _, name := range strings.Split(raw, ",") {
+		tag, err := entity.NormalizeTag(name)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid tags: " + err.Error()})
+			return f, false
+		}
+		f.Names = append(f.Names, tag)
+	}
+	return f, true
+}
+
+func parseFloatParam(ctx *gin.Context, name string) (*float64, bool) {
+	raw := ctx.Query(name)
+	if raw == "" {
+		return nil, true
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return nil, false
+	}
+	return &v, true
+}
+
+func parseBoolParam(ctx *gin.Context, name string) (*bool, bool) {
+	raw := ctx.Query(name)
+	if raw == "" {
+		return nil, true
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return nil, false
+	}
+	return &v, true
+}
+
+// writeList writes one page of a listing, or a 400 if the List function
+// rejected the sort field or cursor.
+func writeList(ctx *gin.Context, page interface{}, next string, err error) {
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if next != "" {
+		ctx.Header("X-Next-Cursor", next)
+	}
+	ctx.JSON(http.StatusOK, page)
+}
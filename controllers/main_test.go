@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auth_controller.go reads JWT_SECRET in init, which runs after every
+// package-level variable, including this one, has been initialized.
+var _ = os.Setenv("JWT_SECRET", "test-secret")
+
+// newTestRouter returns a router whose requests run as the given user in
+// the default workspace, the way AuthMiddleware and WorkspaceMiddleware
+// would have set them up. Admins are admins of the workspace too.
+func newTestRouter(userID int, role string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(ctx *gin.Context) {
+		ctx.Set("user_id", userID)
+		ctx.Set("user_role", role)
+		ctx.Set("workspace_id", entity.DefaultWorkspaceID)
+		if role == "admin" {
+			ctx.Set("workspace_role", entity.WorkspaceRoleAdmin)
+		} else {
+			ctx.Set("workspace_role", entity.WorkspaceRoleMember)
+		}
+	})
+	return r
+}
+
+// serve sends a JSON request through r and returns the recorded response.
+func serve(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// PATCH requests carry an RFC 7396 JSON Merge Patch: the fields present in
+// the body replace the current ones, objects are merged recursively and null
+// removes a field. The patch is applied to the record's editable fields and
+// the result is validated like a full update, so removing a required field
+// fails.
+
+const mergePatchContentType = "application/merge-patch+json"
+
+// bindMergePatch applies the request body to current, the editable fields of
+// the record as they are now, and decodes the result into req. ok is false if
+// the body is not an acceptable patch or the result is invalid; an error
+// response has then already been written.
+func bindMergePatch(ctx *gin.Context, current, req interface{}) (ok bool) {
+	mediaType, _, err := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+	if err != nil || (mediaType != mergePatchContentType && mediaType != "application/json") {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "PATCH expects " + mergePatchContentType})
+		return false
+	}
+
+	var patch json.RawMessage
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&patch); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return false
+	}
+	if err := applyMergePatch(current, patch, req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// applyMergePatch is bindMergePatch for a patch that has already been read.
+func applyMergePatch(current interface{}, patch json.RawMessage, req interface{}) error {
+	var object interface{}
+	if err := json.Unmarshal(patch, &object); err != nil {
+		return err
+	}
+	if _, isObject := object.(map[string]interface{}); !isObject {
+		return errors.New("patch must be a JSON object")
+	}
+
+	var target interface{}
+	data, _ := json.Marshal(current)
+	json.Unmarshal(data, &target)
+
+	merged, _ := json.Marshal(mergePatch(target, object))
+	return decodeStrict(merged, req)
+}
+
+// decodeStrict decodes data into req, rejecting fields req doesn't have, and
+// validates the result against its binding tags.
+func decodeStrict(data []byte, req interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(req)
+}
+
+// mergePatch implements the MergePatch function of RFC 7396.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
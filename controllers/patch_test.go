@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The examples from RFC 7396, appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		var target, patch interface{}
+		if err := json.Unmarshal([]byte(tt.target), &target); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := json.Marshal(mergePatch(target, patch))
+		if string(got) != tt.want {
+			t.Errorf("mergePatch(%s, %s) = %s, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestBindMergePatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	type request struct {
+		Title    string   `json:"title" binding:"required"`
+		Priority string   `json:"priority"`
+		Tags     []string `json:"tags"`
+	}
+	current := request{Title: "Groceries", Priority: "low", Tags: []string{"home"}}
+
+	tests := []struct {
+		name        string
+		contentType string
+		patch       string
+		want        request
+		wantStatus  int // 0 if the patch applies
+	}{
+		{"empty patch", mergePatchContentType, `{}`, current, 0},
+		{"one field", mergePatchContentType, `{"priority":"high"}`, request{Title: "Groceries", Priority: "high", Tags: []string{"home"}}, 0},
+		{"null clears", mergePatchContentType, `{"tags":null}`, request{Title: "Groceries", Priority: "low"}, 0},
+		{"arrays are replaced", mergePatchContentType, `{"tags":["work","urgent"]}`, request{Title: "Groceries", Priority: "low", Tags: []string{"work", "urgent"}}, 0},
+		{"plain JSON", "application/json; charset=utf-8", `{"priority":"high"}`, request{Title: "Groceries", Priority: "high", Tags: []string{"home"}}, 0},
+		{"other media type", "text/plain", `{"priority":"high"}`, request{}, http.StatusUnsupportedMediaType},
+		{"required field removed", mergePatchContentType, `{"title":null}`, request{}, http.StatusBadRequest},
+		{"unknown field", mergePatchContentType, `{"owner":1}`, request{}, http.StatusBadRequest},
+		{"wrong type", mergePatchContentType, `{"title":1}`, request{}, http.StatusBadRequest},
+		{"not an object", mergePatchContentType, `["title"]`, request{}, http.StatusBadRequest},
+		{"null patch", mergePatchContentType, `null`, request{}, http.StatusBadRequest},
+		{"invalid JSON", mergePatchContentType, `{"title":`, request{}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			ctx.Request = httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(tt.patch))
+			ctx.Request.Header.Set("Content-Type", tt.contentType)
+
+			var got request
+			ok := bindMergePatch(ctx, current, &got)
+			if tt.wantStatus != 0 {
+				if ok || w.Code != tt.wantStatus {
+					t.Errorf("bindMergePatch(%s) = %v, status %d; want status %d", tt.patch, ok, w.Code, tt.wantStatus)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("bindMergePatch(%s) failed: %d %s", tt.patch, w.Code, w.Body)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("bindMergePatch(%s) = %s, want %s", tt.patch, gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	type request struct {
+		Title    string   `json:"title"`
+		Priority string   `json:"priority"`
+		Tags     []string `json:"tags"`
+	}
+	current := request{Title: "Groceries", Priority: "low", Tags: []string{"home"}}
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    request
+		wantErr bool
+	}{
+		{"empty patch", `{}`, current, false},
+		{"one field", `{"priority":"high"}`, request{Title: "Groceries", Priority: "high", Tags: []string{"home"}}, false},
+		{"null clears", `{"tags":null}`, request{Title: "Groceries", Priority: "low"}, false},
+		{"arrays are replaced", `{"tags":["work","urgent"]}`, request{Title: "Groceries", Priority: "low", Tags: []string{"work", "urgent"}}, false},
+		{"unknown field", `{"owner":1}`, request{}, true},
+		{"wrong type", `{"title":1}`, request{}, true},
+		{"not an object", `["title"]`, request{}, true},
+		{"null patch", `null`, request{}, true},
+		{"invalid JSON", `{"title":`, request{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got request
+			err := applyMergePatch(current, json.RawMessage(tt.patch), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("applyMergePatch(%s) = %+v, want an error", tt.patch, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyMergePatch(%s): %v", tt.patch, err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("applyMergePatch(%s) = %s, want %s", tt.patch, gotJSON, wantJSON)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"time"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduleRequest is embedded in the requests that create or change todos
+// and items. Each field is either a date (2006-01-02), making the entry
+// all-day, or an RFC 3339 timestamp; see entity.ParseSchedule. Leaving a
+// field out clears it.
+type ScheduleRequest struct {
+	StartAt string `json:"start_at,omitempty"`
+	DueAt   string `json:"due_at,omitempty"`
+}
+
+func scheduleRequest(s entity.Schedule) ScheduleRequest {
+	start, due := s.Format()
+	return ScheduleRequest{StartAt: start, DueAt: due}
+}
+
+func (r ScheduleRequest) schedule() (entity.Schedule, error) {
+	return entity.ParseSchedule(r.StartAt, r.DueAt)
+}
+
+// callerLocation returns the time zone of the user making the request.
+func callerLocation(ctx *gin.Context, users entity.UserStore) *time.Location {
+	userID, _ := ctx.Get("user_id")
+	id, _ := userID.(int)
+	user, err := users.GetByID(id)
+	if err != nil {
+		return time.UTC
+	}
+	return user.Location()
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type SearchController struct {
+	// index is nil when search is turned off, see config.Config.SearchIndex.
+	index             *entity.SearchIndex
+	collaboratorModel entity.CollaboratorStore
+}
+
+func NewSearchController(index *entity.SearchIndex, collaboratorModel entity.CollaboratorStore) *SearchController {
+	return &SearchController{index: index, collaboratorModel: collaboratorModel}
+}
+
+// Search matches q against the titles and descriptions of the todos and
+// items the caller can see in the current workspace, their own and those
+// shared with them. Admins of the workspace search everyone's records and
+// can add deleted ones with deleted=true.
+func (c *SearchController) Search(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if c.index == nil {
+		ctx.JSON(http.StatusNotImplemented, gin.H{"error": "search is disabled"})
+		return
+	}
+
+	workspaceID, admin := currentWorkspace(ctx)
+
+	q := entity.SearchQuery{
+		Text:        ctx.Query("q"),
+		WorkspaceID: workspaceID,
+		Limit:       defaultSearchLimit,
+	}
+
+	switch kind := ctx.Query("type"); kind {
+	case "", entity.SearchTodo, entity.SearchItem:
+		q.Kind = kind
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "type must be todo or item"})
+		return
+	}
+
+	if raw := ctx.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxSearchLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxSearchLimit)})
+			return
+		}
+		q.Limit = limit
+	}
+
+	if admin {
+		deleted, ok := parseBoolParam(ctx, "deleted")
+		if !ok {
+			return
+		}
+		q.IncludeDeleted = deleted != nil && *deleted
+	} else {
+		q.UserID = userID.(int)
+		shared, err := entity.SharedTodoIDs(c.collaboratorModel, q.UserID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		q.SharedTodoIDs = shared
+	}
+
+	results, err := c.index.Search(q)
+	if err != nil {
+		if errors.Is(err, entity.ErrEmptySearch) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
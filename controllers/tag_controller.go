@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TagController struct {
+	tagModel      entity.TagStore
+	todoModel     entity.TodoStore
+	todoItemModel entity.TodoItemStore
+	unitOfWork    entity.UnitOfWork
+}
+
+func NewTagController(tagModel entity.TagStore, todoModel entity.TodoStore, todoItemModel entity.TodoItemStore, unitOfWork entity.UnitOfWork) *TagController {
+	return &TagController{
+		tagModel:      tagModel,
+		todoModel:     todoModel,
+		todoItemModel: todoItemModel,
+		unitOfWork:    unitOfWork,
+	}
+}
+
+type TagRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type MergeTagRequest struct {
+	Into int `json:"into" binding:"required"`
+}
+
+// usage adds the usage counts to tags, which all belong to userID. Tags
+// belong to users across workspaces, but only the records of the current
+// workspace are counted. ok is false if the records couldn't be read; the
+// error response has then already been written.
+func (c *TagController) usage(ctx *gin.Context, userID int, tags []*entity.Tag) (usage []*entity.TagUsage, ok bool) {
+	workspaceID, _ := currentWorkspace(ctx)
+	todos, err := c.todoModel.GetByUserID(workspaceID, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	var items []*entity.TodoItem
+	for _, todo := range todos {
+		todoItems, err := c.todoItemModel.GetByTodoID(todo.ID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		items = append(items, todoItems...)
+	}
+	return entity.CountTagUsage(tags, todos, items), true
+}
+
+// GetAll lists the caller's tags by name, with how many live todos and items
+// carry each. Admins can pass owner to see another user's tags.
+func (c *TagController) GetAll(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userRole, _ := ctx.Get("user_role")
+
+	owner := userID.(int)
+	if raw := ctx.Query("owner"); raw != "" && userRole == "admin" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner"})
+			return
+		}
+		owner = id
+	}
+
+	tags, err := c.tagModel.GetByUserID(owner)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if usage, ok := c.usage(ctx, owner, tags); ok {
+		ctx.JSON(http.StatusOK, usage)
+	}
+}
+
+// Create adds a tag for the caller without putting it on anything yet.
+func (c *TagController) Create(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req TagRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, err := entity.NormalizeTag(req.Name)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag := &entity.Tag{UserID: userID.(int), Name: name}
+	if err := c.tagModel.Create(tag); err != nil {
+		if errors.Is(err, entity.ErrTagExists) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(ctx, tag.Version)
+	ctx.JSON(http.StatusCreated, &entity.TagUsage{Tag: tag})
+}
+
+// load returns the tag named in the URL if the caller may see it. ok is false
+// if not; the error response has then already been written.
+func (c *TagController) load(ctx *gin.Context) (tag *entity.Tag, ok bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	userRole, _ := ctx.Get("user_role")
+
+	tag, err = c.tagModel.GetByID(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+		return nil, false
+	}
+
+	if tag.UserID != userID.(int) && userRole != "admin" {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return tag, true
+}
+
+func (c *TagController) GetByID(ctx *gin.Context) {
+	tag, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	setETag(ctx, tag.Version)
+	if usage, ok := c.usage(ctx, tag.UserID, []*entity.Tag{tag}); ok {
+		ctx.JSON(http.StatusOK, usage[0])
+	}
+}
+
+// Update renames a tag and, in the same transaction, every todo and item
+// that carries it.
+func (c *TagController) Update(ctx *gin.Context) {
+	tag, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, tag.Version)
+	if !ok {
+		return
+	}
+
+	var req TagRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := c.unitOfWork.Do(func(tx entity.Tx) error {
+		var err error
+		tag, err = entity.RenameTag(tx, tag.ID, version, req.Name)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, entity.ErrInvalidTag):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, entity.ErrTagNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+		case errors.Is(err, entity.ErrTagExists):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error() + "; merge the two tags instead"})
+		case errors.Is(err, entity.ErrVersionConflict):
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	setETag(ctx, tag.Version)
+	if usage, ok := c.usage(ctx, tag.UserID, []*entity.Tag{tag}); ok {
+		ctx.JSON(http.StatusOK, usage[0])
+	}
+}
+
+// Delete removes a tag from every todo and item that carries it, then
+// deletes the tag.
+func (c *TagController) Delete(ctx *gin.Context) {
+	tag, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, tag.Version); !ok {
+		return
+	}
+
+	err := c.unitOfWork.Do(func(tx entity.Tx) error {
+		return entity.DeleteTag(tx, tag.ID)
+	})
+	if err != nil {
+		if errors.Is(err, entity.ErrTagNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "tag deleted"})
+}
+
+// Merge moves every todo and item carrying the tag in the URL over to the
+// tag named by into, another tag of the same user, and deletes the first
+// one. The response is the remaining tag.
+func (c *TagController) Merge(ctx *gin.Context) {
+	tag, ok := c.load(ctx)
+	if !ok {
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, tag.Version); !ok {
+		return
+	}
+
+	var req MergeTagRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var into *entity.Tag
+	err := c.unitOfWork.Do(func(tx entity.Tx) error {
+		var err error
+		into, err = entity.MergeTags(tx, tag.ID, req.Into)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, entity.ErrTagNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+		case errors.Is(err, entity.ErrInvalidTagMerge):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	setETag(ctx, into.Version)
+	if usage, ok := c.usage(ctx, into.UserID, []*entity.Tag{into}); ok {
+		ctx.JSON(http.StatusOK, usage[0])
+	}
+}
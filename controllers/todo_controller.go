@@ -1,31 +1,51 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 	"todoapp/entity"
 
 	"github.com/gin-gonic/gin"
 )
 
 type TodoController struct {
-	todoModel *entity.TodoModel
+	todoModel         entity.TodoStore
+	userModel         entity.UserStore
+	commentModel      entity.CommentStore
+	collaboratorModel entity.CollaboratorStore
+	unitOfWork        entity.UnitOfWork
 }
 
-func NewTodoController(todoModel *entity.TodoModel) *TodoController {
+func NewTodoController(todoModel entity.TodoStore, userModel entity.UserStore, commentModel entity.CommentStore, collaboratorModel entity.CollaboratorStore, unitOfWork entity.UnitOfWork) *TodoController {
 	return &TodoController{
-		todoModel: todoModel,
+		todoModel:         todoModel,
+		userModel:         userModel,
+		commentModel:      commentModel,
+		collaboratorModel: collaboratorModel,
+		unitOfWork:        unitOfWork,
 	}
 }
 
+// CreateTodoRequest creates a todo, the first of a recurring series if RRule
+// is set; see entity/recurrence.go.
 type CreateTodoRequest struct {
-	Title       string `json:"title" binding:"required"`
-	Description string `json:"description" binding:"required"`
+	Title       string   `json:"title" binding:"required"`
+	Description string   `json:"description" binding:"required"`
+	Priority    string   `json:"priority" binding:"omitempty,oneof=none low medium high urgent"`
+	Tags        []string `json:"tags"`
+	RRule       string   `json:"rrule,omitempty"`
+	ScheduleRequest
 }
 
 type UpdateTodoRequest struct {
-	Title       string `json:"title" binding:"required"`
-	Description string `json:"description" binding:"required"`
+	Title       string   `json:"title" binding:"required"`
+	Description string   `json:"description" binding:"required"`
+	Priority    string   `json:"priority" binding:"omitempty,oneof=none low medium high urgent"`
+	Tags        []string `json:"tags"`
+	RRule       string   `json:"rrule,omitempty"`
+	ScheduleRequest
 }
 
 func (c *TodoController) Create(ctx *gin.Context) {
@@ -41,18 +61,51 @@ func (c *TodoController) Create(ctx *gin.Context) {
 		return
 	}
 
+	schedule, err := req.schedule()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := entity.NormalizeTags(req.Tags)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recurrence, err := entity.SetRecurrence(entity.Recurrence{}, entity.Schedule{}, schedule, req.RRule)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspaceID, _ := currentWorkspace(ctx)
+
 	todo := &entity.Todo{
 		Title:         req.Title,
 		Description:   req.Description,
 		UserID:        userID.(int),
+		WorkspaceID:   workspaceID,
 		CompletionPct: 0,
+		Priority:      entity.Priority(req.Priority),
+		Tags:          tags,
+		Schedule:      schedule,
+		Recurrence:    recurrence,
 	}
 
-	if err := c.todoModel.Create(todo); err != nil {
+	// Tags the caller doesn't have yet are created along with the todo
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := entity.EnsureTags(tx.Tags(), todo.UserID, todo.Tags); err != nil {
+			return err
+		}
+		return tx.Todos().Create(todo)
+	})
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(ctx, todo.Version)
 	ctx.JSON(http.StatusCreated, todo)
 }
 
@@ -63,20 +116,19 @@ func (c *TodoController) GetByID(ctx *gin.Context) {
 		return
 	}
 
-	userID, exists := ctx.Get("user_id")
-	if !exists {
+	if _, exists := ctx.Get("user_id"); !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	userRole, _ := ctx.Get("user_role")
+	workspaceID, admin := currentWorkspace(ctx)
 	var todo *entity.Todo
 	var err2 error
 
-	if userRole == "admin" {
-		todo, err2 = c.todoModel.GetByIDWithDeleted(id)
+	if admin {
+		todo, err2 = c.todoModel.GetByIDInWorkspaceWithDeleted(id, workspaceID)
 	} else {
-		todo, err2 = c.todoModel.GetByID(id)
+		todo, err2 = c.todoModel.GetByIDInWorkspace(id, workspaceID)
 	}
 
 	if err2 != nil {
@@ -84,11 +136,15 @@ func (c *TodoController) GetByID(ctx *gin.Context) {
 		return
 	}
 
-	if todo.UserID != userID.(int) && userRole != "admin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionView) {
 		return
 	}
 
+	if err := setCommentCounts(c.commentModel, todo); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setETag(ctx, todo.Version)
 	ctx.JSON(http.StatusOK, todo)
 }
 
@@ -99,39 +155,156 @@ func (c *TodoController) GetAll(ctx *gin.Context) {
 		return
 	}
 
-	userRole, _ := ctx.Get("user_role")
+	workspaceID, admin := currentWorkspace(ctx)
 
+	opts, ok := parseListOptions(ctx)
+	if !ok {
+		return
+	}
+	var filter entity.TodoFilter
+	if filter.MinCompletion, ok = parseFloatParam(ctx, "completion_min"); !ok {
+		return
+	}
+	if filter.MaxCompletion, ok = parseFloatParam(ctx, "completion_max"); !ok {
+		return
+	}
+	if filter.Created, ok = parseTimeRange(ctx, "created"); !ok {
+		return
+	}
+	if filter.Updated, ok = parseTimeRange(ctx, "updated"); !ok {
+		return
+	}
+	if filter.Due, ok = parseDueFilter(ctx, callerLocation(ctx, c.userModel)); !ok {
+		return
+	}
+	if filter.Tags, ok = parseTagFilter(ctx); !ok {
+		return
+	}
+
+	// Only admins of the workspace can see other users' todos and deleted
+	// ones. Everyone else sees their own todos and those shared with them.
+	// Either way the list ends at the workspace.
 	var todos []*entity.Todo
-	if userRole == "admin" {
-		todos = c.todoModel.GetAllWithDeleted()
+	var err error
+	if admin {
+		if filter.Deleted, ok = parseBoolParam(ctx, "deleted"); !ok {
+			return
+		}
+		if raw := ctx.Query("owner"); raw != "" {
+			owner, err := strconv.Atoi(raw)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner"})
+				return
+			}
+			todos, err = c.todoModel.GetByUserIDWithDeleted(workspaceID, owner)
+		} else {
+			todos, err = c.todoModel.GetByWorkspaceIDWithDeleted(workspaceID)
+		}
 	} else {
-		todos = c.todoModel.GetByUserID(userID.(int))
+		var shared []*entity.Todo
+		todos, err = c.todoModel.GetByUserID(workspaceID, userID.(int))
+		if err == nil {
+			shared, err = entity.SharedTodos(c.todoModel, c.collaboratorModel, workspaceID, userID.(int))
+			todos = append(todos, shared...)
+		}
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	ctx.JSON(http.StatusOK, todos)
+	page, next, err := entity.ListTodos(todos, filter, opts)
+	if err == nil {
+		if err := setCommentCounts(c.commentModel, page...); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	writeList(ctx, page, next, err)
 }
 
 func (c *TodoController) Update(ctx *gin.Context) {
+	c.update(ctx, func(todo *entity.Todo) bool {
+		var req UpdateTodoRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		return applyTodoRequest(ctx, todo, req)
+	})
+}
+
+// Patch changes only the fields named in a JSON Merge Patch (see patch.go).
+func (c *TodoController) Patch(ctx *gin.Context) {
+	c.update(ctx, func(todo *entity.Todo) bool {
+		current := updateTodoRequest(todo)
+		var req UpdateTodoRequest
+		if !bindMergePatch(ctx, current, &req) {
+			return false
+		}
+		return applyTodoRequest(ctx, todo, req)
+	})
+}
+
+func applyTodoRequest(ctx *gin.Context, todo *entity.Todo, req UpdateTodoRequest) bool {
+	schedule, err := req.schedule()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	tags, err := entity.NormalizeTags(req.Tags)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	recurrence, err := entity.SetRecurrence(todo.Recurrence, todo.Schedule, schedule, req.RRule)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	todo.Title = req.Title
+	todo.Description = req.Description
+	todo.Priority = entity.Priority(req.Priority)
+	todo.Tags = tags
+	todo.Schedule = schedule
+	todo.Recurrence = recurrence
+	return true
+}
+
+func updateTodoRequest(todo *entity.Todo) UpdateTodoRequest {
+	return UpdateTodoRequest{
+		Title:           todo.Title,
+		Description:     todo.Description,
+		Priority:        string(todo.Priority),
+		Tags:            todo.Tags,
+		RRule:           todo.RRule,
+		ScheduleRequest: scheduleRequest(todo.Schedule),
+	}
+}
+
+// update loads the todo named in the URL, checks that the caller may change
+// it and saves it after apply has copied the request into it. apply writes
+// the error response itself when it returns false.
+func (c *TodoController) update(ctx *gin.Context, apply func(todo *entity.Todo) bool) {
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
 
-	userID, exists := ctx.Get("user_id")
-	if !exists {
+	if _, exists := ctx.Get("user_id"); !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	userRole, _ := ctx.Get("user_role")
+	workspaceID, admin := currentWorkspace(ctx)
 
 	var todo *entity.Todo
 	var err2 error
-	if userRole == "admin" {
-		todo, err2 = c.todoModel.GetByIDWithDeleted(id)
+	if admin {
+		todo, err2 = c.todoModel.GetByIDInWorkspaceWithDeleted(id, workspaceID)
 	} else {
-		todo, err2 = c.todoModel.GetByID(id)
+		todo, err2 = c.todoModel.GetByIDInWorkspace(id, workspaceID)
 	}
 
 	if err2 != nil {
@@ -139,25 +312,40 @@ func (c *TodoController) Update(ctx *gin.Context) {
 		return
 	}
 
-	if todo.UserID != userID.(int) && userRole != "admin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionEdit) {
 		return
 	}
 
-	var req UpdateTodoRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	version, ok := checkIfMatch(ctx, todo.Version)
+	if !ok {
 		return
 	}
 
-	todo.Title = req.Title
-	todo.Description = req.Description
-
-	if err := c.todoModel.Update(todo); err != nil {
+	if !apply(todo) {
+		return
+	}
+	todo.Version = version
+
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := entity.EnsureTags(tx.Tags(), todo.UserID, todo.Tags); err != nil {
+			return err
+		}
+		return tx.Todos().Update(todo)
+	})
+	if err != nil {
+		if errors.Is(err, entity.ErrVersionConflict) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := setCommentCounts(c.commentModel, todo); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setETag(ctx, todo.Version)
 	ctx.JSON(http.StatusOK, todo)
 }
 
@@ -168,36 +356,201 @@ func (c *TodoController) Delete(ctx *gin.Context) {
 		return
 	}
 
-	userID, exists := ctx.Get("user_id")
-	if !exists {
+	if _, exists := ctx.Get("user_id"); !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	userRole, _ := ctx.Get("user_role")
+	workspaceID, admin := currentWorkspace(ctx)
 
 	var todo *entity.Todo
-	var err2 error
-	if userRole == "admin" {
-		todo, err2 = c.todoModel.GetByIDWithDeleted(id)
+	if admin {
+		todo, err = c.todoModel.GetByIDInWorkspaceWithDeleted(id, workspaceID)
 	} else {
-		todo, err2 = c.todoModel.GetByID(id)
+		todo, err = c.todoModel.GetByIDInWorkspace(id, workspaceID)
 	}
 
-	if err2 != nil {
+	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
 		return
 	}
 
-	if todo.UserID != userID.(int) && userRole != "admin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionOwn) {
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, todo.Version)
+	if !ok {
 		return
 	}
 
-	if err := c.todoModel.Delete(id); err != nil {
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := tx.Todos().Delete(id); err != nil {
+			return err
+		}
+		deleted, err := tx.Todos().GetByIDWithDeleted(id)
+		if err != nil {
+			return err
+		}
+		return checkChangedOnce(version, deleted.Version)
+	})
+	if err != nil {
+		// An admin may see a todo that is already deleted, or someone else
+		// deleted it in the meantime.
+		if errors.Is(err, entity.ErrTodoNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+			return
+		}
+		if errors.Is(err, entity.ErrVersionConflict) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "todo deleted"})
 }
+
+func (c *TodoController) Restore(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	workspaceID, _ := currentWorkspace(ctx)
+	todo, err := c.todoModel.GetByIDInWorkspaceWithDeleted(id, workspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionOwn) {
+		return
+	}
+
+	if todo.DeletedAt == nil {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "todo is not deleted"})
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, todo.Version)
+	if !ok {
+		return
+	}
+
+	withItems := ctx.Query("with_items") == "true"
+
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := tx.Todos().Restore(id); err != nil {
+			return err
+		}
+		restored, err := tx.Todos().GetByID(id)
+		if err != nil {
+			return err
+		}
+		if err := checkChangedOnce(version, restored.Version); err != nil {
+			return err
+		}
+		if withItems {
+			items, err := tx.Items().GetByTodoIDWithDeleted(id)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				// Items deleted before the todo were removed on their own
+				// and stay in the trash
+				if item.DeletedAt == nil || item.DeletedAt.Before(*todo.DeletedAt) {
+					continue
+				}
+				if err := tx.Items().Restore(item.ID); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Todos().UpdateCompletionPct(id, tx.Items())
+	})
+	if err != nil {
+		if errors.Is(err, entity.ErrTodoNotFound) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "todo is not deleted"})
+			return
+		}
+		if errors.Is(err, entity.ErrVersionConflict) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	todo, err = c.todoModel.GetByIDInWorkspace(id, workspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := setCommentCounts(c.commentModel, todo); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setETag(ctx, todo.Version)
+	ctx.JSON(http.StatusOK, todo)
+}
+
+// Skip drops one occurrence of a recurring todo. The todo is deleted like
+// DELETE does; if it was the head of its series the next occurrence is
+// created first, so the series goes on. The response holds that next
+// occurrence, or null.
+func (c *TodoController) Skip(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	workspaceID, _ := currentWorkspace(ctx)
+	todo, err := c.todoModel.GetByIDInWorkspace(id, workspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionEdit) {
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, todo.Version); !ok {
+		return
+	}
+
+	var next *entity.Todo
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		var err error
+		next, err = entity.SkipTodo(tx, id, time.Now())
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, entity.ErrNotRecurring):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, entity.ErrTodoNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "occurrence skipped", "next": next})
+}
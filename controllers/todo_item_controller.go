@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"todoapp/entity"
 
@@ -10,26 +13,65 @@ import (
 )
 
 type TodoItemController struct {
-	todoItemModel *entity.TodoItemModel
-	todoModel     *entity.TodoModel
+	todoItemModel     entity.TodoItemStore
+	todoModel         entity.TodoStore
+	userModel         entity.UserStore
+	collaboratorModel entity.CollaboratorStore
+	unitOfWork        entity.UnitOfWork
+	maxDepth          int
 }
 
-func NewTodoItemController(todoItemModel *entity.TodoItemModel, todoModel *entity.TodoModel) *TodoItemController {
+func NewTodoItemController(todoItemModel entity.TodoItemStore, todoModel entity.TodoStore, userModel entity.UserStore, collaboratorModel entity.CollaboratorStore, unitOfWork entity.UnitOfWork, maxDepth int) *TodoItemController {
 	return &TodoItemController{
-		todoItemModel: todoItemModel,
-		todoModel:     todoModel,
+		todoItemModel:     todoItemModel,
+		todoModel:         todoModel,
+		userModel:         userModel,
+		collaboratorModel: collaboratorModel,
+		unitOfWork:        unitOfWork,
+		maxDepth:          maxDepth,
 	}
 }
 
+// CreateTodoItemRequest creates an item, as a sub-item of ParentItemID if
+// that is set and as the first of a recurring series if RRule is.
 type CreateTodoItemRequest struct {
-	Title       string `json:"title" binding:"required"`
-	Description string `json:"description" binding:"required"`
+	Title        string   `json:"title" binding:"required"`
+	Description  string   `json:"description" binding:"required"`
+	Priority     string   `json:"priority" binding:"omitempty,oneof=none low medium high urgent"`
+	Tags         []string `json:"tags"`
+	RRule        string   `json:"rrule,omitempty"`
+	ParentItemID *int     `json:"parent_item_id"`
+	ScheduleRequest
 }
 
 type UpdateTodoItemRequest struct {
 	Completed bool `json:"completed"`
 }
 
+// PatchTodoItemRequest holds the fields a PATCH can change. Unlike a PUT, it
+// covers the title, description, tags, dates and recurrence too.
+type PatchTodoItemRequest struct {
+	Title       string   `json:"title" binding:"required"`
+	Description string   `json:"description" binding:"required"`
+	Completed   bool     `json:"completed"`
+	Priority    string   `json:"priority" binding:"omitempty,oneof=none low medium high urgent"`
+	Tags        []string `json:"tags"`
+	RRule       string   `json:"rrule,omitempty"`
+	ScheduleRequest
+}
+
+func patchTodoItemRequest(item *entity.TodoItem) PatchTodoItemRequest {
+	return PatchTodoItemRequest{
+		Title:           item.Title,
+		Description:     item.Description,
+		Completed:       item.Completed,
+		Priority:        string(item.Priority),
+		Tags:            item.Tags,
+		RRule:           item.RRule,
+		ScheduleRequest: scheduleRequest(item.Schedule),
+	}
+}
+
 func (c *TodoItemController) Create(ctx *gin.Context) {
 	todoID, err := strconv.Atoi(ctx.Param("todo_id"))
 	if err != nil {
@@ -43,14 +85,14 @@ func (c *TodoItemController) Create(ctx *gin.Context) {
 		return
 	}
 
-	userRole, _ := ctx.Get("user_role")
+	workspaceID, admin := currentWorkspace(ctx)
 
 	var todo *entity.Todo
 	var err2 error
-	if userRole == "admin" {
-		todo, err2 = c.todoModel.GetByIDWithDeleted(todoID)
+	if admin {
+		todo, err2 = c.todoModel.GetByIDInWorkspaceWithDeleted(todoID, workspaceID)
 	} else {
-		todo, err2 = c.todoModel.GetByID(todoID)
+		todo, err2 = c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
 	}
 
 	if err2 != nil {
@@ -58,8 +100,7 @@ func (c *TodoItemController) Create(ctx *gin.Context) {
 		return
 	}
 
-	if todo.UserID != userID.(int) && userRole != "admin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionEdit) {
 		return
 	}
 
@@ -69,24 +110,60 @@ func (c *TodoItemController) Create(ctx *gin.Context) {
 		return
 	}
 
-	item := &entity.TodoItem{
-		Title:       req.Title,
-		Description: req.Description,
-		TodoID:      todoID,
-		UserID:      userID.(int),
+	schedule, err := req.schedule()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if err := c.todoItemModel.Create(item); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	tags, err := entity.NormalizeTags(req.Tags)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recurrence, err := entity.SetRecurrence(entity.Recurrence{}, entity.Schedule{}, schedule, req.RRule)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Update todo completion percentage
-	if err := c.todoModel.UpdateCompletionPct(todoID, c.todoItemModel); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update todo completion percentage"})
+	item := &entity.TodoItem{
+		Title:        req.Title,
+		Description:  req.Description,
+		TodoID:       todoID,
+		ParentItemID: req.ParentItemID,
+		UserID:       userID.(int),
+		Priority:     entity.Priority(req.Priority),
+		Tags:         tags,
+		Schedule:     schedule,
+		Recurrence:   recurrence,
+	}
+
+	// Create the item and update the todo completion percentage atomically.
+	// Item tags belong to the todo's owner.
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := entity.CheckItemParent(tx.Items(), todoID, item.ParentItemID, c.maxDepth); err != nil {
+			return err
+		}
+		if err := entity.EnsureTags(tx.Tags(), todo.UserID, item.Tags); err != nil {
+			return err
+		}
+		if err := tx.Items().Create(item); err != nil {
+			return err
+		}
+		return tx.Todos().UpdateCompletionPct(todoID, tx.Items())
+	})
+	if err != nil {
+		if errors.Is(err, entity.ErrInvalidParentItem) || errors.Is(err, entity.ErrItemTooDeep) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(ctx, item.Version)
 	ctx.JSON(http.StatusCreated, item)
 }
 
@@ -97,20 +174,19 @@ func (c *TodoItemController) GetByTodoID(ctx *gin.Context) {
 		return
 	}
 
-	userID, exists := ctx.Get("user_id")
-	if !exists {
+	if _, exists := ctx.Get("user_id"); !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	userRole, _ := ctx.Get("user_role")
+	workspaceID, admin := currentWorkspace(ctx)
 
 	var todo *entity.Todo
 	var err2 error
-	if userRole == "admin" {
-		todo, err2 = c.todoModel.GetByIDWithDeleted(todoID)
+	if admin {
+		todo, err2 = c.todoModel.GetByIDInWorkspaceWithDeleted(todoID, workspaceID)
 	} else {
-		todo, err2 = c.todoModel.GetByID(todoID)
+		todo, err2 = c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
 	}
 
 	if err2 != nil {
@@ -118,22 +194,99 @@ func (c *TodoItemController) GetByTodoID(ctx *gin.Context) {
 		return
 	}
 
-	if todo.UserID != userID.(int) && userRole != "admin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionView) {
+		return
+	}
+
+	opts, ok := parseListOptions(ctx)
+	if !ok {
+		return
+	}
+	var filter entity.TodoItemFilter
+	if filter.Completed, ok = parseBoolParam(ctx, "completed"); !ok {
+		return
+	}
+	if filter.Created, ok = parseTimeRange(ctx, "created"); !ok {
+		return
+	}
+	if filter.Updated, ok = parseTimeRange(ctx, "updated"); !ok {
+		return
+	}
+	if filter.Due, ok = parseDueFilter(ctx, callerLocation(ctx, c.userModel)); !ok {
+		return
+	}
+	if filter.Tags, ok = parseTagFilter(ctx); !ok {
 		return
 	}
 
 	var items []*entity.TodoItem
-	if userRole == "admin" {
-		items = c.todoItemModel.GetByTodoIDWithDeleted(todoID)
+	if admin {
+		if filter.Deleted, ok = parseBoolParam(ctx, "deleted"); !ok {
+			return
+		}
+		items, err = c.todoItemModel.GetByTodoIDWithDeleted(todoID)
 	} else {
-		items = c.todoItemModel.GetByTodoID(todoID)
+		items, err = c.todoItemModel.GetByTodoID(todoID)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	ctx.JSON(http.StatusOK, items)
+	page, next, err := entity.ListTodoItems(items, filter, opts)
+	writeList(ctx, page, next, err)
 }
 
 func (c *TodoItemController) Update(ctx *gin.Context) {
+	c.update(ctx, func(item *entity.TodoItem) bool {
+		var req UpdateTodoItemRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		item.Completed = req.Completed
+		return true
+	})
+}
+
+// Patch changes only the fields named in a JSON Merge Patch (see patch.go).
+func (c *TodoItemController) Patch(ctx *gin.Context) {
+	c.update(ctx, func(item *entity.TodoItem) bool {
+		var req PatchTodoItemRequest
+		if !bindMergePatch(ctx, patchTodoItemRequest(item), &req) {
+			return false
+		}
+		schedule, err := req.schedule()
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		tags, err := entity.NormalizeTags(req.Tags)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		recurrence, err := entity.SetRecurrence(item.Recurrence, item.Schedule, schedule, req.RRule)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		item.Title = req.Title
+		item.Description = req.Description
+		item.Completed = req.Completed
+		item.Priority = entity.Priority(req.Priority)
+		item.Tags = tags
+		item.Schedule = schedule
+		item.Recurrence = recurrence
+		return true
+	})
+}
+
+// update loads the item named in the URL, checks that the caller may change
+// it and saves it after apply has copied the request into it, recomputing
+// the todo's completion in the same transaction. Completing the head of a
+// recurring series creates the next occurrence.
+func (c *TodoItemController) update(ctx *gin.Context, apply func(item *entity.TodoItem) bool) {
 	todoID, err := strconv.Atoi(ctx.Param("todo_id"))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid todo id"})
@@ -146,20 +299,19 @@ func (c *TodoItemController) Update(ctx *gin.Context) {
 		return
 	}
 
-	userID, exists := ctx.Get("user_id")
-	if !exists {
+	if _, exists := ctx.Get("user_id"); !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	userRole, _ := ctx.Get("user_role")
+	workspaceID, admin := currentWorkspace(ctx)
 
 	var todo *entity.Todo
 	var err2 error
-	if userRole == "admin" {
-		todo, err2 = c.todoModel.GetByIDWithDeleted(todoID)
+	if admin {
+		todo, err2 = c.todoModel.GetByIDInWorkspaceWithDeleted(todoID, workspaceID)
 	} else {
-		todo, err2 = c.todoModel.GetByID(todoID)
+		todo, err2 = c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
 	}
 
 	if err2 != nil {
@@ -167,42 +319,58 @@ func (c *TodoItemController) Update(ctx *gin.Context) {
 		return
 	}
 
-	if todo.UserID != userID.(int) && userRole != "admin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionEdit) {
 		return
 	}
 
 	var item *entity.TodoItem
 	var err3 error
-	if userRole == "admin" {
+	if admin {
 		item, err3 = c.todoItemModel.GetByIDWithDeleted(itemID)
 	} else {
 		item, err3 = c.todoItemModel.GetByID(itemID)
 	}
 
-	if err3 != nil {
+	if err3 != nil || item.TodoID != todoID {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
 		return
 	}
 
-	var req UpdateTodoItemRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	version, ok := checkIfMatch(ctx, item.Version)
+	if !ok {
 		return
 	}
 
-	item.Completed = req.Completed
-
-	if err := c.todoItemModel.Update(item); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	wasCompleted := item.Completed
+	if !apply(item) {
 		return
 	}
-
-	if err := c.todoModel.UpdateCompletionPct(todoID, c.todoItemModel); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update todo completion percentage"})
+	item.Version = version
+
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := entity.EnsureTags(tx.Tags(), todo.UserID, item.Tags); err != nil {
+			return err
+		}
+		if err := tx.Items().Update(item); err != nil {
+			return err
+		}
+		if err := entity.CompleteTodoItem(tx, item, wasCompleted, time.Now()); err != nil {
+			return err
+		}
+		// Moving the series on changes the item too.
+		item, err = tx.Items().GetByIDWithDeleted(item.ID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, entity.ErrVersionConflict) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(ctx, item.Version)
 	ctx.JSON(http.StatusOK, item)
 }
 
@@ -219,20 +387,19 @@ func (c *TodoItemController) Delete(ctx *gin.Context) {
 		return
 	}
 
-	userID, exists := ctx.Get("user_id")
-	if !exists {
+	if _, exists := ctx.Get("user_id"); !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	userRole, _ := ctx.Get("user_role")
+	workspaceID, admin := currentWorkspace(ctx)
 
 	var todo *entity.Todo
 	var err2 error
-	if userRole == "admin" {
-		todo, err2 = c.todoModel.GetByIDWithDeleted(todoID)
+	if admin {
+		todo, err2 = c.todoModel.GetByIDInWorkspaceWithDeleted(todoID, workspaceID)
 	} else {
-		todo, err2 = c.todoModel.GetByID(todoID)
+		todo, err2 = c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
 	}
 
 	if err2 != nil {
@@ -241,32 +408,353 @@ func (c *TodoItemController) Delete(ctx *gin.Context) {
 	}
 
 	// Check if user is the owner or admin
-	if todo.UserID != userID.(int) && userRole != "admin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionEdit) {
 		return
 	}
 
+	var item *entity.TodoItem
 	var err3 error
-	if userRole == "admin" {
-		_, err3 = c.todoItemModel.GetByIDWithDeleted(itemID)
+	if admin {
+		item, err3 = c.todoItemModel.GetByIDWithDeleted(itemID)
 	} else {
-		_, err3 = c.todoItemModel.GetByID(itemID)
+		item, err3 = c.todoItemModel.GetByID(itemID)
 	}
 
-	if err3 != nil {
+	if err3 != nil || item.TodoID != todoID {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
 		return
 	}
 
-	if err := c.todoItemModel.Delete(itemID); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	version, ok := checkIfMatch(ctx, item.Version)
+	if !ok {
 		return
 	}
 
-	if err := c.todoModel.UpdateCompletionPct(todoID, c.todoItemModel); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update todo completion percentage"})
+	// Sub-items are deleted along with the item
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := entity.DeleteTodoItem(tx.Items(), itemID); err != nil {
+			return err
+		}
+		deleted, err := tx.Items().GetByIDWithDeleted(itemID)
+		if err != nil {
+			return err
+		}
+		if err := checkChangedOnce(version, deleted.Version); err != nil {
+			return err
+		}
+		_, err = entity.RecomputeCompletion(tx, todoID, time.Now())
+		return err
+	})
+	if err != nil {
+		// An admin may see an item that is already deleted, or someone else
+		// deleted it in the meantime.
+		if errors.Is(err, entity.ErrTodoItemNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+			return
+		}
+		if errors.Is(err, entity.ErrVersionConflict) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "todo item deleted"})
 }
+
+func (c *TodoItemController) Restore(ctx *gin.Context) {
+	todoID, err := strconv.Atoi(ctx.Param("todo_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid todo id"})
+		return
+	}
+
+	itemID, err := strconv.Atoi(ctx.Param("item_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+		return
+	}
+
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	workspaceID, _ := currentWorkspace(ctx)
+	// Items can only be restored into a live todo; restore the todo first
+	todo, err := c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionEdit) {
+		return
+	}
+
+	item, err := c.todoItemModel.GetByIDWithDeleted(itemID)
+	if err != nil || item.TodoID != todoID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+		return
+	}
+
+	if item.DeletedAt == nil {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "todo item is not deleted"})
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, item.Version)
+	if !ok {
+		return
+	}
+
+	// Sub-items deleted along with the item come back with it
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		if err := entity.RestoreTodoItem(tx.Items(), itemID); err != nil {
+			return err
+		}
+		restored, err := tx.Items().GetByID(itemID)
+		if err != nil {
+			return err
+		}
+		if err := checkChangedOnce(version, restored.Version); err != nil {
+			return err
+		}
+		_, err = entity.RecomputeCompletion(tx, todoID, time.Now())
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, entity.ErrTodoItemNotFound) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "todo item is not deleted"})
+			return
+		}
+		if errors.Is(err, entity.ErrParentItemDeleted) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "restore the parent item first"})
+			return
+		}
+		if errors.Is(err, entity.ErrVersionConflict) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	item, err = c.todoItemModel.GetByID(itemID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(ctx, item.Version)
+	ctx.JSON(http.StatusOK, item)
+}
+
+// MoveTodoItemRequest says where the item goes: next to a sibling, whose
+// parent it then shares, or at the end of the sub-items of ParentItemID,
+// where null means the top level. Exactly one of the three must be set.
+type MoveTodoItemRequest struct {
+	Before       *int            `json:"before"`
+	After        *int            `json:"after"`
+	ParentItemID json.RawMessage `json:"parent_item_id"`
+}
+
+// Move places an item, with its sub-items, directly before or after another
+// item of the same todo or under another parent. Only the moved item gets a
+// new position.
+func (c *TodoItemController) Move(ctx *gin.Context) {
+	todoID, err := strconv.Atoi(ctx.Param("todo_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid todo id"})
+		return
+	}
+
+	itemID, err := strconv.Atoi(ctx.Param("item_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+		return
+	}
+
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	workspaceID, _ := currentWorkspace(ctx)
+	todo, err := c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionEdit) {
+		return
+	}
+
+	item, err := c.todoItemModel.GetByID(itemID)
+	if err != nil || item.TodoID != todoID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, item.Version)
+	if !ok {
+		return
+	}
+
+	var req MoveTodoItemRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	set := 0
+	for _, given := range []bool{req.Before != nil, req.After != nil, req.ParentItemID != nil} {
+		if given {
+			set++
+		}
+	}
+	if set != 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of before, after and parent_item_id is required"})
+		return
+	}
+
+	to := entity.ItemPlacement{After: req.After != nil}
+	switch {
+	case req.After != nil:
+		to.SiblingID = *req.After
+	case req.Before != nil:
+		to.SiblingID = *req.Before
+	default:
+		if err := json.Unmarshal(req.ParentItemID, &to.ParentItemID); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "parent_item_id must be an item id or null"})
+			return
+		}
+	}
+
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		item, err = entity.MoveTodoItem(tx.Items(), itemID, version, to, c.maxDepth)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, entity.ErrTodoItemNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+		case errors.Is(err, entity.ErrInvalidPosition):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "sibling must be another item of the same todo"})
+		case errors.Is(err, entity.ErrInvalidParentItem), errors.Is(err, entity.ErrItemTooDeep):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, entity.ErrVersionConflict):
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	setETag(ctx, item.Version)
+	ctx.JSON(http.StatusOK, item)
+}
+
+// Tree returns the items of a todo nested under their parents, each with the
+// completion of the leaves below it.
+func (c *TodoItemController) Tree(ctx *gin.Context) {
+	todoID, err := strconv.Atoi(ctx.Param("todo_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid todo id"})
+		return
+	}
+
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	workspaceID, admin := currentWorkspace(ctx)
+
+	var todo *entity.Todo
+	var err2 error
+	if admin {
+		todo, err2 = c.todoModel.GetByIDInWorkspaceWithDeleted(todoID, workspaceID)
+	} else {
+		todo, err2 = c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
+	}
+
+	if err2 != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionView) {
+		return
+	}
+
+	items, err := c.todoItemModel.GetByTodoID(todoID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, entity.BuildItemTree(items))
+}
+
+// Skip drops one occurrence of a recurring item, like SkipTodo does for
+// todos.
+func (c *TodoItemController) Skip(ctx *gin.Context) {
+	todoID, err := strconv.Atoi(ctx.Param("todo_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid todo id"})
+		return
+	}
+
+	itemID, err := strconv.Atoi(ctx.Param("item_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+		return
+	}
+
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	workspaceID, _ := currentWorkspace(ctx)
+	todo, err := c.todoModel.GetByIDInWorkspace(todoID, workspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+		return
+	}
+
+	if !authorizeTodo(ctx, c.collaboratorModel, todo, entity.PermissionEdit) {
+		return
+	}
+
+	item, err := c.todoItemModel.GetByID(itemID)
+	if err != nil || item.TodoID != todoID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, item.Version); !ok {
+		return
+	}
+
+	var next *entity.TodoItem
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		var err error
+		next, err = entity.SkipTodoItem(tx, itemID, time.Now())
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, entity.ErrNotRecurring):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, entity.ErrTodoItemNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "todo item not found"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "occurrence skipped", "next": next})
+}
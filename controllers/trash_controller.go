@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrashController struct {
+	todoModel     entity.TodoStore
+	todoItemModel entity.TodoItemStore
+	janitor       *entity.Janitor
+}
+
+func NewTrashController(todoModel entity.TodoStore, todoItemModel entity.TodoItemStore, janitor *entity.Janitor) *TrashController {
+	return &TrashController{
+		todoModel:     todoModel,
+		todoItemModel: todoItemModel,
+		janitor:       janitor,
+	}
+}
+
+// GetAll lists the soft-deleted todos and items the caller can restore,
+// most recently deleted first, in the current workspace. Admins of the
+// workspace see every member's trash.
+func (c *TrashController) GetAll(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	workspaceID, admin := currentWorkspace(ctx)
+
+	todos := make([]*entity.Todo, 0)
+	items := make([]*entity.TodoItem, 0)
+
+	var owned []*entity.Todo
+	var err error
+	if admin {
+		owned, err = c.todoModel.GetByWorkspaceIDWithDeleted(workspaceID)
+	} else {
+		owned, err = c.todoModel.GetByUserIDWithDeleted(workspaceID, userID.(int))
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, todo := range owned {
+		if todo.DeletedAt != nil {
+			todos = append(todos, todo)
+		}
+		todoItems, err := c.todoItemModel.GetByTodoIDWithDeleted(todo.ID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, item := range todoItems {
+			if item.DeletedAt != nil {
+				items = append(items, item)
+			}
+		}
+	}
+
+	sort.Slice(todos, func(i, j int) bool {
+		return todos[i].DeletedAt.After(*todos[j].DeletedAt)
+	})
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(*items[j].DeletedAt)
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{"todos": todos, "items": items})
+}
+
+// Purge removes the records that are past the retention window right away
+// instead of waiting for the janitor. With dry_run=true nothing is deleted
+// and the response lists what would have been.
+func (c *TrashController) Purge(ctx *gin.Context) {
+	dryRun := ctx.Query("dry_run") == "true"
+
+	report, err := c.janitor.Purge(dryRun)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
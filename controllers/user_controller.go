@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -10,25 +11,38 @@ import (
 )
 
 type UserController struct {
-	userModel *entity.UserModel
+	userModel      entity.UserStore
+	workspaceModel entity.WorkspaceStore
+	unitOfWork     entity.UnitOfWork
+	deletePolicy   string
 }
 
-func NewUserController(userModel *entity.UserModel) *UserController {
+// NewUserController takes the action applied to a deleted user's todos when
+// the request doesn't name one (see entity.UserDeletePolicy).
+func NewUserController(userModel entity.UserStore, workspaceModel entity.WorkspaceStore, unitOfWork entity.UnitOfWork, deletePolicy string) *UserController {
 	return &UserController{
-		userModel: userModel,
+		userModel:      userModel,
+		workspaceModel: workspaceModel,
+		unitOfWork:     unitOfWork,
+		deletePolicy:   deletePolicy,
 	}
 }
 
+// TimeZone in the requests below is an IANA name such as "Europe/Istanbul";
+// empty means UTC.
+
 type CreateUserRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	Role     string `json:"role" binding:"required"`
+	TimeZone string `json:"time_zone"`
 }
 
 type UpdateUserRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password"`
 	Role     string `json:"role" binding:"required"`
+	TimeZone string `json:"time_zone"`
 }
 
 func (c *UserController) Create(ctx *gin.Context) {
@@ -38,22 +52,43 @@ func (c *UserController) Create(ctx *gin.Context) {
 		return
 	}
 
+	// Anyone may sign up, but only admins can make other admins
+	if userRole, _ := ctx.Get("user_role"); req.Role == "admin" && userRole != "admin" {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+		return
+	}
+
 	if _, err := c.userModel.GetByUsername(req.Username); err == nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "username already exists"})
 		return
 	}
 
+	if _, err := entity.LoadTimeZone(req.TimeZone); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	user := &entity.User{
 		Username: req.Username,
 		Password: req.Password,
 		Role:     req.Role,
+		TimeZone: req.TimeZone,
 	}
 
-	if err := c.userModel.Create(user); err != nil {
+	// New users join the default workspace, see entity.RegisterUser
+	err := c.unitOfWork.Do(func(tx entity.Tx) error {
+		return entity.RegisterUser(tx, user)
+	})
+	if err != nil {
+		if errors.Is(err, entity.ErrUsernameExists) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(ctx, user.Version)
 	ctx.JSON(http.StatusCreated, user)
 }
 
@@ -70,9 +105,37 @@ func (c *UserController) GetByID(ctx *gin.Context) {
 		return
 	}
 
+	if !c.authorizeLookup(ctx, user) {
+		return
+	}
+
+	setETag(ctx, user.Version)
 	ctx.JSON(http.StatusOK, user)
 }
 
+// authorizeLookup lets admins of the instance look up anyone, and other
+// users themselves and the members of the workspace they work in. Everyone
+// else is reported as not found. ok is false if the caller may not see user;
+// the error response has then already been written.
+func (c *UserController) authorizeLookup(ctx *gin.Context, user *entity.User) (ok bool) {
+	userID, _ := ctx.Get("user_id")
+	userRole, _ := ctx.Get("user_role")
+	if userRole == "admin" || userID == user.ID {
+		return true
+	}
+
+	workspaceID, _ := currentWorkspace(ctx)
+	if _, err := c.workspaceModel.GetMember(workspaceID, user.ID); err != nil {
+		if errors.Is(err, entity.ErrMemberNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return false
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
 func (c *UserController) GetByUsername(ctx *gin.Context) {
 	username := ctx.Param("username")
 	user, err := c.userModel.GetByUsername(username)
@@ -81,51 +144,136 @@ func (c *UserController) GetByUsername(ctx *gin.Context) {
 		return
 	}
 
+	if !c.authorizeLookup(ctx, user) {
+		return
+	}
+
+	setETag(ctx, user.Version)
 	ctx.JSON(http.StatusOK, user)
 }
 
 func (c *UserController) GetAll(ctx *gin.Context) {
-	users := c.userModel.GetAll()
-	ctx.JSON(http.StatusOK, users)
+	opts, ok := parseListOptions(ctx)
+	if !ok {
+		return
+	}
+	filter := entity.UserFilter{Role: ctx.Query("role")}
+	if filter.Created, ok = parseTimeRange(ctx, "created"); !ok {
+		return
+	}
+	if filter.Updated, ok = parseTimeRange(ctx, "updated"); !ok {
+		return
+	}
+
+	users, err := c.userModel.GetAll()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	page, next, err := entity.ListUsers(users, filter, opts)
+	writeList(ctx, page, next, err)
 }
 
 func (c *UserController) Update(ctx *gin.Context) {
+	c.update(ctx, func(user *entity.User) bool {
+		var req UpdateUserRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		// An empty password leaves the stored one unchanged
+		return applyUserRequest(ctx, user, req)
+	})
+}
+
+// Patch changes only the fields named in a JSON Merge Patch (see patch.go).
+// The password is never returned, so it starts out empty and is only
+// changed when the patch sets it.
+func (c *UserController) Patch(ctx *gin.Context) {
+	c.update(ctx, func(user *entity.User) bool {
+		current := UpdateUserRequest{Username: user.Username, Role: user.Role, TimeZone: user.TimeZone}
+		var req UpdateUserRequest
+		if !bindMergePatch(ctx, current, &req) {
+			return false
+		}
+		return applyUserRequest(ctx, user, req)
+	})
+}
+
+func applyUserRequest(ctx *gin.Context, user *entity.User, req UpdateUserRequest) bool {
+	if _, err := entity.LoadTimeZone(req.TimeZone); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	user.Username = req.Username
+	user.Password = req.Password
+	user.Role = req.Role
+	user.TimeZone = req.TimeZone
+	return true
+}
+
+// update loads the user named in the URL and saves it after apply has copied
+// the request into it. Users can only change themselves, and only admins can
+// change roles.
+func (c *UserController) update(ctx *gin.Context, apply func(user *entity.User) bool) {
 	id, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
 
+	userID, _ := ctx.Get("user_id")
+	userRole, _ := ctx.Get("user_role")
+	admin := userRole == "admin"
+	if userID != id && !admin {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
 	user, err := c.userModel.GetByID(id)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
 
-	var req UpdateUserRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	version, ok := checkIfMatch(ctx, user.Version)
+	if !ok {
+		return
+	}
+
+	username, role := user.Username, user.Role
+	if !apply(user) {
 		return
 	}
 
-	if req.Username != user.Username {
-		if _, err := c.userModel.GetByUsername(req.Username); err == nil {
+	if user.Role != role && !admin {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+		return
+	}
+
+	if user.Username != username {
+		if _, err := c.userModel.GetByUsername(user.Username); err == nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "username already exists"})
 			return
 		}
 	}
 
-	user.Username = req.Username
-	if req.Password != "" {
-		user.Password = req.Password
-	}
-	user.Role = req.Role
+	user.Version = version
 
 	if err := c.userModel.Update(user); err != nil {
+		if errors.Is(err, entity.ErrUsernameExists) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, entity.ErrVersionConflict) {
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(ctx, user.Version)
 	ctx.JSON(http.StatusOK, user)
 }
 
@@ -136,8 +284,41 @@ func (c *UserController) Delete(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.userModel.Delete(id); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user, err := c.userModel.GetByID(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, user.Version); !ok {
+		return
+	}
+
+	// Reassigned todos go to the admin making the request unless another
+	// user is named
+	policy := entity.UserDeletePolicy{Action: ctx.DefaultQuery("policy", c.deletePolicy)}
+	if policy.Action == entity.UserDeleteReassign {
+		adminID, _ := ctx.Get("user_id")
+		policy.ReassignTo, _ = adminID.(int)
+		if to := ctx.Query("reassign_to"); to != "" {
+			if policy.ReassignTo, err = strconv.Atoi(to); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid reassign_to"})
+				return
+			}
+		}
+	}
+
+	if err := c.userModel.Delete(id, policy); err != nil {
+		switch {
+		case errors.Is(err, entity.ErrUserNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, entity.ErrUserHasTodos):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, entity.ErrInvalidDeletePolicy), errors.Is(err, entity.ErrInvalidReassignTarget):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
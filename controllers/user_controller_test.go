@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"todoapp/entity"
+)
+
+type userFixture struct {
+	users      *entity.UserModel
+	workspaces *entity.WorkspaceModel
+	controller *UserController
+}
+
+func newUserFixture() *userFixture {
+	items := entity.NewTodoItemModel()
+	todos := entity.NewTodoModel(items)
+	tags, collaborators := entity.NewTagModel(), entity.NewCollaboratorModel()
+	f := &userFixture{workspaces: entity.NewWorkspaceModel()}
+	f.users = entity.NewUserModel(todos, tags, collaborators, f.workspaces)
+	unitOfWork := entity.NewUnitOfWork(f.users, todos, items, tags, entity.NewCommentModel(), entity.NewAttachmentModel(), collaborators, f.workspaces)
+	f.controller = NewUserController(f.users, f.workspaces, unitOfWork, "cascade")
+	return f
+}
+
+func (f *userFixture) register(t *testing.T, username string) int {
+	t.Helper()
+	r := newTestRouter(0, "")
+	r.POST("/users", f.controller.Create)
+	w := serve(r, http.MethodPost, "/users", `{"username": "`+username+`", "password": "secret", "role": "user"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register %s: status = %d, want %d: %s", username, w.Code, http.StatusCreated, w.Body)
+	}
+	user, err := f.users.GetByUsername(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+func TestRegisterJoinsWorkspace(t *testing.T) {
+	f := newUserFixture()
+
+	// Without a workspace the first user gets one of their own.
+	alice := f.register(t, "alice")
+	if member, err := f.workspaces.GetMember(entity.DefaultWorkspaceID, alice); err != nil || member.Role != entity.WorkspaceRoleAdmin {
+		t.Errorf("alice in workspace %d: %+v, %v; want an admin", entity.DefaultWorkspaceID, member, err)
+	}
+
+	// Later ones join the oldest workspace.
+	if err := f.workspaces.Create(&entity.Workspace{Name: "newer"}); err != nil {
+		t.Fatal(err)
+	}
+	bob := f.register(t, "bob")
+	if member, err := f.workspaces.GetMember(entity.DefaultWorkspaceID, bob); err != nil || member.Role != entity.WorkspaceRoleMember {
+		t.Errorf("bob in workspace %d: %+v, %v; want a member", entity.DefaultWorkspaceID, member, err)
+	}
+	if list, _ := f.workspaces.GetByUserID(bob); len(list) != 1 {
+		t.Errorf("bob is in %d workspaces, want 1", len(list))
+	}
+}
+
+// Users see the members of the workspace they work in, themselves and no
+// one else; admins of the instance see everyone.
+func TestUserLookupsStayInWorkspace(t *testing.T) {
+	f := newUserFixture()
+	alice := f.register(t, "alice")
+	bob := f.register(t, "bob")
+	other := &entity.Workspace{Name: "other"}
+	if err := f.workspaces.Create(other); err != nil {
+		t.Fatal(err)
+	}
+	carol := &entity.User{Username: "carol", Password: "secret", Role: "user"}
+	if err := f.users.Create(carol); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.workspaces.AddMember(&entity.WorkspaceMember{WorkspaceID: other.ID, UserID: carol.ID, Role: entity.WorkspaceRoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		caller int
+		role   string
+		path   string
+		want   int
+	}{
+		{alice, "user", "/users/" + strconv.Itoa(bob), http.StatusOK},
+		{alice, "user", "/users/username/bob", http.StatusOK},
+		{alice, "user", "/users/" + strconv.Itoa(carol.ID), http.StatusNotFound},
+		{alice, "user", "/users/username/carol", http.StatusNotFound},
+		{carol.ID, "user", "/users/" + strconv.Itoa(carol.ID), http.StatusOK},
+		{bob, "admin", "/users/username/carol", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := newTestRouter(tt.caller, tt.role)
+		r.GET("/users/:id", f.controller.GetByID)
+		r.GET("/users/username/:username", f.controller.GetByUsername)
+		if w := serve(r, http.MethodGet, tt.path, ""); w.Code != tt.want {
+			t.Errorf("user %d (%s) GET %s: status = %d, want %d", tt.caller, tt.role, tt.path, w.Code, tt.want)
+		}
+	}
+}
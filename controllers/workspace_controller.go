@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WorkspaceController struct {
+	workspaceModel entity.WorkspaceStore
+	userModel      entity.UserStore
+	unitOfWork     entity.UnitOfWork
+}
+
+func NewWorkspaceController(workspaceModel entity.WorkspaceStore, userModel entity.UserStore, unitOfWork entity.UnitOfWork) *WorkspaceController {
+	return &WorkspaceController{
+		workspaceModel: workspaceModel,
+		userModel:      userModel,
+		unitOfWork:     unitOfWork,
+	}
+}
+
+type WorkspaceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type AddMemberRequest struct {
+	Username string `json:"username" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// MemberResponse is a workspace member with the username of the user it is
+// for, which is what members are added by.
+type MemberResponse struct {
+	*entity.WorkspaceMember
+	Username string `json:"username"`
+}
+
+func (c *WorkspaceController) response(member *entity.WorkspaceMember) *MemberResponse {
+	resp := &MemberResponse{WorkspaceMember: member}
+	if user, err := c.userModel.GetByID(member.UserID); err == nil {
+		resp.Username = user.Username
+	}
+	return resp
+}
+
+// load returns the workspace named in the URL if the caller is a member of
+// it, and whether the caller is one of its admins. Admins of the instance
+// can load every workspace. With needAdmin set, members who aren't admins
+// get a 403. ok is false if the error response has already been written.
+func (c *WorkspaceController) load(ctx *gin.Context, needAdmin bool) (workspace *entity.Workspace, admin bool, ok bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false, false
+	}
+
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false, false
+	}
+
+	userRole, _ := ctx.Get("user_role")
+
+	workspace, err = c.workspaceModel.GetByID(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "workspace not found"})
+		return nil, false, false
+	}
+
+	admin = userRole == "admin"
+	if !admin {
+		member, err := c.workspaceModel.GetMember(id, userID.(int))
+		if err != nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "workspace not found"})
+			return nil, false, false
+		}
+		admin = member.Role == entity.WorkspaceRoleAdmin
+	}
+
+	if needAdmin && !admin {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "workspace admin access required"})
+		return nil, false, false
+	}
+	return workspace, admin, true
+}
+
+// loadMember returns the member of the workspace named in the URL for the
+// user named there.
+func (c *WorkspaceController) loadMember(ctx *gin.Context, workspace *entity.Workspace) (*entity.WorkspaceMember, bool) {
+	userID, err := strconv.Atoi(ctx.Param("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return nil, false
+	}
+
+	member, err := c.workspaceModel.GetMember(workspace.ID, userID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return nil, false
+	}
+	return member, true
+}
+
+// GetAll lists the workspaces the caller is a member of. Admins of the
+// instance see them all.
+func (c *WorkspaceController) GetAll(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userRole, _ := ctx.Get("user_role")
+
+	var workspaces []*entity.Workspace
+	var err error
+	if userRole == "admin" {
+		workspaces, err = c.workspaceModel.GetAll()
+	} else {
+		workspaces, err = c.workspaceModel.GetByUserID(userID.(int))
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, workspaces)
+}
+
+// Create adds a workspace with the caller as its first admin.
+func (c *WorkspaceController) Create(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req WorkspaceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, err := entity.NormalizeWorkspaceName(req.Name)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspace := &entity.Workspace{Name: name}
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		return entity.CreateWorkspace(tx, workspace, userID.(int))
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(ctx, workspace.Version)
+	ctx.JSON(http.StatusCreated, workspace)
+}
+
+func (c *WorkspaceController) GetByID(ctx *gin.Context) {
+	workspace, _, ok := c.load(ctx, false)
+	if !ok {
+		return
+	}
+
+	setETag(ctx, workspace.Version)
+	ctx.JSON(http.StatusOK, workspace)
+}
+
+// Update renames a workspace.
+func (c *WorkspaceController) Update(ctx *gin.Context) {
+	workspace, _, ok := c.load(ctx, true)
+	if !ok {
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, workspace.Version)
+	if !ok {
+		return
+	}
+
+	var req WorkspaceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, err := entity.NormalizeWorkspaceName(req.Name)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspace.Name = name
+	workspace.Version = version
+	if err := c.workspaceModel.Update(workspace); err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	setETag(ctx, workspace.Version)
+	ctx.JSON(http.StatusOK, workspace)
+}
+
+// Delete deletes an empty workspace and its members.
+func (c *WorkspaceController) Delete(ctx *gin.Context) {
+	workspace, _, ok := c.load(ctx, true)
+	if !ok {
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, workspace.Version); !ok {
+		return
+	}
+
+	err := c.unitOfWork.Do(func(tx entity.Tx) error {
+		return entity.DeleteWorkspace(tx, workspace.ID)
+	})
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "workspace deleted"})
+}
+
+// GetMembers lists the members of a workspace. Every member can see them.
+func (c *WorkspaceController) GetMembers(ctx *gin.Context) {
+	workspace, _, ok := c.load(ctx, false)
+	if !ok {
+		return
+	}
+
+	members, err := c.workspaceModel.GetMembers(workspace.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := make([]*MemberResponse, len(members))
+	for i, member := range members {
+		resp[i] = c.response(member)
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// AddMember adds the user of the given username to a workspace.
+func (c *WorkspaceController) AddMember(ctx *gin.Context) {
+	workspace, _, ok := c.load(ctx, true)
+	if !ok {
+		return
+	}
+
+	var req AddMemberRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := entity.ParseWorkspaceRole(req.Role)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var member *entity.WorkspaceMember
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		var err error
+		member, err = entity.AddWorkspaceMember(tx, workspace.ID, req.Username, role)
+		return err
+	})
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	setETag(ctx, member.Version)
+	ctx.JSON(http.StatusCreated, c.response(member))
+}
+
+// UpdateMember changes the role of a member. The last admin of a workspace
+// can't step down.
+func (c *WorkspaceController) UpdateMember(ctx *gin.Context) {
+	workspace, _, ok := c.load(ctx, true)
+	if !ok {
+		return
+	}
+
+	member, ok := c.loadMember(ctx, workspace)
+	if !ok {
+		return
+	}
+
+	version, ok := checkIfMatch(ctx, member.Version)
+	if !ok {
+		return
+	}
+
+	var req UpdateMemberRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := entity.ParseWorkspaceRole(req.Role)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member.Version = version
+	err = c.unitOfWork.Do(func(tx entity.Tx) error {
+		return entity.SetWorkspaceRole(tx, member, role)
+	})
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	setETag(ctx, member.Version)
+	ctx.JSON(http.StatusOK, c.response(member))
+}
+
+// RemoveMember takes a user out of a workspace. Members can also leave on
+// their own, unless they are its last admin.
+func (c *WorkspaceController) RemoveMember(ctx *gin.Context) {
+	workspace, admin, ok := c.load(ctx, false)
+	if !ok {
+		return
+	}
+
+	member, ok := c.loadMember(ctx, workspace)
+	if !ok {
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+	if member.UserID != userID.(int) && !admin {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "workspace admin access required"})
+		return
+	}
+
+	if _, ok := checkIfMatch(ctx, member.Version); !ok {
+		return
+	}
+
+	err := c.unitOfWork.Do(func(tx entity.Tx) error {
+		return entity.RemoveWorkspaceMember(tx, workspace.ID, member.UserID)
+	})
+	if err != nil {
+		respondWorkspaceError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "member removed"})
+}
+
+func respondWorkspaceError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, entity.ErrWorkspaceNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "workspace not found"})
+	case errors.Is(err, entity.ErrMemberNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+	case errors.Is(err, entity.ErrUserNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, entity.ErrMemberExists), errors.Is(err, entity.ErrWorkspaceNotEmpty),
+		errors.Is(err, entity.ErrLastWorkspaceAdmin):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, entity.ErrVersionConflict):
+		ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,107 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+// AgendaEntry is a todo or an item as it appears on the agenda.
+type AgendaEntry struct {
+	Type    string `json:"type"`
+	ID      int    `json:"id"`
+	TodoID  int    `json:"todo_id"`
+	Title   string `json:"title"`
+	Overdue bool   `json:"overdue"`
+	Schedule
+}
+
+// AgendaDay lists the entries that fall on one date.
+type AgendaDay struct {
+	Date    string         `json:"date"`
+	Entries []*AgendaEntry `json:"entries"`
+}
+
+// Agenda is a run of consecutive days in TimeZone, plus the entries that
+// were already overdue before the first of them.
+type Agenda struct {
+	TimeZone string         `json:"time_zone"`
+	Overdue  []*AgendaEntry `json:"overdue"`
+	Days     []*AgendaDay   `json:"days"`
+}
+
+// BuildAgenda places unfinished todos and items on the day they are due, or
+// start if they have no due date, as seen from loc. The agenda covers days
+// days starting with the one from falls on; entries before that show up under
+// Overdue if they are overdue at now, and later ones are left out.
+func BuildAgenda(todos []*Todo, items []*TodoItem, from time.Time, days int, now time.Time, loc *time.Location) *Agenda {
+	first := startOfDay(from, loc)
+	agenda := &Agenda{
+		TimeZone: loc.String(),
+		Overdue:  make([]*AgendaEntry, 0),
+		Days:     make([]*AgendaDay, days),
+	}
+	byDate := make(map[string]*AgendaDay, days)
+	for i := range agenda.Days {
+		date := first.AddDate(0, 0, i).Format(dateLayout)
+		agenda.Days[i] = &AgendaDay{Date: date, Entries: make([]*AgendaEntry, 0)}
+		byDate[date] = agenda.Days[i]
+	}
+
+	add := func(entry *AgendaEntry) {
+		day, ok := entry.DueDay(loc)
+		if !ok {
+			return
+		}
+		entry.Overdue = entry.Schedule.Overdue(now, loc)
+		if day.Before(first) {
+			if entry.Overdue {
+				agenda.Overdue = append(agenda.Overdue, entry)
+			}
+			return
+		}
+		if d, ok := byDate[day.Format(dateLayout)]; ok {
+			d.Entries = append(d.Entries, entry)
+		}
+	}
+	for _, todo := range todos {
+		if todo.CompletionPct < 100 {
+			add(&AgendaEntry{Type: "todo", ID: todo.ID, TodoID: todo.ID, Title: todo.Title, Schedule: todo.Schedule})
+		}
+	}
+	for _, item := range items {
+		if !item.Completed {
+			add(&AgendaEntry{Type: "item", ID: item.ID, TodoID: item.TodoID, Title: item.Title, Schedule: item.Schedule})
+		}
+	}
+
+	sortAgendaEntries(agenda.Overdue, loc)
+	for _, day := range agenda.Days {
+		sortAgendaEntries(day.Entries, loc)
+	}
+	return agenda
+}
+
+// sortAgendaEntries orders entries by when they are due, all-day ones first
+// on each day, then todos before items.
+func sortAgendaEntries(entries []*AgendaEntry, loc *time.Location) {
+	when := func(e *AgendaEntry) time.Time {
+		if e.AllDay {
+			day, _ := e.DueDay(loc)
+			return day
+		}
+		if e.DueAt != nil {
+			return *e.DueAt
+		}
+		return *e.StartAt
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if ta, tb := when(a), when(b); !ta.Equal(tb) {
+			return ta.Before(tb)
+		}
+		if a.Type != b.Type {
+			return a.Type == "todo"
+		}
+		return a.ID < b.ID
+	})
+}
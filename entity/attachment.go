@@ -0,0 +1,178 @@
+package entity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Attachment describes a file uploaded to a todo item. The content lives in
+// a BlobStore under StorageKey; see blob.go.
+type Attachment struct {
+	ID     int `json:"id"`
+	TodoID int `json:"todo_id"`
+	ItemID int `json:"item_id"`
+	// UserID is the uploader.
+	UserID      int    `json:"user_id"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	// StorageKey is internal and not shown to clients; the journal keeps it
+	// through journalAttachment.
+	StorageKey string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type AttachmentModel struct {
+	sync.RWMutex
+	attachments map[int]*Attachment
+	nextID      int
+
+	// byItem indexes attachment IDs by item.
+	byItem map[int]map[int]struct{}
+}
+
+func NewAttachmentModel() *AttachmentModel {
+	return &AttachmentModel{
+		attachments: make(map[int]*Attachment),
+		nextID:      1,
+		byItem:      make(map[int]map[int]struct{}),
+	}
+}
+
+// As in the other models, the exported methods lock and delegate to
+// lower-case variants that transactions call while holding the lock, and
+// attachments are copied on the way in and out. Attachments never change
+// once created and are deleted for good; removing the content from the
+// BlobStore is up to the caller.
+
+func (m *AttachmentModel) Create(attachment *Attachment) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.create(attachment)
+}
+
+func (m *AttachmentModel) GetByID(id int) (*Attachment, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByID(id)
+}
+
+// GetByItemID returns the attachments of an item, oldest first.
+func (m *AttachmentModel) GetByItemID(itemID int) ([]*Attachment, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByItemID(itemID), nil
+}
+
+func (m *AttachmentModel) Delete(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.delete(id)
+}
+
+func (m *AttachmentModel) create(attachment *Attachment) error {
+	attachment.ID = m.nextID
+	attachment.CreatedAt = time.Now()
+
+	m.attachments[attachment.ID] = cloneAttachment(attachment)
+	m.index(attachment)
+	m.nextID++
+	return nil
+}
+
+func (m *AttachmentModel) getByID(id int) (*Attachment, error) {
+	attachment, exists := m.attachments[id]
+	if !exists {
+		return nil, ErrAttachmentNotFound
+	}
+	return cloneAttachment(attachment), nil
+}
+
+func (m *AttachmentModel) getByItemID(itemID int) []*Attachment {
+	attachments := make([]*Attachment, 0, len(m.byItem[itemID]))
+	for id := range m.byItem[itemID] {
+		attachments = append(attachments, cloneAttachment(m.attachments[id]))
+	}
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].ID < attachments[j].ID })
+	return attachments
+}
+
+func (m *AttachmentModel) delete(id int) error {
+	if _, exists := m.attachments[id]; !exists {
+		return ErrAttachmentNotFound
+	}
+	m.remove(id)
+	return nil
+}
+
+func (m *AttachmentModel) index(attachment *Attachment) {
+	if m.byItem[attachment.ItemID] == nil {
+		m.byItem[attachment.ItemID] = make(map[int]struct{})
+	}
+	m.byItem[attachment.ItemID][attachment.ID] = struct{}{}
+}
+
+// remove drops an attachment from the map and the index.
+func (m *AttachmentModel) remove(id int) {
+	if attachment, ok := m.attachments[id]; ok {
+		delete(m.byItem[attachment.ItemID], id)
+		if len(m.byItem[attachment.ItemID]) == 0 {
+			delete(m.byItem, attachment.ItemID)
+		}
+		delete(m.attachments, id)
+	}
+}
+
+// restore puts an attachment back into the map with its original ID. It is
+// used when rebuilding the model from a journal.
+func (m *AttachmentModel) restore(attachment *Attachment) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.remove(attachment.ID)
+	m.attachments[attachment.ID] = attachment
+	m.index(attachment)
+	if attachment.ID >= m.nextID {
+		m.nextID = attachment.ID + 1
+	}
+}
+
+// discard deletes an attachment without reporting missing IDs, for journal
+// replay.
+func (m *AttachmentModel) discard(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.remove(id)
+}
+
+func (m *AttachmentModel) snapshot() []*journalAttachment {
+	m.RLock()
+	defer m.RUnlock()
+
+	attachments := make([]*journalAttachment, 0, len(m.attachments))
+	for _, attachment := range m.attachments {
+		attachments = append(attachments, newJournalAttachment(attachment))
+	}
+	return attachments
+}
+
+func (m *AttachmentModel) nextIDs() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.nextID
+}
+
+func (m *AttachmentModel) reserveIDs(next int) {
+	m.Lock()
+	defer m.Unlock()
+	if next > m.nextID {
+		m.nextID = next
+	}
+}
+
+func cloneAttachment(attachment *Attachment) *Attachment {
+	c := *attachment
+	return &c
+}
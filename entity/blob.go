@@ -0,0 +1,147 @@
+package entity
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BlobStore keeps the content of attachments. Keys are made by NewBlobKey
+// and only contain lower-case hex digits, so implementations can use them
+// as file names.
+type BlobStore interface {
+	// Put stores everything read from r under key and returns the number of
+	// bytes written.
+	Put(key string, r io.Reader) (int64, error)
+	// Open returns the content stored under key, or ErrBlobNotFound.
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the content stored under key. Deleting a missing key is
+	// not an error.
+	Delete(key string) error
+}
+
+const maxFileNameLength = 255
+
+// sniffLength is how much of a file http.DetectContentType looks at.
+const sniffLength = 512
+
+// NewBlobKey returns a random key for a new blob.
+func NewBlobKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// ValidBlobKey reports whether key could have come from NewBlobKey.
+func ValidBlobKey(key string) bool {
+	if len(key) != 32 {
+		return false
+	}
+	for _, r := range key {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// UploadLimits restrict what can be uploaded: at most MaxSize bytes, of one
+// of AllowedTypes. The type is sniffed from the content; whatever the client
+// claims is ignored.
+type UploadLimits struct {
+	MaxSize      int64
+	AllowedTypes []string
+}
+
+func (l UploadLimits) allows(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range l.AllowedTypes {
+		if strings.EqualFold(mediaType, strings.TrimSpace(allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveBlob sniffs the content type of r and, if the limits allow it, streams
+// r into blobs under a new key. The returned attachment has the key, type,
+// size and checksum filled in. Content that turns out to be larger than
+// MaxSize is removed again and ErrAttachmentTooLarge returned.
+func SaveBlob(blobs BlobStore, r io.Reader, limits UploadLimits) (*Attachment, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+	if n == 0 {
+		return nil, ErrEmptyAttachment
+	}
+
+	contentType := http.DetectContentType(head)
+	if !limits.allows(contentType) {
+		return nil, ErrAttachmentType
+	}
+
+	key, err := NewBlobKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read one byte past the limit so oversized content can be told apart
+	// from content of exactly MaxSize bytes.
+	hash := sha256.New()
+	content := io.LimitReader(io.MultiReader(bytes.NewReader(head), r), limits.MaxSize+1)
+	size, err := blobs.Put(key, io.TeeReader(content, hash))
+	if err != nil {
+		blobs.Delete(key)
+		return nil, err
+	}
+	if size > limits.MaxSize {
+		blobs.Delete(key)
+		return nil, ErrAttachmentTooLarge
+	}
+
+	return &Attachment{
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		StorageKey:  key,
+	}, nil
+}
+
+// CleanFileName returns the base name of an uploaded file without control
+// characters, cut to 255 bytes, or "file" if nothing is left.
+func CleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	for len(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "file"
+	}
+	return name
+}
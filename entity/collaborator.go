@@ -0,0 +1,266 @@
+package entity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Collaborator gives a user other than the owner access to a todo, at the
+// level Role says. It starts as an invitation and only takes effect once the
+// user accepts it; see sharing.go.
+type Collaborator struct {
+	ID     int              `json:"id"`
+	TodoID int              `json:"todo_id"`
+	UserID int              `json:"user_id"`
+	Role   CollaboratorRole `json:"role"`
+	// InvitedBy is the user who sent the invitation.
+	InvitedBy  int        `json:"invited_by"`
+	Version    int        `json:"version"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+}
+
+type CollaboratorModel struct {
+	sync.RWMutex
+	collaborators map[int]*Collaborator
+	nextID        int
+
+	// byTodo maps a todo to its collaborators, by user ID; byUser indexes
+	// collaborator IDs by user. A collaborator never moves to another todo
+	// or user.
+	byTodo map[int]map[int]int
+	byUser map[int]map[int]struct{}
+}
+
+func NewCollaboratorModel() *CollaboratorModel {
+	return &CollaboratorModel{
+		collaborators: make(map[int]*Collaborator),
+		nextID:        1,
+		byTodo:        make(map[int]map[int]int),
+		byUser:        make(map[int]map[int]struct{}),
+	}
+}
+
+// As in the other models, the exported methods lock and delegate to
+// lower-case variants that transactions call while holding the lock, and
+// collaborators are copied on the way in and out. Only the role and whether
+// the invitation was accepted can change; a user is a collaborator of a
+// todo at most once.
+
+func (m *CollaboratorModel) Create(collaborator *Collaborator) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.create(collaborator)
+}
+
+func (m *CollaboratorModel) GetByID(id int) (*Collaborator, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByID(id)
+}
+
+// GetByTodoAndUser returns the collaborator entry of a user on a todo,
+// accepted or not.
+func (m *CollaboratorModel) GetByTodoAndUser(todoID, userID int) (*Collaborator, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByTodoAndUser(todoID, userID)
+}
+
+// GetByTodoID returns the collaborators of a todo, invitations included,
+// oldest first.
+func (m *CollaboratorModel) GetByTodoID(todoID int) ([]*Collaborator, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByTodoID(todoID), nil
+}
+
+// GetByUserID returns the todos shared with a user as collaborator entries,
+// invitations included, oldest first.
+func (m *CollaboratorModel) GetByUserID(userID int) ([]*Collaborator, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByUserID(userID), nil
+}
+
+// Update saves the role and AcceptedAt of a collaborator.
+func (m *CollaboratorModel) Update(collaborator *Collaborator) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.update(collaborator)
+}
+
+func (m *CollaboratorModel) Delete(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.delete(id)
+}
+
+func (m *CollaboratorModel) create(collaborator *Collaborator) error {
+	if _, taken := m.byTodo[collaborator.TodoID][collaborator.UserID]; taken {
+		return ErrCollaboratorExists
+	}
+
+	collaborator.ID = m.nextID
+	collaborator.CreatedAt = time.Now()
+	collaborator.UpdatedAt = collaborator.CreatedAt
+	collaborator.Version = 1
+
+	m.collaborators[collaborator.ID] = cloneCollaborator(collaborator)
+	m.index(collaborator)
+	m.nextID++
+	return nil
+}
+
+func (m *CollaboratorModel) getByID(id int) (*Collaborator, error) {
+	collaborator, exists := m.collaborators[id]
+	if !exists {
+		return nil, ErrCollaboratorNotFound
+	}
+	return cloneCollaborator(collaborator), nil
+}
+
+func (m *CollaboratorModel) getByTodoAndUser(todoID, userID int) (*Collaborator, error) {
+	id, exists := m.byTodo[todoID][userID]
+	if !exists {
+		return nil, ErrCollaboratorNotFound
+	}
+	return cloneCollaborator(m.collaborators[id]), nil
+}
+
+func (m *CollaboratorModel) getByTodoID(todoID int) []*Collaborator {
+	collaborators := make([]*Collaborator, 0, len(m.byTodo[todoID]))
+	for _, id := range m.byTodo[todoID] {
+		collaborators = append(collaborators, cloneCollaborator(m.collaborators[id]))
+	}
+	sortCollaborators(collaborators)
+	return collaborators
+}
+
+func (m *CollaboratorModel) getByUserID(userID int) []*Collaborator {
+	collaborators := make([]*Collaborator, 0, len(m.byUser[userID]))
+	for id := range m.byUser[userID] {
+		collaborators = append(collaborators, cloneCollaborator(m.collaborators[id]))
+	}
+	sortCollaborators(collaborators)
+	return collaborators
+}
+
+func (m *CollaboratorModel) update(collaborator *Collaborator) error {
+	existing, exists := m.collaborators[collaborator.ID]
+	if !exists {
+		return ErrCollaboratorNotFound
+	}
+	if collaborator.Version != 0 && collaborator.Version != existing.Version {
+		return ErrVersionConflict
+	}
+
+	updated := cloneCollaborator(existing)
+	updated.Role = collaborator.Role
+	updated.AcceptedAt = collaborator.AcceptedAt
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+	m.collaborators[collaborator.ID] = cloneCollaborator(updated)
+
+	*collaborator = *updated
+	return nil
+}
+
+func (m *CollaboratorModel) delete(id int) error {
+	if _, exists := m.collaborators[id]; !exists {
+		return ErrCollaboratorNotFound
+	}
+	m.remove(id)
+	return nil
+}
+
+func (m *CollaboratorModel) index(collaborator *Collaborator) {
+	if m.byTodo[collaborator.TodoID] == nil {
+		m.byTodo[collaborator.TodoID] = make(map[int]int)
+	}
+	m.byTodo[collaborator.TodoID][collaborator.UserID] = collaborator.ID
+	if m.byUser[collaborator.UserID] == nil {
+		m.byUser[collaborator.UserID] = make(map[int]struct{})
+	}
+	m.byUser[collaborator.UserID][collaborator.ID] = struct{}{}
+}
+
+// remove drops a collaborator from the map and the indexes.
+func (m *CollaboratorModel) remove(id int) {
+	collaborator, ok := m.collaborators[id]
+	if !ok {
+		return
+	}
+
+	delete(m.byTodo[collaborator.TodoID], collaborator.UserID)
+	if len(m.byTodo[collaborator.TodoID]) == 0 {
+		delete(m.byTodo, collaborator.TodoID)
+	}
+	delete(m.byUser[collaborator.UserID], id)
+	if len(m.byUser[collaborator.UserID]) == 0 {
+		delete(m.byUser, collaborator.UserID)
+	}
+	delete(m.collaborators, id)
+}
+
+// restore puts a collaborator back into the map with its original ID. It is
+// used when rebuilding the model from a journal.
+func (m *CollaboratorModel) restore(collaborator *Collaborator) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.remove(collaborator.ID)
+	m.collaborators[collaborator.ID] = collaborator
+	m.index(collaborator)
+	if collaborator.ID >= m.nextID {
+		m.nextID = collaborator.ID + 1
+	}
+}
+
+// discard deletes a collaborator without reporting missing IDs, for journal
+// replay.
+func (m *CollaboratorModel) discard(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.remove(id)
+}
+
+func (m *CollaboratorModel) snapshot() []*Collaborator {
+	m.RLock()
+	defer m.RUnlock()
+
+	collaborators := make([]*Collaborator, 0, len(m.collaborators))
+	for _, collaborator := range m.collaborators {
+		collaborators = append(collaborators, cloneCollaborator(collaborator))
+	}
+	return collaborators
+}
+
+func (m *CollaboratorModel) nextIDs() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.nextID
+}
+
+func (m *CollaboratorModel) reserveIDs(next int) {
+	m.Lock()
+	defer m.Unlock()
+	if next > m.nextID {
+		m.nextID = next
+	}
+}
+
+func sortCollaborators(collaborators []*Collaborator) {
+	sort.Slice(collaborators, func(i, j int) bool { return collaborators[i].ID < collaborators[j].ID })
+}
+
+func cloneCollaborator(collaborator *Collaborator) *Collaborator {
+	c := *collaborator
+	if collaborator.AcceptedAt != nil {
+		acceptedAt := *collaborator.AcceptedAt
+		c.AcceptedAt = &acceptedAt
+	}
+	return &c
+}
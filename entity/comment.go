@@ -0,0 +1,279 @@
+package entity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Comment is a note on a todo or, with ItemID set, on one of its items.
+// Replies point at the comment they answer through ParentID. See thread.go.
+type Comment struct {
+	ID       int  `json:"id"`
+	TodoID   int  `json:"todo_id"`
+	ItemID   *int `json:"item_id"`
+	ParentID *int `json:"parent_id"`
+	// UserID is the author.
+	UserID    int        `json:"user_id"`
+	Body      string     `json:"body"`
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type CommentModel struct {
+	sync.RWMutex
+	comments map[int]*Comment
+	nextID   int
+
+	// byTodo indexes comment IDs, deleted ones and those on items included,
+	// by todo. A comment never moves to another todo.
+	byTodo map[int]map[int]struct{}
+}
+
+func NewCommentModel() *CommentModel {
+	return &CommentModel{
+		comments: make(map[int]*Comment),
+		nextID:   1,
+		byTodo:   make(map[int]map[int]struct{}),
+	}
+}
+
+// As in the other models, the exported methods lock and delegate to
+// lower-case variants that transactions call while holding the lock, and
+// comments are copied on the way in and out. Only the body of a comment can
+// change; deleting one clears the body but keeps the comment, so the replies
+// below it stay in place.
+
+func (m *CommentModel) Create(comment *Comment) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.create(comment)
+}
+
+func (m *CommentModel) GetByID(id int) (*Comment, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByID(id)
+}
+
+// GetByTodoID returns the comments on a todo and its items, deleted ones
+// included, oldest first.
+func (m *CommentModel) GetByTodoID(todoID int) ([]*Comment, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByTodoID(todoID), nil
+}
+
+// CountByTodoIDs returns the number of live comments on each of the todos
+// themselves, leaving out comments on their items. Todos without comments
+// are missing from the map.
+func (m *CommentModel) CountByTodoIDs(todoIDs []int) (map[int]int, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.countByTodoIDs(todoIDs), nil
+}
+
+// Update replaces the body of a live comment and marks it as edited.
+func (m *CommentModel) Update(comment *Comment) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.update(comment)
+}
+
+func (m *CommentModel) Delete(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.delete(id, time.Now())
+}
+
+// Purge removes a comment for good, whether or not it was deleted. Replies
+// to it are left alone.
+func (m *CommentModel) Purge(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.purge(id)
+}
+
+func (m *CommentModel) create(comment *Comment) error {
+	comment.ID = m.nextID
+	comment.CreatedAt = time.Now()
+	comment.UpdatedAt = comment.CreatedAt
+	comment.EditedAt = nil
+	comment.DeletedAt = nil
+	comment.Version = 1
+
+	m.comments[comment.ID] = cloneComment(comment)
+	m.index(comment)
+	m.nextID++
+	return nil
+}
+
+func (m *CommentModel) getByID(id int) (*Comment, error) {
+	comment, exists := m.comments[id]
+	if !exists || comment.DeletedAt != nil {
+		return nil, ErrCommentNotFound
+	}
+	return cloneComment(comment), nil
+}
+
+func (m *CommentModel) getByTodoID(todoID int) []*Comment {
+	comments := make([]*Comment, 0, len(m.byTodo[todoID]))
+	for id := range m.byTodo[todoID] {
+		comments = append(comments, cloneComment(m.comments[id]))
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].ID < comments[j].ID })
+	return comments
+}
+
+func (m *CommentModel) countByTodoIDs(todoIDs []int) map[int]int {
+	counts := make(map[int]int)
+	for _, todoID := range todoIDs {
+		for id := range m.byTodo[todoID] {
+			if comment := m.comments[id]; comment.ItemID == nil && comment.DeletedAt == nil {
+				counts[todoID]++
+			}
+		}
+	}
+	return counts
+}
+
+func (m *CommentModel) update(comment *Comment) error {
+	existing, exists := m.comments[comment.ID]
+	if !exists || existing.DeletedAt != nil {
+		return ErrCommentNotFound
+	}
+	if comment.Version != 0 && comment.Version != existing.Version {
+		return ErrVersionConflict
+	}
+
+	now := time.Now()
+	updated := cloneComment(existing)
+	updated.Body = comment.Body
+	updated.UpdatedAt = now
+	updated.EditedAt = &now
+	updated.Version++
+	m.comments[comment.ID] = updated
+
+	*comment = *cloneComment(updated)
+	return nil
+}
+
+func (m *CommentModel) delete(id int, at time.Time) error {
+	comment, exists := m.comments[id]
+	if !exists || comment.DeletedAt != nil {
+		return ErrCommentNotFound
+	}
+
+	comment.Body = ""
+	comment.DeletedAt = &at
+	comment.Version++
+	return nil
+}
+
+func (m *CommentModel) purge(id int) error {
+	if _, exists := m.comments[id]; !exists {
+		return ErrCommentNotFound
+	}
+	m.remove(id)
+	return nil
+}
+
+func (m *CommentModel) index(comment *Comment) {
+	if m.byTodo[comment.TodoID] == nil {
+		m.byTodo[comment.TodoID] = make(map[int]struct{})
+	}
+	m.byTodo[comment.TodoID][comment.ID] = struct{}{}
+}
+
+// remove drops a comment from the map and the index.
+func (m *CommentModel) remove(id int) {
+	if comment, ok := m.comments[id]; ok {
+		delete(m.byTodo[comment.TodoID], id)
+		if len(m.byTodo[comment.TodoID]) == 0 {
+			delete(m.byTodo, comment.TodoID)
+		}
+		delete(m.comments, id)
+	}
+}
+
+// restore puts a comment back into the map with its original ID. It is used
+// when rebuilding the model from a journal.
+func (m *CommentModel) restore(comment *Comment) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.remove(comment.ID)
+	m.comments[comment.ID] = comment
+	m.index(comment)
+	if comment.ID >= m.nextID {
+		m.nextID = comment.ID + 1
+	}
+}
+
+// find returns a comment whether or not it is deleted, which GetByID
+// doesn't. The journal uses it to log deleted comments. Callers hold the
+// lock.
+func (m *CommentModel) find(id int) (*Comment, bool) {
+	comment, exists := m.comments[id]
+	if !exists {
+		return nil, false
+	}
+	return cloneComment(comment), true
+}
+
+// discard purges a comment without reporting missing IDs, for journal
+// replay.
+func (m *CommentModel) discard(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.remove(id)
+}
+
+func (m *CommentModel) snapshot() []*Comment {
+	m.RLock()
+	defer m.RUnlock()
+
+	comments := make([]*Comment, 0, len(m.comments))
+	for _, comment := range m.comments {
+		comments = append(comments, cloneComment(comment))
+	}
+	return comments
+}
+
+func (m *CommentModel) nextIDs() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.nextID
+}
+
+func (m *CommentModel) reserveIDs(next int) {
+	m.Lock()
+	defer m.Unlock()
+	if next > m.nextID {
+		m.nextID = next
+	}
+}
+
+func cloneComment(comment *Comment) *Comment {
+	c := *comment
+	if comment.ItemID != nil {
+		itemID := *comment.ItemID
+		c.ItemID = &itemID
+	}
+	if comment.ParentID != nil {
+		parentID := *comment.ParentID
+		c.ParentID = &parentID
+	}
+	if comment.EditedAt != nil {
+		editedAt := *comment.EditedAt
+		c.EditedAt = &editedAt
+	}
+	if comment.DeletedAt != nil {
+		deletedAt := *comment.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
@@ -0,0 +1,206 @@
+package entity
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The models hand out copies, so nothing a caller does to a record it got
+// from one, or gave to one, reaches the stored record. Run with -race.
+
+func TestTodoModelCopies(t *testing.T) {
+	m := NewTodoModel(NewTodoItemModel())
+	due := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	todo := &Todo{Title: "original", UserID: 1, WorkspaceID: DefaultWorkspaceID, Tags: []string{"home"}, Schedule: Schedule{DueAt: &due}}
+	if err := m.Create(todo); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record passed to Create, and each one read back, is the caller's.
+	todo.Title = "changed after create"
+	todo.Tags[0] = "changed"
+	got, err := m.GetByID(todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Title = "changed after get"
+	got.Tags[0] = "changed"
+	*got.DueAt = due.AddDate(1, 0, 0)
+	byUser, err := m.GetByUserID(DefaultWorkspaceID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byWorkspace, err := m.GetByWorkspaceID(DefaultWorkspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, list := range [][]*Todo{byUser, byWorkspace} {
+		list[0].Title = "changed in list"
+	}
+
+	stored, err := m.GetByID(todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "original" || stored.Tags[0] != "home" || !stored.DueAt.Equal(due) {
+		t.Errorf("stored todo = %q %v %v, want it unchanged", stored.Title, stored.Tags, stored.DueAt)
+	}
+
+	if err := m.Delete(todo.ID); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := m.GetByIDWithDeleted(todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletedAt := *deleted.DeletedAt
+	*deleted.DeletedAt = deletedAt.AddDate(1, 0, 0)
+	if stored, _ := m.GetByIDWithDeleted(todo.ID); !stored.DeletedAt.Equal(deletedAt) {
+		t.Errorf("stored deleted_at = %v, want %v", stored.DeletedAt, deletedAt)
+	}
+}
+
+func TestTodoItemModelCopies(t *testing.T) {
+	m := NewTodoItemModel()
+	parent := 7
+	item := &TodoItem{Title: "original", TodoID: 1, UserID: 1, Tags: []string{"home"}, ParentItemID: &parent}
+	if err := m.Create(item); err != nil {
+		t.Fatal(err)
+	}
+
+	item.Tags[0] = "changed"
+	*item.ParentItemID = 8
+	got, err := m.GetByID(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Title = "changed after get"
+	list, err := m.GetByTodoID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list[0].Completed = true
+
+	stored, err := m.GetByID(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "original" || stored.Tags[0] != "home" || *stored.ParentItemID != 7 || stored.Completed {
+		t.Errorf("stored item = %+v, want it unchanged", stored)
+	}
+}
+
+// Writers edit their copies and save them while readers list, read and
+// encode the same todos, as the controllers do. The race detector fails the
+// test if any of them share memory.
+func TestConcurrentReadersAndWriters(t *testing.T) {
+	items := NewTodoItemModel()
+	todos := NewTodoModel(items)
+	unitOfWork := newTestUnitOfWork(todos)
+
+	const todoCount = 4
+	for i := 0; i < todoCount; i++ {
+		todo := &Todo{Title: "todo", UserID: 1, WorkspaceID: DefaultWorkspaceID, Tags: []string{"a"}}
+		if err := todos.Create(todo); err != nil {
+			t.Fatal(err)
+		}
+		if err := items.Create(&TodoItem{Title: "item", TodoID: todo.ID, UserID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const rounds = 200
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				id := (w+i)%todoCount + 1
+				todo, err := todos.GetByID(id)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				todo.Title = "edited"
+				todo.Tags = append(todo.Tags, "b")
+				todo.Version = 0
+				if err := todos.Update(todo); err != nil {
+					t.Error(err)
+					return
+				}
+
+				list, err := items.GetByTodoID(id)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				item := list[0]
+				item.Completed = !item.Completed
+				item.Version = 0
+				err = unitOfWork.Do(func(tx Tx) error {
+					if err := tx.Items().Update(item); err != nil {
+						return err
+					}
+					return tx.Todos().UpdateCompletionPct(id, tx.Items())
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				list, err := todos.GetByWorkspaceID(DefaultWorkspaceID)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for _, todo := range list {
+					_ = len(todo.Tags)
+					_ = todo.Title
+				}
+				if _, err := json.Marshal(list); err != nil {
+					t.Error(err)
+					return
+				}
+				todoItems, err := items.GetByTodoID(i%todoCount + 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := json.Marshal(todoItems); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for id := 1; id <= todoCount; id++ {
+		todo, err := todos.GetByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := items.GetByTodoID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item := list[0]
+		want := 0.0
+		if item.Completed {
+			want = 100
+		}
+		if todo.CompletionPct != want {
+			t.Errorf("todo %d completion = %v, want %v", id, todo.CompletionPct, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package entity
+
+import "errors"
+
+// Errors returned by every store implementation, so callers can tell the
+// failure modes apart with errors.Is regardless of the backend.
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUsernameExists   = errors.New("username already exists")
+	ErrTodoNotFound     = errors.New("todo not found")
+	ErrTodoItemNotFound = errors.New("item not found")
+
+	// ErrVersionConflict is returned by Update when the caller set Version
+	// and the stored record has moved on since.
+	ErrVersionConflict = errors.New("version conflict")
+
+	// Returned by UserStore.Delete, see UserDeletePolicy.
+	ErrUserHasTodos          = errors.New("user still owns todos")
+	ErrInvalidReassignTarget = errors.New("invalid reassign target")
+	ErrInvalidDeletePolicy   = errors.New("invalid delete policy")
+
+	// ErrInvalidPosition is returned when an item can't be placed where it
+	// was asked to go, e.g. next to an item of another todo.
+	ErrInvalidPosition = errors.New("invalid position")
+
+	// Returned when nesting items, see item_tree.go.
+	ErrInvalidParentItem = errors.New("parent item must be another item of the same todo")
+	ErrItemTooDeep       = errors.New("items are nested too deep")
+	ErrParentItemDeleted = errors.New("parent item is deleted")
+
+	// ErrInvalidSchedule is returned by ParseSchedule, ErrInvalidTimeZone by
+	// LoadTimeZone.
+	ErrInvalidSchedule = errors.New("start_at and due_at must both be dates or both be RFC 3339 timestamps, with start_at not after due_at")
+	ErrInvalidTimeZone = errors.New("invalid time zone")
+
+	// Returned by the tag functions, see tagging.go.
+	ErrTagNotFound     = errors.New("tag not found")
+	ErrTagExists       = errors.New("a tag with that name already exists")
+	ErrInvalidTag      = errors.New("tag names must be 1 to 50 characters long and can't contain commas")
+	ErrTooManyTags     = errors.New("a todo or item can have at most 20 tags")
+	ErrInvalidTagMerge = errors.New("a tag can only be merged into another tag of the same user")
+
+	// Returned for recurring todos and items, see recurrence.go.
+	ErrInvalidRecurrence   = errors.New("invalid recurrence rule")
+	ErrRecurrenceNeedsDate = errors.New("a recurring todo or item needs a start or due date")
+	ErrNotRecurring        = errors.New("not an occurrence of a recurring series")
+
+	// Returned for comments, see thread.go.
+	ErrCommentNotFound      = errors.New("comment not found")
+	ErrInvalidComment       = errors.New("comment body must be 1 to 10000 characters long")
+	ErrInvalidCommentParent = errors.New("a reply must answer a live comment on the same todo or item")
+
+	// Returned for attachments, see blob.go.
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+	ErrEmptyAttachment    = errors.New("attachment is empty")
+	ErrBlobNotFound       = errors.New("blob not found")
+
+	// Returned when sharing todos, see sharing.go.
+	ErrCollaboratorNotFound = errors.New("collaborator not found")
+	ErrCollaboratorExists   = errors.New("the user already has access to or an invitation for this todo")
+	ErrInvalidCollaborator  = errors.New("the owner of a todo can't be invited to it")
+	ErrInvalidRole          = errors.New("role must be viewer, editor or owner")
+	ErrNotWorkspaceMember   = errors.New("only members of the todo's workspace can be invited to it")
+
+	// Returned for workspaces, see membership.go.
+	ErrWorkspaceNotFound    = errors.New("workspace not found")
+	ErrInvalidWorkspace     = errors.New("workspace names must be 1 to 100 characters long")
+	ErrWorkspaceNotEmpty    = errors.New("workspace still has todos")
+	ErrMemberNotFound       = errors.New("workspace member not found")
+	ErrMemberExists         = errors.New("the user is already a member of this workspace")
+	ErrInvalidWorkspaceRole = errors.New("role must be member or admin")
+	ErrLastWorkspaceAdmin   = errors.New("a workspace needs at least one admin")
+
+	// Returned by the List functions, see ListOptions.
+	ErrInvalidSort   = errors.New("invalid sort field")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
@@ -0,0 +1,76 @@
+package entity
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// The lookups below go through the models' secondary indexes, so their cost
+// depends on how many records they return (ten here) and not on how many the
+// model holds. The ns/op should stay flat from the smallest size to the
+// largest.
+var indexBenchSizes = []int{1000, 10000, 100000}
+
+const recordsPerOwner = 10
+
+func BenchmarkTodoGetByUserID(b *testing.B) {
+	for _, n := range indexBenchSizes {
+		b.Run(fmt.Sprintf("todos=%d", n), func(b *testing.B) {
+			m := NewTodoModel(NewTodoItemModel())
+			now := time.Now()
+			for id := 1; id <= n; id++ {
+				m.restore(&Todo{ID: id, Title: "todo", UserID: id % (n / recordsPerOwner), WorkspaceID: DefaultWorkspaceID, Version: 1, CreatedAt: now, UpdatedAt: now})
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if todos, _ := m.GetByUserID(DefaultWorkspaceID, i%(n/recordsPerOwner)); len(todos) != recordsPerOwner {
+					b.Fatalf("got %d todos, want %d", len(todos), recordsPerOwner)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkTodoItemGetByTodoID(b *testing.B) {
+	for _, n := range indexBenchSizes {
+		b.Run(fmt.Sprintf("items=%d", n), func(b *testing.B) {
+			m := NewTodoItemModel()
+			now := time.Now()
+			for id := 1; id <= n; id++ {
+				m.restore(&TodoItem{ID: id, Title: "item", TodoID: id % (n / recordsPerOwner), Version: 1, CreatedAt: now, UpdatedAt: now})
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if items, _ := m.GetByTodoID(i % (n / recordsPerOwner)); len(items) != recordsPerOwner {
+					b.Fatalf("got %d items, want %d", len(items), recordsPerOwner)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUserGetByUsername(b *testing.B) {
+	for _, n := range indexBenchSizes {
+		b.Run(fmt.Sprintf("users=%d", n), func(b *testing.B) {
+			// restore skips password hashing, which would dominate the setup.
+			m := NewUserModel(NewTodoModel(NewTodoItemModel()), NewTagModel(), NewCollaboratorModel(), NewWorkspaceModel())
+			for id := 1; id <= n; id++ {
+				m.restore(&User{ID: id, Username: fmt.Sprintf("user%d", id), Role: "user", Version: 1})
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				username := fmt.Sprintf("user%d", i%n+1)
+				if _, err := m.GetByUsername(username); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Items can be nested under other items of the same todo through
+// ParentItemID, down to a configurable depth where top-level items are at
+// depth 1. An item with sub-items is done when all of its leaves are, so
+// completion is computed from leaves only and the Completed flag of an inner
+// item does not count.
+//
+// The helpers below work on any TodoItemStore and are meant to run inside a
+// transaction so that the checks and the writes they make see the same
+// state.
+
+func sameParent(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Leaves returns the items of a list that have no sub-items in that list.
+func Leaves(items []*TodoItem) []*TodoItem {
+	hasChildren := make(map[int]bool)
+	for _, item := range items {
+		if item.ParentItemID != nil {
+			hasChildren[*item.ParentItemID] = true
+		}
+	}
+
+	var leaves []*TodoItem
+	for _, item := range items {
+		if !hasChildren[item.ID] {
+			leaves = append(leaves, item)
+		}
+	}
+	return leaves
+}
+
+// TodoItemNode is an item with its sub-items. CompletionPct is the share of
+// completed leaves under it, or 0/100 for a leaf.
+type TodoItemNode struct {
+	*TodoItem
+	CompletionPct float64         `json:"completion_pct"`
+	Children      []*TodoItemNode `json:"children"`
+}
+
+// BuildItemTree arranges the items of one todo into a tree ordered by
+// position. Items whose parent is not in the list end up at the top level.
+func BuildItemTree(items []*TodoItem) []*TodoItemNode {
+	sorted := make([]*TodoItem, len(items))
+	copy(sorted, items)
+	SortByPosition(sorted)
+
+	nodes := make(map[int]*TodoItemNode, len(sorted))
+	for _, item := range sorted {
+		nodes[item.ID] = &TodoItemNode{TodoItem: item, Children: make([]*TodoItemNode, 0)}
+	}
+
+	roots := make([]*TodoItemNode, 0)
+	for _, item := range sorted {
+		node := nodes[item.ID]
+		if item.ParentItemID != nil {
+			if parent, ok := nodes[*item.ParentItemID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	for _, root := range roots {
+		root.rollUp()
+	}
+	return roots
+}
+
+// rollUp fills in CompletionPct for the subtree and returns its number of
+// leaves and completed leaves.
+func (n *TodoItemNode) rollUp() (leaves, completed int) {
+	if len(n.Children) == 0 {
+		if n.Completed {
+			n.CompletionPct = 100
+			return 1, 1
+		}
+		return 1, 0
+	}
+
+	for _, child := range n.Children {
+		l, c := child.rollUp()
+		leaves += l
+		completed += c
+	}
+	n.CompletionPct = float64(completed) / float64(leaves) * 100
+	return leaves, completed
+}
+
+// itemFamily indexes a todo's items by ID and by parent.
+type itemFamily struct {
+	byID     map[int]*TodoItem
+	children map[int][]*TodoItem
+}
+
+func newItemFamily(items []*TodoItem) *itemFamily {
+	f := &itemFamily{
+		byID:     make(map[int]*TodoItem, len(items)),
+		children: make(map[int][]*TodoItem),
+	}
+	for _, item := range items {
+		f.byID[item.ID] = item
+		if item.ParentItemID != nil {
+			f.children[*item.ParentItemID] = append(f.children[*item.ParentItemID], item)
+		}
+	}
+	return f
+}
+
+func (f *itemFamily) parent(item *TodoItem) *TodoItem {
+	if item.ParentItemID == nil {
+		return nil
+	}
+	return f.byID[*item.ParentItemID]
+}
+
+// depth returns how deep the item is, 1 being the top level.
+func (f *itemFamily) depth(id int) int {
+	depth := 0
+	for item := f.byID[id]; item != nil; item = f.parent(item) {
+		depth++
+	}
+	return depth
+}
+
+// height returns the number of levels in the item's subtree, itself
+// included.
+func (f *itemFamily) height(id int) int {
+	height := 0
+	for _, child := range f.children[id] {
+		if h := f.height(child.ID); h > height {
+			height = h
+		}
+	}
+	return height + 1
+}
+
+// isAncestor reports whether ancestor is id or one of the items above it.
+func (f *itemFamily) isAncestor(ancestor, id int) bool {
+	for item := f.byID[id]; item != nil; item = f.parent(item) {
+		if item.ID == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// treeKey is the "position" sort key of an item: the positions of its
+// ancestors and its own, from the top level down. Sorting by it lists the
+// items in tree order: every item after its parent, siblings by position. Each
+// step carries the item ID to break ties between equal positions; the
+// separators sort below every position digit, so a parent comes before its
+// sub-items.
+func (f *itemFamily) treeKey(item *TodoItem) sortKey {
+	var path []string
+	for ; item != nil; item = f.parent(item) {
+		path = append(path, fmt.Sprintf("%s\x01%020d", item.Position, item.ID))
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return sortKey{Str: strings.Join(path, "\x00")}
+}
+
+// descendants returns the items below id, children before grandchildren.
+func (f *itemFamily) descendants(id int) []*TodoItem {
+	var result []*TodoItem
+	queue := []int{id}
+	for len(queue) > 0 {
+		for _, child := range f.children[queue[0]] {
+			result = append(result, child)
+			queue = append(queue, child.ID)
+		}
+		queue = queue[1:]
+	}
+	return result
+}
+
+// checkParent makes sure a subtree of the given height can go under parent:
+// the parent must be a live item of the todo and the subtree must fit within
+// maxDepth below it. maxDepth <= 0 means no limit.
+func (f *itemFamily) checkParent(parent *int, height, maxDepth int) error {
+	depth := 0
+	if parent != nil {
+		if _, ok := f.byID[*parent]; !ok {
+			return ErrInvalidParentItem
+		}
+		depth = f.depth(*parent)
+	}
+	if maxDepth > 0 && depth+height > maxDepth {
+		return ErrItemTooDeep
+	}
+	return nil
+}
+
+// CheckItemParent checks that a new item of todoID can be created under
+// parent, which may be nil for the top level.
+func CheckItemParent(items TodoItemStore, todoID int, parent *int, maxDepth int) error {
+	siblings, err := items.GetByTodoID(todoID)
+	if err != nil {
+		return err
+	}
+	return newItemFamily(siblings).checkParent(parent, 1, maxDepth)
+}
+
+// DeleteTodoItem soft-deletes an item together with everything below it.
+// The item goes first, so its sub-items are never deleted before it; that is
+// what RestoreTodoItem relies on.
+func DeleteTodoItem(items TodoItemStore, id int) error {
+	item, err := items.GetByID(id)
+	if err != nil {
+		return err
+	}
+	siblings, err := items.GetByTodoID(item.TodoID)
+	if err != nil {
+		return err
+	}
+	family := newItemFamily(siblings)
+
+	if err := items.Delete(id); err != nil {
+		return err
+	}
+	for _, descendant := range family.descendants(id) {
+		if err := items.Delete(descendant.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreTodoItem undoes DeleteTodoItem: it restores the item and the items
+// below it that were deleted along with it or later. Sub-items deleted on
+// their own before the item stay deleted. An item whose parent is deleted
+// can't be restored on its own.
+func RestoreTodoItem(items TodoItemStore, id int) error {
+	item, err := items.GetByIDWithDeleted(id)
+	if err != nil {
+		return err
+	}
+	if item.DeletedAt == nil {
+		return ErrTodoItemNotFound
+	}
+	if item.ParentItemID != nil {
+		parent, err := items.GetByIDWithDeleted(*item.ParentItemID)
+		if err == nil && parent.DeletedAt != nil {
+			return ErrParentItemDeleted
+		}
+	}
+
+	siblings, err := items.GetByTodoIDWithDeleted(item.TodoID)
+	if err != nil {
+		return err
+	}
+	family := newItemFamily(siblings)
+	if err := items.Restore(id); err != nil {
+		return err
+	}
+
+	// Only walk into the sub-items that come back, so nothing is restored
+	// under an item that stays deleted.
+	queue := []int{id}
+	for len(queue) > 0 {
+		for _, child := range family.children[queue[0]] {
+			if child.DeletedAt == nil || child.DeletedAt.Before(*item.DeletedAt) {
+				continue
+			}
+			if err := items.Restore(child.ID); err != nil {
+				return err
+			}
+			queue = append(queue, child.ID)
+		}
+		queue = queue[1:]
+	}
+	return nil
+}
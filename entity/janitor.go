@@ -0,0 +1,268 @@
+package entity
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// PurgeReport lists the records a purge removed, or would remove on a dry
+// run.
+type PurgeReport struct {
+	DryRun  bool      `json:"dry_run"`
+	Cutoff  time.Time `json:"cutoff"`
+	TodoIDs []int     `json:"todo_ids"`
+	ItemIDs []int     `json:"item_ids"`
+	// CommentIDs are the comments on the purged todos and items.
+	CommentIDs []int `json:"comment_ids"`
+	// AttachmentIDs are the attachments of the purged items.
+	AttachmentIDs []int `json:"attachment_ids"`
+	// CollaboratorIDs are the shares of the purged todos.
+	CollaboratorIDs []int `json:"collaborator_ids"`
+}
+
+// Janitor hard-deletes todos and items that have been soft-deleted for longer
+// than the retention window. The items of a purged todo go with it, deleted
+// or not, so nothing is left pointing at a missing todo, and so do the
+// comments on purged todos and items, the attachments of purged items and
+// the shares of purged todos.
+type Janitor struct {
+	todos         TodoStore
+	items         TodoItemStore
+	comments      CommentStore
+	attachments   AttachmentStore
+	collaborators CollaboratorStore
+	blobs         BlobStore
+	unitOfWork    UnitOfWork
+	retention     time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewJanitor(todos TodoStore, items TodoItemStore, comments CommentStore, attachments AttachmentStore, collaborators CollaboratorStore, blobs BlobStore, unitOfWork UnitOfWork, retention time.Duration) *Janitor {
+	return &Janitor{
+		todos:         todos,
+		items:         items,
+		comments:      comments,
+		attachments:   attachments,
+		collaborators: collaborators,
+		blobs:         blobs,
+		unitOfWork:    unitOfWork,
+		retention:     retention,
+	}
+}
+
+// Purge removes everything deleted before now minus the retention window.
+// With dryRun set it only reports what would be removed.
+func (j *Janitor) Purge(dryRun bool) (*PurgeReport, error) {
+	cutoff := time.Now().Add(-j.retention)
+
+	if dryRun {
+		report, _, err := collectExpired(j.todos, j.items, j.comments, j.attachments, j.collaborators, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		report.DryRun = true
+		return report, nil
+	}
+
+	// Collect inside the transaction so that a record restored in the
+	// meantime is not purged.
+	var report *PurgeReport
+	var blobKeys []string
+	err := j.unitOfWork.Do(func(tx Tx) error {
+		var err error
+		report, blobKeys, err = collectExpired(tx.Todos(), tx.Items(), tx.Comments(), tx.Attachments(), tx.Collaborators(), cutoff)
+		if err != nil {
+			return err
+		}
+		for _, id := range report.CollaboratorIDs {
+			if err := tx.Collaborators().Delete(id); err != nil {
+				return err
+			}
+		}
+		for _, id := range report.AttachmentIDs {
+			if err := tx.Attachments().Delete(id); err != nil {
+				return err
+			}
+		}
+		for _, id := range report.CommentIDs {
+			if err := tx.Comments().Purge(id); err != nil {
+				return err
+			}
+		}
+		for _, id := range report.ItemIDs {
+			if err := tx.Items().Purge(id); err != nil {
+				return err
+			}
+		}
+		for _, id := range report.TodoIDs {
+			if err := tx.Todos().Purge(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The content goes only once the metadata is gone for good. If deleting
+	// it fails the blob is orphaned, which wastes space but breaks nothing.
+	for _, key := range blobKeys {
+		if err := j.blobs.Delete(key); err != nil {
+			log.Printf("janitor: delete blob %s: %v", key, err)
+		}
+	}
+	return report, nil
+}
+
+// collectExpired also returns the blob keys of the attachments in the
+// report.
+func collectExpired(todos TodoStore, items TodoItemStore, comments CommentStore, attachments AttachmentStore, collaborators CollaboratorStore, cutoff time.Time) (*PurgeReport, []string, error) {
+	report := &PurgeReport{
+		Cutoff:          cutoff,
+		TodoIDs:         make([]int, 0),
+		ItemIDs:         make([]int, 0),
+		CommentIDs:      make([]int, 0),
+		AttachmentIDs:   make([]int, 0),
+		CollaboratorIDs: make([]int, 0),
+	}
+
+	seen := make(map[int]bool)
+	addItem := func(id int) {
+		if !seen[id] {
+			seen[id] = true
+			report.ItemIDs = append(report.ItemIDs, id)
+		}
+	}
+
+	expiredTodos, err := todos.GetDeletedBefore(cutoff)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, todo := range expiredTodos {
+		report.TodoIDs = append(report.TodoIDs, todo.ID)
+		todoItems, err := items.GetByTodoIDWithDeleted(todo.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, item := range todoItems {
+			addItem(item.ID)
+		}
+	}
+	// Sub-items go with their parent, even those deleted after the cutoff,
+	// so no item is left pointing at a parent that no longer exists.
+	families := make(map[int]*itemFamily)
+	expiredItems, err := items.GetDeletedBefore(cutoff)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, item := range expiredItems {
+		addItem(item.ID)
+		family, ok := families[item.TodoID]
+		if !ok {
+			siblings, err := items.GetByTodoIDWithDeleted(item.TodoID)
+			if err != nil {
+				return nil, nil, err
+			}
+			family = newItemFamily(siblings)
+			families[item.TodoID] = family
+		}
+		for _, descendant := range family.descendants(item.ID) {
+			addItem(descendant.ID)
+		}
+	}
+
+	// Comments go with the todo or item they are on, shares with their
+	// todo.
+	purgedTodos := make(map[int]bool)
+	for _, todoID := range report.TodoIDs {
+		purgedTodos[todoID] = true
+		todoComments, err := comments.GetByTodoID(todoID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, comment := range todoComments {
+			report.CommentIDs = append(report.CommentIDs, comment.ID)
+		}
+		shares, err := collaborators.GetByTodoID(todoID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, collaborator := range shares {
+			report.CollaboratorIDs = append(report.CollaboratorIDs, collaborator.ID)
+		}
+	}
+	for todoID := range families {
+		if purgedTodos[todoID] {
+			continue
+		}
+		todoComments, err := comments.GetByTodoID(todoID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, comment := range todoComments {
+			if comment.ItemID != nil && seen[*comment.ItemID] {
+				report.CommentIDs = append(report.CommentIDs, comment.ID)
+			}
+		}
+	}
+
+	// Attachments go with their item.
+	var blobKeys []string
+	for _, itemID := range report.ItemIDs {
+		itemAttachments, err := attachments.GetByItemID(itemID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, attachment := range itemAttachments {
+			report.AttachmentIDs = append(report.AttachmentIDs, attachment.ID)
+			blobKeys = append(blobKeys, attachment.StorageKey)
+		}
+	}
+
+	sort.Ints(report.TodoIDs)
+	sort.Ints(report.ItemIDs)
+	sort.Ints(report.CommentIDs)
+	sort.Ints(report.AttachmentIDs)
+	sort.Ints(report.CollaboratorIDs)
+	return report, blobKeys, nil
+}
+
+// Start runs Purge every interval until Stop is called.
+func (j *Janitor) Start(interval time.Duration) {
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				report, err := j.Purge(false)
+				if err != nil {
+					log.Printf("janitor: purge failed: %v", err)
+					continue
+				}
+				if len(report.TodoIDs) > 0 || len(report.ItemIDs) > 0 {
+					log.Printf("janitor: purged %d todos and %d items deleted before %s",
+						len(report.TodoIDs), len(report.ItemIDs), report.Cutoff.Format(time.RFC3339))
+				}
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (j *Janitor) Stop() {
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+	}
+}
@@ -0,0 +1,82 @@
+package entity
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJanitorPurge(t *testing.T) {
+	items := NewTodoItemModel()
+	todos := NewTodoModel(items)
+	comments := NewCommentModel()
+	attachments := NewAttachmentModel()
+	collaborators := NewCollaboratorModel()
+	users := NewUserModel(todos, NewTagModel(), collaborators, NewWorkspaceModel())
+	unitOfWork := NewUnitOfWork(users, todos, items, users.tags, comments, attachments, collaborators, users.workspaces)
+	// Without attachments there are no blobs to delete.
+	janitor := NewJanitor(todos, items, comments, attachments, collaborators, nil, unitOfWork, time.Hour)
+
+	// Todo 1 is deleted along with its live item 1; todo 2 is live but its
+	// item 3 is deleted; item 2 stays.
+	for i := 0; i < 2; i++ {
+		if err := todos.Create(&Todo{Title: "todo", UserID: 1, WorkspaceID: DefaultWorkspaceID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, todoID := range []int{1, 2, 2} {
+		if err := items.Create(&TodoItem{Title: "item", TodoID: todoID, UserID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := todos.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := items.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing has been in the trash for an hour yet.
+	report, err := janitor.Purge(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.TodoIDs) != 0 || len(report.ItemIDs) != 0 {
+		t.Errorf("Purge within the retention window = %+v, want nothing", report)
+	}
+
+	janitor.retention = 0
+	report, err = janitor.Purge(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || !reflect.DeepEqual(report.TodoIDs, []int{1}) || !reflect.DeepEqual(report.ItemIDs, []int{1, 3}) {
+		t.Errorf("dry run = %+v, want todo 1 and items 1 and 3", report)
+	}
+	if _, err := todos.GetByIDWithDeleted(1); err != nil {
+		t.Errorf("dry run removed todo 1: %v", err)
+	}
+
+	report, err = janitor.Purge(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DryRun || !reflect.DeepEqual(report.TodoIDs, []int{1}) || !reflect.DeepEqual(report.ItemIDs, []int{1, 3}) {
+		t.Errorf("purge = %+v, want todo 1 and items 1 and 3", report)
+	}
+	if _, err := todos.GetByIDWithDeleted(1); !errors.Is(err, ErrTodoNotFound) {
+		t.Errorf("todo 1 after the purge: error = %v, want ErrTodoNotFound", err)
+	}
+	for _, id := range []int{1, 3} {
+		if _, err := items.GetByIDWithDeleted(id); !errors.Is(err, ErrTodoItemNotFound) {
+			t.Errorf("item %d after the purge: error = %v, want ErrTodoItemNotFound", id, err)
+		}
+	}
+	if _, err := todos.GetByID(2); err != nil {
+		t.Errorf("live todo 2 was purged: %v", err)
+	}
+	if _, err := items.GetByID(2); err != nil {
+		t.Errorf("live item 2 was purged: %v", err)
+	}
+}
@@ -0,0 +1,842 @@
+package entity
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+
+	opPutUser            = "user"
+	opDeleteUser         = "user_delete"
+	opPutTodo            = "todo"
+	opPurgeTodo          = "todo_purge"
+	opPutItem            = "item"
+	opPurgeItem          = "item_purge"
+	opPutTag             = "tag"
+	opDeleteTag          = "tag_delete"
+	opPutComment         = "comment"
+	opPurgeComment       = "comment_purge"
+	opPutAttachment      = "attachment"
+	opDeleteAttachment   = "attachment_delete"
+	opPutCollaborator    = "collaborator"
+	opDeleteCollaborator = "collaborator_delete"
+	opPutWorkspace       = "workspace"
+	opDeleteWorkspace    = "workspace_delete"
+	opPutMember          = "member"
+	opDeleteMember       = "member_delete"
+	opBatch              = "batch"
+)
+
+// journalUser carries the password hash, which User hides from JSON.
+type journalUser struct {
+	User
+	Password string `json:"password"`
+}
+
+func newJournalUser(user *User) *journalUser {
+	return &journalUser{User: *user, Password: user.Password}
+}
+
+func (u *journalUser) toUser() *User {
+	user := u.User
+	user.Password = u.Password
+	return &user
+}
+
+// journalAttachment carries the storage key, which Attachment hides from
+// JSON.
+type journalAttachment struct {
+	Attachment
+	StorageKey string `json:"storage_key"`
+}
+
+func newJournalAttachment(attachment *Attachment) *journalAttachment {
+	return &journalAttachment{Attachment: *attachment, StorageKey: attachment.StorageKey}
+}
+
+func (a *journalAttachment) toAttachment() *Attachment {
+	attachment := a.Attachment
+	attachment.StorageKey = a.StorageKey
+	return &attachment
+}
+
+// journalEntry is one line of the write-ahead log. Each entry holds the full
+// state of the record after the change, so replaying an entry twice is
+// harmless.
+type journalEntry struct {
+	Op           string             `json:"op"`
+	ID           int                `json:"id,omitempty"`
+	User         *journalUser       `json:"user,omitempty"`
+	Todo         *Todo              `json:"todo,omitempty"`
+	Item         *TodoItem          `json:"item,omitempty"`
+	Tag          *Tag               `json:"tag,omitempty"`
+	Comment      *Comment           `json:"comment,omitempty"`
+	Attachment   *journalAttachment `json:"attachment,omitempty"`
+	Collaborator *Collaborator      `json:"collaborator,omitempty"`
+	Workspace    *Workspace         `json:"workspace,omitempty"`
+	Member       *WorkspaceMember   `json:"member,omitempty"`
+
+	// Entries holds the changes of one transaction. They are written as a
+	// single line so a crash either keeps or drops all of them.
+	Entries []journalEntry `json:"entries,omitempty"`
+}
+
+type journalSnapshot struct {
+	CreatedAt     time.Time            `json:"created_at"`
+	Users         []*journalUser       `json:"users"`
+	Todos         []*Todo              `json:"todos"`
+	Items         []*TodoItem          `json:"items"`
+	Tags          []*Tag               `json:"tags"`
+	Comments      []*Comment           `json:"comments"`
+	Attachments   []*journalAttachment `json:"attachments"`
+	Collaborators []*Collaborator      `json:"collaborators"`
+	Workspaces    []*Workspace         `json:"workspaces"`
+	Members       []*WorkspaceMember   `json:"members"`
+
+	// NextIDs keeps the ID counters, which are ahead of the largest ID left
+	// once the newest records are deleted. Older snapshots don't have it, and
+	// the counters then follow from the records.
+	NextIDs *journalNextIDs `json:"next_ids,omitempty"`
+}
+
+type journalNextIDs struct {
+	Users         int `json:"users"`
+	Todos         int `json:"todos"`
+	Items         int `json:"items"`
+	Tags          int `json:"tags"`
+	Comments      int `json:"comments"`
+	Attachments   int `json:"attachments"`
+	Collaborators int `json:"collaborators"`
+	Workspaces    int `json:"workspaces"`
+	Members       int `json:"members"`
+}
+
+// Journal makes the in-memory models durable. Every write made through the
+// stores it hands out runs as a transaction of its unit of work, which
+// appends the write to a write-ahead log before committing it; a write that
+// can't be logged is rolled back. Snapshot periodically compacts the log into
+// a single file so that replay on boot stays short.
+type Journal struct {
+	mu  sync.Mutex
+	dir string
+	wal *os.File
+
+	users         *UserModel
+	todos         *TodoModel
+	items         *TodoItemModel
+	tags          *TagModel
+	comments      *CommentModel
+	attachments   *AttachmentModel
+	collaborators *CollaboratorModel
+	workspaces    *WorkspaceModel
+	unitOfWork    *journaledUnitOfWork
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenJournal rebuilds the given (empty) models from the snapshot and log in
+// dir and opens the log for appending.
+func OpenJournal(dir string, users *UserModel, todos *TodoModel, items *TodoItemModel, tags *TagModel, comments *CommentModel, attachments *AttachmentModel, collaborators *CollaboratorModel, workspaces *WorkspaceModel) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		dir:           dir,
+		users:         users,
+		todos:         todos,
+		items:         items,
+		tags:          tags,
+		comments:      comments,
+		attachments:   attachments,
+		collaborators: collaborators,
+		workspaces:    workspaces,
+	}
+
+	if err := j.loadSnapshot(); err != nil {
+		return nil, fmt.Errorf("load snapshot: %w", err)
+	}
+	if err := j.replay(); err != nil {
+		return nil, fmt.Errorf("replay wal: %w", err)
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j.wal = wal
+	j.unitOfWork = &journaledUnitOfWork{inner: NewUnitOfWork(users, todos, items, tags, comments, attachments, collaborators, workspaces), journal: j}
+
+	return j, nil
+}
+
+func (j *Journal) UserStore() UserStore {
+	return &journaledUserStore{UserModel: j.users, journal: j}
+}
+
+func (j *Journal) TodoStore() TodoStore {
+	return &journaledTodoStore{TodoModel: j.todos, journal: j}
+}
+
+func (j *Journal) TodoItemStore() TodoItemStore {
+	return &journaledTodoItemStore{TodoItemModel: j.items, journal: j}
+}
+
+func (j *Journal) TagStore() TagStore {
+	return &journaledTagStore{TagModel: j.tags, journal: j}
+}
+
+func (j *Journal) CommentStore() CommentStore {
+	return &journaledCommentStore{CommentModel: j.comments, journal: j}
+}
+
+func (j *Journal) AttachmentStore() AttachmentStore {
+	return &journaledAttachmentStore{AttachmentModel: j.attachments, journal: j}
+}
+
+func (j *Journal) CollaboratorStore() CollaboratorStore {
+	return &journaledCollaboratorStore{CollaboratorModel: j.collaborators, journal: j}
+}
+
+func (j *Journal) WorkspaceStore() WorkspaceStore {
+	return &journaledWorkspaceStore{WorkspaceModel: j.workspaces, journal: j}
+}
+
+func (j *Journal) UnitOfWork() UnitOfWork {
+	return j.unitOfWork
+}
+
+func (j *Journal) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(j.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap journalSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	for _, u := range snap.Users {
+		j.users.restore(u.toUser())
+	}
+	for _, todo := range snap.Todos {
+		j.todos.restore(todo)
+	}
+	for _, item := range snap.Items {
+		j.items.restore(item)
+	}
+	for _, tag := range snap.Tags {
+		j.tags.restore(tag)
+	}
+	for _, comment := range snap.Comments {
+		j.comments.restore(comment)
+	}
+	for _, a := range snap.Attachments {
+		j.attachments.restore(a.toAttachment())
+	}
+	for _, collaborator := range snap.Collaborators {
+		j.collaborators.restore(collaborator)
+	}
+	for _, workspace := range snap.Workspaces {
+		j.workspaces.restore(workspace)
+	}
+	for _, member := range snap.Members {
+		j.workspaces.restoreMember(member)
+	}
+
+	if next := snap.NextIDs; next != nil {
+		j.users.reserveIDs(next.Users)
+		j.todos.reserveIDs(next.Todos)
+		j.items.reserveIDs(next.Items)
+		j.tags.reserveIDs(next.Tags)
+		j.comments.reserveIDs(next.Comments)
+		j.attachments.reserveIDs(next.Attachments)
+		j.collaborators.reserveIDs(next.Collaborators)
+		j.workspaces.reserveIDs(next.Workspaces, next.Members)
+	}
+	return nil
+}
+
+func (j *Journal) replay() error {
+	path := filepath.Join(j.dir, walFileName)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// A crash in the middle of an append leaves a partial last
+				// line behind. Drop it so the next append starts cleanly.
+				log.Printf("journal: discarding incomplete wal entry at offset %d", offset)
+				return os.Truncate(path, offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("entry at offset %d: %w", offset, err)
+		}
+		j.apply(entry)
+		offset += int64(len(line))
+	}
+}
+
+func (j *Journal) apply(entry journalEntry) {
+	switch entry.Op {
+	case opPutUser:
+		j.users.restore(entry.User.toUser())
+	case opDeleteUser:
+		j.users.discard(entry.ID)
+	case opPutTodo:
+		j.todos.restore(entry.Todo)
+	case opPurgeTodo:
+		j.todos.Purge(entry.ID)
+	case opPutItem:
+		j.items.restore(entry.Item)
+	case opPurgeItem:
+		j.items.Purge(entry.ID)
+	case opPutTag:
+		j.tags.restore(entry.Tag)
+	case opDeleteTag:
+		j.tags.discard(entry.ID)
+	case opPutComment:
+		j.comments.restore(entry.Comment)
+	case opPurgeComment:
+		j.comments.discard(entry.ID)
+	case opPutAttachment:
+		j.attachments.restore(entry.Attachment.toAttachment())
+	case opDeleteAttachment:
+		j.attachments.discard(entry.ID)
+	case opPutCollaborator:
+		j.collaborators.restore(entry.Collaborator)
+	case opDeleteCollaborator:
+		j.collaborators.discard(entry.ID)
+	case opPutWorkspace:
+		j.workspaces.restore(entry.Workspace)
+	case opDeleteWorkspace:
+		j.workspaces.discard(entry.ID)
+	case opPutMember:
+		j.workspaces.restoreMember(entry.Member)
+	case opDeleteMember:
+		j.workspaces.discardMember(entry.ID)
+	case opBatch:
+		for _, e := range entry.Entries {
+			j.apply(e)
+		}
+	default:
+		log.Printf("journal: skipping unknown wal op %q", entry.Op)
+	}
+}
+
+// append writes entry to the log and flushes it to disk. If that fails the
+// log is cut back to where it was, so the entry can't come back on replay
+// after its changes were rolled back. Callers hold j.mu.
+func (j *Journal) append(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	info, err := j.wal.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := j.wal.Write(data); err != nil {
+		j.wal.Truncate(info.Size())
+		return err
+	}
+	if err := j.wal.Sync(); err != nil {
+		j.wal.Truncate(info.Size())
+		return err
+	}
+	return nil
+}
+
+// Snapshot writes the current state of every model to the snapshot file and
+// truncates the log. Writes are blocked while it runs.
+func (j *Journal) Snapshot() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	snap := journalSnapshot{
+		CreatedAt:     time.Now(),
+		Users:         j.users.snapshot(),
+		Todos:         j.todos.snapshot(),
+		Items:         j.items.snapshot(),
+		Tags:          j.tags.snapshot(),
+		Comments:      j.comments.snapshot(),
+		Attachments:   j.attachments.snapshot(),
+		Collaborators: j.collaborators.snapshot(),
+	}
+	snap.Workspaces, snap.Members = j.workspaces.snapshot()
+	snap.NextIDs = &journalNextIDs{
+		Users:         j.users.nextIDs(),
+		Todos:         j.todos.nextIDs(),
+		Items:         j.items.nextIDs(),
+		Tags:          j.tags.nextIDs(),
+		Comments:      j.comments.nextIDs(),
+		Attachments:   j.attachments.nextIDs(),
+		Collaborators: j.collaborators.nextIDs(),
+	}
+	snap.NextIDs.Workspaces, snap.NextIDs.Members = j.workspaces.nextIDs()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(j.dir, snapshotFileName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(j.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	// The rename only survives a crash once the directory is synced. Until
+	// then the log must stay, or a crash could lose both.
+	if err := syncDir(j.dir); err != nil {
+		return err
+	}
+
+	// If we crash before the truncate the log is replayed on top of the new
+	// snapshot, which is safe because entries are idempotent.
+	return j.wal.Truncate(0)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// StartSnapshots takes a snapshot every interval until Close is called.
+func (j *Journal) StartSnapshots(interval time.Duration) {
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := j.Snapshot(); err != nil {
+					log.Printf("journal: snapshot failed: %v", err)
+				}
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops periodic snapshots, writes a final snapshot and closes the log.
+func (j *Journal) Close() error {
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+	}
+
+	if err := j.Snapshot(); err != nil {
+		j.wal.Close()
+		return err
+	}
+	return j.wal.Close()
+}
+
+// The journaled stores read straight from the models and write through the
+// journal's unit of work, so each write is logged as one batch entry and
+// rolled back if logging it fails.
+
+type journaledUserStore struct {
+	*UserModel
+	journal *Journal
+}
+
+func (s *journaledUserStore) Create(user *User) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Users().Create(user)
+	})
+}
+
+func (s *journaledUserStore) Update(user *User) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Users().Update(user)
+	})
+}
+
+func (s *journaledUserStore) Delete(id int, policy UserDeletePolicy) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Users().Delete(id, policy)
+	})
+}
+
+type journaledTodoStore struct {
+	*TodoModel
+	journal *Journal
+}
+
+func (s *journaledTodoStore) Create(todo *Todo) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Todos().Create(todo)
+	})
+}
+
+func (s *journaledTodoStore) Update(todo *Todo) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Todos().Update(todo)
+	})
+}
+
+func (s *journaledTodoStore) Delete(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Todos().Delete(id)
+	})
+}
+
+func (s *journaledTodoStore) Restore(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Todos().Restore(id)
+	})
+}
+
+func (s *journaledTodoStore) Purge(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Todos().Purge(id)
+	})
+}
+
+func (s *journaledTodoStore) SetTags(id int, tags []string) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Todos().SetTags(id, tags)
+	})
+}
+
+// UpdateCompletionPct counts the items as the transaction sees them rather
+// than through todoItemStore, whose model the transaction has locked.
+func (s *journaledTodoStore) UpdateCompletionPct(todoID int, _ TodoItemStore) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Todos().UpdateCompletionPct(todoID, tx.Items())
+	})
+}
+
+type journaledTodoItemStore struct {
+	*TodoItemModel
+	journal *Journal
+}
+
+func (s *journaledTodoItemStore) Create(item *TodoItem) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Items().Create(item)
+	})
+}
+
+func (s *journaledTodoItemStore) Update(item *TodoItem) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Items().Update(item)
+	})
+}
+
+func (s *journaledTodoItemStore) Delete(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Items().Delete(id)
+	})
+}
+
+func (s *journaledTodoItemStore) Restore(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Items().Restore(id)
+	})
+}
+
+func (s *journaledTodoItemStore) Purge(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Items().Purge(id)
+	})
+}
+
+func (s *journaledTodoItemStore) SetTags(id int, tags []string) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Items().SetTags(id, tags)
+	})
+}
+
+type journaledTagStore struct {
+	*TagModel
+	journal *Journal
+}
+
+func (s *journaledTagStore) Create(tag *Tag) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Tags().Create(tag)
+	})
+}
+
+func (s *journaledTagStore) Update(tag *Tag) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Tags().Update(tag)
+	})
+}
+
+func (s *journaledTagStore) Delete(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Tags().Delete(id)
+	})
+}
+
+type journaledCommentStore struct {
+	*CommentModel
+	journal *Journal
+}
+
+func (s *journaledCommentStore) Create(comment *Comment) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Comments().Create(comment)
+	})
+}
+
+func (s *journaledCommentStore) Update(comment *Comment) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Comments().Update(comment)
+	})
+}
+
+func (s *journaledCommentStore) Delete(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Comments().Delete(id)
+	})
+}
+
+func (s *journaledCommentStore) Purge(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Comments().Purge(id)
+	})
+}
+
+type journaledAttachmentStore struct {
+	*AttachmentModel
+	journal *Journal
+}
+
+func (s *journaledAttachmentStore) Create(attachment *Attachment) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Attachments().Create(attachment)
+	})
+}
+
+func (s *journaledAttachmentStore) Delete(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Attachments().Delete(id)
+	})
+}
+
+type journaledCollaboratorStore struct {
+	*CollaboratorModel
+	journal *Journal
+}
+
+func (s *journaledCollaboratorStore) Create(collaborator *Collaborator) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Collaborators().Create(collaborator)
+	})
+}
+
+func (s *journaledCollaboratorStore) Update(collaborator *Collaborator) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Collaborators().Update(collaborator)
+	})
+}
+
+func (s *journaledCollaboratorStore) Delete(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Collaborators().Delete(id)
+	})
+}
+
+type journaledWorkspaceStore struct {
+	*WorkspaceModel
+	journal *Journal
+}
+
+func (s *journaledWorkspaceStore) Create(workspace *Workspace) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Workspaces().Create(workspace)
+	})
+}
+
+func (s *journaledWorkspaceStore) Update(workspace *Workspace) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Workspaces().Update(workspace)
+	})
+}
+
+// Delete logs only the workspace; replaying its deletion removes the
+// members as well.
+func (s *journaledWorkspaceStore) Delete(id int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Workspaces().Delete(id)
+	})
+}
+
+func (s *journaledWorkspaceStore) AddMember(member *WorkspaceMember) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Workspaces().AddMember(member)
+	})
+}
+
+func (s *journaledWorkspaceStore) UpdateMember(member *WorkspaceMember) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Workspaces().UpdateMember(member)
+	})
+}
+
+func (s *journaledWorkspaceStore) RemoveMember(workspaceID, userID int) error {
+	return s.journal.unitOfWork.Do(func(tx Tx) error {
+		return tx.Workspaces().RemoveMember(workspaceID, userID)
+	})
+}
+
+// journaledUnitOfWork logs everything a transaction touched as one batch
+// entry before the transaction commits. If the entry can't be written the
+// transaction is rolled back like any other that fails.
+type journaledUnitOfWork struct {
+	inner   *MemoryUnitOfWork
+	journal *Journal
+}
+
+func (u *journaledUnitOfWork) Do(fn func(tx Tx) error) error {
+	u.journal.mu.Lock()
+	defer u.journal.mu.Unlock()
+
+	_, err := u.inner.do(func(tx *memoryTx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if batch := tx.journalBatch(); len(batch.Entries) > 0 {
+			return u.journal.append(batch)
+		}
+		return nil
+	})
+	return err
+}
+
+// journalBatch returns the entry that logs the current state of every record
+// the transaction wrote to. Records that are gone were deleted or purged.
+func (tx *memoryTx) journalBatch() journalEntry {
+	batch := journalEntry{Op: opBatch}
+	for id := range tx.userIDs {
+		if user, err := tx.users.getByID(id); err == nil {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutUser, User: newJournalUser(user)})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opDeleteUser, ID: id})
+		}
+	}
+	for id := range tx.todoIDs {
+		if todo, err := tx.todos.getByIDWithDeleted(id); err == nil {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutTodo, Todo: todo})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPurgeTodo, ID: id})
+		}
+	}
+	for id := range tx.itemIDs {
+		if item, err := tx.items.getByIDWithDeleted(id); err == nil {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutItem, Item: item})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPurgeItem, ID: id})
+		}
+	}
+	for id := range tx.tagIDs {
+		if tag, err := tx.tags.getByID(id); err == nil {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutTag, Tag: tag})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opDeleteTag, ID: id})
+		}
+	}
+	for id := range tx.commentIDs {
+		if comment, ok := tx.comments.find(id); ok {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutComment, Comment: comment})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPurgeComment, ID: id})
+		}
+	}
+	for id := range tx.attachmentIDs {
+		if attachment, err := tx.attachments.getByID(id); err == nil {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutAttachment, Attachment: newJournalAttachment(attachment)})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opDeleteAttachment, ID: id})
+		}
+	}
+	for id := range tx.collaboratorIDs {
+		if collaborator, err := tx.collaborators.getByID(id); err == nil {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutCollaborator, Collaborator: collaborator})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opDeleteCollaborator, ID: id})
+		}
+	}
+	for id := range tx.workspaceIDs {
+		if workspace, err := tx.workspaces.getByID(id); err == nil {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutWorkspace, Workspace: workspace})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opDeleteWorkspace, ID: id})
+		}
+	}
+	for id := range tx.memberIDs {
+		if member, ok := tx.workspaces.findMember(id); ok {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opPutMember, Member: member})
+		} else {
+			batch.Entries = append(batch.Entries, journalEntry{Op: opDeleteMember, ID: id})
+		}
+	}
+	return batch
+}
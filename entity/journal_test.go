@@ -0,0 +1,217 @@
+package entity
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestJournal opens the journal in dir over a fresh set of models.
+func openTestJournal(t *testing.T, dir string) *Journal {
+	t.Helper()
+	u := newTestUnitOfWork(NewTodoModel(NewTodoItemModel()))
+	j, err := OpenJournal(dir, u.users, u.todos, u.items, u.tags, u.comments, u.attachments, u.collaborators, u.workspaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// crash closes the log without the final snapshot Close would write, as if
+// the process died.
+func crash(t *testing.T, j *Journal) {
+	t.Helper()
+	if err := j.wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func walEntries(t *testing.T, dir string) int {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, dir)
+	todos, items := j.TodoStore(), j.TodoItemStore()
+
+	if err := j.UserStore().Create(&User{Username: "alice", Password: "secret", Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	todo := &Todo{Title: "groceries", UserID: 1, WorkspaceID: DefaultWorkspaceID}
+	if err := todos.Create(todo); err != nil {
+		t.Fatal(err)
+	}
+	todo.Title = "more groceries"
+	if err := todos.Update(todo); err != nil {
+		t.Fatal(err)
+	}
+	kept := &TodoItem{Title: "milk", TodoID: todo.ID, UserID: 1}
+	gone := &TodoItem{Title: "eggs", TodoID: todo.ID, UserID: 1}
+	err := j.UnitOfWork().Do(func(tx Tx) error {
+		if err := tx.Items().Create(kept); err != nil {
+			return err
+		}
+		if err := tx.Items().Create(gone); err != nil {
+			return err
+		}
+		kept.Completed = true
+		if err := tx.Items().Update(kept); err != nil {
+			return err
+		}
+		return tx.Todos().UpdateCompletionPct(todo.ID, tx.Items())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := items.Delete(gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := items.Purge(gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	want, err := j.todos.GetByID(todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(t, j)
+
+	j = openTestJournal(t, dir)
+	defer j.Close()
+	if user, err := j.users.GetByUsername("alice"); err != nil || !user.CheckPassword("secret") {
+		t.Errorf("replayed user = %+v, %v; want alice with her password", user, err)
+	}
+	got, err := j.todos.GetByID(todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "more groceries" || got.Version != want.Version || got.CompletionPct != want.CompletionPct {
+		t.Errorf("replayed todo = %+v, want %+v", got, want)
+	}
+	if list, _ := j.items.GetByTodoIDWithDeleted(todo.ID); len(list) != 1 || list[0].ID != kept.ID || !list[0].Completed {
+		t.Errorf("replayed items = %+v, want only the completed item %d", list, kept.ID)
+	}
+}
+
+// A write whose log entry can't be written is rolled back, and nothing of
+// it comes back on replay.
+func TestJournalRollsBackFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, dir)
+	todo := &Todo{Title: "logged", UserID: 1, WorkspaceID: DefaultWorkspaceID}
+	if err := j.TodoStore().Create(todo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Appends fail once the log is closed.
+	crash(t, j)
+	err := j.UnitOfWork().Do(func(tx Tx) error {
+		if err := tx.Todos().Create(&Todo{Title: "lost", UserID: 1, WorkspaceID: DefaultWorkspaceID}); err != nil {
+			return err
+		}
+		changed := *todo
+		changed.Title = "changed"
+		return tx.Todos().Update(&changed)
+	})
+	if err == nil {
+		t.Fatal("write without a log succeeded")
+	}
+	if list, _ := j.todos.GetByWorkspaceIDWithDeleted(DefaultWorkspaceID); len(list) != 1 || list[0].Title != "logged" {
+		t.Errorf("todos after the failed write = %+v, want only the logged one, unchanged", list)
+	}
+
+	j = openTestJournal(t, dir)
+	defer j.Close()
+	if list, _ := j.todos.GetByWorkspaceIDWithDeleted(DefaultWorkspaceID); len(list) != 1 || list[0].Title != "logged" {
+		t.Errorf("replayed todos = %+v, want only the logged one, unchanged", list)
+	}
+	// The rolled back create doesn't use up an ID either.
+	next := &Todo{Title: "next", UserID: 1, WorkspaceID: DefaultWorkspaceID}
+	if err := j.TodoStore().Create(next); err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != todo.ID+1 {
+		t.Errorf("next todo ID = %d, want %d", next.ID, todo.ID+1)
+	}
+}
+
+func TestJournalSnapshotAndTail(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, dir)
+	todos := j.TodoStore()
+
+	before := &Todo{Title: "before", UserID: 1, WorkspaceID: DefaultWorkspaceID}
+	if err := todos.Create(before); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if n := walEntries(t, dir); n != 0 {
+		t.Errorf("log holds %d entries after the snapshot, want 0", n)
+	}
+
+	after := &Todo{Title: "after", UserID: 1, WorkspaceID: DefaultWorkspaceID}
+	if err := todos.Create(after); err != nil {
+		t.Fatal(err)
+	}
+	before.Title = "before, changed after"
+	if err := todos.Update(before); err != nil {
+		t.Fatal(err)
+	}
+	if n := walEntries(t, dir); n != 2 {
+		t.Errorf("log holds %d entries, want the 2 since the snapshot", n)
+	}
+	crash(t, j)
+
+	j = openTestJournal(t, dir)
+	defer j.Close()
+	for id, want := range map[int]string{before.ID: "before, changed after", after.ID: "after"} {
+		if got, err := j.todos.GetByID(id); err != nil || got.Title != want {
+			t.Errorf("restored todo %d = %+v, %v; want %q, from the snapshot with the log on top", id, got, err, want)
+		}
+	}
+}
+
+// The snapshot keeps the ID counters, so IDs of records purged before it
+// aren't handed out again.
+func TestJournalKeepsNextIDs(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, dir)
+	todos := j.TodoStore()
+
+	for _, title := range []string{"kept", "purged"} {
+		if err := todos.Create(&Todo{Title: title, UserID: 1, WorkspaceID: DefaultWorkspaceID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := todos.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.Purge(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j = openTestJournal(t, dir)
+	defer j.Close()
+	if _, err := j.todos.GetByIDWithDeleted(2); !errors.Is(err, ErrTodoNotFound) {
+		t.Errorf("purged todo: error = %v, want ErrTodoNotFound", err)
+	}
+	todo := &Todo{Title: "new", UserID: 1, WorkspaceID: DefaultWorkspaceID}
+	if err := j.TodoStore().Create(todo); err != nil {
+		t.Fatal(err)
+	}
+	if todo.ID != 3 {
+		t.Errorf("new todo ID = %d, want 3", todo.ID)
+	}
+}
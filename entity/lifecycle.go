@@ -0,0 +1,166 @@
+package entity
+
+import (
+	"errors"
+	"time"
+)
+
+// Lifecycle rules between users, todos and items. Every store applies them
+// itself, so callers only ever delete the parent record:
+//
+//   - Deleting a todo soft-deletes its live items with the same DeletedAt,
+//     which is how a restore recognises the items that went with it.
+//   - Deleting a user hard-deletes the user and handles their todos as the
+//     UserDeletePolicy says. The user's tags are deleted too, or with
+//     UserDeleteReassign go to the new owner of the todos, merging into the
+//     tags that owner already has by the same name. The todos shared with
+//     the user are no longer shared with them, and a todo the new owner
+//     gets is no longer shared with the new owner either. The user leaves
+//     their workspaces, and the new owner joins those of the todos they
+//     get as a member.
+
+const (
+	// UserDeleteCascade soft-deletes the user's live todos and their items.
+	UserDeleteCascade = "cascade"
+	// UserDeleteBlock refuses to delete a user who owns live todos.
+	UserDeleteBlock = "block"
+	// UserDeleteReassign hands the user's todos, deleted ones included, and
+	// the items they created over to another user.
+	UserDeleteReassign = "reassign"
+)
+
+// UserDeletePolicy decides what happens to a user's todos when the user is
+// deleted. ReassignTo is only used with UserDeleteReassign.
+type UserDeletePolicy struct {
+	Action     string
+	ReassignTo int
+}
+
+// Validate reports whether the policy names a known action. Whether
+// ReassignTo points at an existing user is checked by the store.
+func (p UserDeletePolicy) Validate() error {
+	switch p.Action {
+	case UserDeleteCascade, UserDeleteBlock, UserDeleteReassign:
+		return nil
+	}
+	return ErrInvalidDeletePolicy
+}
+
+func (tx *memoryTx) deleteTodo(id int) error {
+	now := time.Now()
+
+	tx.saveTodo(id)
+	if err := tx.todos.delete(id, now); err != nil {
+		return err
+	}
+
+	for itemID := range tx.items.byTodo[id] {
+		if tx.items.items[itemID].DeletedAt == nil {
+			tx.saveItem(itemID)
+			if err := tx.items.delete(itemID, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (tx *memoryTx) deleteUser(id int, policy UserDeletePolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	if _, exists := tx.users.users[id]; !exists {
+		return ErrUserNotFound
+	}
+
+	// Copy the IDs, the loops below change the index they come from.
+	var todoIDs []int
+	for todoID := range tx.todos.byUser[id] {
+		todoIDs = append(todoIDs, todoID)
+	}
+
+	switch policy.Action {
+	case UserDeleteBlock:
+		for _, todoID := range todoIDs {
+			if tx.todos.todos[todoID].DeletedAt == nil {
+				return ErrUserHasTodos
+			}
+		}
+
+	case UserDeleteCascade:
+		for _, todoID := range todoIDs {
+			if tx.todos.todos[todoID].DeletedAt == nil {
+				if err := tx.deleteTodo(todoID); err != nil {
+					return err
+				}
+			}
+		}
+
+	case UserDeleteReassign:
+		if _, exists := tx.users.users[policy.ReassignTo]; !exists || policy.ReassignTo == id {
+			return ErrInvalidReassignTarget
+		}
+
+		now := time.Now()
+		for _, todoID := range todoIDs {
+			if collaboratorID, ok := tx.collaborators.byTodo[todoID][policy.ReassignTo]; ok {
+				tx.saveCollaborator(collaboratorID)
+				tx.collaborators.remove(collaboratorID)
+			}
+			tx.saveTodo(todoID)
+			todo := tx.todos.todos[todoID]
+			if _, member := tx.workspaces.byWorkspace[todo.WorkspaceID][policy.ReassignTo]; !member {
+				tx.saveMember(tx.workspaces.nextMemberID)
+				err := tx.workspaces.addMember(&WorkspaceMember{WorkspaceID: todo.WorkspaceID, UserID: policy.ReassignTo, Role: WorkspaceRoleMember})
+				if err != nil && !errors.Is(err, ErrWorkspaceNotFound) {
+					return err
+				}
+			}
+			todo.UserID = policy.ReassignTo
+			todo.UpdatedAt = now
+			todo.Version++
+			tx.todos.index(todo)
+		}
+		for _, tagID := range tx.tags.byUser[id] {
+			tx.saveTag(tagID)
+			tag := cloneTag(tx.tags.tags[tagID])
+			tx.tags.remove(tagID)
+			if _, taken := tx.tags.byUser[policy.ReassignTo][tag.Name]; taken {
+				continue
+			}
+			tag.UserID = policy.ReassignTo
+			tag.UpdatedAt = now
+			tag.Version++
+			tx.tags.tags[tagID] = tag
+			tx.tags.index(tag)
+		}
+		for itemID, item := range tx.items.items {
+			if item.UserID == id {
+				tx.saveItem(itemID)
+				item.UserID = policy.ReassignTo
+				item.UpdatedAt = now
+				item.Version++
+			}
+		}
+	}
+
+	// Whatever tags are left still belong to the user.
+	for _, tagID := range tx.tags.byUser[id] {
+		tx.saveTag(tagID)
+		tx.tags.remove(tagID)
+	}
+
+	for collaboratorID := range tx.collaborators.byUser[id] {
+		tx.saveCollaborator(collaboratorID)
+		tx.collaborators.remove(collaboratorID)
+	}
+
+	for memberID := range tx.workspaces.byUser[id] {
+		tx.saveMember(memberID)
+		tx.workspaces.unindexMember(memberID)
+	}
+
+	tx.saveUser(id)
+	tx.users.remove(id)
+	return nil
+}
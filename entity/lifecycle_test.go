@@ -0,0 +1,127 @@
+package entity
+
+import (
+	"errors"
+	"testing"
+)
+
+type lifecycleFixture struct {
+	users *UserModel
+	todos *TodoModel
+	items *TodoItemModel
+}
+
+// newLifecycleFixture sets up users 1 and 2. User 1 owns todo 1 with items 1
+// and 2, item 2 already deleted, and a deleted todo 2; user 2 added item 3 to
+// todo 1.
+func newLifecycleFixture(t *testing.T) *lifecycleFixture {
+	t.Helper()
+	f := &lifecycleFixture{items: NewTodoItemModel()}
+	f.todos = NewTodoModel(f.items)
+	f.users = NewUserModel(f.todos, NewTagModel(), NewCollaboratorModel(), NewWorkspaceModel())
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := f.users.Create(&User{Username: name, Password: "secret", Role: "user"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := f.todos.Create(&Todo{Title: "todo", UserID: 1, WorkspaceID: DefaultWorkspaceID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, userID := range []int{1, 1, 2} {
+		if err := f.items.Create(&TodoItem{Title: "item", TodoID: 1, UserID: userID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.items.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.todos.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestTodoDeleteCascadesToItems(t *testing.T) {
+	f := newLifecycleFixture(t)
+	before, err := f.items.GetByIDWithDeleted(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.todos.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	todo, err := f.todos.GetByIDWithDeleted(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 3} {
+		item, err := f.items.GetByIDWithDeleted(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.DeletedAt == nil || !item.DeletedAt.Equal(*todo.DeletedAt) {
+			t.Errorf("item %d deleted_at = %v, want the todo's %v", id, item.DeletedAt, todo.DeletedAt)
+		}
+	}
+	// An item deleted before the todo keeps its own time.
+	if after, _ := f.items.GetByIDWithDeleted(2); !after.DeletedAt.Equal(*before.DeletedAt) {
+		t.Errorf("item 2 deleted_at = %v, want it unchanged at %v", after.DeletedAt, before.DeletedAt)
+	}
+}
+
+func TestUserDeletePolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy UserDeletePolicy
+		err    error
+		// What is left of todo 1 and item 1 afterwards, and who owns them.
+		todoDeleted bool
+		owner       int
+	}{
+		{"block", UserDeletePolicy{Action: UserDeleteBlock}, ErrUserHasTodos, false, 1},
+		{"cascade", UserDeletePolicy{Action: UserDeleteCascade}, nil, true, 1},
+		{"reassign", UserDeletePolicy{Action: UserDeleteReassign, ReassignTo: 2}, nil, false, 2},
+		{"reassign to self", UserDeletePolicy{Action: UserDeleteReassign, ReassignTo: 1}, ErrInvalidReassignTarget, false, 1},
+		{"reassign to nobody", UserDeletePolicy{Action: UserDeleteReassign, ReassignTo: 9}, ErrInvalidReassignTarget, false, 1},
+		{"unknown action", UserDeletePolicy{Action: "archive"}, ErrInvalidDeletePolicy, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLifecycleFixture(t)
+			err := f.users.Delete(1, tt.policy)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Delete: error = %v, want %v", err, tt.err)
+			}
+			_, getErr := f.users.GetByID(1)
+			if deleted := errors.Is(getErr, ErrUserNotFound); deleted != (tt.err == nil) {
+				t.Errorf("user deleted = %v, want %v", deleted, tt.err == nil)
+			}
+
+			todo, err := f.todos.GetByIDWithDeleted(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			item, err := f.items.GetByIDWithDeleted(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (todo.DeletedAt != nil) != tt.todoDeleted || (item.DeletedAt != nil) != tt.todoDeleted {
+				t.Errorf("todo deleted_at = %v, item deleted_at = %v; want deleted %v", todo.DeletedAt, item.DeletedAt, tt.todoDeleted)
+			}
+			if todo.UserID != tt.owner || item.UserID != tt.owner {
+				t.Errorf("todo owner = %d, item owner = %d; want %d", todo.UserID, item.UserID, tt.owner)
+			}
+			// The deleted todo goes along on reassign, and bob's item stays his.
+			if deleted, _ := f.todos.GetByIDWithDeleted(2); deleted.UserID != tt.owner {
+				t.Errorf("deleted todo owner = %d, want %d", deleted.UserID, tt.owner)
+			}
+			if other, _ := f.items.GetByIDWithDeleted(3); other.UserID != 2 {
+				t.Errorf("item 3 owner = %d, want 2", other.UserID)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package entity
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ListOptions selects one page of a listing. Records are ordered by Sort,
+// descending when Desc is set, with the ID breaking ties so that the order is
+// the same on every request and Cursor can pick up where the previous page
+// ended. A Limit of 0 returns everything after the cursor.
+type ListOptions struct {
+	Sort   string
+	Desc   bool
+	Limit  int
+	Cursor string
+}
+
+const defaultSort = "created_at"
+
+// TimeRange matches times in [After, Before). A zero bound is open.
+type TimeRange struct {
+	After  time.Time
+	Before time.Time
+}
+
+func (r TimeRange) Contains(t time.Time) bool {
+	return (r.After.IsZero() || !t.Before(r.After)) && (r.Before.IsZero() || t.Before(r.Before))
+}
+
+// TodoFilter restricts a todo listing. Nil and zero fields match everything.
+type TodoFilter struct {
+	MinCompletion *float64
+	MaxCompletion *float64
+	Created       TimeRange
+	Updated       TimeRange
+	Deleted       *bool
+	Due           DueFilter
+	Tags          TagFilter
+}
+
+func (f TodoFilter) Matches(todo *Todo) bool {
+	if f.MinCompletion != nil && todo.CompletionPct < *f.MinCompletion {
+		return false
+	}
+	if f.MaxCompletion != nil && todo.CompletionPct > *f.MaxCompletion {
+		return false
+	}
+	if f.Deleted != nil && *f.Deleted != (todo.DeletedAt != nil) {
+		return false
+	}
+	if !f.Due.matches(todo.Schedule, todo.CompletionPct >= 100) {
+		return false
+	}
+	if !f.Tags.matches(todo.Tags) {
+		return false
+	}
+	return f.Created.Contains(todo.CreatedAt) && f.Updated.Contains(todo.UpdatedAt)
+}
+
+// TodoItemFilter restricts an item listing. Nil and zero fields match
+// everything.
+type TodoItemFilter struct {
+	Completed *bool
+	Created   TimeRange
+	Updated   TimeRange
+	Deleted   *bool
+	Due       DueFilter
+	Tags      TagFilter
+}
+
+func (f TodoItemFilter) Matches(item *TodoItem) bool {
+	if f.Completed != nil && *f.Completed != item.Completed {
+		return false
+	}
+	if f.Deleted != nil && *f.Deleted != (item.DeletedAt != nil) {
+		return false
+	}
+	if !f.Due.matches(item.Schedule, item.Completed) {
+		return false
+	}
+	if !f.Tags.matches(item.Tags) {
+		return false
+	}
+	return f.Created.Contains(item.CreatedAt) && f.Updated.Contains(item.UpdatedAt)
+}
+
+// UserFilter restricts a user listing. Zero fields match everything.
+type UserFilter struct {
+	Role    string
+	Created TimeRange
+	Updated TimeRange
+}
+
+func (f UserFilter) Matches(user *User) bool {
+	if f.Role != "" && f.Role != user.Role {
+		return false
+	}
+	return f.Created.Contains(user.CreatedAt) && f.Updated.Contains(user.UpdatedAt)
+}
+
+// sortKey is a record's position in a listing. Only the field that matches
+// the sort order is set, so comparing all of them in turn is enough.
+type sortKey struct {
+	Time int64   `json:"t,omitempty"`
+	Num  float64 `json:"n,omitempty"`
+	Str  string  `json:"s,omitempty"`
+	ID   int     `json:"id"`
+}
+
+func (k sortKey) less(o sortKey) bool {
+	switch {
+	case k.Time != o.Time:
+		return k.Time < o.Time
+	case k.Num != o.Num:
+		return k.Num < o.Num
+	case k.Str != o.Str:
+		return k.Str < o.Str
+	}
+	return k.ID < o.ID
+}
+
+func timeKey(t time.Time, id int) sortKey {
+	return sortKey{Time: t.UnixNano(), ID: id}
+}
+
+func textKey(s string, id int) sortKey {
+	return sortKey{Str: strings.ToLower(s), ID: id}
+}
+
+// cursor is what ListOptions.Cursor decodes to: the sort order it was issued
+// for, the key of the last record on the previous page and the time the keys
+// were computed at (see listTime).
+type cursor struct {
+	Sort string  `json:"sort"`
+	Desc bool    `json:"desc,omitempty"`
+	Last sortKey `json:"last"`
+	Now  int64   `json:"now,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// listTime returns the time to compute sort keys at: now for the first page,
+// and for later pages the time the first one was computed at, which their
+// cursor carries. Urgency changes over time, so keys computed at another time
+// would put records the previous pages already returned after the cursor
+// again, or skip ones they didn't.
+func listTime(opts ListOptions) (time.Time, error) {
+	if opts.Cursor == "" {
+		return time.Now(), nil
+	}
+	c, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// Cursors issued before the time was added don't have it
+	if c.Now == 0 {
+		return time.Now(), nil
+	}
+	return time.Unix(0, c.Now), nil
+}
+
+// paginate orders the keys, computed at now, as opts asks and returns the
+// indexes of the records on the requested page, along with the cursor of the
+// next page or "" if this is the last one.
+func paginate(keys []sortKey, opts ListOptions, now time.Time) ([]int, string, error) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		if opts.Desc {
+			return keys[order[b]].less(keys[order[a]])
+		}
+		return keys[order[a]].less(keys[order[b]])
+	})
+
+	start := 0
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if c.Sort != opts.Sort || c.Desc != opts.Desc {
+			return nil, "", ErrInvalidCursor
+		}
+		start = sort.Search(len(order), func(i int) bool {
+			if opts.Desc {
+				return keys[order[i]].less(c.Last)
+			}
+			return c.Last.less(keys[order[i]])
+		})
+	}
+
+	end := len(order)
+	if opts.Limit > 0 && start+opts.Limit < end {
+		end = start + opts.Limit
+	}
+
+	var next string
+	if end < len(order) {
+		next = encodeCursor(cursor{Sort: opts.Sort, Desc: opts.Desc, Last: keys[order[end-1]], Now: now.UnixNano()})
+	}
+	return order[start:end], next, nil
+}
+
+// Sort keys are computed with the same now for every record of a listing, as
+// urgency changes over time. Later pages reuse the now of the first one (see
+// listTime), so paging through a listing sorted by urgency neither repeats
+// nor skips records, even though the urgencies in the responses will have
+// moved on by then.
+var todoSortKeys = map[string]func(*Todo, time.Time) sortKey{
+	"created_at":     func(t *Todo, _ time.Time) sortKey { return timeKey(t.CreatedAt, t.ID) },
+	"updated_at":     func(t *Todo, _ time.Time) sortKey { return timeKey(t.UpdatedAt, t.ID) },
+	"title":          func(t *Todo, _ time.Time) sortKey { return textKey(t.Title, t.ID) },
+	"completion_pct": func(t *Todo, _ time.Time) sortKey { return sortKey{Num: t.CompletionPct, ID: t.ID} },
+	"due_at":         func(t *Todo, _ time.Time) sortKey { return dueKey(t.Schedule, t.ID) },
+	"priority":       func(t *Todo, _ time.Time) sortKey { return priorityKey(t.Priority, t.ID) },
+	"urgency":        func(t *Todo, now time.Time) sortKey { return sortKey{Num: t.Urgency(now), ID: t.ID} },
+}
+
+// ListTodos filters todos and returns the page opts asks for, plus the
+// cursor of the next page.
+func ListTodos(todos []*Todo, filter TodoFilter, opts ListOptions) ([]*Todo, string, error) {
+	if opts.Sort == "" {
+		opts.Sort = defaultSort
+	}
+	keyOf, ok := todoSortKeys[opts.Sort]
+	if !ok {
+		return nil, "", ErrInvalidSort
+	}
+
+	var matched []*Todo
+	var keys []sortKey
+	now, err := listTime(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, todo := range todos {
+		if filter.Matches(todo) {
+			matched = append(matched, todo)
+			keys = append(keys, keyOf(todo, now))
+		}
+	}
+
+	idx, next, err := paginate(keys, opts, now)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]*Todo, len(idx))
+	for i, j := range idx {
+		result[i] = matched[j]
+	}
+	return result, next, nil
+}
+
+var todoItemSortKeys = map[string]func(*TodoItem, time.Time) sortKey{
+	"position":   nil, // needs the whole family, see ListTodoItems
+	"created_at": func(t *TodoItem, _ time.Time) sortKey { return timeKey(t.CreatedAt, t.ID) },
+	"updated_at": func(t *TodoItem, _ time.Time) sortKey { return timeKey(t.UpdatedAt, t.ID) },
+	"title":      func(t *TodoItem, _ time.Time) sortKey { return textKey(t.Title, t.ID) },
+	"due_at":     func(t *TodoItem, _ time.Time) sortKey { return dueKey(t.Schedule, t.ID) },
+	"priority":   func(t *TodoItem, _ time.Time) sortKey { return priorityKey(t.Priority, t.ID) },
+	"urgency":    func(t *TodoItem, now time.Time) sortKey { return sortKey{Num: t.Urgency(now), ID: t.ID} },
+}
+
+// ListTodoItems is ListTodos for items, which are in their manual order
+// unless opts asks for another.
+func ListTodoItems(items []*TodoItem, filter TodoItemFilter, opts ListOptions) ([]*TodoItem, string, error) {
+	if opts.Sort == "" {
+		opts.Sort = "position"
+	}
+	keyOf, ok := todoItemSortKeys[opts.Sort]
+	if !ok {
+		return nil, "", ErrInvalidSort
+	}
+	if opts.Sort == "position" {
+		family := newItemFamily(items)
+		keyOf = func(item *TodoItem, _ time.Time) sortKey { return family.treeKey(item) }
+	}
+
+	var matched []*TodoItem
+	var keys []sortKey
+	now, err := listTime(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, item := range items {
+		if filter.Matches(item) {
+			matched = append(matched, item)
+			keys = append(keys, keyOf(item, now))
+		}
+	}
+
+	idx, next, err := paginate(keys, opts, now)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]*TodoItem, len(idx))
+	for i, j := range idx {
+		result[i] = matched[j]
+	}
+	return result, next, nil
+}
+
+var userSortKeys = map[string]func(*User, time.Time) sortKey{
+	"created_at": func(u *User, _ time.Time) sortKey { return timeKey(u.CreatedAt, u.ID) },
+	"updated_at": func(u *User, _ time.Time) sortKey { return timeKey(u.UpdatedAt, u.ID) },
+	"username":   func(u *User, _ time.Time) sortKey { return textKey(u.Username, u.ID) },
+}
+
+// ListUsers is ListTodos for users.
+func ListUsers(users []*User, filter UserFilter, opts ListOptions) ([]*User, string, error) {
+	if opts.Sort == "" {
+		opts.Sort = defaultSort
+	}
+	keyOf, ok := userSortKeys[opts.Sort]
+	if !ok {
+		return nil, "", ErrInvalidSort
+	}
+
+	var matched []*User
+	var keys []sortKey
+	now, err := listTime(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, user := range users {
+		if filter.Matches(user) {
+			matched = append(matched, user)
+			keys = append(keys, keyOf(user, now))
+		}
+	}
+
+	idx, next, err := paginate(keys, opts, now)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]*User, len(idx))
+	for i, j := range idx {
+		result[i] = matched[j]
+	}
+	return result, next, nil
+}
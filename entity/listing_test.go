@@ -0,0 +1,187 @@
+package entity
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Two pairs of ties, which the ID has to break.
+var listingKeys = []sortKey{
+	{Num: 3, ID: 1},
+	{Num: 1, ID: 2},
+	{Num: 3, ID: 3},
+	{Num: 2, ID: 4},
+	{Num: 1, ID: 5},
+}
+
+// pageThrough follows the cursors from the first page to the last and
+// returns the pages.
+func pageThrough(t *testing.T, keys []sortKey, opts ListOptions) [][]int {
+	t.Helper()
+	var pages [][]int
+	for {
+		page, next, err := paginate(keys, opts, time.Now())
+		if err != nil {
+			t.Fatalf("paginate(%+v): %v", opts, err)
+		}
+		pages = append(pages, page)
+		if next == "" {
+			return pages
+		}
+		if len(pages) > len(keys)+1 {
+			t.Fatalf("paginate(%+v) doesn't stop", opts)
+		}
+		opts.Cursor = next
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name  string
+		desc  bool
+		limit int
+		want  [][]int
+	}{
+		{"everything", false, 0, [][]int{{1, 4, 3, 0, 2}}},
+		{"limit above the count", false, 10, [][]int{{1, 4, 3, 0, 2}}},
+		{"limit equal to the count", false, 5, [][]int{{1, 4, 3, 0, 2}}},
+		{"pages of two", false, 2, [][]int{{1, 4}, {3, 0}, {2}}},
+		{"pages of one", false, 1, [][]int{{1}, {4}, {3}, {0}, {2}}},
+		{"descending", true, 0, [][]int{{2, 0, 3, 4, 1}}},
+		{"descending pages of two", true, 2, [][]int{{2, 0}, {3, 4}, {1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pageThrough(t, listingKeys, ListOptions{Sort: "completion_pct", Desc: tt.desc, Limit: tt.limit})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaginateEmpty(t *testing.T) {
+	page, next, err := paginate(nil, ListOptions{Sort: "completion_pct", Limit: 2}, time.Now())
+	if err != nil || len(page) != 0 || next != "" {
+		t.Errorf("paginate(nil) = %v, %q, %v; want an empty last page", page, next, err)
+	}
+}
+
+// A cursor holds the last key rather than an offset, so records added or
+// removed in front of it don't shift the next page.
+func TestPaginateCursorSurvivesChanges(t *testing.T) {
+	opts := ListOptions{Sort: "completion_pct", Limit: 2}
+	_, next, err := paginate(listingKeys, opts, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the last record of the first page, and add one in front of it.
+	changed := []sortKey{listingKeys[0], listingKeys[1], listingKeys[2], listingKeys[3], {Num: 0, ID: 6}}
+	opts.Cursor = next
+	page, _, err := paginate(changed, opts, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 0}; !reflect.DeepEqual(page, want) {
+		t.Errorf("page = %v, want %v", page, want)
+	}
+}
+
+func TestPaginateInvalidCursor(t *testing.T) {
+	_, next, err := paginate(listingKeys, ListOptions{Sort: "completion_pct", Limit: 2}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+	}{
+		{"not base64", ListOptions{Sort: "completion_pct", Cursor: "not a cursor!"}},
+		{"not JSON", ListOptions{Sort: "completion_pct", Cursor: "bm90IGpzb24"}},
+		{"other sort", ListOptions{Sort: "title", Cursor: next}},
+		{"other direction", ListOptions{Sort: "completion_pct", Desc: true, Cursor: next}},
+	}
+	for _, tt := range tests {
+		if _, _, err := paginate(listingKeys, tt.opts, time.Now()); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: error = %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+}
+
+// Urgency changes over time, so later pages must sort by the urgency the
+// first page was sorted by.
+func TestListTimeComesFromCursor(t *testing.T) {
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	_, next, err := paginate(listingKeys, ListOptions{Sort: "urgency", Limit: 2}, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want time.Time // zero for the current time
+	}{
+		{"first page", ListOptions{Sort: "urgency"}, time.Time{}},
+		{"later page", ListOptions{Sort: "urgency", Cursor: next}, first},
+		{"cursor without a time", ListOptions{Sort: "urgency", Cursor: encodeCursor(cursor{Sort: "urgency", Last: listingKeys[0]})}, time.Time{}},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		got, err := listTime(tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.want.IsZero() {
+			if got.Before(before) || got.After(time.Now()) {
+				t.Errorf("%s: listTime = %v, want the current time", tt.name, got)
+			}
+		} else if !got.Equal(tt.want) {
+			t.Errorf("%s: listTime = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := listTime(ListOptions{Sort: "urgency", Cursor: "not a cursor!"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("listTime with a bad cursor: error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+// Sub-items follow their parent however their positions compare with those
+// of the items around it, page after page.
+func TestListTodoItemsInTreeOrder(t *testing.T) {
+	parent := func(id int) *int { return &id }
+	items := []*TodoItem{
+		{ID: 1, Position: "a"},
+		{ID: 2, Position: "b"},
+		{ID: 3, Position: "U", ParentItemID: parent(1)},
+		{ID: 4, Position: "c", ParentItemID: parent(1)},
+		{ID: 5, Position: "U", ParentItemID: parent(3)},
+		{ID: 6, Position: "U", ParentItemID: parent(2)},
+		{ID: 7, Position: "a"},
+	}
+	want := []int{1, 3, 5, 4, 7, 2, 6}
+
+	var got []int
+	opts := ListOptions{Limit: 2}
+	for {
+		page, next, err := ListTodoItems(items, TodoItemFilter{}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range page {
+			got = append(got, item.ID)
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
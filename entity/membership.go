@@ -0,0 +1,184 @@
+package entity
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Workspaces keep the teams sharing an instance apart. Every todo belongs to
+// one workspace, given when it is created, and its items, comments,
+// attachments and collaborators go with it. Users work in the workspaces
+// they are members of, in one of two roles:
+//
+//   - members see and change their own todos and those shared with them
+//     (see sharing.go)
+//   - admins also own every todo of the workspace, deleted ones included,
+//     and manage the workspace and its members
+//
+// Admins of the instance, users with the "admin" role, are admins of every
+// workspace whether they are members or not. Listing users and purging the
+// trash stay with them. Every store query a request can reach is limited to
+// one workspace; only the janitor and the scheduler look across them.
+
+type WorkspaceRole string
+
+const (
+	WorkspaceRoleMember WorkspaceRole = "member"
+	WorkspaceRoleAdmin  WorkspaceRole = "admin"
+)
+
+// DefaultWorkspaceID is the workspace the records from before workspaces
+// existed were moved into.
+const DefaultWorkspaceID = 1
+
+const maxWorkspaceNameLength = 100
+
+// NormalizeWorkspaceName trims a workspace name and checks its length.
+func NormalizeWorkspaceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxWorkspaceNameLength {
+		return "", ErrInvalidWorkspace
+	}
+	return name, nil
+}
+
+// ParseWorkspaceRole accepts the roles in any case.
+func ParseWorkspaceRole(role string) (WorkspaceRole, error) {
+	switch r := WorkspaceRole(strings.ToLower(strings.TrimSpace(role))); r {
+	case WorkspaceRoleMember, WorkspaceRoleAdmin:
+		return r, nil
+	}
+	return "", ErrInvalidWorkspaceRole
+}
+
+// CreateWorkspace creates a workspace with the user creatorID as its first
+// admin.
+func CreateWorkspace(tx Tx, workspace *Workspace, creatorID int) error {
+	if err := tx.Workspaces().Create(workspace); err != nil {
+		return err
+	}
+	return tx.Workspaces().AddMember(&WorkspaceMember{
+		WorkspaceID: workspace.ID,
+		UserID:      creatorID,
+		Role:        WorkspaceRoleAdmin,
+	})
+}
+
+// RegisterUser creates a user and makes them a member of the oldest
+// workspace, as an admin if they are an admin of the instance. When there is
+// no workspace at all, they get one of their own.
+func RegisterUser(tx Tx, user *User) error {
+	if err := tx.Users().Create(user); err != nil {
+		return err
+	}
+	workspaces, err := tx.Workspaces().GetAll()
+	if err != nil {
+		return err
+	}
+	if len(workspaces) == 0 {
+		return CreateWorkspace(tx, &Workspace{Name: user.Username}, user.ID)
+	}
+
+	role := WorkspaceRoleMember
+	if user.Role == "admin" {
+		role = WorkspaceRoleAdmin
+	}
+	return tx.Workspaces().AddMember(&WorkspaceMember{
+		WorkspaceID: workspaces[0].ID,
+		UserID:      user.ID,
+		Role:        role,
+	})
+}
+
+// DeleteWorkspace deletes a workspace and its members. Only empty
+// workspaces can be deleted: deleted todos count until they are purged.
+func DeleteWorkspace(tx Tx, id int) error {
+	if _, err := tx.Workspaces().GetByID(id); err != nil {
+		return err
+	}
+	todos, err := tx.Todos().GetByWorkspaceIDWithDeleted(id)
+	if err != nil {
+		return err
+	}
+	if len(todos) > 0 {
+		return ErrWorkspaceNotEmpty
+	}
+	return tx.Workspaces().Delete(id)
+}
+
+// AddWorkspaceMember adds the user with the given username to a workspace.
+func AddWorkspaceMember(tx Tx, workspaceID int, username string, role WorkspaceRole) (*WorkspaceMember, error) {
+	user, err := tx.Users().GetByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	member := &WorkspaceMember{
+		WorkspaceID: workspaceID,
+		UserID:      user.ID,
+		Role:        role,
+	}
+	if err := tx.Workspaces().AddMember(member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// SetWorkspaceRole changes the role of a member. A workspace always keeps at
+// least one admin.
+func SetWorkspaceRole(tx Tx, member *WorkspaceMember, role WorkspaceRole) error {
+	if role != WorkspaceRoleAdmin {
+		if err := checkOtherAdmin(tx, member.WorkspaceID, member.UserID); err != nil {
+			return err
+		}
+	}
+	member.Role = role
+	return tx.Workspaces().UpdateMember(member)
+}
+
+// RemoveWorkspaceMember takes a user out of a workspace, and the todos of
+// the workspace that were shared with the user are no longer shared with
+// them. The todos the user owns stay in the workspace, where its admins can
+// still reach them. The last admin can't leave.
+func RemoveWorkspaceMember(tx Tx, workspaceID, userID int) error {
+	if err := checkOtherAdmin(tx, workspaceID, userID); err != nil {
+		return err
+	}
+
+	shares, err := tx.Collaborators().GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, collaborator := range shares {
+		todo, err := tx.Todos().GetByIDWithDeleted(collaborator.TodoID)
+		if err != nil || todo.WorkspaceID != workspaceID {
+			continue
+		}
+		if err := tx.Collaborators().Delete(collaborator.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Workspaces().RemoveMember(workspaceID, userID)
+}
+
+// checkOtherAdmin returns ErrLastWorkspaceAdmin if userID is the only admin
+// of the workspace.
+func checkOtherAdmin(tx Tx, workspaceID, userID int) error {
+	member, err := tx.Workspaces().GetMember(workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if member.Role != WorkspaceRoleAdmin {
+		return nil
+	}
+	members, err := tx.Workspaces().GetMembers(workspaceID)
+	if err != nil {
+		return err
+	}
+	for _, other := range members {
+		if other.UserID != userID && other.Role == WorkspaceRoleAdmin {
+			return nil
+		}
+	}
+	return ErrLastWorkspaceAdmin
+}
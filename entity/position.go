@@ -0,0 +1,210 @@
+package entity
+
+import (
+	"sort"
+	"strings"
+)
+
+// Items are ordered within their todo by Position, a string rank compared
+// byte by byte. A rank can always be found between two others by making it
+// longer, so moving an item only rewrites that one item. Ranks use the
+// digits below and never end in the lowest one, which keeps room in front of
+// every rank.
+const positionDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// PositionAfter returns a rank that sorts after p, or a first rank if p is
+// empty. It bumps the leftmost digit that can still grow and drops the rest,
+// so appending keeps ranks short.
+func PositionAfter(p string) string {
+	for i := 0; i < len(p); i++ {
+		if d := strings.IndexByte(positionDigits, p[i]); d < len(positionDigits)-1 {
+			return p[:i] + string(positionDigits[d+1])
+		}
+	}
+	return p + string(positionDigits[len(positionDigits)/2])
+}
+
+// PositionBetween returns a rank that sorts strictly between lo and hi. An
+// empty lo means the start of the list and an empty hi its end; otherwise lo
+// must sort before hi.
+func PositionBetween(lo, hi string) (string, error) {
+	if hi != "" && lo >= hi {
+		return "", ErrInvalidPosition
+	}
+	return midpoint(lo, hi), nil
+}
+
+func midpoint(lo, hi string) string {
+	if hi != "" {
+		// Keep the prefix the two share and look for room after it. A
+		// missing digit in lo counts as the lowest one.
+		n := 0
+		for n < len(hi) && digitAt(lo, n) == strings.IndexByte(positionDigits, hi[n]) {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(lo) {
+				rest = lo[n:]
+			}
+			return hi[:n] + midpoint(rest, hi[n:])
+		}
+	}
+
+	low := digitAt(lo, 0)
+	high := len(positionDigits)
+	if hi != "" {
+		high = strings.IndexByte(positionDigits, hi[0])
+	}
+	if high-low > 1 {
+		return string(positionDigits[(low+high)/2])
+	}
+
+	// The first digits are adjacent. If hi goes on, its first digit alone
+	// is already between the two; otherwise extend lo.
+	if len(hi) > 1 {
+		return hi[:1]
+	}
+	rest := ""
+	if len(lo) > 1 {
+		rest = lo[1:]
+	}
+	return string(positionDigits[low]) + midpoint(rest, "")
+}
+
+func digitAt(p string, i int) int {
+	if i >= len(p) {
+		return 0
+	}
+	return strings.IndexByte(positionDigits, p[i])
+}
+
+// SortByPosition orders items by position, breaking ties by ID.
+func SortByPosition(items []*TodoItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Position != items[j].Position {
+			return items[i].Position < items[j].Position
+		}
+		return items[i].ID < items[j].ID
+	})
+}
+
+// ItemPlacement says where MoveTodoItem puts an item: directly before or
+// after SiblingID, taking over the sibling's parent, or when SiblingID is 0
+// at the end of the sub-items of ParentItemID (the top level if nil).
+type ItemPlacement struct {
+	SiblingID    int
+	After        bool
+	ParentItemID *int
+}
+
+// MoveTodoItem moves an item, and everything below it, to another place in
+// the same todo. A non-zero version makes the move conditional, as with
+// Update. Moving under a new parent fails with ErrInvalidParentItem if that
+// would put the item below itself, and with ErrItemTooDeep if the subtree
+// would end up deeper than maxDepth.
+//
+// Only the moved item is rewritten, unless its new siblings have no usable
+// order yet (items created before positions existed, or two concurrent
+// appends that picked the same rank); they are then renumbered first.
+func MoveTodoItem(items TodoItemStore, id, version int, to ItemPlacement, maxDepth int) (*TodoItem, error) {
+	item, err := items.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != item.Version {
+		return nil, ErrVersionConflict
+	}
+
+	all, err := items.GetByTodoID(item.TodoID)
+	if err != nil {
+		return nil, err
+	}
+	family := newItemFamily(all)
+
+	parent := to.ParentItemID
+	if to.SiblingID != 0 {
+		sibling, ok := family.byID[to.SiblingID]
+		if !ok || sibling.ID == item.ID {
+			return nil, ErrInvalidPosition
+		}
+		parent = sibling.ParentItemID
+	}
+	if parent != nil && family.isAncestor(item.ID, *parent) {
+		return nil, ErrInvalidParentItem
+	}
+	if !sameParent(parent, item.ParentItemID) {
+		if err := family.checkParent(parent, family.height(item.ID), maxDepth); err != nil {
+			return nil, err
+		}
+	}
+
+	var siblings []*TodoItem
+	for _, other := range family.byID {
+		if other.ID != item.ID && sameParent(other.ParentItemID, parent) {
+			siblings = append(siblings, other)
+		}
+	}
+	SortByPosition(siblings)
+	if err := renumberIfNeeded(items, siblings); err != nil {
+		return nil, err
+	}
+
+	var lo, hi string
+	if to.SiblingID == 0 && len(siblings) > 0 {
+		lo = siblings[len(siblings)-1].Position
+	}
+	for i, other := range siblings {
+		if other.ID != to.SiblingID {
+			continue
+		}
+		if to.After {
+			lo = other.Position
+			if i+1 < len(siblings) {
+				hi = siblings[i+1].Position
+			}
+		} else {
+			hi = other.Position
+			if i > 0 {
+				lo = siblings[i-1].Position
+			}
+		}
+	}
+
+	if to.SiblingID == 0 {
+		item.Position = PositionAfter(lo)
+	} else if item.Position, err = PositionBetween(lo, hi); err != nil {
+		return nil, err
+	}
+	item.ParentItemID = parent
+	if err := items.Update(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// renumberIfNeeded gives sorted items fresh ranks unless every one of them
+// already has a rank of its own.
+func renumberIfNeeded(items TodoItemStore, sorted []*TodoItem) error {
+	ordered := true
+	for i, item := range sorted {
+		if item.Position == "" || (i > 0 && sorted[i-1].Position >= item.Position) {
+			ordered = false
+			break
+		}
+	}
+	if ordered {
+		return nil
+	}
+
+	position := ""
+	for _, item := range sorted {
+		position = PositionAfter(position)
+		item.Position = position
+		item.Version = 0
+		if err := items.Update(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package entity
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPositionAfter(t *testing.T) {
+	tests := []struct {
+		p    string
+		want string
+	}{
+		{"", "V"},
+		{"V", "W"},
+		{"A", "B"},
+		{"zV", "zW"},
+		{"z", "zV"},
+		{"zz", "zzV"},
+	}
+	for _, tt := range tests {
+		if got := PositionAfter(tt.p); got != tt.want {
+			t.Errorf("PositionAfter(%q) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestPositionBetween(t *testing.T) {
+	tests := []struct {
+		lo, hi string
+		want   string
+	}{
+		{"", "", "V"},
+		{"V", "", "k"},
+		{"", "V", "F"},
+		{"A", "C", "B"},
+		{"A", "B", "AV"},
+		{"1", "2", "1V"},
+		{"A", "A1", "A0V"},
+		{"Az", "B", "AzV"},
+		{"AV", "AW", "AVV"},
+		{"A0V", "A1", "A0k"},
+		{"z", "", "zV"},
+		{"", "1", "0V"},
+		{"", "01", "00V"},
+	}
+	for _, tt := range tests {
+		got, err := PositionBetween(tt.lo, tt.hi)
+		if err != nil {
+			t.Errorf("PositionBetween(%q, %q): %v", tt.lo, tt.hi, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("PositionBetween(%q, %q) = %q, want %q", tt.lo, tt.hi, got, tt.want)
+		}
+		if got <= tt.lo || (tt.hi != "" && got >= tt.hi) {
+			t.Errorf("PositionBetween(%q, %q) = %q, which is not between them", tt.lo, tt.hi, got)
+		}
+	}
+}
+
+func TestPositionBetweenInvalid(t *testing.T) {
+	for _, tt := range []struct{ lo, hi string }{{"B", "A"}, {"A", "A"}, {"AV", "A"}} {
+		if _, err := PositionBetween(tt.lo, tt.hi); !errors.Is(err, ErrInvalidPosition) {
+			t.Errorf("PositionBetween(%q, %q) error = %v, want ErrInvalidPosition", tt.lo, tt.hi, err)
+		}
+	}
+}
+
+// Moving items to the same spot over and over only makes ranks longer; it
+// must never run out of room or end a rank in the lowest digit.
+func TestPositionBetweenRepeated(t *testing.T) {
+	tests := []struct {
+		name   string
+		lo, hi string
+		// towardLo inserts each rank right after lo rather than right
+		// before hi.
+		towardLo bool
+	}{
+		{"front", "", "V", true},
+		{"back", "V", "", false},
+		{"after lo", "A", "B", true},
+		{"before hi", "A", "B", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lo, hi := tt.lo, tt.hi
+			for i := 0; i < 200; i++ {
+				p, err := PositionBetween(lo, hi)
+				if err != nil {
+					t.Fatalf("step %d: PositionBetween(%q, %q): %v", i, lo, hi, err)
+				}
+				if p <= lo || (hi != "" && p >= hi) {
+					t.Fatalf("step %d: %q is not between %q and %q", i, p, lo, hi)
+				}
+				if strings.HasSuffix(p, positionDigits[:1]) {
+					t.Fatalf("step %d: %q ends in the lowest digit", i, p)
+				}
+				if tt.towardLo {
+					hi = p
+				} else {
+					lo = p
+				}
+			}
+		})
+	}
+}
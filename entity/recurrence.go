@@ -0,0 +1,388 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+// A recurring todo or item repeats by an RRULE (see rrule.go). Every
+// occurrence is a record of its own. The rule lives on the latest one, the
+// head of the series: when the head is completed or skipped, or comes up
+// within the scheduler's horizon, the next occurrence is created from it and
+// the rule moves over. The occurrences before the head are plain records, so
+// editing one of them changes that occurrence alone, while what is changed
+// on the head carries over to the occurrences that follow.
+//
+// Occurrences that were missed are not made up for: the next one is always
+// the first the rule gives that hasn't already gone by.
+
+// Recurrence is embedded in Todo and TodoItem. SeriesID is the first record
+// of the series and is unset on that record itself. Occurrence is when the
+// rule had this occurrence start, or be due if it has no start; it stays put
+// when the record's dates are edited, and the next occurrence is worked out
+// from it.
+type Recurrence struct {
+	RRule      string     `json:"rrule,omitempty"`
+	SeriesID   *int       `json:"series_id,omitempty"`
+	Occurrence *time.Time `json:"occurrence,omitempty"`
+}
+
+func (r Recurrence) clone() Recurrence {
+	c := r
+	if r.SeriesID != nil {
+		seriesID := *r.SeriesID
+		c.SeriesID = &seriesID
+	}
+	if r.Occurrence != nil {
+		occurrence := *r.Occurrence
+		c.Occurrence = &occurrence
+	}
+	return c
+}
+
+// inSeries reports whether the record is an occurrence of a series.
+func (r Recurrence) inSeries() bool {
+	return r.RRule != "" || r.Occurrence != nil
+}
+
+// SetRecurrence returns r with its rule set to rule for a record whose dates
+// change from old to s. An empty rule ends the series with this record. A
+// rule set on a record that isn't a head starts a new series there.
+//
+// Setting or changing the rule, or switching between dates and timestamps,
+// makes the record's new dates the occurrence the rule counts from. Moving
+// the dates of the head otherwise moves that one occurrence only.
+func SetRecurrence(r Recurrence, old, s Schedule, rule string) (Recurrence, error) {
+	if rule == "" {
+		r.RRule = ""
+		return r, nil
+	}
+	parsed, err := parseRRule(rule)
+	if err != nil {
+		return r, err
+	}
+	anchor := s.anchor()
+	if anchor == nil {
+		return r, ErrRecurrenceNeedsDate
+	}
+
+	if r.RRule == "" {
+		r.SeriesID = nil
+		r.Occurrence = nil
+	}
+	normalized := parsed.String()
+	if normalized != r.RRule || old.AllDay != s.AllDay || r.Occurrence == nil {
+		at := *anchor
+		r.Occurrence = &at
+	}
+	r.RRule = normalized
+	return r, nil
+}
+
+// next works out when the occurrence after r's is, not counting those
+// before now, and the rule the record for it carries: a COUNT goes down by
+// the occurrences passed. ok is false when the series is over.
+func (r Recurrence) next(allDay bool, loc *time.Location, now time.Time) (at time.Time, rule string, ok bool) {
+	parsed, err := parseRRule(r.RRule)
+	if err != nil || r.Occurrence == nil {
+		return time.Time{}, "", false
+	}
+	notBefore := now
+	if allDay {
+		y, m, d := now.In(loc).Date()
+		notBefore = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	at, steps, ok := parsed.next(*r.Occurrence, allDay, loc, notBefore)
+	if !ok {
+		return time.Time{}, "", false
+	}
+	if parsed.count > 0 {
+		parsed.count -= steps
+	}
+	return at, parsed.String(), true
+}
+
+// series returns the ID the occurrences following the record point back to.
+func (r Recurrence) series(id int) *int {
+	if r.SeriesID != nil {
+		return r.SeriesID
+	}
+	return &id
+}
+
+// startsAt returns the moment the occurrence begins as seen from loc: the
+// start of its day there for all-day records.
+func (r Recurrence) startsAt(allDay bool, loc *time.Location) time.Time {
+	if allDay {
+		y, m, d := r.Occurrence.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+	return *r.Occurrence
+}
+
+// anchor is the date a rule counts from: the start, or the due date if there
+// is no start.
+func (s Schedule) anchor() *time.Time {
+	if s.StartAt != nil {
+		return s.StartAt
+	}
+	return s.DueAt
+}
+
+// movedTo returns s moved so that its anchor is at, keeping the time
+// between the start and the due date.
+func (s Schedule) movedTo(at time.Time) Schedule {
+	c := s.clone()
+	if s.StartAt != nil {
+		c.StartAt = &at
+		if s.DueAt != nil {
+			due := at.Add(s.DueAt.Sub(*s.StartAt))
+			c.DueAt = &due
+		}
+	} else if s.DueAt != nil {
+		c.DueAt = &at
+	}
+	return c
+}
+
+// movedBy returns s with both dates moved by d.
+func (s Schedule) movedBy(d time.Duration) Schedule {
+	c := s.clone()
+	if c.StartAt != nil {
+		*c.StartAt = c.StartAt.Add(d)
+	}
+	if c.DueAt != nil {
+		*c.DueAt = c.DueAt.Add(d)
+	}
+	return c
+}
+
+// ownerLocation returns the time zone of a user, UTC if there is no such
+// user any more.
+func ownerLocation(users UserStore, userID int) *time.Location {
+	user, err := users.GetByID(userID)
+	if err != nil {
+		return time.UTC
+	}
+	return user.Location()
+}
+
+// AdvanceTodo creates the occurrence that follows the todo with the given
+// id if that todo is the head of a series, and moves the rule over to it.
+// The todo's live items are copied along, not completed, with their dates
+// moved as far as the todo's. It returns the new todo, or nil if the todo
+// isn't a head or its series is over; in the latter case the rule is just
+// removed.
+func AdvanceTodo(tx Tx, id int, now time.Time) (*Todo, error) {
+	head, err := tx.Todos().GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if head.RRule == "" {
+		return nil, nil
+	}
+
+	at, rule, ok := head.Recurrence.next(head.AllDay, ownerLocation(tx.Users(), head.UserID), now)
+	head.RRule = ""
+	if err := tx.Todos().Update(head); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	next := &Todo{
+		Title:       head.Title,
+		Description: head.Description,
+		UserID:      head.UserID,
+		WorkspaceID: head.WorkspaceID,
+		Priority:    head.Priority,
+		Tags:        head.Tags,
+		Schedule:    head.Schedule.movedTo(at),
+		Recurrence:  Recurrence{RRule: rule, SeriesID: head.series(head.ID), Occurrence: &at},
+	}
+	if err := tx.Todos().Create(next); err != nil {
+		return nil, err
+	}
+	if err := copyItems(tx.Items(), head.ID, next.ID, at.Sub(*head.Occurrence)); err != nil {
+		return nil, err
+	}
+	if err := tx.Todos().UpdateCompletionPct(next.ID, tx.Items()); err != nil {
+		return nil, err
+	}
+	return tx.Todos().GetByID(next.ID)
+}
+
+// copyItems copies the live items of one todo to another, parents before
+// their sub-items, keeping their order. Item series are not carried over.
+func copyItems(items TodoItemStore, fromID, toID int, shift time.Duration) error {
+	live, err := items.GetByTodoID(fromID)
+	if err != nil {
+		return err
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].ID < live[j].ID })
+
+	copied := make(map[int]int)
+	for len(copied) < len(live) {
+		progress := false
+		for _, item := range live {
+			if _, done := copied[item.ID]; done {
+				continue
+			}
+			c := &TodoItem{
+				Title:       item.Title,
+				Description: item.Description,
+				Position:    item.Position,
+				TodoID:      toID,
+				UserID:      item.UserID,
+				Priority:    item.Priority,
+				Tags:        item.Tags,
+				Schedule:    item.Schedule.movedBy(shift),
+			}
+			if item.ParentItemID != nil {
+				parent, ok := copied[*item.ParentItemID]
+				if !ok {
+					continue
+				}
+				c.ParentItemID = &parent
+			}
+			if err := items.Create(c); err != nil {
+				return err
+			}
+			copied[item.ID] = c.ID
+			progress = true
+		}
+		if !progress {
+			// Only items under a parent that isn't live are left; they
+			// aren't part of the todo as it stands.
+			return nil
+		}
+	}
+	return nil
+}
+
+// AdvanceItem is AdvanceTodo for items. The new item goes at the end of the
+// sub-items of the head's parent, or at the top level if that parent is no
+// longer there; the head's own sub-items are not copied. The todo's
+// completion is recomputed.
+func AdvanceItem(tx Tx, id int, now time.Time) (*TodoItem, error) {
+	head, err := tx.Items().GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if head.RRule == "" {
+		return nil, nil
+	}
+	todo, err := tx.Todos().GetByID(head.TodoID)
+	if err != nil {
+		return nil, err
+	}
+
+	at, rule, ok := head.Recurrence.next(head.AllDay, ownerLocation(tx.Users(), todo.UserID), now)
+	head.RRule = ""
+	if err := tx.Items().Update(head); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	next := &TodoItem{
+		Title:        head.Title,
+		Description:  head.Description,
+		TodoID:       head.TodoID,
+		ParentItemID: head.ParentItemID,
+		UserID:       head.UserID,
+		Priority:     head.Priority,
+		Tags:         head.Tags,
+		Schedule:     head.Schedule.movedTo(at),
+		Recurrence:   Recurrence{RRule: rule, SeriesID: head.series(head.ID), Occurrence: &at},
+	}
+	if next.ParentItemID != nil {
+		if _, err := tx.Items().GetByID(*next.ParentItemID); err != nil {
+			next.ParentItemID = nil
+		}
+	}
+	if err := tx.Items().Create(next); err != nil {
+		return nil, err
+	}
+	return next, tx.Todos().UpdateCompletionPct(head.TodoID, tx.Items())
+}
+
+// SkipTodo drops one occurrence of a series: the todo is deleted, after the
+// next occurrence has been created if it was the head. It returns that next
+// occurrence, if there is one, and ErrNotRecurring for a todo that isn't
+// part of a series.
+func SkipTodo(tx Tx, id int, now time.Time) (*Todo, error) {
+	todo, err := tx.Todos().GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !todo.inSeries() {
+		return nil, ErrNotRecurring
+	}
+	next, err := AdvanceTodo(tx, id, now)
+	if err != nil {
+		return nil, err
+	}
+	return next, tx.Todos().Delete(id)
+}
+
+// SkipTodoItem is SkipTodo for items. The item's sub-items are deleted with
+// it.
+func SkipTodoItem(tx Tx, id int, now time.Time) (*TodoItem, error) {
+	item, err := tx.Items().GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !item.inSeries() {
+		return nil, ErrNotRecurring
+	}
+	next, err := AdvanceItem(tx, id, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := DeleteTodoItem(tx.Items(), id); err != nil {
+		return nil, err
+	}
+	return next, tx.Todos().UpdateCompletionPct(item.TodoID, tx.Items())
+}
+
+// CompleteTodoItem is called in the transaction that saved an item, with
+// wasCompleted telling whether the item had been completed before. If the
+// change completed the head of a series, the next occurrence is created.
+// The completion of the item's todo is then recomputed, which may in turn
+// complete a todo that heads a series; see RecomputeCompletion.
+func CompleteTodoItem(tx Tx, item *TodoItem, wasCompleted bool, now time.Time) error {
+	if item.Completed && !wasCompleted && item.RRule != "" {
+		if _, err := AdvanceItem(tx, item.ID, now); err != nil {
+			return err
+		}
+	}
+	_, err := RecomputeCompletion(tx, item.TodoID, now)
+	return err
+}
+
+// RecomputeCompletion updates the completion of a todo. If that completes a
+// todo at the head of a series, the next occurrence is created and
+// returned.
+func RecomputeCompletion(tx Tx, todoID int, now time.Time) (*Todo, error) {
+	before, err := tx.Todos().GetByIDWithDeleted(todoID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Todos().UpdateCompletionPct(todoID, tx.Items()); err != nil {
+		return nil, err
+	}
+	if before.RRule == "" || before.DeletedAt != nil || before.CompletionPct >= 100 {
+		return nil, nil
+	}
+	after, err := tx.Todos().GetByID(todoID)
+	if err != nil {
+		return nil, err
+	}
+	if after.CompletionPct < 100 {
+		return nil, nil
+	}
+	return AdvanceTodo(tx, todoID, now)
+}
@@ -0,0 +1,97 @@
+package entity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTodoModelRestore(t *testing.T) {
+	m := NewTodoModel(NewTodoItemModel())
+	todo := &Todo{Title: "todo", UserID: 1, WorkspaceID: DefaultWorkspaceID}
+	if err := m.Create(todo); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Restore(todo.ID); !errors.Is(err, ErrTodoNotFound) {
+		t.Errorf("Restore of a live todo: error = %v, want ErrTodoNotFound", err)
+	}
+	if err := m.Delete(todo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetByID(todo.ID); !errors.Is(err, ErrTodoNotFound) {
+		t.Errorf("GetByID of a deleted todo: error = %v, want ErrTodoNotFound", err)
+	}
+	if trash, _ := m.GetByUserIDWithDeleted(DefaultWorkspaceID, 1); len(trash) != 1 || trash[0].DeletedAt == nil {
+		t.Errorf("GetByUserIDWithDeleted = %+v, want the deleted todo", trash)
+	}
+
+	if err := m.Restore(todo.ID); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := m.GetByID(todo.ID)
+	if err != nil {
+		t.Fatalf("GetByID after Restore: %v", err)
+	}
+	// Create, Delete and Restore each bump the version.
+	if restored.DeletedAt != nil || restored.Version != 3 {
+		t.Errorf("restored todo = deleted_at %v, version %d; want nil, 3", restored.DeletedAt, restored.Version)
+	}
+	if err := m.Restore(todo.ID); !errors.Is(err, ErrTodoNotFound) {
+		t.Errorf("second Restore: error = %v, want ErrTodoNotFound", err)
+	}
+	if err := m.Restore(todo.ID + 1); !errors.Is(err, ErrTodoNotFound) {
+		t.Errorf("Restore of a missing todo: error = %v, want ErrTodoNotFound", err)
+	}
+}
+
+func TestTodoItemModelRestore(t *testing.T) {
+	m := NewTodoItemModel()
+	item := &TodoItem{Title: "item", TodoID: 1, UserID: 1}
+	if err := m.Create(item); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := m.GetByTodoID(1); len(items) != 0 {
+		t.Errorf("GetByTodoID = %d items, want the deleted item left out", len(items))
+	}
+
+	if err := m.Restore(item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := m.GetByTodoID(1); len(items) != 1 || items[0].DeletedAt != nil {
+		t.Errorf("GetByTodoID after Restore = %+v, want the item back", items)
+	}
+	if err := m.Restore(item.ID); !errors.Is(err, ErrTodoItemNotFound) {
+		t.Errorf("second Restore: error = %v, want ErrTodoItemNotFound", err)
+	}
+}
+
+// A restore inside a transaction that fails is undone with the rest of it.
+func TestRestoreRollsBack(t *testing.T) {
+	items := NewTodoItemModel()
+	todos := NewTodoModel(items)
+	unitOfWork := newTestUnitOfWork(todos)
+	todo := &Todo{Title: "todo", UserID: 1, WorkspaceID: DefaultWorkspaceID}
+	if err := todos.Create(todo); err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.Delete(todo.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	fail := errors.New("fail")
+	err := unitOfWork.Do(func(tx Tx) error {
+		if err := tx.Todos().Restore(todo.ID); err != nil {
+			return err
+		}
+		return fail
+	})
+	if !errors.Is(err, fail) {
+		t.Fatalf("Do: error = %v, want %v", err, fail)
+	}
+	if _, err := todos.GetByID(todo.ID); !errors.Is(err, ErrTodoNotFound) {
+		t.Errorf("GetByID after the rollback: error = %v, want ErrTodoNotFound", err)
+	}
+}
@@ -0,0 +1,398 @@
+package entity
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rrule is the subset of an iCalendar recurrence rule (RFC 5545, section
+// 3.3.10) that todos and items support:
+//
+//	FREQ        DAILY, WEEKLY, MONTHLY or YEARLY; required
+//	INTERVAL    every how many days, weeks, months or years, 1 by default
+//	COUNT       how many occurrences there are, the first one included
+//	UNTIL       the last day (20240501) or instant (20240501T150000Z) an
+//	            occurrence can fall on; can't be combined with COUNT
+//	BYDAY       weekdays (MO to SU). DAILY and WEEKLY rules take plain ones,
+//	            MONTHLY rules numbered ones too: 2TU is the second Tuesday
+//	            of the month, -1FR the last Friday
+//	BYMONTHDAY  days of the month, negative ones counting from its end;
+//	            MONTHLY and YEARLY only, and not together with BYDAY
+//	BYMONTH     months (1 to 12); YEARLY only
+//
+// What a rule leaves out is taken from its first occurrence, as in RFC 5545:
+// FREQ=MONTHLY repeats on the same day of the month, skipping months that
+// don't have it. Weeks start on Monday.
+type rrule struct {
+	freq       string
+	interval   int
+	count      int
+	until      *time.Time
+	untilDate  bool
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []int
+}
+
+// weekdayNum is a BYDAY entry: a weekday, and which of them in the month it
+// is if n isn't 0.
+type weekdayNum struct {
+	n   int
+	day time.Weekday
+}
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+const (
+	untilDateLayout     = "20060102"
+	untilDateTimeLayout = "20060102T150405Z"
+
+	// maxRRulePeriods bounds the search for the next occurrence, so that a
+	// rule like FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30 can't loop forever.
+	maxRRulePeriods = 100000
+)
+
+func invalidRRule(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidRecurrence}, args...)...)
+}
+
+// parseRRule reads a rule such as "FREQ=WEEKLY;BYDAY=MO,TH". Part names and
+// values are case-insensitive and an "RRULE:" prefix is allowed.
+func parseRRule(s string) (*rrule, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	r := &rrule{interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, invalidRRule("%q is not of the form NAME=VALUE", part)
+		}
+		if seen[key] {
+			return nil, invalidRRule("%s is given twice", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.freq = value
+			default:
+				return nil, invalidRRule("FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY")
+			}
+		case "INTERVAL":
+			if r.interval, err = strconv.Atoi(value); err != nil || r.interval < 1 || r.interval > 1000 {
+				return nil, invalidRRule("INTERVAL must be between 1 and 1000")
+			}
+		case "COUNT":
+			if r.count, err = strconv.Atoi(value); err != nil || r.count < 1 {
+				return nil, invalidRRule("COUNT must be a positive number")
+			}
+		case "UNTIL":
+			var until time.Time
+			if until, err = time.Parse(untilDateLayout, value); err == nil {
+				r.untilDate = true
+			} else if until, err = time.Parse(untilDateTimeLayout, value); err != nil {
+				return nil, invalidRRule("UNTIL must be a date (20060102) or a UTC time (20060102T150405Z)")
+			}
+			r.until = &until
+		case "BYDAY":
+			for _, v := range strings.Split(value, ",") {
+				wd, ok := parseWeekdayNum(v)
+				if !ok {
+					return nil, invalidRRule("invalid BYDAY value %q", v)
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "BYMONTHDAY":
+			if r.byMonthDay, err = parseInts(value, -31, 31); err != nil {
+				return nil, invalidRRule("BYMONTHDAY values must be between 1 and 31 or -31 and -1")
+			}
+		case "BYMONTH":
+			if r.byMonth, err = parseInts(value, 1, 12); err != nil {
+				return nil, invalidRRule("BYMONTH values must be between 1 and 12")
+			}
+		default:
+			return nil, invalidRRule("%s is not supported", key)
+		}
+	}
+	return r, r.validate()
+}
+
+func (r *rrule) validate() error {
+	switch {
+	case r.freq == "":
+		return invalidRRule("FREQ is required")
+	case r.count > 0 && r.until != nil:
+		return invalidRRule("COUNT and UNTIL can't be combined")
+	case len(r.byMonth) > 0 && r.freq != "YEARLY":
+		return invalidRRule("BYMONTH needs FREQ=YEARLY")
+	case len(r.byMonthDay) > 0 && r.freq != "MONTHLY" && r.freq != "YEARLY":
+		return invalidRRule("BYMONTHDAY needs FREQ=MONTHLY or YEARLY")
+	case len(r.byDay) > 0 && r.freq == "YEARLY":
+		return invalidRRule("BYDAY can't be used with FREQ=YEARLY")
+	case len(r.byDay) > 0 && len(r.byMonthDay) > 0:
+		return invalidRRule("BYDAY and BYMONTHDAY can't be combined")
+	}
+	if r.freq != "MONTHLY" {
+		for _, wd := range r.byDay {
+			if wd.n != 0 {
+				return invalidRRule("numbered BYDAY values need FREQ=MONTHLY")
+			}
+		}
+	}
+	return nil
+}
+
+func parseWeekdayNum(s string) (weekdayNum, bool) {
+	if len(s) < 2 {
+		return weekdayNum{}, false
+	}
+	var wd weekdayNum
+	code := s[len(s)-2:]
+	found := false
+	for i, c := range weekdayCodes {
+		if c == code {
+			wd.day, found = time.Weekday(i), true
+		}
+	}
+	if !found {
+		return weekdayNum{}, false
+	}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return weekdayNum{}, false
+		}
+		wd.n = n
+	}
+	return wd, true
+}
+
+// parseInts reads a comma-separated list of non-zero numbers in [min, max].
+func parseInts(s string, min, max int) ([]int, error) {
+	var out []int
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(v)
+		if err != nil || n == 0 || n < min || n > max {
+			return nil, ErrInvalidRecurrence
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// String writes the rule back out with its parts in a fixed order, which is
+// the form todos and items store.
+func (r *rrule) String() string {
+	parts := []string{"FREQ=" + r.freq}
+	if r.interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.interval))
+	}
+	if r.count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.count))
+	}
+	if r.until != nil {
+		layout := untilDateTimeLayout
+		if r.untilDate {
+			layout = untilDateLayout
+		}
+		parts = append(parts, "UNTIL="+r.until.Format(layout))
+	}
+	if len(r.byMonth) > 0 {
+		parts = append(parts, "BYMONTH="+joinInts(r.byMonth))
+	}
+	if len(r.byMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.byMonthDay))
+	}
+	if len(r.byDay) > 0 {
+		days := make([]string, len(r.byDay))
+		for i, wd := range r.byDay {
+			days[i] = weekdayCodes[wd.day]
+			if wd.n != 0 {
+				days[i] = strconv.Itoa(wd.n) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+// next returns the first occurrence after occ, an occurrence of the rule,
+// that isn't before notBefore, and how many occurrences on from occ it is.
+// ok is false if the rule runs out first.
+//
+// All-day occurrences are dates at midnight UTC and repeat by the calendar.
+// Timed ones repeat at the same wall-clock time in loc, so a weekly 09:00
+// stays at 09:00 across daylight saving changes.
+func (r *rrule) next(occ time.Time, allDay bool, loc *time.Location, notBefore time.Time) (next time.Time, steps int, ok bool) {
+	if allDay {
+		loc = time.UTC
+	}
+	start := occ.In(loc)
+	until := r.untilIn(allDay, loc)
+
+	for period := 0; period < maxRRulePeriods; period++ {
+		for _, t := range r.period(start, period, loc) {
+			if !t.After(start) {
+				continue
+			}
+			if until != nil && t.After(*until) {
+				return time.Time{}, 0, false
+			}
+			steps++
+			if r.count > 0 && steps >= r.count {
+				return time.Time{}, 0, false
+			}
+			if t.Before(notBefore) {
+				continue
+			}
+			return t.UTC(), steps, true
+		}
+	}
+	return time.Time{}, 0, false
+}
+
+// untilIn returns the last moment an occurrence may fall on. A date-only
+// UNTIL lasts until the end of that day in loc.
+func (r *rrule) untilIn(allDay bool, loc *time.Location) *time.Time {
+	if r.until == nil {
+		return nil
+	}
+	until := *r.until
+	switch {
+	case allDay:
+		y, m, d := until.Date()
+		until = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	case r.untilDate:
+		y, m, d := until.Date()
+		until = time.Date(y, m, d+1, 0, 0, 0, 0, loc).Add(-time.Nanosecond)
+	}
+	return &until
+}
+
+// period returns the candidate occurrences, in order, in the n-th day, week,
+// month or year counted in steps of INTERVAL from the one start falls in.
+func (r *rrule) period(start time.Time, n int, loc *time.Location) []time.Time {
+	y, m, d := start.Date()
+	h, min, sec := start.Clock()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, h, min, sec, 0, loc)
+	}
+
+	var times []time.Time
+	switch r.freq {
+	case "DAILY":
+		t := at(y, m, d+n*r.interval)
+		if len(r.byDay) == 0 || r.onWeekday(t.Weekday()) {
+			times = append(times, t)
+		}
+
+	case "WEEKLY":
+		monday := d - (int(start.Weekday())+6)%7 + 7*n*r.interval
+		if len(r.byDay) == 0 {
+			times = append(times, at(y, m, monday+(int(start.Weekday())+6)%7))
+		}
+		for _, wd := range r.byDay {
+			times = append(times, at(y, m, monday+(int(wd.day)+6)%7))
+		}
+
+	case "MONTHLY":
+		first := time.Date(y, m+time.Month(n*r.interval), 1, 0, 0, 0, 0, time.UTC)
+		var days []int
+		switch {
+		case len(r.byMonthDay) > 0:
+			days = monthDays(first, r.byMonthDay)
+		case len(r.byDay) > 0:
+			for _, wd := range r.byDay {
+				days = append(days, weekdaysInMonth(first, wd)...)
+			}
+		default:
+			days = monthDays(first, []int{d})
+		}
+		for _, day := range days {
+			times = append(times, at(first.Year(), first.Month(), day))
+		}
+
+	case "YEARLY":
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []int{int(m)}
+		}
+		monthDay := r.byMonthDay
+		if len(monthDay) == 0 {
+			monthDay = []int{d}
+		}
+		for _, month := range months {
+			first := time.Date(y+n*r.interval, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+			for _, day := range monthDays(first, monthDay) {
+				times = append(times, at(first.Year(), first.Month(), day))
+			}
+		}
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	unique := times[:0]
+	for i, t := range times {
+		if i == 0 || !t.Equal(times[i-1]) {
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+func (r *rrule) onWeekday(day time.Weekday) bool {
+	for _, wd := range r.byDay {
+		if wd.day == day {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDays resolves BYMONTHDAY values against the month starting at first,
+// dropping the days it doesn't have.
+func monthDays(first time.Time, values []int) []int {
+	length := first.AddDate(0, 1, -1).Day()
+	var days []int
+	for _, v := range values {
+		if v < 0 {
+			v = length + v + 1
+		}
+		if v >= 1 && v <= length {
+			days = append(days, v)
+		}
+	}
+	return days
+}
+
+// weekdaysInMonth returns the days of the month starting at first that match
+// a BYDAY value: every such weekday, or only the n-th one.
+func weekdaysInMonth(first time.Time, wd weekdayNum) []int {
+	length := first.AddDate(0, 1, -1).Day()
+	var days []int
+	for day := 1 + (int(wd.day)-int(first.Weekday())+7)%7; day <= length; day += 7 {
+		days = append(days, day)
+	}
+	switch {
+	case wd.n > 0 && wd.n <= len(days):
+		return days[wd.n-1 : wd.n]
+	case wd.n < 0 && -wd.n <= len(days):
+		return days[len(days)+wd.n : len(days)+wd.n+1]
+	case wd.n != 0:
+		return nil
+	}
+	return days
+}
@@ -0,0 +1,130 @@
+package entity
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	// The DST case needs a zone database on hosts without one.
+	_ "time/tzdata"
+)
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // the rule as String writes it; "" if in is invalid
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"rrule:freq=weekly;byday=mo,th", "FREQ=WEEKLY;BYDAY=MO,TH"},
+		{" RRULE:FREQ=DAILY;INTERVAL=1 ", "FREQ=DAILY"},
+		{"FREQ=DAILY;INTERVAL=3;COUNT=5", "FREQ=DAILY;INTERVAL=3;COUNT=5"},
+		{"BYDAY=2TU,-1FR;FREQ=MONTHLY", "FREQ=MONTHLY;BYDAY=2TU,-1FR"},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,-1", "FREQ=MONTHLY;BYMONTHDAY=1,-1"},
+		{"FREQ=YEARLY;BYMONTH=2,8;BYMONTHDAY=29", "FREQ=YEARLY;BYMONTH=2,8;BYMONTHDAY=29"},
+		{"FREQ=WEEKLY;UNTIL=20240501", "FREQ=WEEKLY;UNTIL=20240501"},
+		{"FREQ=WEEKLY;UNTIL=20240501T150000Z", "FREQ=WEEKLY;UNTIL=20240501T150000Z"},
+
+		{"", ""},
+		{"INTERVAL=2", ""},
+		{"FREQ=HOURLY", ""},
+		{"FREQ=DAILY;FREQ=WEEKLY", ""},
+		{"FREQ=DAILY;INTERVAL=0", ""},
+		{"FREQ=DAILY;INTERVAL=1001", ""},
+		{"FREQ=DAILY;COUNT=0", ""},
+		{"FREQ=DAILY;COUNT=2;UNTIL=20240501", ""},
+		{"FREQ=DAILY;UNTIL=2024-05-01", ""},
+		{"FREQ=DAILY;BYSETPOS=1", ""},
+		{"FREQ=DAILY;BYMONTH=1", ""},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", ""},
+		{"FREQ=YEARLY;BYDAY=MO", ""},
+		{"FREQ=MONTHLY;BYDAY=MO;BYMONTHDAY=1", ""},
+		{"FREQ=WEEKLY;BYDAY=2MO", ""},
+		{"FREQ=MONTHLY;BYDAY=6MO", ""},
+		{"FREQ=MONTHLY;BYDAY=0MO", ""},
+		{"FREQ=WEEKLY;BYDAY=XX", ""},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", ""},
+		{"FREQ=MONTHLY;BYMONTHDAY=0", ""},
+		{"FREQ=YEARLY;BYMONTH=13", ""},
+		{"FREQ", ""},
+		{"FREQ=", ""},
+	}
+	for _, tt := range tests {
+		r, err := parseRRule(tt.in)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidRecurrence) {
+				t.Errorf("parseRRule(%q) error = %v, want ErrInvalidRecurrence", tt.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRRule(%q): %v", tt.in, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("parseRRule(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRRuleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	at := func(y int, m time.Month, d, h int) time.Time { return time.Date(y, m, d, h, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name      string
+		rule      string
+		occ       time.Time
+		allDay    bool
+		loc       *time.Location
+		notBefore time.Time
+		want      time.Time // zero if the rule runs out
+		steps     int
+	}{
+		{"daily", "FREQ=DAILY", at(2024, 1, 1, 9), false, time.UTC, time.Time{}, at(2024, 1, 2, 9), 1},
+		{"interval", "FREQ=DAILY;INTERVAL=2", day(2024, 1, 1), true, time.UTC, time.Time{}, day(2024, 1, 3), 1},
+		{"weekly on the same day", "FREQ=WEEKLY", day(2024, 1, 3), true, time.UTC, time.Time{}, day(2024, 1, 10), 1},
+		{"byday in the same week", "FREQ=WEEKLY;BYDAY=MO,TH", day(2024, 1, 1), true, time.UTC, time.Time{}, day(2024, 1, 4), 1},
+		{"byday into the next week", "FREQ=WEEKLY;BYDAY=MO,TH", day(2024, 1, 4), true, time.UTC, time.Time{}, day(2024, 1, 8), 1},
+		{"daily byday skips the weekend", "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", day(2024, 1, 5), true, time.UTC, time.Time{}, day(2024, 1, 8), 1},
+		{"monthly skips short months", "FREQ=MONTHLY", day(2024, 1, 31), true, time.UTC, time.Time{}, day(2024, 3, 31), 1},
+		{"last day of the month", "FREQ=MONTHLY;BYMONTHDAY=-1", day(2024, 1, 31), true, time.UTC, time.Time{}, day(2024, 2, 29), 1},
+		{"last friday", "FREQ=MONTHLY;BYDAY=-1FR", day(2024, 1, 26), true, time.UTC, time.Time{}, day(2024, 2, 23), 1},
+		{"second tuesday", "FREQ=MONTHLY;BYDAY=2TU", day(2024, 1, 9), true, time.UTC, time.Time{}, day(2024, 2, 13), 1},
+		{"leap day", "FREQ=YEARLY", day(2024, 2, 29), true, time.UTC, time.Time{}, day(2028, 2, 29), 1},
+		{"bymonth", "FREQ=YEARLY;BYMONTH=3,9", day(2024, 3, 15), true, time.UTC, time.Time{}, day(2024, 9, 15), 1},
+		{"never", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", day(2024, 1, 30), true, time.UTC, time.Time{}, time.Time{}, 0},
+		{"not before", "FREQ=DAILY", day(2024, 1, 1), true, time.UTC, day(2024, 1, 10), day(2024, 1, 10), 9},
+		{"within count", "FREQ=DAILY;COUNT=3", day(2024, 1, 1), true, time.UTC, day(2024, 1, 3), day(2024, 1, 3), 2},
+		{"past count", "FREQ=DAILY;COUNT=3", day(2024, 1, 1), true, time.UTC, day(2024, 1, 4), time.Time{}, 0},
+		{"on until date", "FREQ=DAILY;UNTIL=20240102", day(2024, 1, 1), true, time.UTC, time.Time{}, day(2024, 1, 2), 1},
+		{"past until date", "FREQ=DAILY;UNTIL=20240102", day(2024, 1, 2), true, time.UTC, time.Time{}, time.Time{}, 0},
+		{"until date lasts the day", "FREQ=DAILY;UNTIL=20240102", at(2024, 1, 1, 22), false, time.UTC, time.Time{}, at(2024, 1, 2, 22), 1},
+		{"past until instant", "FREQ=DAILY;UNTIL=20240102T090000Z", at(2024, 1, 1, 10), false, time.UTC, time.Time{}, time.Time{}, 0},
+		// 09:00 in Berlin is 08:00 UTC before the switch to summer time on
+		// March 31st and 07:00 UTC after it.
+		{"same wall clock across dst", "FREQ=WEEKLY", at(2024, 3, 25, 8), false, berlin, time.Time{}, at(2024, 4, 1, 7), 1},
+		{"all day ignores the zone", "FREQ=WEEKLY", day(2024, 3, 25), true, berlin, time.Time{}, day(2024, 4, 1), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, steps, ok := r.next(tt.occ, tt.allDay, tt.loc, tt.notBefore)
+			if tt.want.IsZero() {
+				if ok {
+					t.Errorf("next = %v, want none", got)
+				}
+				return
+			}
+			if !ok || !got.Equal(tt.want) || steps != tt.steps {
+				t.Errorf("next = %v, %d steps, %v; want %v, %d steps", got, steps, ok, tt.want, tt.steps)
+			}
+		})
+	}
+}
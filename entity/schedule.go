@@ -0,0 +1,185 @@
+package entity
+
+import "time"
+
+// Schedule is when a todo or item starts and when it is due. Timed entries
+// hold instants. All-day entries hold calendar dates, stored as midnight UTC,
+// that mean the whole of that day in the time zone of whoever looks at them:
+// an item due all day on the 5th is overdue once the 6th begins for its user.
+type Schedule struct {
+	StartAt *time.Time `json:"start_at,omitempty"`
+	DueAt   *time.Time `json:"due_at,omitempty"`
+	AllDay  bool       `json:"all_day"`
+}
+
+const dateLayout = "2006-01-02"
+
+// ParseSchedule reads a start and a due value, either of which may be empty.
+// Each is a date (2006-01-02) for an all-day schedule or an RFC 3339
+// timestamp for a timed one; the two can't be mixed, and the start can't be
+// after the due date.
+func ParseSchedule(start, due string) (Schedule, error) {
+	var s Schedule
+	kinds := 0
+	for _, v := range []struct {
+		raw string
+		dst **time.Time
+	}{{start, &s.StartAt}, {due, &s.DueAt}} {
+		if v.raw == "" {
+			continue
+		}
+		if t, err := time.Parse(dateLayout, v.raw); err == nil {
+			s.AllDay = true
+			kinds |= 1
+			*v.dst = &t
+		} else if t, err := time.Parse(time.RFC3339, v.raw); err == nil {
+			t = t.UTC()
+			kinds |= 2
+			*v.dst = &t
+		} else {
+			return Schedule{}, ErrInvalidSchedule
+		}
+	}
+	if kinds == 3 {
+		return Schedule{}, ErrInvalidSchedule
+	}
+	if s.StartAt != nil && s.DueAt != nil && s.StartAt.After(*s.DueAt) {
+		return Schedule{}, ErrInvalidSchedule
+	}
+	if s.StartAt == nil && s.DueAt == nil {
+		s.AllDay = false
+	}
+	return s, nil
+}
+
+// Format is the inverse of ParseSchedule.
+func (s Schedule) Format() (start, due string) {
+	format := func(t *time.Time) string {
+		switch {
+		case t == nil:
+			return ""
+		case s.AllDay:
+			return t.Format(dateLayout)
+		}
+		return t.Format(time.RFC3339)
+	}
+	return format(s.StartAt), format(s.DueAt)
+}
+
+func (s Schedule) clone() Schedule {
+	c := s
+	if s.StartAt != nil {
+		start := *s.StartAt
+		c.StartAt = &start
+	}
+	if s.DueAt != nil {
+		due := *s.DueAt
+		c.DueAt = &due
+	}
+	return c
+}
+
+// startOfDay returns midnight in loc of the day t falls on there.
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// DueTime is when the entry falls due as seen from loc: the due instant, or
+// the start of the due date there for an all-day entry. ok is false if there
+// is no due date.
+func (s Schedule) DueTime(loc *time.Location) (due time.Time, ok bool) {
+	if s.DueAt == nil {
+		return time.Time{}, false
+	}
+	if s.AllDay {
+		y, m, d := s.DueAt.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, loc), true
+	}
+	return *s.DueAt, true
+}
+
+// DueDay returns midnight in loc of the day the entry is due on, or of the
+// day it starts on if it has no due date. ok is false if it has neither.
+func (s Schedule) DueDay(loc *time.Location) (day time.Time, ok bool) {
+	t := s.DueAt
+	if t == nil {
+		t = s.StartAt
+	}
+	if t == nil {
+		return time.Time{}, false
+	}
+	if s.AllDay {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, loc), true
+	}
+	return startOfDay(*t, loc), true
+}
+
+// Overdue reports whether the due date has passed at now. An all-day entry
+// is due until its day is over in loc.
+func (s Schedule) Overdue(now time.Time, loc *time.Location) bool {
+	due, ok := s.DueTime(loc)
+	if !ok {
+		return false
+	}
+	if s.AllDay {
+		due = due.AddDate(0, 0, 1)
+		return !now.Before(due)
+	}
+	return now.After(due)
+}
+
+// DueFilter restricts a listing by due date. Dates are evaluated in Location
+// at Now; a nil Location means UTC. Finished records are never overdue.
+type DueFilter struct {
+	Due      TimeRange
+	Overdue  *bool
+	Today    bool
+	Now      time.Time
+	Location *time.Location
+}
+
+func (f DueFilter) matches(s Schedule, done bool) bool {
+	loc := f.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	if !f.Due.After.IsZero() || !f.Due.Before.IsZero() {
+		due, ok := s.DueTime(loc)
+		if !ok || !f.Due.Contains(due) {
+			return false
+		}
+	}
+	if f.Overdue != nil && *f.Overdue != (!done && s.Overdue(f.Now, loc)) {
+		return false
+	}
+	if f.Today {
+		if s.DueAt == nil {
+			return false
+		}
+		day, _ := s.DueDay(loc)
+		if !day.Equal(startOfDay(f.Now, loc)) {
+			return false
+		}
+	}
+	return true
+}
+
+// dueKey sorts records by due date, those without one last.
+func dueKey(s Schedule, id int) sortKey {
+	if s.DueAt == nil {
+		return sortKey{Time: 1<<63 - 1, ID: id}
+	}
+	return timeKey(*s.DueAt, id)
+}
+
+// LoadTimeZone resolves an IANA time zone name such as "Europe/Istanbul".
+// The empty name is UTC.
+func LoadTimeZone(name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, ErrInvalidTimeZone
+	}
+	return loc, nil
+}
@@ -0,0 +1,146 @@
+package entity
+
+import (
+	"log"
+	"time"
+)
+
+// SchedulerReport lists the occurrences one run of the scheduler created.
+type SchedulerReport struct {
+	TodoIDs []int `json:"todo_ids"`
+	ItemIDs []int `json:"item_ids"`
+}
+
+// Scheduler creates the occurrences of recurring todos and items ahead of
+// time: every head that starts within the horizon is advanced (see
+// recurrence.go), so the occurrences coming up already exist and can be
+// edited or skipped one by one.
+type Scheduler struct {
+	todos      TodoStore
+	items      TodoItemStore
+	unitOfWork UnitOfWork
+	horizon    time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewScheduler(todos TodoStore, items TodoItemStore, unitOfWork UnitOfWork, horizon time.Duration) *Scheduler {
+	return &Scheduler{
+		todos:      todos,
+		items:      items,
+		unitOfWork: unitOfWork,
+		horizon:    horizon,
+	}
+}
+
+// Materialize advances every series whose head starts before now plus the
+// horizon, as often as it takes to get the head past it. Each series is
+// advanced in a transaction of its own; one that fails is logged and left
+// for the next run.
+func (s *Scheduler) Materialize(now time.Time) *SchedulerReport {
+	report := &SchedulerReport{TodoIDs: make([]int, 0), ItemIDs: make([]int, 0)}
+	limit := now.Add(s.horizon)
+
+	todoHeads, err := s.todos.GetRecurring()
+	if err != nil {
+		log.Printf("scheduler: listing recurring todos failed: %v", err)
+	}
+	for _, head := range todoHeads {
+		var created []int
+		err := s.unitOfWork.Do(func(tx Tx) error {
+			for id := head.ID; ; {
+				// Reload the head in the transaction in case it changed
+				// since it was listed.
+				todo, err := tx.Todos().GetByID(id)
+				if err != nil || todo.RRule == "" {
+					return nil
+				}
+				if todo.startsAt(todo.AllDay, ownerLocation(tx.Users(), todo.UserID)).After(limit) {
+					return nil
+				}
+				next, err := AdvanceTodo(tx, id, now)
+				if err != nil || next == nil {
+					return err
+				}
+				created = append(created, next.ID)
+				id = next.ID
+			}
+		})
+		if err != nil {
+			log.Printf("scheduler: advancing todo %d failed: %v", head.ID, err)
+			continue
+		}
+		report.TodoIDs = append(report.TodoIDs, created...)
+	}
+
+	itemHeads, err := s.items.GetRecurring()
+	if err != nil {
+		log.Printf("scheduler: listing recurring items failed: %v", err)
+	}
+	for _, head := range itemHeads {
+		var created []int
+		err := s.unitOfWork.Do(func(tx Tx) error {
+			for id := head.ID; ; {
+				item, err := tx.Items().GetByID(id)
+				if err != nil || item.RRule == "" {
+					return nil
+				}
+				// Items of a deleted todo wait for it to be restored.
+				todo, err := tx.Todos().GetByID(item.TodoID)
+				if err != nil {
+					return nil
+				}
+				if item.startsAt(item.AllDay, ownerLocation(tx.Users(), todo.UserID)).After(limit) {
+					return nil
+				}
+				next, err := AdvanceItem(tx, id, now)
+				if err != nil || next == nil {
+					return err
+				}
+				created = append(created, next.ID)
+				id = next.ID
+			}
+		})
+		if err != nil {
+			log.Printf("scheduler: advancing item %d failed: %v", head.ID, err)
+			continue
+		}
+		report.ItemIDs = append(report.ItemIDs, created...)
+	}
+
+	return report
+}
+
+// Start runs Materialize right away and then every interval until Stop is
+// called.
+func (s *Scheduler) Start(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			report := s.Materialize(time.Now())
+			if len(report.TodoIDs) > 0 || len(report.ItemIDs) > 0 {
+				log.Printf("scheduler: created %d todo and %d item occurrences", len(report.TodoIDs), len(report.ItemIDs))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+}
@@ -0,0 +1,597 @@
+package entity
+
+import (
+	"errors"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	SearchTodo = "todo"
+	SearchItem = "item"
+
+	// titleWeight makes a match in the title count for more than the same
+	// match in the description.
+	titleWeight = 2.0
+
+	snippetRadius = 60
+)
+
+var ErrEmptySearch = errors.New("search query has no words")
+
+// SearchQuery describes one search. Every word of Text must appear in a
+// record's title or description for it to match.
+type SearchQuery struct {
+	Text string
+	Kind string // SearchTodo, SearchItem or "" for both
+
+	// WorkspaceID limits the results to the todos of one workspace and
+	// their items. It is always set.
+	WorkspaceID int
+	// UserID limits the results to the todos of one user and their items;
+	// 0 searches everything. Deleted records, and items of deleted todos,
+	// are only returned with IncludeDeleted.
+	UserID         int
+	IncludeDeleted bool
+	// SharedTodoIDs adds todos shared with UserID, and their items, to the
+	// results.
+	SharedTodoIDs []int
+
+	Limit int
+}
+
+// SearchResult is one match. Highlights holds a snippet of every field that
+// matched, HTML-escaped, with the matching words wrapped in <mark>.
+type SearchResult struct {
+	Kind       string            `json:"type"`
+	ID         int               `json:"id"`
+	TodoID     int               `json:"todo_id"`
+	Title      string            `json:"title"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+	Deleted    bool              `json:"deleted,omitempty"`
+}
+
+type searchDoc struct {
+	kind string
+	id   int
+}
+
+// posting counts how often a word appears in each field of a record.
+type posting struct {
+	title       int
+	description int
+}
+
+// workspaceIndex holds the postings of one workspace's records.
+type workspaceIndex struct {
+	postings map[string]map[searchDoc]posting
+	size     int
+}
+
+// indexedDoc is where a record sits in the index: its workspace, its todo
+// (the record itself for a todo) and the words it was filed under.
+type indexedDoc struct {
+	workspaceID int
+	todoID      int
+	words       []string
+}
+
+// SearchIndex is an inverted index over the titles and descriptions of todos
+// and items. It is built once on startup and then kept up to date by the
+// stores it hands out, which reindex a record whenever its text can have
+// changed. Everything else about a record, such as its owner or whether it
+// is deleted, is looked up from the underlying stores at query time, so
+// deletes, restores and reassignments need no index work at all.
+//
+// Every workspace has postings of its own, so a search only ever looks at
+// the records of one workspace, and ranks them by how common a word is in
+// that workspace alone.
+//
+// The index lives in process memory and only sees the writes made through
+// this process. main therefore leaves it off for a database that several
+// instances can share (see config.Config.SearchIndex).
+type SearchIndex struct {
+	mu         sync.RWMutex
+	workspaces map[int]*workspaceIndex
+	docs       map[searchDoc]indexedDoc
+
+	todos TodoStore
+	items TodoItemStore
+}
+
+func NewSearchIndex(todos TodoStore, items TodoItemStore) *SearchIndex {
+	return &SearchIndex{
+		workspaces: make(map[int]*workspaceIndex),
+		docs:       make(map[searchDoc]indexedDoc),
+		todos:      todos,
+		items:      items,
+	}
+}
+
+// Build indexes every todo and item of every workspace, deleted ones
+// included.
+func (s *SearchIndex) Build(workspaces WorkspaceStore) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := workspaces.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, workspace := range all {
+		todos, err := s.todos.GetByWorkspaceIDWithDeleted(workspace.ID)
+		if err != nil {
+			return err
+		}
+		for _, todo := range todos {
+			s.add(searchDoc{SearchTodo, todo.ID}, todo, todo.Title, todo.Description)
+			items, err := s.items.GetByTodoIDWithDeleted(todo.ID)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				s.add(searchDoc{SearchItem, item.ID}, todo, item.Title, item.Description)
+			}
+		}
+	}
+	return nil
+}
+
+// refresh reindexes a record from its current state in the store, or drops
+// it if it no longer exists. Reading under the index lock means that of two
+// concurrent refreshes of the same record, the later one sees the later
+// state.
+func (s *SearchIndex) refresh(doc searchDoc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(doc)
+	switch doc.kind {
+	case SearchTodo:
+		if todo, err := s.todos.GetByIDWithDeleted(doc.id); err == nil {
+			s.add(doc, todo, todo.Title, todo.Description)
+		}
+	case SearchItem:
+		item, err := s.items.GetByIDWithDeleted(doc.id)
+		if err != nil {
+			return
+		}
+		if todo, err := s.todos.GetByIDWithDeleted(item.TodoID); err == nil {
+			s.add(doc, todo, item.Title, item.Description)
+		}
+	}
+}
+
+// add files doc, which is todo or one of its items, under the words of its
+// title and description.
+func (s *SearchIndex) add(doc searchDoc, todo *Todo, title, description string) {
+	counts := make(map[string]posting)
+	for _, word := range tokenize(title) {
+		p := counts[word.text]
+		p.title++
+		counts[word.text] = p
+	}
+	for _, word := range tokenize(description) {
+		p := counts[word.text]
+		p.description++
+		counts[word.text] = p
+	}
+
+	ws := s.workspaces[todo.WorkspaceID]
+	if ws == nil {
+		ws = &workspaceIndex{postings: make(map[string]map[searchDoc]posting)}
+		s.workspaces[todo.WorkspaceID] = ws
+	}
+	words := make([]string, 0, len(counts))
+	for word, p := range counts {
+		if ws.postings[word] == nil {
+			ws.postings[word] = make(map[searchDoc]posting)
+		}
+		ws.postings[word][doc] = p
+		words = append(words, word)
+	}
+	ws.size++
+	s.docs[doc] = indexedDoc{workspaceID: todo.WorkspaceID, todoID: todo.ID, words: words}
+}
+
+func (s *SearchIndex) remove(doc searchDoc) {
+	indexed, ok := s.docs[doc]
+	if !ok {
+		return
+	}
+	ws := s.workspaces[indexed.workspaceID]
+	for _, word := range indexed.words {
+		delete(ws.postings[word], doc)
+		if len(ws.postings[word]) == 0 {
+			delete(ws.postings, word)
+		}
+	}
+	ws.size--
+	if ws.size == 0 {
+		delete(s.workspaces, indexed.workspaceID)
+	}
+	delete(s.docs, doc)
+}
+
+type scoredDoc struct {
+	doc    searchDoc
+	todoID int
+	score  float64
+}
+
+// resolveBatch is how many ranked records Search loads at a time.
+const resolveBatch = 100
+
+// Search returns the records matching q, best first. Records are ranked by
+// TF-IDF, so rare words weigh more than common ones.
+func (s *SearchIndex) Search(q SearchQuery) ([]*SearchResult, error) {
+	terms := uniqueWords(q.Text)
+	if len(terms) == 0 {
+		return nil, ErrEmptySearch
+	}
+
+	ranked := s.rank(q.WorkspaceID, terms, q.Kind)
+
+	results := make([]*SearchResult, 0)
+	for len(ranked) > 0 && (q.Limit <= 0 || len(results) < q.Limit) {
+		n := resolveBatch
+		if n > len(ranked) {
+			n = len(ranked)
+		}
+		batch, err := s.resolve(ranked[:n], terms, q)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+		ranked = ranked[n:]
+	}
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+func (s *SearchIndex) rank(workspaceID int, terms []string, kind string) []scoredDoc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ws := s.workspaces[workspaceID]
+	if ws == nil {
+		return nil
+	}
+
+	total := float64(ws.size)
+	scores := make(map[searchDoc]float64)
+	for i, term := range terms {
+		docs := ws.postings[term]
+		idf := math.Log(1 + total/float64(len(docs)+1))
+
+		next := make(map[searchDoc]float64)
+		for doc, p := range docs {
+			if kind != "" && doc.kind != kind {
+				continue
+			}
+			score, ok := scores[doc]
+			if i > 0 && !ok {
+				continue
+			}
+			tf := titleWeight*float64(p.title) + float64(p.description)
+			next[doc] = score + idf*(1+math.Log(tf))
+		}
+		scores = next
+	}
+
+	ranked := make([]scoredDoc, 0, len(scores))
+	for doc, score := range scores {
+		ranked = append(ranked, scoredDoc{doc, s.docs[doc].todoID, score})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		if ranked[i].doc.kind != ranked[j].doc.kind {
+			return ranked[i].doc.kind > ranked[j].doc.kind
+		}
+		return ranked[i].doc.id < ranked[j].doc.id
+	})
+	return ranked
+}
+
+// resolve loads a batch of ranked records, with one query for their todos
+// and one for the items among them, and turns the ones the query may see
+// into results, keeping their order.
+func (s *SearchIndex) resolve(ranked []scoredDoc, terms []string, q SearchQuery) ([]*SearchResult, error) {
+	var todoIDs []int
+	for _, r := range ranked {
+		todoIDs = append(todoIDs, r.todoID)
+	}
+	list, err := s.todos.GetByIDsWithDeleted(todoIDs)
+	if err != nil {
+		return nil, err
+	}
+	todos := make(map[int]*Todo, len(list))
+	for _, todo := range list {
+		todos[todo.ID] = todo
+	}
+
+	// An item is visible when its todo is, so only the items of visible
+	// todos need loading.
+	visible := func(todoID int) bool {
+		todo, ok := todos[todoID]
+		if !ok || todo.WorkspaceID != q.WorkspaceID {
+			return false
+		}
+		if q.UserID != 0 && todo.UserID != q.UserID && !containsInt(q.SharedTodoIDs, todo.ID) {
+			return false
+		}
+		return todo.DeletedAt == nil || q.IncludeDeleted
+	}
+	var itemIDs []int
+	for _, r := range ranked {
+		if r.doc.kind == SearchItem && visible(r.todoID) {
+			itemIDs = append(itemIDs, r.doc.id)
+		}
+	}
+	items := make(map[int]*TodoItem, len(itemIDs))
+	if len(itemIDs) > 0 {
+		list, err := s.items.GetByIDsWithDeleted(itemIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list {
+			items[item.ID] = item
+		}
+	}
+
+	results := make([]*SearchResult, 0, len(ranked))
+	for _, r := range ranked {
+		var result *SearchResult
+		var description string
+
+		switch r.doc.kind {
+		case SearchTodo:
+			todo, ok := todos[r.doc.id]
+			if !ok || !visible(todo.ID) {
+				continue
+			}
+			result = &SearchResult{Kind: SearchTodo, ID: todo.ID, TodoID: todo.ID, Title: todo.Title, Deleted: todo.DeletedAt != nil}
+			description = todo.Description
+		case SearchItem:
+			item, ok := items[r.doc.id]
+			if !ok || !visible(item.TodoID) {
+				continue
+			}
+			result = &SearchResult{Kind: SearchItem, ID: item.ID, TodoID: item.TodoID, Title: item.Title, Deleted: item.DeletedAt != nil}
+			description = item.Description
+		default:
+			continue
+		}
+
+		if result.Deleted && !q.IncludeDeleted {
+			continue
+		}
+
+		result.Score = math.Round(r.score*1000) / 1000
+		result.Highlights = make(map[string]string)
+		if snippet, ok := highlight(result.Title, terms); ok {
+			result.Highlights["title"] = snippet
+		}
+		if snippet, ok := highlight(description, terms); ok {
+			result.Highlights["description"] = snippet
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+type word struct {
+	text       string
+	start, end int // rune offsets
+}
+
+// tokenize splits text into lower-cased runs of letters and digits.
+func tokenize(text string) []word {
+	var words []word
+	runes := []rune(text)
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		inWord := i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			words = append(words, word{strings.ToLower(string(runes[start:i])), start, i})
+			start = -1
+		}
+	}
+	return words
+}
+
+func uniqueWords(text string) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, w := range tokenize(text) {
+		if !seen[w.text] {
+			seen[w.text] = true
+			words = append(words, w.text)
+		}
+	}
+	return words
+}
+
+// highlight cuts a window of text around the first matching word and marks
+// every match inside it. ok is false if no word matches.
+func highlight(text string, terms []string) (string, bool) {
+	match := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		match[term] = true
+	}
+
+	var hits []word
+	for _, w := range tokenize(text) {
+		if match[w.text] {
+			hits = append(hits, w)
+		}
+	}
+	if len(hits) == 0 {
+		return "", false
+	}
+
+	runes := []rune(text)
+	from := hits[0].start - snippetRadius
+	if from < 0 {
+		from = 0
+	}
+	to := hits[0].end + snippetRadius
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, hit := range hits {
+		if hit.start < from || hit.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:hit.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[hit.start:hit.end])))
+		b.WriteString("</mark>")
+		pos = hit.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+// The stores below wrap the ones the index reads from and reindex every
+// record whose text a write can change. Deletes and restores leave the text
+// alone and pass straight through.
+
+func (s *SearchIndex) TodoStore(inner TodoStore) TodoStore {
+	return &searchTodoStore{TodoStore: inner, touch: s.refresh}
+}
+
+func (s *SearchIndex) TodoItemStore(inner TodoItemStore) TodoItemStore {
+	return &searchTodoItemStore{TodoItemStore: inner, touch: s.refresh}
+}
+
+// UnitOfWork wraps inner so that writes made inside a transaction are
+// reindexed once it has committed, and not at all if it rolls back.
+func (s *SearchIndex) UnitOfWork(inner UnitOfWork) UnitOfWork {
+	return &searchUnitOfWork{inner: inner, index: s}
+}
+
+type searchTodoStore struct {
+	TodoStore
+	touch func(searchDoc)
+}
+
+func (s *searchTodoStore) Create(todo *Todo) error {
+	if err := s.TodoStore.Create(todo); err != nil {
+		return err
+	}
+	s.touch(searchDoc{SearchTodo, todo.ID})
+	return nil
+}
+
+func (s *searchTodoStore) Update(todo *Todo) error {
+	if err := s.TodoStore.Update(todo); err != nil {
+		return err
+	}
+	s.touch(searchDoc{SearchTodo, todo.ID})
+	return nil
+}
+
+func (s *searchTodoStore) Purge(id int) error {
+	if err := s.TodoStore.Purge(id); err != nil {
+		return err
+	}
+	s.touch(searchDoc{SearchTodo, id})
+	return nil
+}
+
+type searchTodoItemStore struct {
+	TodoItemStore
+	touch func(searchDoc)
+}
+
+func (s *searchTodoItemStore) Create(item *TodoItem) error {
+	if err := s.TodoItemStore.Create(item); err != nil {
+		return err
+	}
+	s.touch(searchDoc{SearchItem, item.ID})
+	return nil
+}
+
+func (s *searchTodoItemStore) Update(item *TodoItem) error {
+	if err := s.TodoItemStore.Update(item); err != nil {
+		return err
+	}
+	s.touch(searchDoc{SearchItem, item.ID})
+	return nil
+}
+
+func (s *searchTodoItemStore) Purge(id int) error {
+	if err := s.TodoItemStore.Purge(id); err != nil {
+		return err
+	}
+	s.touch(searchDoc{SearchItem, id})
+	return nil
+}
+
+type searchUnitOfWork struct {
+	inner UnitOfWork
+	index *SearchIndex
+}
+
+func (u *searchUnitOfWork) Do(fn func(tx Tx) error) error {
+	var touched []searchDoc
+	touch := func(doc searchDoc) {
+		touched = append(touched, doc)
+	}
+
+	err := u.inner.Do(func(tx Tx) error {
+		return fn(&searchTx{
+			Tx:    tx,
+			todos: &searchTodoStore{TodoStore: tx.Todos(), touch: touch},
+			items: &searchTodoItemStore{TodoItemStore: tx.Items(), touch: touch},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range touched {
+		u.index.refresh(doc)
+	}
+	return nil
+}
+
+type searchTx struct {
+	Tx
+	todos TodoStore
+	items TodoItemStore
+}
+
+func (tx *searchTx) Todos() TodoStore     { return tx.todos }
+func (tx *searchTx) Items() TodoItemStore { return tx.items }
+
+func containsInt(ids []int, id int) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package entity
+
+import (
+	"testing"
+)
+
+// countingTodoStore counts the lookups a search makes.
+type countingTodoStore struct {
+	*TodoModel
+	single, batch int
+}
+
+func (s *countingTodoStore) GetByIDWithDeleted(id int) (*Todo, error) {
+	s.single++
+	return s.TodoModel.GetByIDWithDeleted(id)
+}
+
+func (s *countingTodoStore) GetByIDsWithDeleted(ids []int) ([]*Todo, error) {
+	s.batch++
+	return s.TodoModel.GetByIDsWithDeleted(ids)
+}
+
+func newSearchFixture(t *testing.T) (*SearchIndex, TodoStore, TodoItemStore) {
+	t.Helper()
+	items := NewTodoItemModel()
+	index := NewSearchIndex(NewTodoModel(items), items)
+	index.todos = &countingTodoStore{TodoModel: index.todos.(*TodoModel)}
+	return index, index.TodoStore(index.todos), index.TodoItemStore(items)
+}
+
+func createSearchTodo(t *testing.T, todos TodoStore, workspaceID int, title string) *Todo {
+	t.Helper()
+	todo := &Todo{Title: title, UserID: 1, WorkspaceID: workspaceID}
+	if err := todos.Create(todo); err != nil {
+		t.Fatal(err)
+	}
+	return todo
+}
+
+// Records of another workspace neither show up nor change the ranking.
+func TestSearchStaysInWorkspace(t *testing.T) {
+	index, todos, _ := newSearchFixture(t)
+	mine := createSearchTodo(t, todos, 1, "buy milk")
+	createSearchTodo(t, todos, 1, "buy bread")
+
+	q := SearchQuery{Text: "milk", WorkspaceID: 1}
+	before, err := index.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		createSearchTodo(t, todos, 2, "milk")
+	}
+	after, err := index.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(after) != 1 || after[0].ID != mine.ID {
+		t.Fatalf("results = %+v, want only todo %d", after, mine.ID)
+	}
+	if after[0].Score != before[0].Score {
+		t.Errorf("score = %v after records were added to another workspace, want %v", after[0].Score, before[0].Score)
+	}
+}
+
+// A search loads its todos and items in batches rather than one by one.
+func TestSearchLoadsInBatches(t *testing.T) {
+	index, todos, items := newSearchFixture(t)
+	for i := 0; i < 10; i++ {
+		todo := createSearchTodo(t, todos, 1, "milk")
+		for j := 0; j < 3; j++ {
+			if err := items.Create(&TodoItem{Title: "milk", TodoID: todo.ID, UserID: 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	counter := index.todos.(*countingTodoStore)
+	counter.single, counter.batch = 0, 0
+
+	results, err := index.Search(SearchQuery{Text: "milk", WorkspaceID: 1, UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 40 {
+		t.Errorf("got %d results, want 40", len(results))
+	}
+	if counter.single != 0 || counter.batch != 1 {
+		t.Errorf("todo lookups: %d single, %d batched; want none and 1", counter.single, counter.batch)
+	}
+}
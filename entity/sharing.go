@@ -0,0 +1,129 @@
+package entity
+
+import "strings"
+
+// The owner of a todo, Todo.UserID, can share it with other users, who then
+// work on it at one of three levels:
+//
+//   - viewers see the todo with its items, comments and attachments
+//   - editors also change the todo and its items, comment and upload files
+//   - owners also delete and restore the todo and decide who it is shared
+//     with
+//
+// Sharing starts with an invitation, which the invited user accepts or
+// declines; until then it grants nothing, and only members of the todo's
+// workspace can be invited. Workspace admins own every todo of the
+// workspace. UserID
+// stays the owner of record whoever else owns the todo: changes made by
+// collaborators create tags for that user, and the todo goes with that user
+// when the user is deleted.
+
+type CollaboratorRole string
+
+const (
+	RoleViewer CollaboratorRole = "viewer"
+	RoleEditor CollaboratorRole = "editor"
+	RoleOwner  CollaboratorRole = "owner"
+)
+
+// Permission is what a user may do with a todo. Higher levels include the
+// lower ones, so they can be compared with <.
+type Permission int
+
+const (
+	PermissionNone Permission = iota
+	PermissionView
+	PermissionEdit
+	PermissionOwn
+)
+
+// ParseCollaboratorRole accepts the roles in any case.
+func ParseCollaboratorRole(role string) (CollaboratorRole, error) {
+	switch r := CollaboratorRole(strings.ToLower(strings.TrimSpace(role))); r {
+	case RoleViewer, RoleEditor, RoleOwner:
+		return r, nil
+	}
+	return "", ErrInvalidRole
+}
+
+func (r CollaboratorRole) Permission() Permission {
+	switch r {
+	case RoleViewer:
+		return PermissionView
+	case RoleEditor:
+		return PermissionEdit
+	case RoleOwner:
+		return PermissionOwn
+	}
+	return PermissionNone
+}
+
+// TodoPermission returns what userID may do with todo.
+func TodoPermission(collaborators CollaboratorStore, todo *Todo, userID int, admin bool) Permission {
+	if admin || todo.UserID == userID {
+		return PermissionOwn
+	}
+	collaborator, err := collaborators.GetByTodoAndUser(todo.ID, userID)
+	if err != nil || collaborator.AcceptedAt == nil {
+		return PermissionNone
+	}
+	return collaborator.Role.Permission()
+}
+
+// SharedTodoIDs returns the todos a user accepted an invitation to.
+func SharedTodoIDs(collaborators CollaboratorStore, userID int) ([]int, error) {
+	shares, err := collaborators.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, collaborator := range shares {
+		if collaborator.AcceptedAt != nil {
+			ids = append(ids, collaborator.TodoID)
+		}
+	}
+	return ids, nil
+}
+
+// SharedTodos returns the live todos of a workspace a user accepted an
+// invitation to.
+func SharedTodos(todos TodoStore, collaborators CollaboratorStore, workspaceID, userID int) ([]*Todo, error) {
+	ids, err := SharedTodoIDs(collaborators, userID)
+	if err != nil {
+		return nil, err
+	}
+	var shared []*Todo
+	for _, id := range ids {
+		if todo, err := todos.GetByID(id); err == nil && todo.WorkspaceID == workspaceID {
+			shared = append(shared, todo)
+		}
+	}
+	return shared, nil
+}
+
+// InviteCollaborator invites the user with the given username to todo. The
+// owner of record can't be invited, and a user who already has an entry on
+// the todo, accepted or not, gets ErrCollaboratorExists.
+func InviteCollaborator(tx Tx, todo *Todo, username string, role CollaboratorRole, invitedBy int) (*Collaborator, error) {
+	user, err := tx.Users().GetByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == todo.UserID {
+		return nil, ErrInvalidCollaborator
+	}
+	if _, err := tx.Workspaces().GetMember(todo.WorkspaceID, user.ID); err != nil {
+		return nil, ErrNotWorkspaceMember
+	}
+
+	collaborator := &Collaborator{
+		TodoID:    todo.ID,
+		UserID:    user.ID,
+		Role:      role,
+		InvitedBy: invitedBy,
+	}
+	if err := tx.Collaborators().Create(collaborator); err != nil {
+		return nil, err
+	}
+	return collaborator, nil
+}
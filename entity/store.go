@@ -0,0 +1,137 @@
+package entity
+
+import "time"
+
+// TodoStore is the storage contract for todos. TodoModel is the default
+// in-memory implementation.
+type TodoStore interface {
+	Create(todo *Todo) error
+	GetByID(id int) (*Todo, error)
+	GetByIDWithDeleted(id int) (*Todo, error)
+	// GetByIDsWithDeleted returns the todos with the given IDs, deleted or
+	// not, in no particular order. IDs that don't exist are left out.
+	GetByIDsWithDeleted(ids []int) ([]*Todo, error)
+	// GetByIDInWorkspace and GetByIDInWorkspaceWithDeleted return
+	// ErrTodoNotFound for todos of other workspaces. Requests look todos up
+	// with these, and reach items, comments and attachments through them.
+	GetByIDInWorkspace(id, workspaceID int) (*Todo, error)
+	GetByIDInWorkspaceWithDeleted(id, workspaceID int) (*Todo, error)
+	// The lists are limited to one workspace, see membership.go.
+	GetByWorkspaceID(workspaceID int) ([]*Todo, error)
+	GetByWorkspaceIDWithDeleted(workspaceID int) ([]*Todo, error)
+	GetByUserID(workspaceID, userID int) ([]*Todo, error)
+	GetByUserIDWithDeleted(workspaceID, userID int) ([]*Todo, error)
+	// GetDeletedBefore and GetRecurring look across workspaces, for the
+	// janitor and the scheduler.
+	GetDeletedBefore(before time.Time) ([]*Todo, error)
+	GetRecurring() ([]*Todo, error)
+	Update(todo *Todo) error
+	Delete(id int) error
+	Restore(id int) error
+	Purge(id int) error
+	UpdateCompletionPct(todoID int, todoItemStore TodoItemStore) error
+	// SetTags replaces the tags of a todo, deleted or not. See tagging.go.
+	SetTags(id int, tags []string) error
+}
+
+// TodoItemStore is the storage contract for todo items. TodoItemModel is the
+// default in-memory implementation.
+type TodoItemStore interface {
+	Create(item *TodoItem) error
+	GetByID(id int) (*TodoItem, error)
+	GetByIDWithDeleted(id int) (*TodoItem, error)
+	// GetByIDsWithDeleted works like TodoStore.GetByIDsWithDeleted.
+	GetByIDsWithDeleted(ids []int) ([]*TodoItem, error)
+	GetByTodoID(todoID int) ([]*TodoItem, error)
+	GetByTodoIDWithDeleted(todoID int) ([]*TodoItem, error)
+	GetDeletedBefore(before time.Time) ([]*TodoItem, error)
+	GetRecurring() ([]*TodoItem, error)
+	Update(item *TodoItem) error
+	Delete(id int) error
+	Restore(id int) error
+	Purge(id int) error
+	SetTags(id int, tags []string) error
+}
+
+// UserStore is the storage contract for users. UserModel is the default
+// in-memory implementation.
+type UserStore interface {
+	Create(user *User) error
+	GetByID(id int) (*User, error)
+	GetByUsername(username string) (*User, error)
+	GetAll() ([]*User, error)
+	Update(user *User) error
+	Delete(id int, policy UserDeletePolicy) error
+}
+
+// TagStore is the storage contract for tags. TagModel is the default
+// in-memory implementation.
+type TagStore interface {
+	Create(tag *Tag) error
+	GetByID(id int) (*Tag, error)
+	GetByName(userID int, name string) (*Tag, error)
+	GetByUserID(userID int) ([]*Tag, error)
+	Update(tag *Tag) error
+	Delete(id int) error
+}
+
+// CommentStore is the storage contract for comments. CommentModel is the
+// default in-memory implementation.
+type CommentStore interface {
+	Create(comment *Comment) error
+	GetByID(id int) (*Comment, error)
+	GetByTodoID(todoID int) ([]*Comment, error)
+	CountByTodoIDs(todoIDs []int) (map[int]int, error)
+	Update(comment *Comment) error
+	Delete(id int) error
+	Purge(id int) error
+}
+
+// AttachmentStore is the storage contract for attachment metadata.
+// AttachmentModel is the default in-memory implementation; the content is
+// kept in a BlobStore.
+type AttachmentStore interface {
+	Create(attachment *Attachment) error
+	GetByID(id int) (*Attachment, error)
+	GetByItemID(itemID int) ([]*Attachment, error)
+	Delete(id int) error
+}
+
+// CollaboratorStore is the storage contract for the users a todo is shared
+// with. CollaboratorModel is the default in-memory implementation.
+type CollaboratorStore interface {
+	Create(collaborator *Collaborator) error
+	GetByID(id int) (*Collaborator, error)
+	GetByTodoAndUser(todoID, userID int) (*Collaborator, error)
+	GetByTodoID(todoID int) ([]*Collaborator, error)
+	GetByUserID(userID int) ([]*Collaborator, error)
+	Update(collaborator *Collaborator) error
+	Delete(id int) error
+}
+
+// WorkspaceStore is the storage contract for workspaces and their members.
+// WorkspaceModel is the default in-memory implementation.
+type WorkspaceStore interface {
+	Create(workspace *Workspace) error
+	GetByID(id int) (*Workspace, error)
+	GetAll() ([]*Workspace, error)
+	GetByUserID(userID int) ([]*Workspace, error)
+	Update(workspace *Workspace) error
+	Delete(id int) error
+	AddMember(member *WorkspaceMember) error
+	GetMember(workspaceID, userID int) (*WorkspaceMember, error)
+	GetMembers(workspaceID int) ([]*WorkspaceMember, error)
+	UpdateMember(member *WorkspaceMember) error
+	RemoveMember(workspaceID, userID int) error
+}
+
+var (
+	_ TodoStore         = (*TodoModel)(nil)
+	_ TodoItemStore     = (*TodoItemModel)(nil)
+	_ UserStore         = (*UserModel)(nil)
+	_ TagStore          = (*TagModel)(nil)
+	_ CommentStore      = (*CommentModel)(nil)
+	_ AttachmentStore   = (*AttachmentModel)(nil)
+	_ CollaboratorStore = (*CollaboratorModel)(nil)
+	_ WorkspaceStore    = (*WorkspaceModel)(nil)
+)
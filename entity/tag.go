@@ -0,0 +1,231 @@
+package entity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tag is one of a user's labels. Todos and items carry the names of their
+// tags rather than IDs, see tagging.go.
+type Tag struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type TagModel struct {
+	sync.RWMutex
+	tags   map[int]*Tag
+	nextID int
+
+	// byUser maps each user's tag names to tag IDs. Names are unique per
+	// user.
+	byUser map[int]map[string]int
+}
+
+func NewTagModel() *TagModel {
+	return &TagModel{
+		tags:   make(map[int]*Tag),
+		nextID: 1,
+		byUser: make(map[int]map[string]int),
+	}
+}
+
+// As in the other models, the exported methods lock and delegate to
+// lower-case variants that transactions call while holding the lock, and
+// tags are copied on the way in and out. Tags are deleted for good; a tag in
+// use is removed from its records first (see DeleteTag).
+
+func (m *TagModel) Create(tag *Tag) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.create(tag)
+}
+
+func (m *TagModel) GetByID(id int) (*Tag, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByID(id)
+}
+
+func (m *TagModel) GetByName(userID int, name string) (*Tag, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByName(userID, name)
+}
+
+func (m *TagModel) GetByUserID(userID int) ([]*Tag, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByUserID(userID), nil
+}
+
+// Update renames a tag. The owner can't be changed.
+func (m *TagModel) Update(tag *Tag) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.update(tag)
+}
+
+func (m *TagModel) Delete(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.delete(id)
+}
+
+func (m *TagModel) create(tag *Tag) error {
+	if _, taken := m.byUser[tag.UserID][tag.Name]; taken {
+		return ErrTagExists
+	}
+
+	tag.ID = m.nextID
+	m.nextID++
+	tag.CreatedAt = time.Now()
+	tag.UpdatedAt = tag.CreatedAt
+	tag.Version = 1
+
+	m.tags[tag.ID] = cloneTag(tag)
+	m.index(tag)
+	return nil
+}
+
+func (m *TagModel) getByID(id int) (*Tag, error) {
+	tag, exists := m.tags[id]
+	if !exists {
+		return nil, ErrTagNotFound
+	}
+	return cloneTag(tag), nil
+}
+
+func (m *TagModel) getByName(userID int, name string) (*Tag, error) {
+	id, exists := m.byUser[userID][name]
+	if !exists {
+		return nil, ErrTagNotFound
+	}
+	return cloneTag(m.tags[id]), nil
+}
+
+// getByUserID returns the user's tags ordered by name.
+func (m *TagModel) getByUserID(userID int) []*Tag {
+	tags := make([]*Tag, 0, len(m.byUser[userID]))
+	for _, id := range m.byUser[userID] {
+		tags = append(tags, cloneTag(m.tags[id]))
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags
+}
+
+func (m *TagModel) update(tag *Tag) error {
+	existing, exists := m.tags[tag.ID]
+	if !exists {
+		return ErrTagNotFound
+	}
+	if tag.Version != 0 && tag.Version != existing.Version {
+		return ErrVersionConflict
+	}
+	if id, taken := m.byUser[existing.UserID][tag.Name]; taken && id != tag.ID {
+		return ErrTagExists
+	}
+
+	// Tags are replaced rather than changed in place, so a transaction can
+	// put the old record back as a whole.
+	updated := cloneTag(existing)
+	updated.Name = tag.Name
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+
+	m.unindex(existing)
+	m.tags[tag.ID] = updated
+	m.index(updated)
+
+	*tag = *cloneTag(updated)
+	return nil
+}
+
+func (m *TagModel) delete(id int) error {
+	if _, exists := m.tags[id]; !exists {
+		return ErrTagNotFound
+	}
+	m.remove(id)
+	return nil
+}
+
+func (m *TagModel) index(tag *Tag) {
+	if m.byUser[tag.UserID] == nil {
+		m.byUser[tag.UserID] = make(map[string]int)
+	}
+	m.byUser[tag.UserID][tag.Name] = tag.ID
+}
+
+func (m *TagModel) unindex(tag *Tag) {
+	if m.byUser[tag.UserID][tag.Name] != tag.ID {
+		return
+	}
+	delete(m.byUser[tag.UserID], tag.Name)
+	if len(m.byUser[tag.UserID]) == 0 {
+		delete(m.byUser, tag.UserID)
+	}
+}
+
+// remove drops a tag from the map and the index.
+func (m *TagModel) remove(id int) {
+	if tag, ok := m.tags[id]; ok {
+		m.unindex(tag)
+		delete(m.tags, id)
+	}
+}
+
+// restore puts a tag back into the map with its original ID. It is used when
+// rebuilding the model from a journal.
+func (m *TagModel) restore(tag *Tag) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.remove(tag.ID)
+	m.tags[tag.ID] = tag
+	m.index(tag)
+	if tag.ID >= m.nextID {
+		m.nextID = tag.ID + 1
+	}
+}
+
+// discard deletes a tag without reporting missing IDs, for journal replay.
+func (m *TagModel) discard(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.remove(id)
+}
+
+func (m *TagModel) snapshot() []*Tag {
+	m.RLock()
+	defer m.RUnlock()
+
+	tags := make([]*Tag, 0, len(m.tags))
+	for _, tag := range m.tags {
+		tags = append(tags, cloneTag(tag))
+	}
+	return tags
+}
+
+func (m *TagModel) nextIDs() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.nextID
+}
+
+func (m *TagModel) reserveIDs(next int) {
+	m.Lock()
+	defer m.Unlock()
+	if next > m.nextID {
+		m.nextID = next
+	}
+}
+
+func cloneTag(tag *Tag) *Tag {
+	c := *tag
+	return &c
+}
@@ -0,0 +1,258 @@
+package entity
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tags are free-form: putting a new name on a todo or item creates the tag
+// for the todo's owner, and the tags of a todo's items belong to the todo's
+// owner as well. Records carry tag names, sorted and without duplicates, so
+// renaming, merging or deleting a tag rewrites every record that carries it.
+// The functions below do that inside a transaction and cover deleted records
+// too, so nothing comes back from the trash with a name that is gone.
+
+const (
+	maxTagLength     = 50
+	maxTagsPerRecord = 20
+)
+
+// NormalizeTag returns a tag name the way it is stored: trimmed and in lower
+// case. Names can't be empty, longer than 50 characters or contain commas,
+// which separate names in the tags filter.
+func NormalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || utf8.RuneCountInString(name) > maxTagLength || strings.ContainsRune(name, ',') {
+		return "", ErrInvalidTag
+	}
+	return name, nil
+}
+
+// NormalizeTags normalizes the tag names of one record, sorts them and drops
+// duplicates. A record can carry at most 20 tags.
+func NormalizeTags(names []string) ([]string, error) {
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		tag, err := NormalizeTag(name)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	tags = sortTags(tags)
+	if len(tags) > maxTagsPerRecord {
+		return nil, ErrTooManyTags
+	}
+	return tags, nil
+}
+
+func sortTags(names []string) []string {
+	sort.Strings(names)
+	unique := names[:0]
+	for _, name := range names {
+		if len(unique) == 0 || unique[len(unique)-1] != name {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// EnsureTags creates the tags among names that userID doesn't have yet.
+// names must already be normalized.
+func EnsureTags(tags TagStore, userID int, names []string) error {
+	for _, name := range names {
+		_, err := tags.GetByName(userID, name)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrTagNotFound) {
+			return err
+		}
+		if err := tags.Create(&Tag{UserID: userID, Name: name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameTag renames a tag along with every record that carries it. It fails
+// with ErrTagExists if the owner already has a tag by the new name; use
+// MergeTags to combine the two. A non-zero version makes the rename
+// conditional, as with Update.
+func RenameTag(tx Tx, id, version int, name string) (*Tag, error) {
+	name, err := NormalizeTag(name)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := tx.Tags().GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	old := tag.Name
+	tag.Name = name
+	tag.Version = version
+	if err := tx.Tags().Update(tag); err != nil {
+		return nil, err
+	}
+	if err := retag(tx, tag.UserID, old, name); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+// MergeTags puts tag intoID on every record that carries tag id, takes id
+// off them and deletes it. Both tags must belong to the same user.
+func MergeTags(tx Tx, id, intoID int) (*Tag, error) {
+	from, err := tx.Tags().GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	into, err := tx.Tags().GetByID(intoID)
+	if err != nil {
+		return nil, err
+	}
+	if from.ID == into.ID || from.UserID != into.UserID {
+		return nil, ErrInvalidTagMerge
+	}
+
+	if err := retag(tx, from.UserID, from.Name, into.Name); err != nil {
+		return nil, err
+	}
+	if err := tx.Tags().Delete(from.ID); err != nil {
+		return nil, err
+	}
+	return into, nil
+}
+
+// DeleteTag takes a tag off every record that carries it and deletes it.
+func DeleteTag(tx Tx, id int) error {
+	tag, err := tx.Tags().GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := retag(tx, tag.UserID, tag.Name, ""); err != nil {
+		return err
+	}
+	return tx.Tags().Delete(id)
+}
+
+// retag replaces tag from with to on the todos of userID and their items,
+// or removes it if to is empty. Tags belong to users, not workspaces, so
+// this goes through the user's todos in every workspace.
+func retag(tx Tx, userID int, from, to string) error {
+	workspaces, err := tx.Workspaces().GetAll()
+	if err != nil {
+		return err
+	}
+	for _, workspace := range workspaces {
+		todos, err := tx.Todos().GetByUserIDWithDeleted(workspace.ID, userID)
+		if err != nil {
+			return err
+		}
+		for _, todo := range todos {
+			if tags, changed := replaceTag(todo.Tags, from, to); changed {
+				if err := tx.Todos().SetTags(todo.ID, tags); err != nil {
+					return err
+				}
+			}
+			items, err := tx.Items().GetByTodoIDWithDeleted(todo.ID)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				if tags, changed := replaceTag(item.Tags, from, to); changed {
+					if err := tx.Items().SetTags(item.ID, tags); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// replaceTag returns names with from replaced by to, or left out if to is
+// empty. changed is false if from isn't among names.
+func replaceTag(names []string, from, to string) (tags []string, changed bool) {
+	tags = make([]string, 0, len(names))
+	for _, name := range names {
+		if name != from {
+			tags = append(tags, name)
+			continue
+		}
+		changed = true
+		if to != "" {
+			tags = append(tags, to)
+		}
+	}
+	if changed && from != to {
+		tags = sortTags(tags)
+	}
+	return tags, changed && from != to
+}
+
+func hasTag(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TagUsage is a tag with the number of records that carry it.
+type TagUsage struct {
+	*Tag
+	TodoCount int `json:"todo_count"`
+	ItemCount int `json:"item_count"`
+}
+
+// CountTagUsage counts the todos and items that carry each of tags. The
+// records passed in should be the live todos of the tags' owner and their
+// live items.
+func CountTagUsage(tags []*Tag, todos []*Todo, items []*TodoItem) []*TagUsage {
+	usage := make([]*TagUsage, len(tags))
+	byName := make(map[string]*TagUsage, len(tags))
+	for i, tag := range tags {
+		usage[i] = &TagUsage{Tag: tag}
+		byName[tag.Name] = usage[i]
+	}
+
+	for _, todo := range todos {
+		for _, name := range todo.Tags {
+			if u, ok := byName[name]; ok {
+				u.TodoCount++
+			}
+		}
+	}
+	for _, item := range items {
+		for _, name := range item.Tags {
+			if u, ok := byName[name]; ok {
+				u.ItemCount++
+			}
+		}
+	}
+	return usage
+}
+
+// TagFilter matches records by their tags: those that carry all of Names,
+// or with Any set at least one of them. No names match everything.
+type TagFilter struct {
+	Names []string
+	Any   bool
+}
+
+func (f TagFilter) matches(tags []string) bool {
+	if len(f.Names) == 0 {
+		return true
+	}
+	for _, name := range f.Names {
+		if hasTag(tags, name) == f.Any {
+			return f.Any
+		}
+	}
+	return !f.Any
+}
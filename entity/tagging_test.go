@@ -0,0 +1,143 @@
+package entity
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type tagFixture struct {
+	todos      *TodoModel
+	items      *TodoItemModel
+	tags       *TagModel
+	unitOfWork *MemoryUnitOfWork
+}
+
+// newTagFixture gives user 1 the tags "home" and "work", and a live and a
+// deleted todo tagged "home", each with an item tagged "home".
+func newTagFixture(t *testing.T) *tagFixture {
+	t.Helper()
+	f := &tagFixture{items: NewTodoItemModel(), tags: NewTagModel()}
+	f.todos = NewTodoModel(f.items)
+	workspaces := NewWorkspaceModel()
+	users := NewUserModel(f.todos, f.tags, NewCollaboratorModel(), workspaces)
+	f.unitOfWork = NewUnitOfWork(users, f.todos, f.items, f.tags, NewCommentModel(), NewAttachmentModel(), users.collaborators, workspaces)
+	if err := workspaces.Create(&Workspace{Name: "Default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := f.unitOfWork.Do(func(tx Tx) error {
+		if err := EnsureTags(tx.Tags(), 1, []string{"home", "work"}); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			todo := &Todo{Title: "todo", UserID: 1, WorkspaceID: DefaultWorkspaceID, Tags: []string{"home", "work"}}
+			if err := tx.Todos().Create(todo); err != nil {
+				return err
+			}
+			if err := tx.Items().Create(&TodoItem{Title: "item", TodoID: todo.ID, UserID: 1, Tags: []string{"home"}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.todos.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// tagsOf returns the tags of todos 1 and 2 and items 1 and 2.
+func (f *tagFixture) tagsOf(t *testing.T) [][]string {
+	t.Helper()
+	var tags [][]string
+	for id := 1; id <= 2; id++ {
+		todo, err := f.todos.GetByIDWithDeleted(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, err := f.items.GetByIDWithDeleted(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, todo.Tags, item.Tags)
+	}
+	return tags
+}
+
+func TestRenameTag(t *testing.T) {
+	f := newTagFixture(t)
+	home, err := f.tags.GetByName(1, "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.unitOfWork.Do(func(tx Tx) error {
+		_, err := RenameTag(tx, home.ID, 0, " Chores ")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.tags.GetByName(1, "chores"); err != nil {
+		t.Errorf("renamed tag: %v", err)
+	}
+	// Deleted records are renamed too, and names stay sorted.
+	want := [][]string{{"chores", "work"}, {"chores"}, {"chores", "work"}, {"chores"}}
+	if got := f.tagsOf(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("tags = %v, want %v", got, want)
+	}
+}
+
+// A rename that fails, or whose transaction fails later on, leaves the tag
+// and every record as they were.
+func TestRenameTagIsAtomic(t *testing.T) {
+	fail := errors.New("fail")
+	tests := []struct {
+		name    string
+		rename  func(tx Tx, id int) error
+		wantErr error
+	}{
+		{"name taken", func(tx Tx, id int) error {
+			_, err := RenameTag(tx, id, 0, "work")
+			return err
+		}, ErrTagExists},
+		{"stale version", func(tx Tx, id int) error {
+			_, err := RenameTag(tx, id, 99, "chores")
+			return err
+		}, ErrVersionConflict},
+		{"later failure", func(tx Tx, id int) error {
+			if _, err := RenameTag(tx, id, 0, "chores"); err != nil {
+				return err
+			}
+			return fail
+		}, fail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTagFixture(t)
+			home, err := f.tags.GetByName(1, "home")
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := f.tagsOf(t)
+
+			err = f.unitOfWork.Do(func(tx Tx) error { return tt.rename(tx, home.ID) })
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tag, err := f.tags.GetByID(home.ID); err != nil || tag.Name != "home" {
+				t.Errorf("tag after the failed rename = %+v, %v; want home", tag, err)
+			}
+			if _, err := f.tags.GetByName(1, "chores"); !errors.Is(err, ErrTagNotFound) {
+				t.Errorf("chores after the failed rename: error = %v, want ErrTagNotFound", err)
+			}
+			if got := f.tagsOf(t); !reflect.DeepEqual(got, before) {
+				t.Errorf("tags = %v, want them unchanged at %v", got, before)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package entity
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Comments form one thread per todo and one per item: a comment without
+// ParentID starts a discussion and replies nest below the comment they
+// answer, which must be a live comment in the same thread. A deleted comment
+// loses its body and only stays in the thread while it has replies.
+
+const maxCommentLength = 10000
+
+// NormalizeCommentBody trims a comment body. Bodies can't be empty or longer
+// than 10000 characters.
+func NormalizeCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > maxCommentLength {
+		return "", ErrInvalidComment
+	}
+	return body, nil
+}
+
+// CheckCommentParent reports whether comment may answer its ParentID. It
+// is meant to run in the transaction that creates the comment.
+func CheckCommentParent(comments CommentStore, comment *Comment) error {
+	if comment.ParentID == nil {
+		return nil
+	}
+	parent, err := comments.GetByID(*comment.ParentID)
+	if err != nil || parent.TodoID != comment.TodoID || !sameParent(parent.ItemID, comment.ItemID) {
+		return ErrInvalidCommentParent
+	}
+	return nil
+}
+
+// CommentNode is a comment with the replies to it, oldest first.
+type CommentNode struct {
+	*Comment
+	Replies []*CommentNode `json:"replies"`
+}
+
+// BuildCommentThread arranges the comments of one todo into the thread of
+// the todo, or with itemID set the thread of that item. Comments whose
+// parent is not in the list end up at the top level.
+func BuildCommentThread(comments []*Comment, itemID *int) []*CommentNode {
+	nodes := make(map[int]*CommentNode)
+	var thread []*Comment
+	for _, comment := range comments {
+		if sameParent(comment.ItemID, itemID) {
+			thread = append(thread, comment)
+			nodes[comment.ID] = &CommentNode{Comment: comment, Replies: make([]*CommentNode, 0)}
+		}
+	}
+
+	roots := make([]*CommentNode, 0)
+	for _, comment := range thread {
+		node := nodes[comment.ID]
+		if comment.ParentID != nil {
+			if parent, ok := nodes[*comment.ParentID]; ok {
+				parent.Replies = append(parent.Replies, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return pruneDeleted(roots)
+}
+
+// pruneDeleted drops deleted comments that have no replies left.
+func pruneDeleted(nodes []*CommentNode) []*CommentNode {
+	kept := nodes[:0]
+	for _, node := range nodes {
+		node.Replies = pruneDeleted(node.Replies)
+		if node.DeletedAt == nil || len(node.Replies) > 0 {
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
@@ -1,8 +1,6 @@
 package entity
 
 import (
-	"errors"
-	"fmt"
 	"sync"
 	"time"
 )
@@ -12,174 +10,515 @@ type Todo struct {
 	Title         string     `json:"title"`
 	Description   string     `json:"description"`
 	UserID        int        `json:"user_id"`
+	WorkspaceID   int        `json:"workspace_id"`
 	CompletionPct float64    `json:"completion_pct"`
+	Priority      Priority   `json:"priority"`
+	Tags          []string   `json:"tags"`
+	Version       int        `json:"version"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+
+	// CommentCount is the number of live comments on the todo itself. It is
+	// filled in for API responses and not stored.
+	CommentCount int `json:"comment_count"`
+
+	// Start and due dates, see schedule.go.
+	Schedule
+
+	// Repetition, see recurrence.go.
+	Recurrence
 }
 
 type TodoModel struct {
 	sync.RWMutex
 	todos  map[int]*Todo
 	nextID int
+
+	// byUser indexes todo IDs (deleted ones included) by owner. ownerOf
+	// remembers the owner each todo is indexed under so Update can move it.
+	// byWorkspace indexes them by workspace, which never changes.
+	byUser      map[int]map[int]struct{}
+	ownerOf     map[int]int
+	byWorkspace map[int]map[int]struct{}
+
+	// items are deleted along with their todo.
+	items *TodoItemModel
 }
 
-func NewTodoModel() *TodoModel {
+func NewTodoModel(items *TodoItemModel) *TodoModel {
 	return &TodoModel{
-		todos:  make(map[int]*Todo),
-		nextID: 1,
+		items:       items,
+		todos:       make(map[int]*Todo),
+		nextID:      1,
+		byUser:      make(map[int]map[int]struct{}),
+		ownerOf:     make(map[int]int),
+		byWorkspace: make(map[int]map[int]struct{}),
 	}
 }
 
+// The exported methods take the model lock and delegate to the lower-case
+// variants below, which expect the caller to hold it. Transactions (see
+// unit_of_work.go) hold the lock for their whole duration and call the
+// lower-case variants directly.
+//
+// The model never shares its records: todos passed in are copied before they
+// are stored, and every getter returns a copy. Changes only take effect
+// through Update and the other write methods.
+//
+// Version starts at 1 and goes up with every Update and Delete. An Update
+// with a non-zero Version only succeeds if it matches the stored one. The
+// completion percentage is derived from the items, so recomputing it leaves
+// the version alone.
+
 func (m *TodoModel) Create(todo *Todo) error {
 	m.Lock()
 	defer m.Unlock()
+	return m.create(todo)
+}
+
+func (m *TodoModel) GetByID(id int) (*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByID(id)
+}
+
+func (m *TodoModel) GetByIDWithDeleted(id int) (*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByIDWithDeleted(id)
+}
+
+func (m *TodoModel) GetByIDsWithDeleted(ids []int) ([]*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByIDsWithDeleted(ids), nil
+}
+
+func (m *TodoModel) GetByIDInWorkspace(id, workspaceID int) (*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByIDInWorkspace(id, workspaceID)
+}
+
+func (m *TodoModel) GetByIDInWorkspaceWithDeleted(id, workspaceID int) (*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByIDInWorkspaceWithDeleted(id, workspaceID)
+}
+
+func (m *TodoModel) GetByWorkspaceID(workspaceID int) ([]*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByWorkspaceID(workspaceID), nil
+}
+
+func (m *TodoModel) GetByWorkspaceIDWithDeleted(workspaceID int) ([]*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByWorkspaceIDWithDeleted(workspaceID), nil
+}
+
+func (m *TodoModel) GetByUserID(workspaceID, userID int) ([]*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByUserID(workspaceID, userID), nil
+}
 
+func (m *TodoModel) GetByUserIDWithDeleted(workspaceID, userID int) ([]*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByUserIDWithDeleted(workspaceID, userID), nil
+}
+
+// GetDeletedBefore returns the todos that were soft-deleted before the given
+// time.
+func (m *TodoModel) GetDeletedBefore(before time.Time) ([]*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getDeletedBefore(before), nil
+}
+
+// GetRecurring returns the live todos that head a recurring series.
+func (m *TodoModel) GetRecurring() ([]*Todo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getRecurring(), nil
+}
+
+func (m *TodoModel) Update(todo *Todo) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.update(todo)
+}
+
+// Delete soft-deletes a todo together with its items (see lifecycle.go).
+func (m *TodoModel) Delete(id int) error {
+	// The same lock order as MemoryUnitOfWork.
+	m.items.Lock()
+	defer m.items.Unlock()
+	m.Lock()
+	defer m.Unlock()
+
+	u := &MemoryUnitOfWork{todos: m, items: m.items}
+	_, err := u.run(func(tx *memoryTx) error {
+		return tx.deleteTodo(id)
+	})
+	return err
+}
+
+// Restore undoes a soft delete. It returns ErrTodoNotFound if the todo
+// doesn't exist or isn't deleted.
+func (m *TodoModel) Restore(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.undelete(id)
+}
+
+// Purge removes a todo for good, whether or not it was soft-deleted. It does
+// not touch the todo's items.
+func (m *TodoModel) Purge(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.purge(id)
+}
+
+func (m *TodoModel) UpdateCompletionPct(todoID int, todoItemStore TodoItemStore) error {
+	// Read the items before taking our own lock so that the todo and item
+	// locks are never held at the same time outside a transaction.
+	items, err := todoItemStore.GetByTodoID(todoID)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	return m.setCompletionPct(todoID, items)
+}
+
+// SetTags replaces the tags of a todo, whether or not it is deleted.
+func (m *TodoModel) SetTags(id int, tags []string) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.setTags(id, tags)
+}
+
+func (m *TodoModel) create(todo *Todo) error {
 	todo.ID = m.nextID
 	todo.CreatedAt = time.Now()
 	todo.UpdatedAt = time.Now()
 	todo.DeletedAt = nil
+	todo.Version = 1
 
-	m.todos[todo.ID] = todo
+	m.todos[todo.ID] = cloneTodo(todo)
+	m.index(todo)
 	m.nextID++
 
 	return nil
 }
 
-func (m *TodoModel) GetByID(id int) (*Todo, error) {
-	m.RLock()
-	defer m.RUnlock()
-
+func (m *TodoModel) getByID(id int) (*Todo, error) {
 	todo, exists := m.todos[id]
 	if !exists || todo.DeletedAt != nil {
-		return nil, errors.New("todo not found")
+		return nil, ErrTodoNotFound
 	}
 
-	return todo, nil
+	return cloneTodo(todo), nil
 }
 
-func (m *TodoModel) GetByIDWithDeleted(id int) (*Todo, error) {
-	m.RLock()
-	defer m.RUnlock()
-
+func (m *TodoModel) getByIDWithDeleted(id int) (*Todo, error) {
 	todo, exists := m.todos[id]
 	if !exists {
-		return nil, errors.New("todo not found")
+		return nil, ErrTodoNotFound
 	}
 
-	return todo, nil
+	return cloneTodo(todo), nil
 }
 
-func (m *TodoModel) GetAll() []*Todo {
-	m.RLock()
-	defer m.RUnlock()
+func (m *TodoModel) getByIDsWithDeleted(ids []int) []*Todo {
+	todos := make([]*Todo, 0, len(ids))
+	for _, id := range ids {
+		if todo, exists := m.todos[id]; exists {
+			todos = append(todos, cloneTodo(todo))
+		}
+	}
+	return todos
+}
+
+func (m *TodoModel) getByIDInWorkspace(id, workspaceID int) (*Todo, error) {
+	todo, exists := m.todos[id]
+	if !exists || todo.DeletedAt != nil || todo.WorkspaceID != workspaceID {
+		return nil, ErrTodoNotFound
+	}
+
+	return cloneTodo(todo), nil
+}
 
+func (m *TodoModel) getByIDInWorkspaceWithDeleted(id, workspaceID int) (*Todo, error) {
+	todo, exists := m.todos[id]
+	if !exists || todo.WorkspaceID != workspaceID {
+		return nil, ErrTodoNotFound
+	}
+
+	return cloneTodo(todo), nil
+}
+
+func (m *TodoModel) getByWorkspaceID(workspaceID int) []*Todo {
 	var activeTodos []*Todo
-	for _, todo := range m.todos {
-		if todo.DeletedAt == nil {
-			activeTodos = append(activeTodos, todo)
+	for id := range m.byWorkspace[workspaceID] {
+		if todo := m.todos[id]; todo.DeletedAt == nil {
+			activeTodos = append(activeTodos, cloneTodo(todo))
 		}
 	}
 
 	return activeTodos
 }
 
-func (m *TodoModel) GetAllWithDeleted() []*Todo {
-	m.RLock()
-	defer m.RUnlock()
-
+func (m *TodoModel) getByWorkspaceIDWithDeleted(workspaceID int) []*Todo {
 	var allTodos []*Todo
-	fmt.Printf("Total todos in map: %d\n", len(m.todos))
-	for id, todo := range m.todos {
-		fmt.Printf("Todo ID: %d, Title: %s, DeletedAt: %v\n", id, todo.Title, todo.DeletedAt)
-		allTodos = append(allTodos, todo)
+	for id := range m.byWorkspace[workspaceID] {
+		allTodos = append(allTodos, cloneTodo(m.todos[id]))
 	}
-	fmt.Printf("Returning %d todos\n", len(allTodos))
+
 	return allTodos
 }
 
-func (m *TodoModel) GetByUserID(userID int) []*Todo {
-	m.RLock()
-	defer m.RUnlock()
-
+func (m *TodoModel) getByUserID(workspaceID, userID int) []*Todo {
 	var userTodos []*Todo
-	for _, todo := range m.todos {
-		if todo.UserID == userID && todo.DeletedAt == nil {
-			userTodos = append(userTodos, todo)
+	for id := range m.byUser[userID] {
+		if todo := m.todos[id]; todo.DeletedAt == nil && todo.WorkspaceID == workspaceID {
+			userTodos = append(userTodos, cloneTodo(todo))
 		}
 	}
 
 	return userTodos
 }
 
-func (m *TodoModel) GetByUserIDWithDeleted(userID int) []*Todo {
-	m.RLock()
-	defer m.RUnlock()
-
+func (m *TodoModel) getByUserIDWithDeleted(workspaceID, userID int) []*Todo {
 	var userTodos []*Todo
-	for _, todo := range m.todos {
-		if todo.UserID == userID {
-			userTodos = append(userTodos, todo)
+	for id := range m.byUser[userID] {
+		if todo := m.todos[id]; todo.WorkspaceID == workspaceID {
+			userTodos = append(userTodos, cloneTodo(todo))
 		}
 	}
 
 	return userTodos
 }
 
-func (m *TodoModel) Update(todo *Todo) error {
-	m.Lock()
-	defer m.Unlock()
+func (m *TodoModel) getDeletedBefore(before time.Time) []*Todo {
+	var todos []*Todo
+	for _, todo := range m.todos {
+		if todo.DeletedAt != nil && todo.DeletedAt.Before(before) {
+			todos = append(todos, cloneTodo(todo))
+		}
+	}
+
+	return todos
+}
 
+func (m *TodoModel) getRecurring() []*Todo {
+	var todos []*Todo
+	for _, todo := range m.todos {
+		if todo.DeletedAt == nil && todo.RRule != "" {
+			todos = append(todos, cloneTodo(todo))
+		}
+	}
+
+	return todos
+}
+
+func (m *TodoModel) update(todo *Todo) error {
 	existing, exists := m.todos[todo.ID]
 	if !exists || existing.DeletedAt != nil {
-		return errors.New("todo not found")
+		return ErrTodoNotFound
+	}
+	if todo.Version != 0 && todo.Version != existing.Version {
+		return ErrVersionConflict
 	}
 
+	// Creation and deletion times are owned by the model, a todo stays in its
+	// workspace, and its completion only follows its items (see
+	// UpdateCompletionPct).
+	todo.WorkspaceID = existing.WorkspaceID
+	todo.CompletionPct = existing.CompletionPct
+	todo.CreatedAt = existing.CreatedAt
+	todo.DeletedAt = nil
 	todo.UpdatedAt = time.Now()
-	m.todos[todo.ID] = todo
+	todo.Version = existing.Version + 1
+	m.todos[todo.ID] = cloneTodo(todo)
+	m.index(todo)
 
 	return nil
 }
 
-func (m *TodoModel) Delete(id int) error {
-	m.Lock()
-	defer m.Unlock()
-
+func (m *TodoModel) delete(id int, at time.Time) error {
 	todo, exists := m.todos[id]
 	if !exists || todo.DeletedAt != nil {
-		return errors.New("todo not found")
+		return ErrTodoNotFound
 	}
 
-	now := time.Now()
-	todo.DeletedAt = &now
+	todo.DeletedAt = &at
+	todo.Version++
 	return nil
 }
 
-func (m *TodoModel) UpdateCompletionPct(todoID int, todoItemModel *TodoItemModel) error {
-	m.Lock()
-	defer m.Unlock()
+func (m *TodoModel) undelete(id int) error {
+	todo, exists := m.todos[id]
+	if !exists || todo.DeletedAt == nil {
+		return ErrTodoNotFound
+	}
+
+	todo.DeletedAt = nil
+	todo.UpdatedAt = time.Now()
+	todo.Version++
+	return nil
+}
+
+func (m *TodoModel) purge(id int) error {
+	if _, exists := m.todos[id]; !exists {
+		return ErrTodoNotFound
+	}
+
+	m.remove(id)
+	return nil
+}
 
+func (m *TodoModel) setCompletionPct(todoID int, items []*TodoItem) error {
 	todo, exists := m.todos[todoID]
 	if !exists {
-		return errors.New("todo not found")
+		return ErrTodoNotFound
 	}
 
-	// Get all items for this todo
-	items := todoItemModel.GetByTodoID(todoID)
-	if len(items) == 0 {
+	// Items with sub-items are done when their leaves are, so only the
+	// leaves count.
+	leaves := Leaves(items)
+	if len(leaves) == 0 {
 		todo.CompletionPct = 0
 		return nil
 	}
 
 	completedCount := 0
-	for _, item := range items {
+	for _, item := range leaves {
 		if item.Completed {
 			completedCount++
 		}
 	}
 
-	todo.CompletionPct = float64(completedCount) / float64(len(items)) * 100
+	todo.CompletionPct = float64(completedCount) / float64(len(leaves)) * 100
 	todo.UpdatedAt = time.Now()
 
 	return nil
 }
+
+func (m *TodoModel) setTags(id int, tags []string) error {
+	todo, exists := m.todos[id]
+	if !exists {
+		return ErrTodoNotFound
+	}
+
+	todo.Tags = append([]string(nil), tags...)
+	todo.UpdatedAt = time.Now()
+	todo.Version++
+	return nil
+}
+
+// index files todo under its workspace and current owner, moving it away
+// from the owner it was indexed under before if that changed.
+func (m *TodoModel) index(todo *Todo) {
+	if m.byWorkspace[todo.WorkspaceID] == nil {
+		m.byWorkspace[todo.WorkspaceID] = make(map[int]struct{})
+	}
+	m.byWorkspace[todo.WorkspaceID][todo.ID] = struct{}{}
+
+	if owner, ok := m.ownerOf[todo.ID]; ok {
+		if owner == todo.UserID {
+			return
+		}
+		delete(m.byUser[owner], todo.ID)
+		if len(m.byUser[owner]) == 0 {
+			delete(m.byUser, owner)
+		}
+	}
+
+	if m.byUser[todo.UserID] == nil {
+		m.byUser[todo.UserID] = make(map[int]struct{})
+	}
+	m.byUser[todo.UserID][todo.ID] = struct{}{}
+	m.ownerOf[todo.ID] = todo.UserID
+}
+
+// remove drops a todo from the map and the indexes.
+func (m *TodoModel) remove(id int) {
+	if owner, ok := m.ownerOf[id]; ok {
+		delete(m.byUser[owner], id)
+		if len(m.byUser[owner]) == 0 {
+			delete(m.byUser, owner)
+		}
+		delete(m.ownerOf, id)
+	}
+	if todo, ok := m.todos[id]; ok {
+		delete(m.byWorkspace[todo.WorkspaceID], id)
+		if len(m.byWorkspace[todo.WorkspaceID]) == 0 {
+			delete(m.byWorkspace, todo.WorkspaceID)
+		}
+	}
+	delete(m.todos, id)
+}
+
+// restore puts a todo back into the map with its original ID. It is used
+// when rebuilding the model from a journal.
+func (m *TodoModel) restore(todo *Todo) {
+	m.Lock()
+	defer m.Unlock()
+
+	// Journals written before versioning was added carry no version.
+	if todo.Version == 0 {
+		todo.Version = 1
+	}
+	// Nor, before workspaces were added, a workspace.
+	if todo.WorkspaceID == 0 {
+		todo.WorkspaceID = DefaultWorkspaceID
+	}
+	m.todos[todo.ID] = todo
+	m.index(todo)
+	if todo.ID >= m.nextID {
+		m.nextID = todo.ID + 1
+	}
+}
+
+func (m *TodoModel) snapshot() []*Todo {
+	m.RLock()
+	defer m.RUnlock()
+
+	todos := make([]*Todo, 0, len(m.todos))
+	for _, todo := range m.todos {
+		todos = append(todos, cloneTodo(todo))
+	}
+	return todos
+}
+
+func (m *TodoModel) nextIDs() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.nextID
+}
+
+func (m *TodoModel) reserveIDs(next int) {
+	m.Lock()
+	defer m.Unlock()
+	if next > m.nextID {
+		m.nextID = next
+	}
+}
+
+func cloneTodo(todo *Todo) *Todo {
+	c := *todo
+	c.Tags = append([]string(nil), todo.Tags...)
+	c.Schedule = todo.Schedule.clone()
+	c.Recurrence = todo.Recurrence.clone()
+	if todo.DeletedAt != nil {
+		deletedAt := *todo.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
@@ -1,154 +1,392 @@
 package entity
 
 import (
-	"errors"
 	"sync"
 	"time"
 )
 
 type TodoItem struct {
-	ID          int        `json:"id"`
-	Title       string     `json:"title"`
-	Description string     `json:"description"`
-	Completed   bool       `json:"completed"`
-	TodoID      int        `json:"todo_id"`
-	UserID      int        `json:"user_id"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
-	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	ID          int      `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Completed   bool     `json:"completed"`
+	Priority    Priority `json:"priority"`
+	Tags        []string `json:"tags"`
+	Position    string   `json:"position"`
+	TodoID      int      `json:"todo_id"`
+	// ParentItemID is the item this one is nested under, or nil for an item
+	// at the top level of its todo. See item_tree.go.
+	ParentItemID *int       `json:"parent_item_id"`
+	UserID       int        `json:"user_id"`
+	Version      int        `json:"version"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+
+	// Start and due dates, see schedule.go.
+	Schedule
+
+	// Repetition, see recurrence.go.
+	Recurrence
 }
 
 type TodoItemModel struct {
 	sync.RWMutex
 	items  map[int]*TodoItem
 	nextID int
+
+	// byTodo indexes item IDs (deleted ones included) by parent todo, and
+	// parentOf remembers the todo each item is indexed under.
+	byTodo   map[int]map[int]struct{}
+	parentOf map[int]int
 }
 
 func NewTodoItemModel() *TodoItemModel {
 	return &TodoItemModel{
-		items:  make(map[int]*TodoItem),
-		nextID: 1,
+		items:    make(map[int]*TodoItem),
+		nextID:   1,
+		byTodo:   make(map[int]map[int]struct{}),
+		parentOf: make(map[int]int),
 	}
 }
 
+// As in TodoModel, the exported methods lock and delegate to lower-case
+// variants that transactions call while already holding the lock, items are
+// copied on the way in and out, and versions follow the same rules.
+
 func (m *TodoItemModel) Create(item *TodoItem) error {
 	m.Lock()
 	defer m.Unlock()
+	return m.create(item)
+}
+
+func (m *TodoItemModel) GetByID(id int) (*TodoItem, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByID(id)
+}
+
+func (m *TodoItemModel) GetByIDWithDeleted(id int) (*TodoItem, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByIDWithDeleted(id)
+}
+
+func (m *TodoItemModel) GetByIDsWithDeleted(ids []int) ([]*TodoItem, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByIDsWithDeleted(ids), nil
+}
+
+func (m *TodoItemModel) GetByTodoID(todoID int) ([]*TodoItem, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByTodoID(todoID), nil
+}
+
+func (m *TodoItemModel) GetByTodoIDWithDeleted(todoID int) ([]*TodoItem, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByTodoIDWithDeleted(todoID), nil
+}
+
+func (m *TodoItemModel) GetDeletedBefore(before time.Time) ([]*TodoItem, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getDeletedBefore(before), nil
+}
+
+// GetRecurring returns the live items that head a recurring series.
+func (m *TodoItemModel) GetRecurring() ([]*TodoItem, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getRecurring(), nil
+}
+
+func (m *TodoItemModel) Update(item *TodoItem) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.update(item)
+}
 
+func (m *TodoItemModel) Delete(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.delete(id, time.Now())
+}
+
+// Restore undoes a soft delete. It returns ErrTodoItemNotFound if the item
+// doesn't exist or isn't deleted.
+func (m *TodoItemModel) Restore(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.undelete(id)
+}
+
+// Purge removes an item for good, whether or not it was soft-deleted.
+func (m *TodoItemModel) Purge(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.purge(id)
+}
+
+// SetTags replaces the tags of an item, whether or not it is deleted.
+func (m *TodoItemModel) SetTags(id int, tags []string) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.setTags(id, tags)
+}
+
+// create appends the item to the end of its todo unless it already has a
+// position.
+func (m *TodoItemModel) create(item *TodoItem) error {
+	if item.Position == "" {
+		item.Position = PositionAfter(m.lastPosition(item.TodoID, item.ParentItemID))
+	}
 	item.ID = m.nextID
 	item.CreatedAt = time.Now()
 	item.UpdatedAt = time.Now()
 	item.DeletedAt = nil
+	item.Version = 1
 
-	m.items[item.ID] = item
+	m.items[item.ID] = cloneTodoItem(item)
+	m.index(item)
 	m.nextID++
 
 	return nil
 }
 
-func (m *TodoItemModel) GetByID(id int) (*TodoItem, error) {
-	m.RLock()
-	defer m.RUnlock()
-
+func (m *TodoItemModel) getByID(id int) (*TodoItem, error) {
 	item, exists := m.items[id]
 	if !exists || item.DeletedAt != nil {
-		return nil, errors.New("item not found")
+		return nil, ErrTodoItemNotFound
 	}
 
-	return item, nil
+	return cloneTodoItem(item), nil
 }
 
-func (m *TodoItemModel) GetByIDWithDeleted(id int) (*TodoItem, error) {
-	m.RLock()
-	defer m.RUnlock()
-
+func (m *TodoItemModel) getByIDWithDeleted(id int) (*TodoItem, error) {
 	item, exists := m.items[id]
 	if !exists {
-		return nil, errors.New("item not found")
+		return nil, ErrTodoItemNotFound
 	}
 
-	return item, nil
+	return cloneTodoItem(item), nil
 }
 
-func (m *TodoItemModel) GetByTodoID(todoID int) []*TodoItem {
-	m.RLock()
-	defer m.RUnlock()
+func (m *TodoItemModel) getByIDsWithDeleted(ids []int) []*TodoItem {
+	items := make([]*TodoItem, 0, len(ids))
+	for _, id := range ids {
+		if item, exists := m.items[id]; exists {
+			items = append(items, cloneTodoItem(item))
+		}
+	}
+	return items
+}
 
+func (m *TodoItemModel) getByTodoID(todoID int) []*TodoItem {
 	var todoItems []*TodoItem
-	for _, item := range m.items {
-		if item.TodoID == todoID && item.DeletedAt == nil {
-			todoItems = append(todoItems, item)
+	for id := range m.byTodo[todoID] {
+		if item := m.items[id]; item.DeletedAt == nil {
+			todoItems = append(todoItems, cloneTodoItem(item))
 		}
 	}
 
 	return todoItems
 }
 
-func (m *TodoItemModel) GetByTodoIDWithDeleted(todoID int) []*TodoItem {
-	m.RLock()
-	defer m.RUnlock()
-
+func (m *TodoItemModel) getByTodoIDWithDeleted(todoID int) []*TodoItem {
 	var todoItems []*TodoItem
-	for _, item := range m.items {
-		if item.TodoID == todoID {
-			todoItems = append(todoItems, item)
-		}
+	for id := range m.byTodo[todoID] {
+		todoItems = append(todoItems, cloneTodoItem(m.items[id]))
 	}
 
 	return todoItems
 }
 
-func (m *TodoItemModel) GetAll() []*TodoItem {
-	m.RLock()
-	defer m.RUnlock()
-
-	var activeItems []*TodoItem
+func (m *TodoItemModel) getDeletedBefore(before time.Time) []*TodoItem {
+	var items []*TodoItem
 	for _, item := range m.items {
-		if item.DeletedAt == nil {
-			activeItems = append(activeItems, item)
+		if item.DeletedAt != nil && item.DeletedAt.Before(before) {
+			items = append(items, cloneTodoItem(item))
 		}
 	}
 
-	return activeItems
+	return items
 }
 
-func (m *TodoItemModel) GetAllWithDeleted() []*TodoItem {
-	m.RLock()
-	defer m.RUnlock()
-
-	var allItems []*TodoItem
+func (m *TodoItemModel) getRecurring() []*TodoItem {
+	var items []*TodoItem
 	for _, item := range m.items {
-		allItems = append(allItems, item)
+		if item.DeletedAt == nil && item.RRule != "" {
+			items = append(items, cloneTodoItem(item))
+		}
 	}
 
-	return allItems
+	return items
 }
 
-func (m *TodoItemModel) Update(item *TodoItem) error {
-	m.Lock()
-	defer m.Unlock()
-
+func (m *TodoItemModel) update(item *TodoItem) error {
 	existing, exists := m.items[item.ID]
 	if !exists || existing.DeletedAt != nil {
-		return errors.New("item not found")
+		return ErrTodoItemNotFound
+	}
+	if item.Version != 0 && item.Version != existing.Version {
+		return ErrVersionConflict
 	}
 
+	item.CreatedAt = existing.CreatedAt
+	item.DeletedAt = nil
 	item.UpdatedAt = time.Now()
-	m.items[item.ID] = item
+	item.Version = existing.Version + 1
+	m.items[item.ID] = cloneTodoItem(item)
+	m.index(item)
 
 	return nil
 }
 
-func (m *TodoItemModel) Delete(id int) error {
-	m.Lock()
-	defer m.Unlock()
-
+func (m *TodoItemModel) delete(id int, at time.Time) error {
 	item, exists := m.items[id]
 	if !exists || item.DeletedAt != nil {
-		return errors.New("item not found")
+		return ErrTodoItemNotFound
+	}
+
+	item.DeletedAt = &at
+	item.Version++
+	return nil
+}
+
+func (m *TodoItemModel) undelete(id int) error {
+	item, exists := m.items[id]
+	if !exists || item.DeletedAt == nil {
+		return ErrTodoItemNotFound
+	}
+
+	item.DeletedAt = nil
+	item.UpdatedAt = time.Now()
+	item.Version++
+	return nil
+}
+
+func (m *TodoItemModel) purge(id int) error {
+	if _, exists := m.items[id]; !exists {
+		return ErrTodoItemNotFound
+	}
+
+	m.remove(id)
+	return nil
+}
+
+func (m *TodoItemModel) setTags(id int, tags []string) error {
+	item, exists := m.items[id]
+	if !exists {
+		return ErrTodoItemNotFound
 	}
 
-	now := time.Now()
-	item.DeletedAt = &now
+	item.Tags = append([]string(nil), tags...)
+	item.UpdatedAt = time.Now()
+	item.Version++
 	return nil
 }
+
+// lastPosition returns the highest position among the items under parent,
+// deleted ones included so that a restored item doesn't share its rank.
+func (m *TodoItemModel) lastPosition(todoID int, parent *int) string {
+	last := ""
+	for id := range m.byTodo[todoID] {
+		item := m.items[id]
+		if sameParent(item.ParentItemID, parent) && item.Position > last {
+			last = item.Position
+		}
+	}
+	return last
+}
+
+// index files item under its current todo, moving it away from the todo it
+// was indexed under before if that changed.
+func (m *TodoItemModel) index(item *TodoItem) {
+	if parent, ok := m.parentOf[item.ID]; ok {
+		if parent == item.TodoID {
+			return
+		}
+		delete(m.byTodo[parent], item.ID)
+		if len(m.byTodo[parent]) == 0 {
+			delete(m.byTodo, parent)
+		}
+	}
+
+	if m.byTodo[item.TodoID] == nil {
+		m.byTodo[item.TodoID] = make(map[int]struct{})
+	}
+	m.byTodo[item.TodoID][item.ID] = struct{}{}
+	m.parentOf[item.ID] = item.TodoID
+}
+
+// remove drops an item from the map and the indexes.
+func (m *TodoItemModel) remove(id int) {
+	if parent, ok := m.parentOf[id]; ok {
+		delete(m.byTodo[parent], id)
+		if len(m.byTodo[parent]) == 0 {
+			delete(m.byTodo, parent)
+		}
+		delete(m.parentOf, id)
+	}
+	delete(m.items, id)
+}
+
+// restore puts an item back into the map with its original ID. It is used
+// when rebuilding the model from a journal.
+func (m *TodoItemModel) restore(item *TodoItem) {
+	m.Lock()
+	defer m.Unlock()
+
+	if item.Version == 0 {
+		item.Version = 1
+	}
+	m.items[item.ID] = item
+	m.index(item)
+	if item.ID >= m.nextID {
+		m.nextID = item.ID + 1
+	}
+}
+
+func (m *TodoItemModel) snapshot() []*TodoItem {
+	m.RLock()
+	defer m.RUnlock()
+
+	items := make([]*TodoItem, 0, len(m.items))
+	for _, item := range m.items {
+		items = append(items, cloneTodoItem(item))
+	}
+	return items
+}
+
+func (m *TodoItemModel) nextIDs() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.nextID
+}
+
+func (m *TodoItemModel) reserveIDs(next int) {
+	m.Lock()
+	defer m.Unlock()
+	if next > m.nextID {
+		m.nextID = next
+	}
+}
+
+func cloneTodoItem(item *TodoItem) *TodoItem {
+	c := *item
+	c.Tags = append([]string(nil), item.Tags...)
+	c.Schedule = item.Schedule.clone()
+	c.Recurrence = item.Recurrence.clone()
+	if item.ParentItemID != nil {
+		parent := *item.ParentItemID
+		c.ParentItemID = &parent
+	}
+	if item.DeletedAt != nil {
+		deletedAt := *item.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
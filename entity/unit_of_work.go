@@ -0,0 +1,746 @@
+package entity
+
+import "time"
+
+// Tx exposes the stores inside a transaction. Everything done through it is
+// committed together when the function passed to UnitOfWork.Do returns nil,
+// and rolled back when it returns an error.
+type Tx interface {
+	Users() UserStore
+	Todos() TodoStore
+	Items() TodoItemStore
+	Tags() TagStore
+	Comments() CommentStore
+	Attachments() AttachmentStore
+	Collaborators() CollaboratorStore
+	Workspaces() WorkspaceStore
+}
+
+// UnitOfWork runs a group of changes atomically, e.g. an item mutation
+// together with the recomputed completion percentage of its todo.
+type UnitOfWork interface {
+	Do(fn func(tx Tx) error) error
+}
+
+// MemoryUnitOfWork is the UnitOfWork for the in-memory models. A transaction
+// holds the write locks of all models for its whole duration and keeps an
+// undo log that is replayed if the transaction fails.
+//
+// Taking every lock is deliberate. fn doesn't say up front which models it
+// will touch, and locking them as it goes would need a lock order that
+// follows the code rather than a fixed one. Transactions are short, but with
+// the journal they include an fsync, during which every reader waits;
+// BenchmarkUnitOfWork measures that. The models' own Delete methods, which
+// know what they touch, lock only those models.
+type MemoryUnitOfWork struct {
+	users         *UserModel
+	todos         *TodoModel
+	items         *TodoItemModel
+	tags          *TagModel
+	comments      *CommentModel
+	attachments   *AttachmentModel
+	collaborators *CollaboratorModel
+	workspaces    *WorkspaceModel
+}
+
+var _ UnitOfWork = (*MemoryUnitOfWork)(nil)
+
+// NewUnitOfWork takes the models the others were built on: todos over items,
+// and users over todos, tags, collaborators and workspaces.
+func NewUnitOfWork(users *UserModel, todos *TodoModel, items *TodoItemModel, tags *TagModel, comments *CommentModel, attachments *AttachmentModel, collaborators *CollaboratorModel, workspaces *WorkspaceModel) *MemoryUnitOfWork {
+	return &MemoryUnitOfWork{
+		users:         users,
+		todos:         todos,
+		items:         items,
+		tags:          tags,
+		comments:      comments,
+		attachments:   attachments,
+		collaborators: collaborators,
+		workspaces:    workspaces,
+	}
+}
+
+func (u *MemoryUnitOfWork) Do(fn func(tx Tx) error) error {
+	_, err := u.do(func(tx *memoryTx) error {
+		return fn(tx)
+	})
+	return err
+}
+
+// do runs fn like Do and also returns the transaction, so the journal can
+// see which records it touched.
+func (u *MemoryUnitOfWork) do(fn func(tx *memoryTx) error) (*memoryTx, error) {
+	// Always lock users, then items, then todos, then tags, then comments,
+	// then attachments, then collaborators, then workspaces so concurrent
+	// transactions can't deadlock each other.
+	u.users.mu.Lock()
+	defer u.users.mu.Unlock()
+	u.items.Lock()
+	defer u.items.Unlock()
+	u.todos.Lock()
+	defer u.todos.Unlock()
+	u.tags.Lock()
+	defer u.tags.Unlock()
+	u.comments.Lock()
+	defer u.comments.Unlock()
+	u.attachments.Lock()
+	defer u.attachments.Unlock()
+	u.collaborators.Lock()
+	defer u.collaborators.Unlock()
+	u.workspaces.Lock()
+	defer u.workspaces.Unlock()
+
+	return u.run(fn)
+}
+
+// run runs fn in a transaction over the models of u, whose write locks the
+// caller holds. The models u leaves out must not be touched.
+func (u *MemoryUnitOfWork) run(fn func(tx *memoryTx) error) (*memoryTx, error) {
+	tx := &memoryTx{
+		users:           u.users,
+		todos:           u.todos,
+		items:           u.items,
+		tags:            u.tags,
+		comments:        u.comments,
+		attachments:     u.attachments,
+		collaborators:   u.collaborators,
+		workspaces:      u.workspaces,
+		userIDs:         make(map[int]bool),
+		todoIDs:         make(map[int]bool),
+		itemIDs:         make(map[int]bool),
+		tagIDs:          make(map[int]bool),
+		commentIDs:      make(map[int]bool),
+		attachmentIDs:   make(map[int]bool),
+		collaboratorIDs: make(map[int]bool),
+		workspaceIDs:    make(map[int]bool),
+		memberIDs:       make(map[int]bool),
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.rollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+type memoryTx struct {
+	users         *UserModel
+	todos         *TodoModel
+	items         *TodoItemModel
+	tags          *TagModel
+	comments      *CommentModel
+	attachments   *AttachmentModel
+	collaborators *CollaboratorModel
+	workspaces    *WorkspaceModel
+	undo          []func()
+
+	// The IDs of every record the transaction wrote to.
+	userIDs         map[int]bool
+	todoIDs         map[int]bool
+	itemIDs         map[int]bool
+	tagIDs          map[int]bool
+	commentIDs      map[int]bool
+	attachmentIDs   map[int]bool
+	collaboratorIDs map[int]bool
+	workspaceIDs    map[int]bool
+	memberIDs       map[int]bool
+}
+
+func (tx *memoryTx) Users() UserStore {
+	return &txUserStore{tx: tx}
+}
+
+func (tx *memoryTx) Todos() TodoStore {
+	return &txTodoStore{tx: tx}
+}
+
+func (tx *memoryTx) Items() TodoItemStore {
+	return &txTodoItemStore{tx: tx}
+}
+
+func (tx *memoryTx) Tags() TagStore {
+	return &txTagStore{tx: tx}
+}
+
+func (tx *memoryTx) Comments() CommentStore {
+	return &txCommentStore{tx: tx}
+}
+
+func (tx *memoryTx) Attachments() AttachmentStore {
+	return &txAttachmentStore{tx: tx}
+}
+
+func (tx *memoryTx) Collaborators() CollaboratorStore {
+	return &txCollaboratorStore{tx: tx}
+}
+
+func (tx *memoryTx) Workspaces() WorkspaceStore {
+	return &txWorkspaceStore{tx: tx}
+}
+
+func (tx *memoryTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+}
+
+// saveUser records how to put user id back into its current state. If the
+// user doesn't exist yet, undoing removes it and rewinds nextID.
+func (tx *memoryTx) saveUser(id int) {
+	tx.userIDs[id] = true
+	m := tx.users
+	existing, exists := m.users[id]
+	if !exists {
+		nextID := m.nextID
+		tx.undo = append(tx.undo, func() {
+			m.remove(id)
+			m.nextID = nextID
+		})
+		return
+	}
+
+	// Users are replaced rather than changed in place on update, so put the
+	// old record back as a whole.
+	tx.undo = append(tx.undo, func() {
+		m.users[id] = existing
+		m.index(existing)
+	})
+}
+
+// saveTodo records how to put todo id back into its current state. If the
+// todo doesn't exist yet, undoing removes it and rewinds nextID.
+func (tx *memoryTx) saveTodo(id int) {
+	tx.todoIDs[id] = true
+	m := tx.todos
+	existing, exists := m.todos[id]
+	if !exists {
+		nextID := m.nextID
+		tx.undo = append(tx.undo, func() {
+			m.remove(id)
+			m.nextID = nextID
+		})
+		return
+	}
+
+	prev := *existing
+	tx.undo = append(tx.undo, func() {
+		*existing = prev
+		m.todos[id] = existing
+		m.index(existing)
+	})
+}
+
+func (tx *memoryTx) saveItem(id int) {
+	tx.itemIDs[id] = true
+	m := tx.items
+	existing, exists := m.items[id]
+	if !exists {
+		nextID := m.nextID
+		tx.undo = append(tx.undo, func() {
+			m.remove(id)
+			m.nextID = nextID
+		})
+		return
+	}
+
+	prev := *existing
+	tx.undo = append(tx.undo, func() {
+		*existing = prev
+		m.items[id] = existing
+		m.index(existing)
+	})
+}
+
+// saveTag records how to put tag id back into its current state. Like
+// users, tags are replaced on update, and may also be removed.
+func (tx *memoryTx) saveTag(id int) {
+	tx.tagIDs[id] = true
+	m := tx.tags
+	existing, exists := m.tags[id]
+	if !exists {
+		nextID := m.nextID
+		tx.undo = append(tx.undo, func() {
+			m.remove(id)
+			m.nextID = nextID
+		})
+		return
+	}
+
+	tx.undo = append(tx.undo, func() {
+		m.remove(id)
+		m.tags[id] = existing
+		m.index(existing)
+	})
+}
+
+func (tx *memoryTx) saveComment(id int) {
+	tx.commentIDs[id] = true
+	m := tx.comments
+	existing, exists := m.comments[id]
+	if !exists {
+		nextID := m.nextID
+		tx.undo = append(tx.undo, func() {
+			m.remove(id)
+			m.nextID = nextID
+		})
+		return
+	}
+
+	prev := *existing
+	tx.undo = append(tx.undo, func() {
+		*existing = prev
+		m.comments[id] = existing
+		m.index(existing)
+	})
+}
+
+// saveAttachment records how to put attachment id back. Attachments never
+// change, so there is only creating and deleting to undo.
+func (tx *memoryTx) saveAttachment(id int) {
+	tx.attachmentIDs[id] = true
+	m := tx.attachments
+	existing, exists := m.attachments[id]
+	if !exists {
+		nextID := m.nextID
+		tx.undo = append(tx.undo, func() {
+			m.remove(id)
+			m.nextID = nextID
+		})
+		return
+	}
+
+	tx.undo = append(tx.undo, func() {
+		m.attachments[id] = existing
+		m.index(existing)
+	})
+}
+
+// saveCollaborator records how to put collaborator id back into its current
+// state. Like tags, collaborators are replaced on update.
+func (tx *memoryTx) saveCollaborator(id int) {
+	tx.collaboratorIDs[id] = true
+	m := tx.collaborators
+	existing, exists := m.collaborators[id]
+	if !exists {
+		nextID := m.nextID
+		tx.undo = append(tx.undo, func() {
+			m.remove(id)
+			m.nextID = nextID
+		})
+		return
+	}
+
+	tx.undo = append(tx.undo, func() {
+		m.remove(id)
+		m.collaborators[id] = existing
+		m.index(existing)
+	})
+}
+
+// saveWorkspace records how to put workspace id back into its current
+// state, members included: deleting a workspace deletes them too.
+func (tx *memoryTx) saveWorkspace(id int) {
+	tx.workspaceIDs[id] = true
+	m := tx.workspaces
+	existing, exists := m.workspaces[id]
+	if !exists {
+		nextID := m.nextID
+		tx.undo = append(tx.undo, func() {
+			m.remove(id)
+			m.nextID = nextID
+		})
+		return
+	}
+
+	for _, memberID := range m.byWorkspace[id] {
+		tx.saveMember(memberID)
+	}
+	tx.undo = append(tx.undo, func() {
+		m.workspaces[id] = existing
+	})
+}
+
+// saveMember records how to put workspace member id back into its current
+// state. Like collaborators, members are replaced on update.
+func (tx *memoryTx) saveMember(id int) {
+	tx.memberIDs[id] = true
+	m := tx.workspaces
+	existing, exists := m.members[id]
+	if !exists {
+		nextID := m.nextMemberID
+		tx.undo = append(tx.undo, func() {
+			m.unindexMember(id)
+			m.nextMemberID = nextID
+		})
+		return
+	}
+
+	tx.undo = append(tx.undo, func() {
+		m.unindexMember(id)
+		m.members[id] = existing
+		m.indexMember(existing)
+	})
+}
+
+type txUserStore struct {
+	tx *memoryTx
+}
+
+func (s *txUserStore) Create(user *User) error {
+	s.tx.saveUser(s.tx.users.nextID)
+	return s.tx.users.create(user)
+}
+
+func (s *txUserStore) GetByID(id int) (*User, error) {
+	return s.tx.users.getByID(id)
+}
+
+func (s *txUserStore) GetByUsername(username string) (*User, error) {
+	return s.tx.users.getByUsername(username)
+}
+
+func (s *txUserStore) GetAll() ([]*User, error) {
+	return s.tx.users.getAll(), nil
+}
+
+func (s *txUserStore) Update(user *User) error {
+	s.tx.saveUser(user.ID)
+	return s.tx.users.update(user)
+}
+
+func (s *txUserStore) Delete(id int, policy UserDeletePolicy) error {
+	return s.tx.deleteUser(id, policy)
+}
+
+type txTodoStore struct {
+	tx *memoryTx
+}
+
+func (s *txTodoStore) Create(todo *Todo) error {
+	s.tx.saveTodo(s.tx.todos.nextID)
+	return s.tx.todos.create(todo)
+}
+
+func (s *txTodoStore) GetByID(id int) (*Todo, error) {
+	return s.tx.todos.getByID(id)
+}
+
+func (s *txTodoStore) GetByIDWithDeleted(id int) (*Todo, error) {
+	return s.tx.todos.getByIDWithDeleted(id)
+}
+
+func (s *txTodoStore) GetByIDsWithDeleted(ids []int) ([]*Todo, error) {
+	return s.tx.todos.getByIDsWithDeleted(ids), nil
+}
+
+func (s *txTodoStore) GetByIDInWorkspace(id, workspaceID int) (*Todo, error) {
+	return s.tx.todos.getByIDInWorkspace(id, workspaceID)
+}
+
+func (s *txTodoStore) GetByIDInWorkspaceWithDeleted(id, workspaceID int) (*Todo, error) {
+	return s.tx.todos.getByIDInWorkspaceWithDeleted(id, workspaceID)
+}
+
+func (s *txTodoStore) GetByWorkspaceID(workspaceID int) ([]*Todo, error) {
+	return s.tx.todos.getByWorkspaceID(workspaceID), nil
+}
+
+func (s *txTodoStore) GetByWorkspaceIDWithDeleted(workspaceID int) ([]*Todo, error) {
+	return s.tx.todos.getByWorkspaceIDWithDeleted(workspaceID), nil
+}
+
+func (s *txTodoStore) GetByUserID(workspaceID, userID int) ([]*Todo, error) {
+	return s.tx.todos.getByUserID(workspaceID, userID), nil
+}
+
+func (s *txTodoStore) GetByUserIDWithDeleted(workspaceID, userID int) ([]*Todo, error) {
+	return s.tx.todos.getByUserIDWithDeleted(workspaceID, userID), nil
+}
+
+func (s *txTodoStore) GetDeletedBefore(before time.Time) ([]*Todo, error) {
+	return s.tx.todos.getDeletedBefore(before), nil
+}
+
+func (s *txTodoStore) GetRecurring() ([]*Todo, error) {
+	return s.tx.todos.getRecurring(), nil
+}
+
+func (s *txTodoStore) Update(todo *Todo) error {
+	s.tx.saveTodo(todo.ID)
+	return s.tx.todos.update(todo)
+}
+
+func (s *txTodoStore) Delete(id int) error {
+	return s.tx.deleteTodo(id)
+}
+
+func (s *txTodoStore) Restore(id int) error {
+	s.tx.saveTodo(id)
+	return s.tx.todos.undelete(id)
+}
+
+func (s *txTodoStore) Purge(id int) error {
+	s.tx.saveTodo(id)
+	return s.tx.todos.purge(id)
+}
+
+func (s *txTodoStore) SetTags(id int, tags []string) error {
+	s.tx.saveTodo(id)
+	return s.tx.todos.setTags(id, tags)
+}
+
+// UpdateCompletionPct always counts the items as seen by this transaction;
+// the store argument is only there to satisfy TodoStore.
+func (s *txTodoStore) UpdateCompletionPct(todoID int, _ TodoItemStore) error {
+	s.tx.saveTodo(todoID)
+	return s.tx.todos.setCompletionPct(todoID, s.tx.items.getByTodoID(todoID))
+}
+
+type txTodoItemStore struct {
+	tx *memoryTx
+}
+
+func (s *txTodoItemStore) Create(item *TodoItem) error {
+	s.tx.saveItem(s.tx.items.nextID)
+	return s.tx.items.create(item)
+}
+
+func (s *txTodoItemStore) GetByID(id int) (*TodoItem, error) {
+	return s.tx.items.getByID(id)
+}
+
+func (s *txTodoItemStore) GetByIDWithDeleted(id int) (*TodoItem, error) {
+	return s.tx.items.getByIDWithDeleted(id)
+}
+
+func (s *txTodoItemStore) GetByIDsWithDeleted(ids []int) ([]*TodoItem, error) {
+	return s.tx.items.getByIDsWithDeleted(ids), nil
+}
+
+func (s *txTodoItemStore) GetByTodoID(todoID int) ([]*TodoItem, error) {
+	return s.tx.items.getByTodoID(todoID), nil
+}
+
+func (s *txTodoItemStore) GetByTodoIDWithDeleted(todoID int) ([]*TodoItem, error) {
+	return s.tx.items.getByTodoIDWithDeleted(todoID), nil
+}
+
+func (s *txTodoItemStore) GetDeletedBefore(before time.Time) ([]*TodoItem, error) {
+	return s.tx.items.getDeletedBefore(before), nil
+}
+
+func (s *txTodoItemStore) GetRecurring() ([]*TodoItem, error) {
+	return s.tx.items.getRecurring(), nil
+}
+
+func (s *txTodoItemStore) Update(item *TodoItem) error {
+	s.tx.saveItem(item.ID)
+	return s.tx.items.update(item)
+}
+
+func (s *txTodoItemStore) Delete(id int) error {
+	s.tx.saveItem(id)
+	return s.tx.items.delete(id, time.Now())
+}
+
+func (s *txTodoItemStore) Restore(id int) error {
+	s.tx.saveItem(id)
+	return s.tx.items.undelete(id)
+}
+
+func (s *txTodoItemStore) Purge(id int) error {
+	s.tx.saveItem(id)
+	return s.tx.items.purge(id)
+}
+
+func (s *txTodoItemStore) SetTags(id int, tags []string) error {
+	s.tx.saveItem(id)
+	return s.tx.items.setTags(id, tags)
+}
+
+type txTagStore struct {
+	tx *memoryTx
+}
+
+func (s *txTagStore) Create(tag *Tag) error {
+	s.tx.saveTag(s.tx.tags.nextID)
+	return s.tx.tags.create(tag)
+}
+
+func (s *txTagStore) GetByID(id int) (*Tag, error) {
+	return s.tx.tags.getByID(id)
+}
+
+func (s *txTagStore) GetByName(userID int, name string) (*Tag, error) {
+	return s.tx.tags.getByName(userID, name)
+}
+
+func (s *txTagStore) GetByUserID(userID int) ([]*Tag, error) {
+	return s.tx.tags.getByUserID(userID), nil
+}
+
+func (s *txTagStore) Update(tag *Tag) error {
+	s.tx.saveTag(tag.ID)
+	return s.tx.tags.update(tag)
+}
+
+func (s *txTagStore) Delete(id int) error {
+	s.tx.saveTag(id)
+	return s.tx.tags.delete(id)
+}
+
+type txCommentStore struct {
+	tx *memoryTx
+}
+
+func (s *txCommentStore) Create(comment *Comment) error {
+	s.tx.saveComment(s.tx.comments.nextID)
+	return s.tx.comments.create(comment)
+}
+
+func (s *txCommentStore) GetByID(id int) (*Comment, error) {
+	return s.tx.comments.getByID(id)
+}
+
+func (s *txCommentStore) GetByTodoID(todoID int) ([]*Comment, error) {
+	return s.tx.comments.getByTodoID(todoID), nil
+}
+
+func (s *txCommentStore) CountByTodoIDs(todoIDs []int) (map[int]int, error) {
+	return s.tx.comments.countByTodoIDs(todoIDs), nil
+}
+
+func (s *txCommentStore) Update(comment *Comment) error {
+	s.tx.saveComment(comment.ID)
+	return s.tx.comments.update(comment)
+}
+
+func (s *txCommentStore) Delete(id int) error {
+	s.tx.saveComment(id)
+	return s.tx.comments.delete(id, time.Now())
+}
+
+func (s *txCommentStore) Purge(id int) error {
+	s.tx.saveComment(id)
+	return s.tx.comments.purge(id)
+}
+
+type txAttachmentStore struct {
+	tx *memoryTx
+}
+
+func (s *txAttachmentStore) Create(attachment *Attachment) error {
+	s.tx.saveAttachment(s.tx.attachments.nextID)
+	return s.tx.attachments.create(attachment)
+}
+
+func (s *txAttachmentStore) GetByID(id int) (*Attachment, error) {
+	return s.tx.attachments.getByID(id)
+}
+
+func (s *txAttachmentStore) GetByItemID(itemID int) ([]*Attachment, error) {
+	return s.tx.attachments.getByItemID(itemID), nil
+}
+
+func (s *txAttachmentStore) Delete(id int) error {
+	s.tx.saveAttachment(id)
+	return s.tx.attachments.delete(id)
+}
+
+type txCollaboratorStore struct {
+	tx *memoryTx
+}
+
+func (s *txCollaboratorStore) Create(collaborator *Collaborator) error {
+	s.tx.saveCollaborator(s.tx.collaborators.nextID)
+	return s.tx.collaborators.create(collaborator)
+}
+
+func (s *txCollaboratorStore) GetByID(id int) (*Collaborator, error) {
+	return s.tx.collaborators.getByID(id)
+}
+
+func (s *txCollaboratorStore) GetByTodoAndUser(todoID, userID int) (*Collaborator, error) {
+	return s.tx.collaborators.getByTodoAndUser(todoID, userID)
+}
+
+func (s *txCollaboratorStore) GetByTodoID(todoID int) ([]*Collaborator, error) {
+	return s.tx.collaborators.getByTodoID(todoID), nil
+}
+
+func (s *txCollaboratorStore) GetByUserID(userID int) ([]*Collaborator, error) {
+	return s.tx.collaborators.getByUserID(userID), nil
+}
+
+func (s *txCollaboratorStore) Update(collaborator *Collaborator) error {
+	s.tx.saveCollaborator(collaborator.ID)
+	return s.tx.collaborators.update(collaborator)
+}
+
+func (s *txCollaboratorStore) Delete(id int) error {
+	s.tx.saveCollaborator(id)
+	return s.tx.collaborators.delete(id)
+}
+
+type txWorkspaceStore struct {
+	tx *memoryTx
+}
+
+func (s *txWorkspaceStore) Create(workspace *Workspace) error {
+	s.tx.saveWorkspace(s.tx.workspaces.nextID)
+	return s.tx.workspaces.create(workspace)
+}
+
+func (s *txWorkspaceStore) GetByID(id int) (*Workspace, error) {
+	return s.tx.workspaces.getByID(id)
+}
+
+func (s *txWorkspaceStore) GetAll() ([]*Workspace, error) {
+	return s.tx.workspaces.getAll(), nil
+}
+
+func (s *txWorkspaceStore) GetByUserID(userID int) ([]*Workspace, error) {
+	return s.tx.workspaces.getByUserID(userID), nil
+}
+
+func (s *txWorkspaceStore) Update(workspace *Workspace) error {
+	s.tx.saveWorkspace(workspace.ID)
+	return s.tx.workspaces.update(workspace)
+}
+
+func (s *txWorkspaceStore) Delete(id int) error {
+	s.tx.saveWorkspace(id)
+	return s.tx.workspaces.delete(id)
+}
+
+func (s *txWorkspaceStore) AddMember(member *WorkspaceMember) error {
+	s.tx.saveMember(s.tx.workspaces.nextMemberID)
+	return s.tx.workspaces.addMember(member)
+}
+
+func (s *txWorkspaceStore) GetMember(workspaceID, userID int) (*WorkspaceMember, error) {
+	return s.tx.workspaces.getMember(workspaceID, userID)
+}
+
+func (s *txWorkspaceStore) GetMembers(workspaceID int) ([]*WorkspaceMember, error) {
+	return s.tx.workspaces.getMembers(workspaceID), nil
+}
+
+func (s *txWorkspaceStore) UpdateMember(member *WorkspaceMember) error {
+	s.tx.saveMember(member.ID)
+	return s.tx.workspaces.updateMember(member)
+}
+
+func (s *txWorkspaceStore) RemoveMember(workspaceID, userID int) error {
+	if id, ok := s.tx.workspaces.byWorkspace[workspaceID][userID]; ok {
+		s.tx.saveMember(id)
+	}
+	return s.tx.workspaces.removeMember(workspaceID, userID)
+}
@@ -0,0 +1,65 @@
+package entity
+
+import (
+	"testing"
+)
+
+// newTestUnitOfWork builds the other models around todos and returns the
+// unit of work over all of them.
+func newTestUnitOfWork(todos *TodoModel) *MemoryUnitOfWork {
+	tags, collaborators, workspaces := NewTagModel(), NewCollaboratorModel(), NewWorkspaceModel()
+	users := NewUserModel(todos, tags, collaborators, workspaces)
+	return NewUnitOfWork(users, todos, todos.items, tags, NewCommentModel(), NewAttachmentModel(), collaborators, workspaces)
+}
+
+// BenchmarkUnitOfWork reads a todo while one operation in ten updates an
+// item of it, either straight through the item model, which only locks the
+// items, or in a transaction, which locks every model and, when journaled,
+// waits for the log while holding them.
+func BenchmarkUnitOfWork(b *testing.B) {
+	cases := []struct {
+		name  string
+		write func(u *MemoryUnitOfWork, j *Journal, item *TodoItem) error
+	}{
+		{"model", func(u *MemoryUnitOfWork, _ *Journal, item *TodoItem) error {
+			return u.items.Update(item)
+		}},
+		{"Do", func(u *MemoryUnitOfWork, _ *Journal, item *TodoItem) error {
+			return u.Do(func(tx Tx) error { return tx.Items().Update(item) })
+		}},
+		{"journaled Do", func(_ *MemoryUnitOfWork, j *Journal, item *TodoItem) error {
+			return j.UnitOfWork().Do(func(tx Tx) error { return tx.Items().Update(item) })
+		}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			u := newTestUnitOfWork(NewTodoModel(NewTodoItemModel()))
+			j, err := OpenJournal(b.TempDir(), u.users, u.todos, u.items, u.tags, u.comments, u.attachments, u.collaborators, u.workspaces)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer j.Close()
+			if err := u.todos.Create(&Todo{Title: "todo", UserID: 1, WorkspaceID: DefaultWorkspaceID}); err != nil {
+				b.Fatal(err)
+			}
+			if err := u.items.Create(&TodoItem{Title: "item", TodoID: 1, UserID: 1}); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for n := 0; pb.Next(); n++ {
+					if n%10 == 0 {
+						if err := c.write(u, j, &TodoItem{ID: 1, Title: "item", TodoID: 1, UserID: 1}); err != nil {
+							b.Error(err)
+						}
+						continue
+					}
+					if _, err := u.todos.GetByID(1); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package entity
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Priority is how important a todo or item is. An empty priority, as on
+// records created before priorities existed, counts as PriorityNone.
+type Priority string
+
+const (
+	PriorityNone   Priority = "none"
+	PriorityLow    Priority = "low"
+	PriorityMedium Priority = "medium"
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
+)
+
+var priorityWeights = map[Priority]float64{
+	PriorityNone:   0,
+	PriorityLow:    1.8,
+	PriorityMedium: 3.9,
+	PriorityHigh:   6,
+	PriorityUrgent: 9,
+}
+
+// priorityKey sorts by priority weight, so "low" comes before "high".
+func priorityKey(p Priority, id int) sortKey {
+	return sortKey{Num: priorityWeights[p.orNone()], ID: id}
+}
+
+func (p Priority) orNone() Priority {
+	if p == "" {
+		return PriorityNone
+	}
+	return p
+}
+
+// Urgency ranks open work so that every client orders it the same way. It
+// adds up three terms:
+//
+//   - the priority weight, from 0 (none) to 9 (urgent)
+//   - up to 12 for the due date, growing linearly from 2.4 two weeks
+//     before it to 12 a week after it; nothing without a due date
+//   - up to 2 for age, growing linearly over a year from CreatedAt
+//
+// All-day dates count as due at the end of their day in UTC, since the
+// score must not depend on who asks. Finished work has an urgency of 0.
+const (
+	urgencyDueWeight = 12
+	urgencyAgeWeight = 2
+	urgencyDueBefore = 14 * 24 * time.Hour
+	urgencyDueAfter  = 7 * 24 * time.Hour
+	urgencyMaxAge    = 365 * 24 * time.Hour
+)
+
+func urgency(p Priority, s Schedule, createdAt time.Time, done bool, now time.Time) float64 {
+	if done {
+		return 0
+	}
+	score := priorityWeights[p.orNone()]
+
+	if s.DueAt != nil {
+		due := *s.DueAt
+		if s.AllDay {
+			due = due.Add(24 * time.Hour)
+		}
+		// 0.2 two weeks or more before the due date, 1 a week or more after.
+		since := now.Sub(due)
+		factor := 0.2 + 0.8*float64(since+urgencyDueBefore)/float64(urgencyDueBefore+urgencyDueAfter)
+		score += urgencyDueWeight * math.Max(0.2, math.Min(1, factor))
+	}
+
+	if age := now.Sub(createdAt); age > 0 {
+		score += urgencyAgeWeight * math.Min(1, float64(age)/float64(urgencyMaxAge))
+	}
+	return math.Round(score*100) / 100
+}
+
+// Urgency returns the todo's urgency at now. A todo is finished once all
+// of its items are.
+func (t *Todo) Urgency(now time.Time) float64 {
+	return urgency(t.Priority, t.Schedule, t.CreatedAt, t.CompletionPct >= 100, now)
+}
+
+// Urgency returns the item's urgency at now.
+func (i *TodoItem) Urgency(now time.Time) float64 {
+	return urgency(i.Priority, i.Schedule, i.CreatedAt, i.Completed, now)
+}
+
+// Todos and items carry their urgency as of the moment they are encoded, so
+// every response has an up-to-date score without storing one. Missing
+// priorities and tags are spelled out as "none" and [].
+
+type (
+	plainTodo     Todo
+	plainTodoItem TodoItem
+)
+
+type encodedTodo struct {
+	plainTodo
+	Urgency float64 `json:"urgency"`
+}
+
+type encodedTodoItem struct {
+	plainTodoItem
+	Urgency float64 `json:"urgency"`
+}
+
+func (t Todo) MarshalJSON() ([]byte, error) {
+	t.Priority = t.Priority.orNone()
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+	return json.Marshal(encodedTodo{plainTodo(t), t.Urgency(time.Now())})
+}
+
+func (i TodoItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.encoded())
+}
+
+func (i TodoItem) encoded() encodedTodoItem {
+	i.Priority = i.Priority.orNone()
+	if i.Tags == nil {
+		i.Tags = []string{}
+	}
+	return encodedTodoItem{plainTodoItem(i), i.Urgency(time.Now())}
+}
+
+// MarshalJSON puts the node's fields next to the item's. TodoItemNode would
+// otherwise inherit the item's MarshalJSON and lose them.
+func (n TodoItemNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		encodedTodoItem
+		CompletionPct float64         `json:"completion_pct"`
+		Children      []*TodoItemNode `json:"children"`
+	}{n.TodoItem.encoded(), n.CompletionPct, n.Children})
+}
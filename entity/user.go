@@ -1,7 +1,6 @@
 package entity
 
 import (
-	"errors"
 	"sync"
 	"time"
 
@@ -13,6 +12,8 @@ type User struct {
 	Username  string    `json:"username"`
 	Password  string    `json:"-"`
 	Role      string    `json:"role"`
+	TimeZone  string    `json:"time_zone"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -21,12 +22,30 @@ type UserModel struct {
 	users  map[int]*User
 	nextID int
 	mu     sync.RWMutex
+
+	// byUsername maps usernames to user IDs, and usernameOf remembers the
+	// name each user is indexed under so Update can re-file it. Like the
+	// other models, users are copied on the way in and out.
+	byUsername map[string]int
+	usernameOf map[int]string
+
+	// Deleting a user changes these; see lifecycle.go.
+	todos         *TodoModel
+	tags          *TagModel
+	collaborators *CollaboratorModel
+	workspaces    *WorkspaceModel
 }
 
-func NewUserModel() *UserModel {
+func NewUserModel(todos *TodoModel, tags *TagModel, collaborators *CollaboratorModel, workspaces *WorkspaceModel) *UserModel {
 	return &UserModel{
-		users:  make(map[int]*User),
-		nextID: 1,
+		todos:         todos,
+		tags:          tags,
+		collaborators: collaborators,
+		workspaces:    workspaces,
+		users:         make(map[int]*User),
+		nextID:        1,
+		byUsername:    make(map[string]int),
+		usernameOf:    make(map[int]string),
 	}
 }
 
@@ -39,106 +58,246 @@ func (u *User) SetPassword(password string) error {
 	return nil
 }
 
+// Location returns the time zone named by TimeZone, which decides where the
+// user's days begin and end, e.g. for all-day due dates. It is UTC if
+// TimeZone is empty or unknown.
+func (u *User) Location() *time.Location {
+	if loc, err := LoadTimeZone(u.TimeZone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
 func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 	return err == nil
 }
 
+// Like the other models, the exported methods lock and delegate to
+// lower-case variants that transactions call while holding the lock.
+
 func (m *UserModel) Create(user *User) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.create(user)
+}
 
-	for _, u := range m.users {
-		if u.Username == user.Username {
-			return errors.New("username already exists")
-		}
+func (m *UserModel) GetByID(id int) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getByID(id)
+}
+
+func (m *UserModel) GetByUsername(username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getByUsername(username)
+}
+
+func (m *UserModel) GetAll() ([]*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getAll(), nil
+}
+
+func (m *UserModel) Update(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.update(user)
+}
+
+// Delete removes a user and deals with the todos they own according to
+// policy (see lifecycle.go). The records of the other models it changes are
+// changed in the same transaction.
+func (m *UserModel) Delete(id int, policy UserDeletePolicy) error {
+	// The same lock order as MemoryUnitOfWork, leaving out the comments and
+	// attachments, which a user's deletion doesn't touch.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.todos.items.Lock()
+	defer m.todos.items.Unlock()
+	m.todos.Lock()
+	defer m.todos.Unlock()
+	m.tags.Lock()
+	defer m.tags.Unlock()
+	m.collaborators.Lock()
+	defer m.collaborators.Unlock()
+	m.workspaces.Lock()
+	defer m.workspaces.Unlock()
+
+	u := &MemoryUnitOfWork{
+		users:         m,
+		todos:         m.todos,
+		items:         m.todos.items,
+		tags:          m.tags,
+		collaborators: m.collaborators,
+		workspaces:    m.workspaces,
+	}
+	_, err := u.run(func(tx *memoryTx) error {
+		return tx.deleteUser(id, policy)
+	})
+	return err
+}
+
+func (m *UserModel) create(user *User) error {
+	if _, taken := m.byUsername[user.Username]; taken {
+		return ErrUsernameExists
 	}
 
 	user.ID = m.nextID
 	m.nextID++
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
+	user.Version = 1
 
 	if err := user.SetPassword(user.Password); err != nil {
 		return err
 	}
 
-	m.users[user.ID] = user
+	m.users[user.ID] = cloneUser(user)
+	m.index(user)
 	return nil
 }
 
-func (m *UserModel) GetByID(id int) (*User, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
+func (m *UserModel) getByID(id int) (*User, error) {
 	user, exists := m.users[id]
 	if !exists {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
-	return user, nil
+	return cloneUser(user), nil
 }
 
-func (m *UserModel) GetByUsername(username string) (*User, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	for _, user := range m.users {
-		if user.Username == username {
-			return user, nil
-		}
+func (m *UserModel) getByUsername(username string) (*User, error) {
+	id, exists := m.byUsername[username]
+	if !exists {
+		return nil, ErrUserNotFound
 	}
-	return nil, errors.New("user not found")
+	return cloneUser(m.users[id]), nil
 }
 
-func (m *UserModel) GetAll() []*User {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
+func (m *UserModel) getAll() []*User {
 	users := make([]*User, 0, len(m.users))
 	for _, user := range m.users {
-		users = append(users, user)
+		users = append(users, cloneUser(user))
 	}
 	return users
 }
 
-func (m *UserModel) Update(user *User) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
+func (m *UserModel) update(user *User) error {
 	existingUser, exists := m.users[user.ID]
 	if !exists {
-		return errors.New("user not found")
+		return ErrUserNotFound
+	}
+	if user.Version != 0 && user.Version != existingUser.Version {
+		return ErrVersionConflict
 	}
 
-	if user.Username != existingUser.Username {
-		for _, u := range m.users {
-			if u.Username == user.Username {
-				return errors.New("username already exists")
-			}
-		}
+	if id, taken := m.byUsername[user.Username]; taken && id != user.ID {
+		return ErrUsernameExists
 	}
 
-	existingUser.Username = user.Username
+	// An empty Password keeps the current one; anything else is treated as
+	// a new plain-text password.
+	updated := cloneUser(existingUser)
+	updated.Username = user.Username
 	if user.Password != "" {
-		existingUser.Password = user.Password
-		if err := existingUser.SetPassword(user.Password); err != nil {
+		if err := updated.SetPassword(user.Password); err != nil {
 			return err
 		}
 	}
-	existingUser.Role = user.Role
-	existingUser.UpdatedAt = time.Now()
+	updated.Role = user.Role
+	updated.TimeZone = user.TimeZone
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+
+	m.users[user.ID] = updated
+	m.index(updated)
 
+	user.Password = updated.Password
+	user.CreatedAt = updated.CreatedAt
+	user.UpdatedAt = updated.UpdatedAt
+	user.Version = updated.Version
 	return nil
 }
 
-func (m *UserModel) Delete(id int) error {
+// index files user under its current username, dropping the name it was
+// indexed under before if that changed.
+func (m *UserModel) index(user *User) {
+	if name, ok := m.usernameOf[user.ID]; ok && name != user.Username {
+		delete(m.byUsername, name)
+	}
+	m.byUsername[user.Username] = user.ID
+	m.usernameOf[user.ID] = user.Username
+}
+
+func (m *UserModel) unindex(id int) {
+	if name, ok := m.usernameOf[id]; ok {
+		delete(m.byUsername, name)
+		delete(m.usernameOf, id)
+	}
+}
+
+// restore puts a user back into the map with its original ID and password
+// hash. It is used when rebuilding the model from a journal.
+func (m *UserModel) restore(user *User) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, exists := m.users[id]; !exists {
-		return errors.New("user not found")
+	if user.Version == 0 {
+		user.Version = 1
 	}
+	m.users[user.ID] = user
+	m.index(user)
+	if user.ID >= m.nextID {
+		m.nextID = user.ID + 1
+	}
+}
 
+// remove drops a user from the map and the index.
+func (m *UserModel) remove(id int) {
+	m.unindex(id)
 	delete(m.users, id)
-	return nil
+}
+
+// discard deletes a user without applying any lifecycle rules or reporting
+// missing IDs. It is used when replaying a journal, where the effects on the
+// user's todos were logged separately.
+func (m *UserModel) discard(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+}
+
+func (m *UserModel) snapshot() []*journalUser {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]*journalUser, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, newJournalUser(user))
+	}
+	return users
+}
+
+// nextIDs returns the ID the next user gets and reserveIDs makes sure the
+// counter doesn't go back below next. The journal keeps the counters of all
+// models in its snapshots, since deleted and purged records can have taken
+// IDs above the largest one left.
+func (m *UserModel) nextIDs() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.nextID
+}
+
+func (m *UserModel) reserveIDs(next int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if next > m.nextID {
+		m.nextID = next
+	}
+}
+
+func cloneUser(user *User) *User {
+	c := *user
+	return &c
 }
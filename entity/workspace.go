@@ -0,0 +1,394 @@
+package entity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Workspace is a team's share of the instance. Every todo belongs to one,
+// and so do its items, comments, attachments and collaborators; see
+// membership.go.
+type Workspace struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WorkspaceMember lets a user work in a workspace, in the role Role says.
+type WorkspaceMember struct {
+	ID          int           `json:"id"`
+	WorkspaceID int           `json:"workspace_id"`
+	UserID      int           `json:"user_id"`
+	Role        WorkspaceRole `json:"role"`
+	Version     int           `json:"version"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+type WorkspaceModel struct {
+	sync.RWMutex
+	workspaces map[int]*Workspace
+	nextID     int
+
+	members      map[int]*WorkspaceMember
+	nextMemberID int
+
+	// byWorkspace maps a workspace to its members, by user ID; byUser
+	// indexes member IDs by user. A member never moves to another workspace
+	// or user.
+	byWorkspace map[int]map[int]int
+	byUser      map[int]map[int]struct{}
+}
+
+func NewWorkspaceModel() *WorkspaceModel {
+	return &WorkspaceModel{
+		workspaces:   make(map[int]*Workspace),
+		nextID:       1,
+		members:      make(map[int]*WorkspaceMember),
+		nextMemberID: 1,
+		byWorkspace:  make(map[int]map[int]int),
+		byUser:       make(map[int]map[int]struct{}),
+	}
+}
+
+// As in the other models, the exported methods lock and delegate to
+// lower-case variants that transactions call while holding the lock, and
+// records are copied on the way in and out. The model keeps both the
+// workspaces and their members; deleting a workspace deletes its members,
+// and a user is a member of a workspace at most once.
+
+func (m *WorkspaceModel) Create(workspace *Workspace) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.create(workspace)
+}
+
+func (m *WorkspaceModel) GetByID(id int) (*Workspace, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByID(id)
+}
+
+// GetAll returns every workspace, oldest first.
+func (m *WorkspaceModel) GetAll() ([]*Workspace, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getAll(), nil
+}
+
+// GetByUserID returns the workspaces a user is a member of, oldest first.
+func (m *WorkspaceModel) GetByUserID(userID int) ([]*Workspace, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getByUserID(userID), nil
+}
+
+// Update renames a workspace.
+func (m *WorkspaceModel) Update(workspace *Workspace) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.update(workspace)
+}
+
+// Delete deletes a workspace and its members. Whether it still holds todos
+// is checked by DeleteWorkspace.
+func (m *WorkspaceModel) Delete(id int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.delete(id)
+}
+
+func (m *WorkspaceModel) AddMember(member *WorkspaceMember) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.addMember(member)
+}
+
+func (m *WorkspaceModel) GetMember(workspaceID, userID int) (*WorkspaceMember, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getMember(workspaceID, userID)
+}
+
+// GetMembers returns the members of a workspace, oldest first.
+func (m *WorkspaceModel) GetMembers(workspaceID int) ([]*WorkspaceMember, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.getMembers(workspaceID), nil
+}
+
+// UpdateMember saves the role of a member.
+func (m *WorkspaceModel) UpdateMember(member *WorkspaceMember) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.updateMember(member)
+}
+
+func (m *WorkspaceModel) RemoveMember(workspaceID, userID int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.removeMember(workspaceID, userID)
+}
+
+func (m *WorkspaceModel) create(workspace *Workspace) error {
+	workspace.ID = m.nextID
+	workspace.CreatedAt = time.Now()
+	workspace.UpdatedAt = workspace.CreatedAt
+	workspace.Version = 1
+
+	m.workspaces[workspace.ID] = cloneWorkspace(workspace)
+	m.nextID++
+	return nil
+}
+
+func (m *WorkspaceModel) getByID(id int) (*Workspace, error) {
+	workspace, exists := m.workspaces[id]
+	if !exists {
+		return nil, ErrWorkspaceNotFound
+	}
+	return cloneWorkspace(workspace), nil
+}
+
+func (m *WorkspaceModel) getAll() []*Workspace {
+	workspaces := make([]*Workspace, 0, len(m.workspaces))
+	for _, workspace := range m.workspaces {
+		workspaces = append(workspaces, cloneWorkspace(workspace))
+	}
+	sortWorkspaces(workspaces)
+	return workspaces
+}
+
+func (m *WorkspaceModel) getByUserID(userID int) []*Workspace {
+	workspaces := make([]*Workspace, 0, len(m.byUser[userID]))
+	for id := range m.byUser[userID] {
+		workspaces = append(workspaces, cloneWorkspace(m.workspaces[m.members[id].WorkspaceID]))
+	}
+	sortWorkspaces(workspaces)
+	return workspaces
+}
+
+func (m *WorkspaceModel) update(workspace *Workspace) error {
+	existing, exists := m.workspaces[workspace.ID]
+	if !exists {
+		return ErrWorkspaceNotFound
+	}
+	if workspace.Version != 0 && workspace.Version != existing.Version {
+		return ErrVersionConflict
+	}
+
+	updated := cloneWorkspace(existing)
+	updated.Name = workspace.Name
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+	m.workspaces[workspace.ID] = cloneWorkspace(updated)
+
+	*workspace = *updated
+	return nil
+}
+
+func (m *WorkspaceModel) delete(id int) error {
+	if _, exists := m.workspaces[id]; !exists {
+		return ErrWorkspaceNotFound
+	}
+	m.remove(id)
+	return nil
+}
+
+func (m *WorkspaceModel) addMember(member *WorkspaceMember) error {
+	if _, exists := m.workspaces[member.WorkspaceID]; !exists {
+		return ErrWorkspaceNotFound
+	}
+	if _, taken := m.byWorkspace[member.WorkspaceID][member.UserID]; taken {
+		return ErrMemberExists
+	}
+
+	member.ID = m.nextMemberID
+	member.CreatedAt = time.Now()
+	member.UpdatedAt = member.CreatedAt
+	member.Version = 1
+
+	m.members[member.ID] = cloneWorkspaceMember(member)
+	m.indexMember(member)
+	m.nextMemberID++
+	return nil
+}
+
+func (m *WorkspaceModel) getMember(workspaceID, userID int) (*WorkspaceMember, error) {
+	id, exists := m.byWorkspace[workspaceID][userID]
+	if !exists {
+		return nil, ErrMemberNotFound
+	}
+	return cloneWorkspaceMember(m.members[id]), nil
+}
+
+func (m *WorkspaceModel) getMembers(workspaceID int) []*WorkspaceMember {
+	members := make([]*WorkspaceMember, 0, len(m.byWorkspace[workspaceID]))
+	for _, id := range m.byWorkspace[workspaceID] {
+		members = append(members, cloneWorkspaceMember(m.members[id]))
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+func (m *WorkspaceModel) updateMember(member *WorkspaceMember) error {
+	existing, exists := m.members[member.ID]
+	if !exists {
+		return ErrMemberNotFound
+	}
+	if member.Version != 0 && member.Version != existing.Version {
+		return ErrVersionConflict
+	}
+
+	updated := cloneWorkspaceMember(existing)
+	updated.Role = member.Role
+	updated.UpdatedAt = time.Now()
+	updated.Version++
+	m.members[member.ID] = cloneWorkspaceMember(updated)
+
+	*member = *updated
+	return nil
+}
+
+func (m *WorkspaceModel) removeMember(workspaceID, userID int) error {
+	id, exists := m.byWorkspace[workspaceID][userID]
+	if !exists {
+		return ErrMemberNotFound
+	}
+	m.unindexMember(id)
+	return nil
+}
+
+func (m *WorkspaceModel) indexMember(member *WorkspaceMember) {
+	if m.byWorkspace[member.WorkspaceID] == nil {
+		m.byWorkspace[member.WorkspaceID] = make(map[int]int)
+	}
+	m.byWorkspace[member.WorkspaceID][member.UserID] = member.ID
+	if m.byUser[member.UserID] == nil {
+		m.byUser[member.UserID] = make(map[int]struct{})
+	}
+	m.byUser[member.UserID][member.ID] = struct{}{}
+}
+
+// unindexMember drops a member from the map and the indexes.
+func (m *WorkspaceModel) unindexMember(id int) {
+	member, ok := m.members[id]
+	if !ok {
+		return
+	}
+
+	delete(m.byWorkspace[member.WorkspaceID], member.UserID)
+	if len(m.byWorkspace[member.WorkspaceID]) == 0 {
+		delete(m.byWorkspace, member.WorkspaceID)
+	}
+	delete(m.byUser[member.UserID], id)
+	if len(m.byUser[member.UserID]) == 0 {
+		delete(m.byUser, member.UserID)
+	}
+	delete(m.members, id)
+}
+
+// remove drops a workspace and its members.
+func (m *WorkspaceModel) remove(id int) {
+	for _, memberID := range m.byWorkspace[id] {
+		m.unindexMember(memberID)
+	}
+	delete(m.workspaces, id)
+}
+
+// restore and restoreMember put a record back into the model with its
+// original ID. They are used when rebuilding the model from a journal.
+func (m *WorkspaceModel) restore(workspace *Workspace) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.workspaces[workspace.ID] = workspace
+	if workspace.ID >= m.nextID {
+		m.nextID = workspace.ID + 1
+	}
+}
+
+func (m *WorkspaceModel) restoreMember(member *WorkspaceMember) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.unindexMember(member.ID)
+	m.members[member.ID] = member
+	m.indexMember(member)
+	if member.ID >= m.nextMemberID {
+		m.nextMemberID = member.ID + 1
+	}
+}
+
+// discard and discardMember delete records without reporting missing IDs,
+// for journal replay.
+func (m *WorkspaceModel) discard(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.remove(id)
+}
+
+func (m *WorkspaceModel) discardMember(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.unindexMember(id)
+}
+
+// findMember returns a member by ID, for the journal. Callers hold the lock.
+func (m *WorkspaceModel) findMember(id int) (*WorkspaceMember, bool) {
+	member, ok := m.members[id]
+	if !ok {
+		return nil, false
+	}
+	return cloneWorkspaceMember(member), true
+}
+
+func (m *WorkspaceModel) snapshot() ([]*Workspace, []*WorkspaceMember) {
+	m.RLock()
+	defer m.RUnlock()
+
+	workspaces := make([]*Workspace, 0, len(m.workspaces))
+	for _, workspace := range m.workspaces {
+		workspaces = append(workspaces, cloneWorkspace(workspace))
+	}
+	members := make([]*WorkspaceMember, 0, len(m.members))
+	for _, member := range m.members {
+		members = append(members, cloneWorkspaceMember(member))
+	}
+	return workspaces, members
+}
+
+// nextIDs and reserveIDs do for both ID counters what they do for the other
+// models, see UserModel.nextIDs.
+func (m *WorkspaceModel) nextIDs() (workspace, member int) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.nextID, m.nextMemberID
+}
+
+func (m *WorkspaceModel) reserveIDs(workspace, member int) {
+	m.Lock()
+	defer m.Unlock()
+	if workspace > m.nextID {
+		m.nextID = workspace
+	}
+	if member > m.nextMemberID {
+		m.nextMemberID = member
+	}
+}
+
+func sortWorkspaces(workspaces []*Workspace) {
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].ID < workspaces[j].ID })
+}
+
+func cloneWorkspace(workspace *Workspace) *Workspace {
+	c := *workspace
+	return &c
+}
+
+func cloneWorkspaceMember(member *WorkspaceMember) *WorkspaceMember {
+	c := *member
+	return &c
+}
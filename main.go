@@ -1,33 +1,91 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"todoapp/config"
 	"todoapp/controllers"
 	"todoapp/entity"
 	"todoapp/routes"
+	"todoapp/storage"
+
+	// Embed the time zone database so user time zones resolve on hosts
+	// without one.
+	_ "time/tzdata"
 )
 
-func createDefaultUser(userModel *entity.UserModel, username, password, role string) (*entity.User, error) {
-	user, err := userModel.GetByUsername(username)
+// defaultWorkspace returns the oldest workspace, creating one with every
+// existing user in it if there is none yet. Users with the admin role become
+// its admins.
+func defaultWorkspace(workspaceModel entity.WorkspaceStore, userModel entity.UserStore) (*entity.Workspace, error) {
+	workspaces, err := workspaceModel.GetAll()
 	if err != nil {
-		user = &entity.User{
-			Username: username,
-			Role:     role,
-			Password: password,
-		}
-		if err := userModel.Create(user); err != nil {
+		return nil, err
+	}
+	if len(workspaces) > 0 {
+		return workspaces[0], nil
+	}
+
+	workspace := &entity.Workspace{Name: "Default"}
+	if err := workspaceModel.Create(workspace); err != nil {
+		return nil, err
+	}
+	users, err := userModel.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if err := addDefaultMember(workspaceModel, workspace.ID, user); err != nil {
 			return nil, err
 		}
-		log.Printf("Default %s user created successfully", role)
 	}
-	return user, nil
+	log.Printf("Workspace '%s' created successfully", workspace.Name)
+	return workspace, nil
 }
 
-func createDefaultTodo(todoModel *entity.TodoModel, title, description string, userID int) (*entity.Todo, error) {
+func addDefaultMember(workspaceModel entity.WorkspaceStore, workspaceID int, user *entity.User) error {
+	role := entity.WorkspaceRoleMember
+	if user.Role == "admin" {
+		role = entity.WorkspaceRoleAdmin
+	}
+	return workspaceModel.AddMember(&entity.WorkspaceMember{
+		WorkspaceID: workspaceID,
+		UserID:      user.ID,
+		Role:        role,
+	})
+}
+
+func createDefaultUser(userModel entity.UserStore, username, password, role string) (*entity.User, bool, error) {
+	user, err := userModel.GetByUsername(username)
+	if err == nil {
+		return user, false, nil
+	}
+
+	user = &entity.User{
+		Username: username,
+		Role:     role,
+		Password: password,
+	}
+	if err := userModel.Create(user); err != nil {
+		return nil, false, err
+	}
+	log.Printf("Default %s user created successfully", role)
+	return user, true, nil
+}
+
+func createDefaultTodo(todoModel entity.TodoStore, title, description string, workspaceID, userID int) (*entity.Todo, error) {
 	todo := &entity.Todo{
 		Title:       title,
 		Description: description,
 		UserID:      userID,
+		WorkspaceID: workspaceID,
 	}
 	if err := todoModel.Create(todo); err != nil {
 		return nil, err
@@ -36,7 +94,7 @@ func createDefaultTodo(todoModel *entity.TodoModel, title, description string, u
 	return todo, nil
 }
 
-func createDefaultTodoItem(todoItemModel *entity.TodoItemModel, title, description string, todoID, userID int) error {
+func createDefaultTodoItem(todoItemModel entity.TodoItemStore, title, description string, todoID, userID int) error {
 	todoItem := &entity.TodoItem{
 		Title:       title,
 		Description: description,
@@ -50,61 +108,324 @@ func createDefaultTodoItem(todoItemModel *entity.TodoItemModel, title, descripti
 	return nil
 }
 
-func initializeDefaultData(userModel *entity.UserModel, todoModel *entity.TodoModel, todoItemModel *entity.TodoItemModel) error {
-	adminUser, err := createDefaultUser(userModel, "admin", "admin123", "admin")
+func initializeDefaultData(workspaceModel entity.WorkspaceStore, userModel entity.UserStore, todoModel entity.TodoStore, todoItemModel entity.TodoItemStore) error {
+	workspace, err := defaultWorkspace(workspaceModel, userModel)
 	if err != nil {
 		return err
 	}
 
-	adminTodo, err := createDefaultTodo(todoModel, "Admin Todo", "This is admin's todo", adminUser.ID)
+	// Sample todos are only seeded alongside a freshly created user, so a
+	// persistent store does not collect duplicates on every restart.
+	adminUser, created, err := createDefaultUser(userModel, "admin", "admin123", "admin")
 	if err != nil {
 		return err
 	}
 
-	if err := createDefaultTodoItem(todoItemModel, "Admin Todo Item", "This is admin's todo item", adminTodo.ID, adminUser.ID); err != nil {
-		return err
+	if created {
+		if err := addDefaultMember(workspaceModel, workspace.ID, adminUser); err != nil {
+			return err
+		}
+
+		adminTodo, err := createDefaultTodo(todoModel, "Admin Todo", "This is admin's todo", workspace.ID, adminUser.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := createDefaultTodoItem(todoItemModel, "Admin Todo Item", "This is admin's todo item", adminTodo.ID, adminUser.ID); err != nil {
+			return err
+		}
 	}
 
-	normalUser, err := createDefaultUser(userModel, "user", "user123", "user")
+	normalUser, created, err := createDefaultUser(userModel, "user", "user123", "user")
 	if err != nil {
 		return err
 	}
 
-	normalTodo, err := createDefaultTodo(todoModel, "User Todo", "This is normal user's todo", normalUser.ID)
+	if created {
+		if err := addDefaultMember(workspaceModel, workspace.ID, normalUser); err != nil {
+			return err
+		}
+
+		normalTodo, err := createDefaultTodo(todoModel, "User Todo", "This is normal user's todo", workspace.ID, normalUser.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := createDefaultTodoItem(todoItemModel, "User Todo Item", "This is normal user's todo item", normalTodo.ID, normalUser.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func openDB(cfg config.Config) (*storage.DB, error) {
+	pool := storage.PoolConfig{
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: cfg.DBConnMaxLifetime,
+		QueryTimeout:    cfg.DBQueryTimeout,
+	}
+
+	switch cfg.StorageDriver {
+	case config.StorageSQLite:
+		log.Printf("Using SQLite storage at %s", cfg.SQLitePath)
+		return storage.OpenSQLite(cfg.SQLitePath, pool)
+	case config.StoragePostgres:
+		if cfg.DatabaseURL == "" {
+			return nil, errors.New("DATABASE_URL is required for the postgres driver")
+		}
+		log.Println("Using PostgreSQL storage")
+		return storage.OpenPostgres(cfg.DatabaseURL, pool)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
+
+// stores bundles the storage backends selected by the configuration.
+type stores struct {
+	users         entity.UserStore
+	todos         entity.TodoStore
+	todoItems     entity.TodoItemStore
+	tags          entity.TagStore
+	comments      entity.CommentStore
+	attachments   entity.AttachmentStore
+	collaborators entity.CollaboratorStore
+	workspaces    entity.WorkspaceStore
+	unitOfWork    entity.UnitOfWork
+	close         func()
+}
+
+// openMemoryStores returns the map-based models, wrapped in a journal when
+// WAL_DIR is set so that their contents survive restarts and crashes.
+func openMemoryStores(cfg config.Config) (*stores, error) {
+	todoItemModel := entity.NewTodoItemModel()
+	todoModel := entity.NewTodoModel(todoItemModel)
+	tagModel := entity.NewTagModel()
+	commentModel := entity.NewCommentModel()
+	attachmentModel := entity.NewAttachmentModel()
+	collaboratorModel := entity.NewCollaboratorModel()
+	workspaceModel := entity.NewWorkspaceModel()
+	userModel := entity.NewUserModel(todoModel, tagModel, collaboratorModel, workspaceModel)
+
+	if cfg.WALDir == "" {
+		return &stores{
+			users:         userModel,
+			todos:         todoModel,
+			todoItems:     todoItemModel,
+			tags:          tagModel,
+			comments:      commentModel,
+			attachments:   attachmentModel,
+			collaborators: collaboratorModel,
+			workspaces:    workspaceModel,
+			unitOfWork:    entity.NewUnitOfWork(userModel, todoModel, todoItemModel, tagModel, commentModel, attachmentModel, collaboratorModel, workspaceModel),
+			close:         func() {},
+		}, nil
+	}
+
+	journal, err := entity.OpenJournal(cfg.WALDir, userModel, todoModel, todoItemModel, tagModel, commentModel, attachmentModel, collaboratorModel, workspaceModel)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	journal.StartSnapshots(cfg.WALSnapshotInterval)
+	log.Printf("Using in-memory storage with write-ahead log in %s", cfg.WALDir)
+
+	return &stores{
+		users:         journal.UserStore(),
+		todos:         journal.TodoStore(),
+		todoItems:     journal.TodoItemStore(),
+		tags:          journal.TagStore(),
+		comments:      journal.CommentStore(),
+		attachments:   journal.AttachmentStore(),
+		collaborators: journal.CollaboratorStore(),
+		workspaces:    journal.WorkspaceStore(),
+		unitOfWork:    journal.UnitOfWork(),
+		close: func() {
+			if err := journal.Close(); err != nil {
+				log.Printf("Failed to close journal: %v", err)
+			}
+		},
+	}, nil
+}
+
+func openStores(cfg config.Config) (*stores, error) {
+	if cfg.StorageDriver == config.StorageMemory {
+		return openMemoryStores(cfg)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DBAutoMigrate {
+		if err := storage.Migrate(db); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
 	}
 
-	if err := createDefaultTodoItem(todoItemModel, "User Todo Item", "This is normal user's todo item", normalTodo.ID, normalUser.ID); err != nil {
+	return &stores{
+		users:         storage.NewUserStore(db),
+		todos:         storage.NewTodoStore(db),
+		todoItems:     storage.NewTodoItemStore(db),
+		tags:          storage.NewTagStore(db),
+		comments:      storage.NewCommentStore(db),
+		attachments:   storage.NewAttachmentStore(db),
+		collaborators: storage.NewCollaboratorStore(db),
+		workspaces:    storage.NewWorkspaceStore(db),
+		unitOfWork:    storage.NewUnitOfWork(db),
+		close:         func() { db.Close() },
+	}, nil
+}
+
+// runMigrations implements the "migrate" subcommand, which applies pending
+// schema migrations and exits without starting the server.
+func runMigrations(cfg config.Config) error {
+	if cfg.StorageDriver == config.StorageMemory {
+		return errors.New("the memory driver has no schema to migrate")
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	return nil
+	return storage.Migrate(db)
 }
 
+// shutdownTimeout is how long requests in flight get to finish once the
+// server has been told to stop.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	userModel := entity.NewUserModel()
-	todoModel := entity.NewTodoModel()
-	todoItemModel := entity.NewTodoItemModel()
+	cfg := config.Load()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrations(cfg); err != nil {
+			log.Fatalf("Failed to apply migrations: %v", err)
+		}
+		log.Println("Migrations applied successfully")
+		return
+	}
+
+	if err := (entity.UserDeletePolicy{Action: cfg.UserDeletePolicy}).Validate(); err != nil {
+		log.Fatalf("Invalid USER_DELETE_POLICY %q", cfg.UserDeletePolicy)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := initializeDefaultData(userModel, todoModel, todoItemModel); err != nil {
-		log.Fatalf("Failed to initialize default data: %v", err)
+	if err := run(ctx, cfg); err != nil {
+		log.Fatalf("Server stopped: %v", err)
 	}
+	log.Println("Server stopped")
+}
 
-	authController := controllers.NewAuthController(userModel)
-	userController := controllers.NewUserController(userModel)
-	todoController := controllers.NewTodoController(todoModel)
-	todoItemController := controllers.NewTodoItemController(todoItemModel, todoModel)
+// run serves the API until ctx is done and then shuts down gracefully. It
+// returns errors rather than exiting so that its deferred calls always run:
+// they stop the background jobs and close the stores, which for the journal
+// writes a final snapshot.
+func run(ctx context.Context, cfg config.Config) error {
+	st, err := openStores(cfg)
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer st.close()
+
+	// Everything below goes through the search index's stores so that it
+	// sees every change to a todo or item's text.
+	var searchIndex *entity.SearchIndex
+	if cfg.SearchIndex {
+		searchIndex = entity.NewSearchIndex(st.todos, st.todoItems)
+		if err := searchIndex.Build(st.workspaces); err != nil {
+			return fmt.Errorf("build search index: %w", err)
+		}
+		st.todos = searchIndex.TodoStore(st.todos)
+		st.todoItems = searchIndex.TodoItemStore(st.todoItems)
+		st.unitOfWork = searchIndex.UnitOfWork(st.unitOfWork)
+	} else {
+		log.Println("Search is disabled; set SEARCH_INDEX=true if this is the only instance using the database")
+	}
+
+	if err := initializeDefaultData(st.workspaces, st.users, st.todos, st.todoItems); err != nil {
+		return fmt.Errorf("initialize default data: %w", err)
+	}
+
+	blobs, err := storage.NewLocalBlobStore(cfg.AttachmentDir)
+	if err != nil {
+		return fmt.Errorf("open attachment storage: %w", err)
+	}
+
+	janitor := entity.NewJanitor(st.todos, st.todoItems, st.comments, st.attachments, st.collaborators, blobs, st.unitOfWork, cfg.PurgeRetention)
+	if cfg.PurgeRetention > 0 {
+		janitor.Start(cfg.PurgeInterval)
+		defer janitor.Stop()
+	}
+
+	scheduler := entity.NewScheduler(st.todos, st.todoItems, st.unitOfWork, cfg.RecurrenceHorizon)
+	if cfg.RecurrenceHorizon > 0 {
+		scheduler.Start(cfg.RecurrenceInterval)
+		defer scheduler.Stop()
+	}
+
+	authController := controllers.NewAuthController(st.users)
+	userController := controllers.NewUserController(st.users, st.workspaces, st.unitOfWork, cfg.UserDeletePolicy)
+	todoController := controllers.NewTodoController(st.todos, st.users, st.comments, st.collaborators, st.unitOfWork)
+	todoItemController := controllers.NewTodoItemController(st.todoItems, st.todos, st.users, st.collaborators, st.unitOfWork, cfg.MaxItemDepth)
+	trashController := controllers.NewTrashController(st.todos, st.todoItems, janitor)
+	searchController := controllers.NewSearchController(searchIndex, st.collaborators)
+	batchController := controllers.NewBatchController(st.todos, st.todoItems, st.unitOfWork, cfg.MaxItemDepth)
+	agendaController := controllers.NewAgendaController(st.users, st.todos, st.todoItems)
+	tagController := controllers.NewTagController(st.tags, st.todos, st.todoItems, st.unitOfWork)
+	commentController := controllers.NewCommentController(st.comments, st.todos, st.todoItems, st.collaborators, st.unitOfWork)
+	attachmentController := controllers.NewAttachmentController(st.attachments, blobs, st.todos, st.todoItems, st.collaborators, st.unitOfWork, entity.UploadLimits{
+		MaxSize:      cfg.MaxAttachmentSize,
+		AllowedTypes: cfg.AttachmentTypes,
+	})
+	collaboratorController := controllers.NewCollaboratorController(st.collaborators, st.todos, st.users, st.unitOfWork)
+	workspaceController := controllers.NewWorkspaceController(st.workspaces, st.users, st.unitOfWork)
 
 	r := routes.SetupRoutes(
 		authController,
 		userController,
 		todoController,
 		todoItemController,
+		trashController,
+		searchController,
+		batchController,
+		agendaController,
+		tagController,
+		commentController,
+		attachmentController,
+		collaboratorController,
+		workspaceController,
+		st.workspaces,
 	)
 
-	log.Println("Server starting on :8080")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: r,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Println("Server starting on :8080")
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
 	}
-}
\ No newline at end of file
+	return nil
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"strings"
@@ -13,8 +14,8 @@ import (
 var SecretKey string
 
 func init() {
-	err := godotenv.Load()
-	if err != nil {
+	// The .env file is optional when the environment already has the secret
+	if err := godotenv.Load(); err != nil && os.Getenv("JWT_SECRET") == "" {
 		panic("Error loading .env file")
 	}
 
@@ -40,33 +41,57 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := parts[1]
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(SecretKey), nil
-		})
-
+		claims, err := parseToken(parts[1])
 		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			ctx.Abort()
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			userID := int(claims["user_id"].(float64))
-			userRole := claims["role"].(string)
+		ctx.Set("user_id", int(claims["user_id"].(float64)))
+		ctx.Set("user_role", claims["role"].(string))
+		ctx.Next()
+	}
+}
 
-			ctx.Set("user_id", userID)
-			ctx.Set("user_role", userRole)
-			ctx.Next()
-		} else {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
-			ctx.Abort()
-			return
+// OptionalAuth sets user_id and user_role like AuthMiddleware when the
+// request carries a valid token, and lets it through anonymously otherwise.
+// It is for routes that anyone may call but that do more for admins.
+func OptionalAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		parts := strings.Split(ctx.GetHeader("Authorization"), " ")
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			if claims, err := parseToken(parts[1]); err == nil {
+				ctx.Set("user_id", int(claims["user_id"].(float64)))
+				ctx.Set("user_role", claims["role"].(string))
+			}
 		}
+		ctx.Next()
+	}
+}
+
+func parseToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(SecretKey), nil
+	})
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token claims")
+	}
+	if _, ok := claims["user_id"].(float64); !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	if _, ok := claims["role"].(string); !ok {
+		return nil, errors.New("invalid token claims")
 	}
+	return claims, nil
 }
 
 func AdminOnly() gin.HandlerFunc {
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"todoapp/entity"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkspaceMiddleware picks the workspace a request works in: the one named
+// in the X-Workspace-ID header, or else the oldest one the caller is a
+// member of. It sets workspace_id and workspace_role, the caller's
+// entity.WorkspaceRole there. Admins of the instance are admins of every
+// workspace, member or not. It must run after AuthMiddleware.
+func WorkspaceMiddleware(workspaces entity.WorkspaceStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, _ := ctx.Get("user_id")
+		userRole, _ := ctx.Get("user_role")
+		id, _ := userID.(int)
+		admin := userRole == "admin"
+
+		var workspace *entity.Workspace
+		if header := ctx.GetHeader("X-Workspace-ID"); header != "" {
+			workspaceID, err := strconv.Atoi(header)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid X-Workspace-ID header"})
+				ctx.Abort()
+				return
+			}
+			if workspace, err = workspaces.GetByID(workspaceID); err != nil {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "workspace not found"})
+				ctx.Abort()
+				return
+			}
+		} else {
+			candidates, err := workspaces.GetByUserID(id)
+			if err == nil && len(candidates) == 0 && admin {
+				candidates, err = workspaces.GetAll()
+			}
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				ctx.Abort()
+				return
+			}
+			if len(candidates) == 0 {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": "not a member of any workspace"})
+				ctx.Abort()
+				return
+			}
+			workspace = candidates[0]
+		}
+
+		role := entity.WorkspaceRoleAdmin
+		if !admin {
+			// Workspaces the caller isn't in don't exist as far as they
+			// are concerned.
+			member, err := workspaces.GetMember(workspace.ID, id)
+			if err != nil {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "workspace not found"})
+				ctx.Abort()
+				return
+			}
+			role = member.Role
+		}
+
+		ctx.Set("workspace_id", workspace.ID)
+		ctx.Set("workspace_role", role)
+		ctx.Next()
+	}
+}
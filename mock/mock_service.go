@@ -5,20 +5,37 @@ import (
 )
 
 type MockService struct {
-	todoModel     *entity.TodoModel
-	userModel     *entity.UserModel
-	todoItemModel *entity.TodoItemModel
+	todoModel         entity.TodoStore
+	userModel         entity.UserStore
+	todoItemModel     entity.TodoItemStore
+	tagModel          entity.TagStore
+	commentModel      entity.CommentStore
+	attachmentModel   entity.AttachmentStore
+	collaboratorModel entity.CollaboratorStore
+	workspaceModel    entity.WorkspaceStore
+	unitOfWork        entity.UnitOfWork
 }
 
 func NewMockService() *MockService {
-	todoModel := entity.NewTodoModel()
-	userModel := entity.NewUserModel()
 	todoItemModel := entity.NewTodoItemModel()
+	todoModel := entity.NewTodoModel(todoItemModel)
+	tagModel := entity.NewTagModel()
+	commentModel := entity.NewCommentModel()
+	attachmentModel := entity.NewAttachmentModel()
+	collaboratorModel := entity.NewCollaboratorModel()
+	workspaceModel := entity.NewWorkspaceModel()
+	userModel := entity.NewUserModel(todoModel, tagModel, collaboratorModel, workspaceModel)
 
 	service := &MockService{
-		todoModel:     todoModel,
-		userModel:     userModel,
-		todoItemModel: todoItemModel,
+		todoModel:         todoModel,
+		userModel:         userModel,
+		todoItemModel:     todoItemModel,
+		tagModel:          tagModel,
+		commentModel:      commentModel,
+		attachmentModel:   attachmentModel,
+		collaboratorModel: collaboratorModel,
+		workspaceModel:    workspaceModel,
+		unitOfWork:        entity.NewUnitOfWork(userModel, todoModel, todoItemModel, tagModel, commentModel, attachmentModel, collaboratorModel, workspaceModel),
 	}
 
 	service.createMockData()
@@ -26,18 +43,42 @@ func NewMockService() *MockService {
 	return service
 }
 
-func (s *MockService) GetTodoModel() *entity.TodoModel {
+func (s *MockService) GetTodoModel() entity.TodoStore {
 	return s.todoModel
 }
 
-func (s *MockService) GetUserModel() *entity.UserModel {
+func (s *MockService) GetUserModel() entity.UserStore {
 	return s.userModel
 }
 
-func (s *MockService) GetTodoItemModel() *entity.TodoItemModel {
+func (s *MockService) GetTodoItemModel() entity.TodoItemStore {
 	return s.todoItemModel
 }
 
+func (s *MockService) GetTagModel() entity.TagStore {
+	return s.tagModel
+}
+
+func (s *MockService) GetCommentModel() entity.CommentStore {
+	return s.commentModel
+}
+
+func (s *MockService) GetAttachmentModel() entity.AttachmentStore {
+	return s.attachmentModel
+}
+
+func (s *MockService) GetCollaboratorModel() entity.CollaboratorStore {
+	return s.collaboratorModel
+}
+
+func (s *MockService) GetWorkspaceModel() entity.WorkspaceStore {
+	return s.workspaceModel
+}
+
+func (s *MockService) GetUnitOfWork() entity.UnitOfWork {
+	return s.unitOfWork
+}
+
 func (s *MockService) createMockData() {
 	adminUser := &entity.User{
 		Username: "admin",
@@ -53,10 +94,24 @@ func (s *MockService) createMockData() {
 	}
 	s.userModel.Create(normalUser)
 
+	workspace := &entity.Workspace{Name: "Default"}
+	s.workspaceModel.Create(workspace)
+	s.workspaceModel.AddMember(&entity.WorkspaceMember{
+		WorkspaceID: workspace.ID,
+		UserID:      adminUser.ID,
+		Role:        entity.WorkspaceRoleAdmin,
+	})
+	s.workspaceModel.AddMember(&entity.WorkspaceMember{
+		WorkspaceID: workspace.ID,
+		UserID:      normalUser.ID,
+		Role:        entity.WorkspaceRoleMember,
+	})
+
 	adminTodo1 := &entity.Todo{
 		Title:       "Admin Todo 1",
 		Description: "Admin's first todo",
 		UserID:      1,
+		WorkspaceID: workspace.ID,
 	}
 	s.todoModel.Create(adminTodo1)
 
@@ -64,6 +119,7 @@ func (s *MockService) createMockData() {
 		Title:       "Admin Todo 2",
 		Description: "Admin's second todo",
 		UserID:      1,
+		WorkspaceID: workspace.ID,
 	}
 	s.todoModel.Create(adminTodo2)
 
@@ -71,6 +127,7 @@ func (s *MockService) createMockData() {
 		Title:       "User Todo 1",
 		Description: "User's first todo",
 		UserID:      2,
+		WorkspaceID: workspace.ID,
 	}
 	s.todoModel.Create(userTodo1)
 
@@ -78,6 +135,7 @@ func (s *MockService) createMockData() {
 		Title:       "User Todo 2",
 		Description: "User's second todo",
 		UserID:      2,
+		WorkspaceID: workspace.ID,
 	}
 	s.todoModel.Create(userTodo2)
 
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"todoapp/controllers"
+	"todoapp/entity"
 	"todoapp/middleware"
 
 	"github.com/gin-gonic/gin"
@@ -12,9 +13,23 @@ func SetupRoutes(
 	userController *controllers.UserController,
 	todoController *controllers.TodoController,
 	todoItemController *controllers.TodoItemController,
+	trashController *controllers.TrashController,
+	searchController *controllers.SearchController,
+	batchController *controllers.BatchController,
+	agendaController *controllers.AgendaController,
+	tagController *controllers.TagController,
+	commentController *controllers.CommentController,
+	attachmentController *controllers.AttachmentController,
+	collaboratorController *controllers.CollaboratorController,
+	workspaceController *controllers.WorkspaceController,
+	workspaceStore entity.WorkspaceStore,
 ) *gin.Engine {
 	r := gin.Default()
 
+	// Everything about todos, and looking up other users, happens inside
+	// the workspace picked by WorkspaceMiddleware.
+	inWorkspace := middleware.WorkspaceMiddleware(workspaceStore)
+
 	r.Use(middleware.CORSMiddleware())
 
 	r.POST("/login", authController.Login)
@@ -23,25 +38,37 @@ func SetupRoutes(
 	{
 		users := api.Group("/users")
 		{
-			users.POST("", userController.Create)
+			users.POST("", middleware.OptionalAuth(), userController.Create)
 			users.GET("", middleware.AuthMiddleware(), middleware.AdminOnly(), userController.GetAll)
-			users.GET("/:id", middleware.AuthMiddleware(), userController.GetByID)
-			users.GET("/username/:username", middleware.AuthMiddleware(), userController.GetByUsername)
+			users.GET("/:id", middleware.AuthMiddleware(), inWorkspace, userController.GetByID)
+			users.GET("/username/:username", middleware.AuthMiddleware(), inWorkspace, userController.GetByUsername)
 			users.PUT("/:id", middleware.AuthMiddleware(), userController.Update)
+			users.PATCH("/:id", middleware.AuthMiddleware(), userController.Patch)
 			users.DELETE("/:id", middleware.AuthMiddleware(), middleware.AdminOnly(), userController.Delete)
 		}
 
 		// Todo routes
 		todos := api.Group("/todos")
-		todos.Use(middleware.AuthMiddleware())
+		todos.Use(middleware.AuthMiddleware(), inWorkspace)
 		{
 			// Todo item routes
 			items := todos.Group("/items")
 			{
 				items.POST("/:todo_id", todoItemController.Create)
 				items.GET("/:todo_id", todoItemController.GetByTodoID)
+				items.GET("/:todo_id/tree", todoItemController.Tree)
 				items.PUT("/:todo_id/:item_id", todoItemController.Update)
+				items.PATCH("/:todo_id/:item_id", todoItemController.Patch)
 				items.DELETE("/:todo_id/:item_id", todoItemController.Delete)
+				items.POST("/:todo_id/:item_id/restore", todoItemController.Restore)
+				items.POST("/:todo_id/:item_id/move", todoItemController.Move)
+				items.POST("/:todo_id/:item_id/skip", todoItemController.Skip)
+				items.GET("/:todo_id/:item_id/comments", commentController.GetItemComments)
+				items.POST("/:todo_id/:item_id/comments", commentController.CreateItemComment)
+				items.GET("/:todo_id/:item_id/attachments", attachmentController.GetByItemID)
+				items.POST("/:todo_id/:item_id/attachments", attachmentController.Upload)
+				items.GET("/:todo_id/:item_id/attachments/:attachment_id", attachmentController.Download)
+				items.DELETE("/:todo_id/:item_id/attachments/:attachment_id", attachmentController.Delete)
 			}
 
 			// Todo routes
@@ -49,8 +76,70 @@ func SetupRoutes(
 			todos.GET("", todoController.GetAll)
 			todos.GET("/:id", todoController.GetByID)
 			todos.PUT("/:id", todoController.Update)
+			todos.PATCH("/:id", todoController.Patch)
 			todos.DELETE("/:id", todoController.Delete)
+			todos.POST("/:id/restore", todoController.Restore)
+			todos.POST("/:id/skip", todoController.Skip)
+			todos.GET("/:id/comments", commentController.GetTodoComments)
+			todos.POST("/:id/comments", commentController.CreateTodoComment)
+			todos.GET("/:id/collaborators", collaboratorController.GetByTodoID)
+			todos.POST("/:id/collaborators", collaboratorController.Invite)
+			todos.PUT("/:id/collaborators/:user_id", collaboratorController.Update)
+			todos.PATCH("/:id/collaborators/:user_id", collaboratorController.Update)
+			todos.DELETE("/:id/collaborators/:user_id", collaboratorController.Delete)
+		}
+
+		api.GET("/trash", middleware.AuthMiddleware(), inWorkspace, trashController.GetAll)
+		api.POST("/trash/purge", middleware.AuthMiddleware(), middleware.AdminOnly(), trashController.Purge)
+
+		tags := api.Group("/tags")
+		tags.Use(middleware.AuthMiddleware(), inWorkspace)
+		{
+			tags.GET("", tagController.GetAll)
+			tags.POST("", tagController.Create)
+			tags.GET("/:id", tagController.GetByID)
+			tags.PUT("/:id", tagController.Update)
+			tags.PATCH("/:id", tagController.Update)
+			tags.DELETE("/:id", tagController.Delete)
+			tags.POST("/:id/merge", tagController.Merge)
+		}
+
+		comments := api.Group("/comments")
+		comments.Use(middleware.AuthMiddleware(), inWorkspace)
+		{
+			comments.GET("/:id", commentController.GetByID)
+			comments.PUT("/:id", commentController.Update)
+			comments.PATCH("/:id", commentController.Update)
+			comments.DELETE("/:id", commentController.Delete)
 		}
+
+		invitations := api.Group("/invitations")
+		invitations.Use(middleware.AuthMiddleware())
+		{
+			invitations.GET("", collaboratorController.GetInvitations)
+			invitations.POST("/:id/accept", collaboratorController.Accept)
+			invitations.POST("/:id/decline", collaboratorController.Decline)
+		}
+
+		workspaces := api.Group("/workspaces")
+		workspaces.Use(middleware.AuthMiddleware())
+		{
+			workspaces.GET("", workspaceController.GetAll)
+			workspaces.POST("", workspaceController.Create)
+			workspaces.GET("/:id", workspaceController.GetByID)
+			workspaces.PUT("/:id", workspaceController.Update)
+			workspaces.PATCH("/:id", workspaceController.Update)
+			workspaces.DELETE("/:id", workspaceController.Delete)
+			workspaces.GET("/:id/members", workspaceController.GetMembers)
+			workspaces.POST("/:id/members", workspaceController.AddMember)
+			workspaces.PUT("/:id/members/:user_id", workspaceController.UpdateMember)
+			workspaces.PATCH("/:id/members/:user_id", workspaceController.UpdateMember)
+			workspaces.DELETE("/:id/members/:user_id", workspaceController.RemoveMember)
+		}
+
+		api.GET("/search", middleware.AuthMiddleware(), inWorkspace, searchController.Search)
+		api.POST("/batch", middleware.AuthMiddleware(), inWorkspace, batchController.Run)
+		api.GET("/agenda", middleware.AuthMiddleware(), inWorkspace, agendaController.Get)
 	}
 
 	return r
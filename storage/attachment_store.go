@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"todoapp/entity"
+)
+
+const attachmentColumns = "id, todo_id, item_id, user_id, file_name, content_type, size, sha256, storage_key, created_at"
+
+type AttachmentStore struct {
+	db *DB
+}
+
+var _ entity.AttachmentStore = (*AttachmentStore)(nil)
+
+func NewAttachmentStore(db *DB) *AttachmentStore {
+	return &AttachmentStore{db: db}
+}
+
+func scanAttachment(row scanner) (*entity.Attachment, error) {
+	attachment := &entity.Attachment{}
+	if err := row.Scan(&attachment.ID, &attachment.TodoID, &attachment.ItemID, &attachment.UserID, &attachment.FileName, &attachment.ContentType, &attachment.Size, &attachment.SHA256, &attachment.StorageKey, &attachment.CreatedAt); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *AttachmentStore) Create(attachment *entity.Attachment) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO attachments (todo_id, item_id, user_id, file_name, content_type, size, sha256, storage_key, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		attachment.TodoID, attachment.ItemID, attachment.UserID, attachment.FileName, attachment.ContentType, attachment.Size, attachment.SHA256, attachment.StorageKey, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	attachment.ID = id
+	attachment.CreatedAt = now
+	return nil
+}
+
+func (s *AttachmentStore) GetByID(id int) (*entity.Attachment, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	attachment, err := scanAttachment(s.db.queryRow(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrAttachmentNotFound
+	}
+	return attachment, err
+}
+
+// GetByItemID returns the attachments of an item, oldest first.
+func (s *AttachmentStore) GetByItemID(itemID int) ([]*entity.Attachment, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE item_id = ? ORDER BY id", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*entity.Attachment, 0)
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+func (s *AttachmentStore) Delete(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "DELETE FROM attachments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrAttachmentNotFound
+	}
+	return nil
+}
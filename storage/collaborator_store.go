@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"todoapp/entity"
+)
+
+const collaboratorColumns = "id, todo_id, user_id, role, invited_by, version, created_at, updated_at, accepted_at"
+
+type CollaboratorStore struct {
+	db *DB
+}
+
+var _ entity.CollaboratorStore = (*CollaboratorStore)(nil)
+
+func NewCollaboratorStore(db *DB) *CollaboratorStore {
+	return &CollaboratorStore{db: db}
+}
+
+func scanCollaborator(row scanner) (*entity.Collaborator, error) {
+	collaborator := &entity.Collaborator{}
+	var acceptedAt sql.NullTime
+	if err := row.Scan(&collaborator.ID, &collaborator.TodoID, &collaborator.UserID, &collaborator.Role, &collaborator.InvitedBy, &collaborator.Version, &collaborator.CreatedAt, &collaborator.UpdatedAt, &acceptedAt); err != nil {
+		return nil, err
+	}
+	if acceptedAt.Valid {
+		collaborator.AcceptedAt = &acceptedAt.Time
+	}
+	return collaborator, nil
+}
+
+func (s *CollaboratorStore) getCollaborator(query string, args ...interface{}) (*entity.Collaborator, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	collaborator, err := scanCollaborator(s.db.queryRow(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrCollaboratorNotFound
+	}
+	return collaborator, err
+}
+
+func (s *CollaboratorStore) listCollaborators(query string, args ...interface{}) ([]*entity.Collaborator, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := make([]*entity.Collaborator, 0)
+	for rows.Next() {
+		collaborator, err := scanCollaborator(rows)
+		if err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+	return collaborators, rows.Err()
+}
+
+func (s *CollaboratorStore) Create(collaborator *entity.Collaborator) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO collaborators (todo_id, user_id, role, invited_by, created_at, updated_at, accepted_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		collaborator.TodoID, collaborator.UserID, collaborator.Role, collaborator.InvitedBy, now, now, collaborator.AcceptedAt,
+	)
+	if err != nil {
+		if s.db.dialect.isUniqueViolation(err) {
+			return entity.ErrCollaboratorExists
+		}
+		return err
+	}
+
+	collaborator.ID = id
+	collaborator.CreatedAt = now
+	collaborator.UpdatedAt = now
+	collaborator.Version = 1
+	return nil
+}
+
+func (s *CollaboratorStore) GetByID(id int) (*entity.Collaborator, error) {
+	return s.getCollaborator("SELECT "+collaboratorColumns+" FROM collaborators WHERE id = ?", id)
+}
+
+func (s *CollaboratorStore) GetByTodoAndUser(todoID, userID int) (*entity.Collaborator, error) {
+	return s.getCollaborator("SELECT "+collaboratorColumns+" FROM collaborators WHERE todo_id = ? AND user_id = ?", todoID, userID)
+}
+
+func (s *CollaboratorStore) GetByTodoID(todoID int) ([]*entity.Collaborator, error) {
+	return s.listCollaborators("SELECT "+collaboratorColumns+" FROM collaborators WHERE todo_id = ? ORDER BY id", todoID)
+}
+
+func (s *CollaboratorStore) GetByUserID(userID int) ([]*entity.Collaborator, error) {
+	return s.listCollaborators("SELECT "+collaboratorColumns+" FROM collaborators WHERE user_id = ? ORDER BY id", userID)
+}
+
+// Update saves the role and accepted_at of a collaborator.
+func (s *CollaboratorStore) Update(collaborator *entity.Collaborator) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	updated, err := scanCollaborator(s.db.queryRow(ctx,
+		"UPDATE collaborators SET role = ?, accepted_at = ?, updated_at = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?) RETURNING "+collaboratorColumns,
+		collaborator.Role, collaborator.AcceptedAt, time.Now(), collaborator.ID, collaborator.Version, collaborator.Version,
+	))
+	if err == sql.ErrNoRows {
+		if _, err := s.GetByID(collaborator.ID); err != nil {
+			return err
+		}
+		return entity.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	*collaborator = *updated
+	return nil
+}
+
+func (s *CollaboratorStore) Delete(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "DELETE FROM collaborators WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrCollaboratorNotFound
+	}
+	return nil
+}
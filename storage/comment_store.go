@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"todoapp/entity"
+)
+
+const commentColumns = "id, todo_id, item_id, parent_id, user_id, body, version, created_at, updated_at, edited_at, deleted_at"
+
+type CommentStore struct {
+	db *DB
+}
+
+var _ entity.CommentStore = (*CommentStore)(nil)
+
+func NewCommentStore(db *DB) *CommentStore {
+	return &CommentStore{db: db}
+}
+
+func scanComment(row scanner) (*entity.Comment, error) {
+	comment := &entity.Comment{}
+	var itemID, parentID sql.NullInt64
+	var editedAt, deletedAt sql.NullTime
+	if err := row.Scan(&comment.ID, &comment.TodoID, &itemID, &parentID, &comment.UserID, &comment.Body, &comment.Version, &comment.CreatedAt, &comment.UpdatedAt, &editedAt, &deletedAt); err != nil {
+		return nil, err
+	}
+	comment.ItemID, comment.ParentID = nullInt(itemID), nullInt(parentID)
+	if editedAt.Valid {
+		comment.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		comment.DeletedAt = &deletedAt.Time
+	}
+	return comment, nil
+}
+
+func (s *CommentStore) Create(comment *entity.Comment) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO comments (todo_id, item_id, parent_id, user_id, body, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		comment.TodoID, comment.ItemID, comment.ParentID, comment.UserID, comment.Body, now, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	comment.ID = id
+	comment.CreatedAt = now
+	comment.UpdatedAt = now
+	comment.EditedAt = nil
+	comment.DeletedAt = nil
+	comment.Version = 1
+	return nil
+}
+
+func (s *CommentStore) GetByID(id int) (*entity.Comment, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	comment, err := scanComment(s.db.queryRow(ctx, "SELECT "+commentColumns+" FROM comments WHERE id = ? AND deleted_at IS NULL", id))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrCommentNotFound
+	}
+	return comment, err
+}
+
+// GetByTodoID returns the comments on a todo and its items, deleted ones
+// included, oldest first.
+func (s *CommentStore) GetByTodoID(todoID int) ([]*entity.Comment, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, "SELECT "+commentColumns+" FROM comments WHERE todo_id = ? ORDER BY id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]*entity.Comment, 0)
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// CountByTodoIDs counts the live comments on the todos themselves, leaving
+// out comments on their items.
+func (s *CommentStore) CountByTodoIDs(todoIDs []int) (map[int]int, error) {
+	counts := make(map[int]int)
+	if len(todoIDs) == 0 {
+		return counts, nil
+	}
+
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	placeholders, args := inList(todoIDs)
+
+	rows, err := s.db.query(ctx,
+		"SELECT todo_id, COUNT(*) FROM comments WHERE item_id IS NULL AND deleted_at IS NULL AND todo_id IN ("+placeholders+") GROUP BY todo_id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var todoID, n int
+		if err := rows.Scan(&todoID, &n); err != nil {
+			return nil, err
+		}
+		counts[todoID] = n
+	}
+	return counts, rows.Err()
+}
+
+// Update replaces the body of a live comment and marks it as edited.
+func (s *CommentStore) Update(comment *entity.Comment) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	updated, err := scanComment(s.db.queryRow(ctx,
+		"UPDATE comments SET body = ?, updated_at = ?, edited_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?) RETURNING "+commentColumns,
+		comment.Body, now, now, comment.ID, comment.Version, comment.Version,
+	))
+	if err == sql.ErrNoRows {
+		if _, err := s.GetByID(comment.ID); err != nil {
+			return err
+		}
+		return entity.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	*comment = *updated
+	return nil
+}
+
+// Delete clears the body of a comment and marks it as deleted.
+func (s *CommentStore) Delete(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "UPDATE comments SET body = '', deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrCommentNotFound
+	}
+	return nil
+}
+
+func (s *CommentStore) Purge(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "DELETE FROM comments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrCommentNotFound
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect captures the few places where SQLite and PostgreSQL differ for the
+// queries in this package.
+type dialect struct {
+	name              string
+	numberedParams    bool
+	forUpdate         string
+	isUniqueViolation func(err error) bool
+}
+
+// querier is the subset of *sql.DB and *sql.Tx the stores need.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// DB is a connection pool plus the dialect and per-query timeout used by the
+// stores in this package. Inside a transaction (see UnitOfWork) queries go
+// to the *sql.Tx instead of the pool.
+type DB struct {
+	conn         *sql.DB
+	q            querier
+	ctx          context.Context
+	dialect      dialect
+	queryTimeout time.Duration
+}
+
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	QueryTimeout    time.Duration
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func newDB(conn *sql.DB, d dialect, pool PoolConfig) *DB {
+	if pool.MaxOpenConns > 0 {
+		conn.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		conn.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+
+	return &DB{
+		conn:         conn,
+		q:            conn,
+		ctx:          context.Background(),
+		dialect:      d,
+		queryTimeout: pool.QueryTimeout,
+	}
+}
+
+// inList returns the placeholders and arguments for "IN (...)" over ids.
+func inList(ids []int) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
+
+// withTx returns a copy of db whose queries run inside tx.
+func (db *DB) withTx(ctx context.Context, tx *sql.Tx) *DB {
+	txDB := *db
+	txDB.q = tx
+	txDB.ctx = ctx
+	return &txDB
+}
+
+// inTx runs fn inside a transaction. If db is already bound to one, fn joins
+// it; otherwise a new transaction is started and committed when fn succeeds.
+func (db *DB) inTx(fn func(db *DB) error) error {
+	if _, ok := db.q.(*sql.Tx); ok {
+		return fn(db)
+	}
+
+	ctx, cancel := db.context()
+	defer cancel()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(db.withTx(ctx, tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) Close() error {
+	return db.conn.Close()
+}
+
+// context returns the context a single store call runs under.
+func (db *DB) context() (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return context.WithCancel(db.ctx)
+	}
+	return context.WithTimeout(db.ctx, db.queryTimeout)
+}
+
+// rebind rewrites the "?" placeholders used throughout this package into the
+// "$1, $2, ..." form PostgreSQL expects.
+func (db *DB) rebind(query string) string {
+	if !db.dialect.numberedParams {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (db *DB) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.q.ExecContext(ctx, db.rebind(query), args...)
+}
+
+func (db *DB) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.q.QueryContext(ctx, db.rebind(query), args...)
+}
+
+func (db *DB) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.q.QueryRowContext(ctx, db.rebind(query), args...)
+}
+
+// insert runs an INSERT ... RETURNING id statement and returns the new id.
+func (db *DB) insert(ctx context.Context, query string, args ...interface{}) (int, error) {
+	var id int
+	if err := db.queryRow(ctx, query+" RETURNING id", args...).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"todoapp/entity"
+)
+
+// LocalBlobStore keeps blobs as files below a directory on the local disk,
+// spread over subdirectories named after the first two characters of the
+// key so that no single directory grows too large.
+type LocalBlobStore struct {
+	dir string
+}
+
+var _ entity.BlobStore = (*LocalBlobStore)(nil)
+
+// NewLocalBlobStore creates dir if it doesn't exist yet.
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{dir: dir}, nil
+}
+
+// path returns where the blob with the given key lives. Keys are checked so
+// a bad key can't point outside the directory.
+func (s *LocalBlobStore) path(key string) (string, error) {
+	if !entity.ValidBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, key[:2], key), nil
+}
+
+// Put writes to a temporary file first and renames it into place, so a
+// failed upload never leaves a partial blob under key.
+func (s *LocalBlobStore) Put(key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	tmp := f.Name()
+
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *LocalBlobStore) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, entity.ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
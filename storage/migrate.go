@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// pgMigrationLockKey is an arbitrary constant used with pg_advisory_lock so
+// that replicas starting at the same time don't race to apply migrations.
+const pgMigrationLockKey = 7216340981
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations(dialectName string) ([]migration, error) {
+	dir := path.Join("migrations", dialectName)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version prefix", name)
+		}
+
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: name, sql: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// Migrate applies every embedded migration for the database's dialect that
+// has not been applied yet. Each migration runs in its own transaction and is
+// recorded in the schema_migrations table.
+func Migrate(db *DB) error {
+	ctx := context.Background()
+
+	migrations, err := loadMigrations(db.dialect.name)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if db.dialect.name == postgresDialect.name {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", pgMigrationLockKey); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", pgMigrationLockKey)
+	}
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)"); err != nil {
+		return err
+	}
+
+	var current int
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := applyMigration(ctx, db, conn, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		log.Printf("Applied migration %s", m.name)
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *DB, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, db.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"), m.version, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
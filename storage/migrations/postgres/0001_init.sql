@@ -0,0 +1,35 @@
+CREATE TABLE users (
+	id         SERIAL PRIMARY KEY,
+	username   TEXT NOT NULL UNIQUE,
+	password   TEXT NOT NULL,
+	role       TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE todos (
+	id             SERIAL PRIMARY KEY,
+	title          TEXT NOT NULL,
+	description    TEXT NOT NULL,
+	user_id        INTEGER NOT NULL,
+	completion_pct DOUBLE PRECISION NOT NULL DEFAULT 0,
+	created_at     TIMESTAMPTZ NOT NULL,
+	updated_at     TIMESTAMPTZ NOT NULL,
+	deleted_at     TIMESTAMPTZ
+);
+
+CREATE INDEX idx_todos_user_id ON todos (user_id);
+
+CREATE TABLE todo_items (
+	id          SERIAL PRIMARY KEY,
+	title       TEXT NOT NULL,
+	description TEXT NOT NULL,
+	completed   BOOLEAN NOT NULL DEFAULT FALSE,
+	todo_id     INTEGER NOT NULL,
+	user_id     INTEGER NOT NULL,
+	created_at  TIMESTAMPTZ NOT NULL,
+	updated_at  TIMESTAMPTZ NOT NULL,
+	deleted_at  TIMESTAMPTZ
+);
+
+CREATE INDEX idx_todo_items_todo_id ON todo_items (todo_id);
@@ -0,0 +1,3 @@
+-- Positions are compared byte by byte, whatever the database's locale.
+ALTER TABLE todo_items ADD COLUMN position TEXT COLLATE "C" NOT NULL DEFAULT '';
+CREATE INDEX idx_todo_items_todo_position ON todo_items (todo_id, position);
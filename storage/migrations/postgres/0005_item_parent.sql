@@ -0,0 +1,2 @@
+ALTER TABLE todo_items ADD COLUMN parent_item_id INTEGER;
+CREATE INDEX idx_todo_items_parent ON todo_items (todo_id, parent_item_id);
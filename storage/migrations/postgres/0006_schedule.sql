@@ -0,0 +1,8 @@
+ALTER TABLE todos ADD COLUMN start_at TIMESTAMPTZ;
+ALTER TABLE todos ADD COLUMN due_at TIMESTAMPTZ;
+ALTER TABLE todos ADD COLUMN all_day BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE todo_items ADD COLUMN start_at TIMESTAMPTZ;
+ALTER TABLE todo_items ADD COLUMN due_at TIMESTAMPTZ;
+ALTER TABLE todo_items ADD COLUMN all_day BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN time_zone TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_todo_items_due_at ON todo_items (due_at);
@@ -0,0 +1,2 @@
+ALTER TABLE todos ADD COLUMN priority TEXT NOT NULL DEFAULT '';
+ALTER TABLE todo_items ADD COLUMN priority TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+CREATE TABLE tags (
+	id         SERIAL PRIMARY KEY,
+	user_id    INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	version    INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (user_id, name)
+);
+ALTER TABLE todos ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+ALTER TABLE todo_items ADD COLUMN tags TEXT NOT NULL DEFAULT '';
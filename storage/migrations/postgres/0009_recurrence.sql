@@ -0,0 +1,6 @@
+ALTER TABLE todos ADD COLUMN rrule TEXT NOT NULL DEFAULT '';
+ALTER TABLE todos ADD COLUMN series_id INTEGER;
+ALTER TABLE todos ADD COLUMN occurrence TIMESTAMPTZ;
+ALTER TABLE todo_items ADD COLUMN rrule TEXT NOT NULL DEFAULT '';
+ALTER TABLE todo_items ADD COLUMN series_id INTEGER;
+ALTER TABLE todo_items ADD COLUMN occurrence TIMESTAMPTZ;
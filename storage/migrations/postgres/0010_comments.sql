@@ -0,0 +1,14 @@
+CREATE TABLE comments (
+	id         SERIAL PRIMARY KEY,
+	todo_id    INTEGER NOT NULL,
+	item_id    INTEGER,
+	parent_id  INTEGER,
+	user_id    INTEGER NOT NULL,
+	body       TEXT NOT NULL,
+	version    INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	edited_at  TIMESTAMPTZ,
+	deleted_at TIMESTAMPTZ
+);
+CREATE INDEX idx_comments_todo_id ON comments (todo_id);
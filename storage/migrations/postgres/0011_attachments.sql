@@ -0,0 +1,13 @@
+CREATE TABLE attachments (
+	id           SERIAL PRIMARY KEY,
+	todo_id      INTEGER NOT NULL,
+	item_id      INTEGER NOT NULL,
+	user_id      INTEGER NOT NULL,
+	file_name    TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size         BIGINT NOT NULL,
+	sha256       TEXT NOT NULL,
+	storage_key  TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_attachments_item_id ON attachments (item_id);
@@ -0,0 +1,13 @@
+CREATE TABLE collaborators (
+	id          SERIAL PRIMARY KEY,
+	todo_id     INTEGER NOT NULL,
+	user_id     INTEGER NOT NULL,
+	role        TEXT NOT NULL,
+	invited_by  INTEGER NOT NULL,
+	version     INTEGER NOT NULL DEFAULT 1,
+	created_at  TIMESTAMPTZ NOT NULL,
+	updated_at  TIMESTAMPTZ NOT NULL,
+	accepted_at TIMESTAMPTZ,
+	UNIQUE (todo_id, user_id)
+);
+CREATE INDEX idx_collaborators_user_id ON collaborators (user_id);
@@ -0,0 +1,28 @@
+CREATE TABLE workspaces (
+	id         SERIAL PRIMARY KEY,
+	name       TEXT NOT NULL,
+	version    INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE workspace_members (
+	id           SERIAL PRIMARY KEY,
+	workspace_id INTEGER NOT NULL,
+	user_id      INTEGER NOT NULL,
+	role         TEXT NOT NULL,
+	version      INTEGER NOT NULL DEFAULT 1,
+	created_at   TIMESTAMPTZ NOT NULL,
+	updated_at   TIMESTAMPTZ NOT NULL,
+	UNIQUE (workspace_id, user_id)
+);
+CREATE INDEX idx_workspace_members_user_id ON workspace_members (user_id);
+
+-- Everything from before workspaces moves into a default one, with every
+-- user as a member and the admins as its admins.
+INSERT INTO workspaces (id, name, created_at, updated_at) VALUES (1, 'Default', NOW(), NOW());
+SELECT setval('workspaces_id_seq', 1);
+INSERT INTO workspace_members (workspace_id, user_id, role, created_at, updated_at)
+	SELECT 1, id, CASE WHEN role = 'admin' THEN 'admin' ELSE 'member' END, NOW(), NOW() FROM users ORDER BY id;
+
+ALTER TABLE todos ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX idx_todos_workspace_id ON todos (workspace_id);
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS users (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	username   TEXT NOT NULL UNIQUE,
+	password   TEXT NOT NULL,
+	role       TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS todos (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	title          TEXT NOT NULL,
+	description    TEXT NOT NULL,
+	user_id        INTEGER NOT NULL,
+	completion_pct REAL NOT NULL DEFAULT 0,
+	created_at     DATETIME NOT NULL,
+	updated_at     DATETIME NOT NULL,
+	deleted_at     DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_todos_user_id ON todos (user_id);
+
+CREATE TABLE IF NOT EXISTS todo_items (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	title       TEXT NOT NULL,
+	description TEXT NOT NULL,
+	completed   BOOLEAN NOT NULL DEFAULT 0,
+	todo_id     INTEGER NOT NULL,
+	user_id     INTEGER NOT NULL,
+	created_at  DATETIME NOT NULL,
+	updated_at  DATETIME NOT NULL,
+	deleted_at  DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_todo_items_todo_id ON todo_items (todo_id);
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE todo_items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+CREATE INDEX idx_todos_deleted_at ON todos (deleted_at);
+CREATE INDEX idx_todo_items_deleted_at ON todo_items (deleted_at);
@@ -0,0 +1,2 @@
+ALTER TABLE todo_items ADD COLUMN position TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_todo_items_todo_position ON todo_items (todo_id, position);
@@ -0,0 +1,14 @@
+CREATE TABLE comments (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	todo_id    INTEGER NOT NULL,
+	item_id    INTEGER,
+	parent_id  INTEGER,
+	user_id    INTEGER NOT NULL,
+	body       TEXT NOT NULL,
+	version    INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	edited_at  DATETIME,
+	deleted_at DATETIME
+);
+CREATE INDEX idx_comments_todo_id ON comments (todo_id);
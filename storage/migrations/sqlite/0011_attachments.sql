@@ -0,0 +1,13 @@
+CREATE TABLE attachments (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	todo_id      INTEGER NOT NULL,
+	item_id      INTEGER NOT NULL,
+	user_id      INTEGER NOT NULL,
+	file_name    TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size         INTEGER NOT NULL,
+	sha256       TEXT NOT NULL,
+	storage_key  TEXT NOT NULL,
+	created_at   DATETIME NOT NULL
+);
+CREATE INDEX idx_attachments_item_id ON attachments (item_id);
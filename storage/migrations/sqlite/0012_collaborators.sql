@@ -0,0 +1,13 @@
+CREATE TABLE collaborators (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	todo_id     INTEGER NOT NULL,
+	user_id     INTEGER NOT NULL,
+	role        TEXT NOT NULL,
+	invited_by  INTEGER NOT NULL,
+	version     INTEGER NOT NULL DEFAULT 1,
+	created_at  DATETIME NOT NULL,
+	updated_at  DATETIME NOT NULL,
+	accepted_at DATETIME,
+	UNIQUE (todo_id, user_id)
+);
+CREATE INDEX idx_collaborators_user_id ON collaborators (user_id);
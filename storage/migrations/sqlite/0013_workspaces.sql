@@ -0,0 +1,27 @@
+CREATE TABLE workspaces (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	name       TEXT NOT NULL,
+	version    INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE TABLE workspace_members (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	workspace_id INTEGER NOT NULL,
+	user_id      INTEGER NOT NULL,
+	role         TEXT NOT NULL,
+	version      INTEGER NOT NULL DEFAULT 1,
+	created_at   DATETIME NOT NULL,
+	updated_at   DATETIME NOT NULL,
+	UNIQUE (workspace_id, user_id)
+);
+CREATE INDEX idx_workspace_members_user_id ON workspace_members (user_id);
+
+-- Everything from before workspaces moves into a default one, with every
+-- user as a member and the admins as its admins.
+INSERT INTO workspaces (id, name, created_at, updated_at) VALUES (1, 'Default', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
+INSERT INTO workspace_members (workspace_id, user_id, role, created_at, updated_at)
+	SELECT 1, id, CASE WHEN role = 'admin' THEN 'admin' ELSE 'member' END, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP FROM users ORDER BY id;
+
+ALTER TABLE todos ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX idx_todos_workspace_id ON todos (workspace_id);
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const pgUniqueViolation = "23505"
+
+var postgresDialect = dialect{
+	name:           "postgres",
+	numberedParams: true,
+	forUpdate:      " FOR UPDATE",
+	isUniqueViolation: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+	},
+}
+
+// OpenPostgres connects to the database at dsn and verifies the connection.
+// Several API replicas can share the same database; call Migrate before
+// serving requests so the schema is up to date.
+func OpenPostgres(dsn string, pool PoolConfig) (*DB, error) {
+	conn, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db := newDB(conn, postgresDialect, pool)
+
+	ctx, cancel := db.context()
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	isUniqueViolation: func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	},
+}
+
+// OpenSQLite opens (or creates) the database file at path. Call Migrate
+// before using it so the schema is in place.
+func OpenSQLite(path string, pool PoolConfig) (*DB, error) {
+	conn, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer; one connection avoids "database is
+	// locked" errors under concurrent requests.
+	pool.MaxOpenConns = 1
+	pool.MaxIdleConns = 1
+
+	return newDB(conn, sqliteDialect, pool), nil
+}
@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"todoapp/entity"
+)
+
+// newSQLiteDB opens a migrated database in a fresh file.
+func newSQLiteDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "todo.db"), PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func createUser(t *testing.T, users *UserStore, username string) *entity.User {
+	t.Helper()
+	user := &entity.User{Username: username, Password: "secret", Role: "user"}
+	if err := users.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func createTodo(t *testing.T, todos *TodoStore, userID int, title string) *entity.Todo {
+	t.Helper()
+	todo := &entity.Todo{Title: title, UserID: userID, WorkspaceID: entity.DefaultWorkspaceID}
+	if err := todos.Create(todo); err != nil {
+		t.Fatal(err)
+	}
+	return todo
+}
+
+func createItem(t *testing.T, items *TodoItemStore, todoID, userID int, parent *int) *entity.TodoItem {
+	t.Helper()
+	item := &entity.TodoItem{Title: "item", TodoID: todoID, UserID: userID, ParentItemID: parent}
+	if err := items.Create(item); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestMigrateFromScratch(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	migrations, err := loadMigrations(sqliteDialect.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied, latest int
+	if err := db.conn.QueryRow("SELECT COUNT(*), MAX(version) FROM schema_migrations").Scan(&applied, &latest); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) || latest != migrations[len(migrations)-1].version {
+		t.Errorf("%d migrations applied up to version %d, want all %d up to %d", applied, latest, len(migrations), migrations[len(migrations)-1].version)
+	}
+
+	// Running it again finds nothing to do.
+	if err := Migrate(db); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("%d migrations recorded after the second run, want %d", applied, len(migrations))
+	}
+
+	// The last migration leaves the default workspace in place.
+	workspace, err := NewWorkspaceStore(db).GetByID(entity.DefaultWorkspaceID)
+	if err != nil || workspace.Name != "Default" {
+		t.Errorf("default workspace = %+v, %v", workspace, err)
+	}
+}
+
+func TestTodoVersions(t *testing.T) {
+	db := newSQLiteDB(t)
+	user := createUser(t, NewUserStore(db), "alice")
+	todos := NewTodoStore(db)
+
+	todo := createTodo(t, todos, user.ID, "groceries")
+	if todo.ID == 0 || todo.Version != 1 {
+		t.Fatalf("created todo = %+v, want an ID and version 1", todo)
+	}
+	got, err := todos.GetByID(todo.ID)
+	if err != nil || got.Title != "groceries" || got.WorkspaceID != entity.DefaultWorkspaceID {
+		t.Fatalf("GetByID = %+v, %v", got, err)
+	}
+
+	got.Title = "more groceries"
+	if err := todos.Update(got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 {
+		t.Errorf("version after the update = %d, want 2", got.Version)
+	}
+
+	// todo still holds version 1.
+	todo.Title = "stale"
+	if err := todos.Update(todo); !errors.Is(err, entity.ErrVersionConflict) {
+		t.Errorf("update with a stale version: error = %v, want ErrVersionConflict", err)
+	}
+	// Version 0 skips the check.
+	todo.Version = 0
+	if err := todos.Update(todo); err != nil {
+		t.Errorf("update without a version: %v", err)
+	}
+
+	missing := &entity.Todo{ID: 99, Title: "missing", Version: 1}
+	if err := todos.Update(missing); !errors.Is(err, entity.ErrTodoNotFound) {
+		t.Errorf("update of a missing todo: error = %v, want ErrTodoNotFound", err)
+	}
+	if _, err := todos.GetByIDInWorkspace(todo.ID, 2); !errors.Is(err, entity.ErrTodoNotFound) {
+		t.Errorf("GetByIDInWorkspace in another workspace: error = %v, want ErrTodoNotFound", err)
+	}
+}
+
+func TestUniqueUsername(t *testing.T) {
+	db := newSQLiteDB(t)
+	users := NewUserStore(db)
+	createUser(t, users, "alice")
+	bob := createUser(t, users, "bob")
+
+	if err := users.Create(&entity.User{Username: "alice", Password: "secret", Role: "user"}); !errors.Is(err, entity.ErrUsernameExists) {
+		t.Errorf("create: error = %v, want ErrUsernameExists", err)
+	}
+	bob.Username = "alice"
+	if err := users.Update(bob); !errors.Is(err, entity.ErrUsernameExists) {
+		t.Errorf("update: error = %v, want ErrUsernameExists", err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	db := newSQLiteDB(t)
+	user := createUser(t, NewUserStore(db), "alice")
+	todos := NewTodoStore(db)
+	items := NewTodoItemStore(db)
+	todo := createTodo(t, todos, user.ID, "deleted")
+	kept := createTodo(t, todos, user.ID, "kept")
+	item := createItem(t, items, todo.ID, user.ID, nil)
+
+	if err := todos.Delete(todo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.Delete(todo.ID); !errors.Is(err, entity.ErrTodoNotFound) {
+		t.Errorf("second delete: error = %v, want ErrTodoNotFound", err)
+	}
+
+	if _, err := todos.GetByID(todo.ID); !errors.Is(err, entity.ErrTodoNotFound) {
+		t.Errorf("GetByID: error = %v, want ErrTodoNotFound", err)
+	}
+	deleted, err := todos.GetByIDWithDeleted(todo.ID)
+	if err != nil || deleted.DeletedAt == nil {
+		t.Errorf("GetByIDWithDeleted = %+v, %v; want the deleted todo", deleted, err)
+	}
+	if _, err := items.GetByID(item.ID); !errors.Is(err, entity.ErrTodoItemNotFound) {
+		t.Errorf("item of the deleted todo: error = %v, want ErrTodoItemNotFound", err)
+	}
+
+	ws := entity.DefaultWorkspaceID
+	lists := []struct {
+		name string
+		list func() ([]*entity.Todo, error)
+		want []int
+	}{
+		{"GetByWorkspaceID", func() ([]*entity.Todo, error) { return todos.GetByWorkspaceID(ws) }, []int{kept.ID}},
+		{"GetByWorkspaceIDWithDeleted", func() ([]*entity.Todo, error) { return todos.GetByWorkspaceIDWithDeleted(ws) }, []int{todo.ID, kept.ID}},
+		{"GetByUserID", func() ([]*entity.Todo, error) { return todos.GetByUserID(ws, user.ID) }, []int{kept.ID}},
+		{"GetByUserIDWithDeleted", func() ([]*entity.Todo, error) { return todos.GetByUserIDWithDeleted(ws, user.ID) }, []int{todo.ID, kept.ID}},
+	}
+	for _, tt := range lists {
+		got, err := tt.list()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if ids := todoIDs(got); !equalInts(ids, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, ids, tt.want)
+		}
+	}
+	if got, err := items.GetByTodoIDWithDeleted(todo.ID); err != nil || len(got) != 1 || got[0].DeletedAt == nil {
+		t.Errorf("GetByTodoIDWithDeleted = %+v, %v; want the deleted item", got, err)
+	}
+
+	if err := todos.Restore(todo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.Restore(todo.ID); !errors.Is(err, entity.ErrTodoNotFound) {
+		t.Errorf("restore of a live todo: error = %v, want ErrTodoNotFound", err)
+	}
+	if _, err := todos.GetByID(todo.ID); err != nil {
+		t.Errorf("GetByID after the restore: %v", err)
+	}
+}
+
+func TestUpdateCompletionPct(t *testing.T) {
+	db := newSQLiteDB(t)
+	user := createUser(t, NewUserStore(db), "alice")
+	todos := NewTodoStore(db)
+	items := NewTodoItemStore(db)
+	todo := createTodo(t, todos, user.ID, "todo")
+
+	// Only leaves count: the parent stands for its two sub-items.
+	parent := createItem(t, items, todo.ID, user.ID, nil)
+	done := createItem(t, items, todo.ID, user.ID, &parent.ID)
+	createItem(t, items, todo.ID, user.ID, &parent.ID)
+	createItem(t, items, todo.ID, user.ID, nil)
+
+	done.Completed = true
+	if err := items.Update(done); err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.UpdateCompletionPct(todo.ID, items); err != nil {
+		t.Fatal(err)
+	}
+	got, err := todos.GetByID(todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CompletionPct < 33.3 || got.CompletionPct > 33.4 {
+		t.Errorf("completion = %v, want 1 of 3 leaves", got.CompletionPct)
+	}
+
+	if err := items.Delete(done.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := todos.UpdateCompletionPct(todo.ID, items); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := todos.GetByID(todo.ID); got.CompletionPct != 0 {
+		t.Errorf("completion after deleting the completed item = %v, want 0", got.CompletionPct)
+	}
+}
+
+func TestUserDeletePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		wantErr error
+	}{
+		{"block", entity.UserDeleteBlock, entity.ErrUserHasTodos},
+		{"cascade", entity.UserDeleteCascade, nil},
+		{"reassign", entity.UserDeleteReassign, nil},
+		{"invalid", "archive", entity.ErrInvalidDeletePolicy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newSQLiteDB(t)
+			users := NewUserStore(db)
+			todos := NewTodoStore(db)
+			items := NewTodoItemStore(db)
+			alice := createUser(t, users, "alice")
+			bob := createUser(t, users, "bob")
+			todo := createTodo(t, todos, alice.ID, "todo")
+			item := createItem(t, items, todo.ID, alice.ID, nil)
+
+			err := users.Delete(alice.ID, entity.UserDeletePolicy{Action: tt.action, ReassignTo: bob.ID})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Delete: error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if _, err := users.GetByID(alice.ID); err != nil {
+					t.Errorf("user after the failed delete: %v", err)
+				}
+				return
+			}
+			if _, err := users.GetByID(alice.ID); !errors.Is(err, entity.ErrUserNotFound) {
+				t.Errorf("deleted user: error = %v, want ErrUserNotFound", err)
+			}
+
+			got, err := todos.GetByIDWithDeleted(todo.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch tt.action {
+			case entity.UserDeleteCascade:
+				if got.DeletedAt == nil {
+					t.Errorf("todo of the deleted user = %+v, want it deleted", got)
+				}
+			case entity.UserDeleteReassign:
+				if got.DeletedAt != nil || got.UserID != bob.ID {
+					t.Errorf("todo of the deleted user = %+v, want it live and owned by %d", got, bob.ID)
+				}
+				if got, err := items.GetByID(item.ID); err != nil || got.UserID != bob.ID {
+					t.Errorf("item of the deleted user = %+v, %v; want it owned by %d", got, err, bob.ID)
+				}
+			}
+		})
+	}
+}
+
+// Reassigning also hands over the tags, merging them by name, and takes the
+// new owner into the workspaces of the todos.
+func TestUserDeleteReassign(t *testing.T) {
+	db := newSQLiteDB(t)
+	users := NewUserStore(db)
+	todos := NewTodoStore(db)
+	tags := NewTagStore(db)
+	workspaces := NewWorkspaceStore(db)
+	alice := createUser(t, users, "alice")
+	bob := createUser(t, users, "bob")
+	createTodo(t, todos, alice.ID, "todo")
+	for _, tag := range []*entity.Tag{{UserID: alice.ID, Name: "home"}, {UserID: alice.ID, Name: "work"}, {UserID: bob.ID, Name: "home"}} {
+		if err := tags.Create(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := users.Delete(bob.ID, entity.UserDeletePolicy{Action: entity.UserDeleteReassign, ReassignTo: bob.ID}); !errors.Is(err, entity.ErrInvalidReassignTarget) {
+		t.Errorf("reassign to the deleted user: error = %v, want ErrInvalidReassignTarget", err)
+	}
+	if err := users.Delete(alice.ID, entity.UserDeletePolicy{Action: entity.UserDeleteReassign, ReassignTo: 99}); !errors.Is(err, entity.ErrInvalidReassignTarget) {
+		t.Errorf("reassign to a missing user: error = %v, want ErrInvalidReassignTarget", err)
+	}
+
+	if err := users.Delete(alice.ID, entity.UserDeletePolicy{Action: entity.UserDeleteReassign, ReassignTo: bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tags.GetByUserID(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tag := range got {
+		names = append(names, tag.Name)
+	}
+	if len(names) != 2 || names[0] != "home" || names[1] != "work" {
+		t.Errorf("tags of the new owner = %v, want [home work]", names)
+	}
+	if _, err := workspaces.GetMember(entity.DefaultWorkspaceID, bob.ID); err != nil {
+		t.Errorf("new owner in the todo's workspace: %v", err)
+	}
+}
+
+func TestGetByIDsWithDeleted(t *testing.T) {
+	db := newSQLiteDB(t)
+	user := createUser(t, NewUserStore(db), "alice")
+	todos := NewTodoStore(db)
+	items := NewTodoItemStore(db)
+	deleted := createTodo(t, todos, user.ID, "deleted")
+	live := createTodo(t, todos, user.ID, "live")
+	item := createItem(t, items, live.ID, user.ID, nil)
+	if err := todos.Delete(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := todos.GetByIDsWithDeleted([]int{live.ID, deleted.ID, 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := todoIDs(list)
+	sort.Ints(ids)
+	if want := []int{deleted.ID, live.ID}; !equalInts(ids, want) {
+		t.Errorf("todos = %v, want %v", ids, want)
+	}
+	if list, err := todos.GetByIDsWithDeleted(nil); err != nil || len(list) != 0 {
+		t.Errorf("no IDs: got %v, %v; want nothing", list, err)
+	}
+	if list, err := items.GetByIDsWithDeleted([]int{item.ID, 99}); err != nil || len(list) != 1 || list[0].ID != item.ID {
+		t.Errorf("items = %+v, %v; want item %d", list, err, item.ID)
+	}
+}
+
+func todoIDs(todos []*entity.Todo) []int {
+	ids := make([]int, len(todos))
+	for i, todo := range todos {
+		ids[i] = todo.ID
+	}
+	return ids
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"todoapp/entity"
+)
+
+const tagColumns = "id, user_id, name, version, created_at, updated_at"
+
+type TagStore struct {
+	db *DB
+}
+
+var _ entity.TagStore = (*TagStore)(nil)
+
+func NewTagStore(db *DB) *TagStore {
+	return &TagStore{db: db}
+}
+
+// Todos and items keep their tag names in a single column, joined by commas,
+// which tag names can't contain.
+
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+func splitTags(column string) []string {
+	if column == "" {
+		return nil
+	}
+	return strings.Split(column, ",")
+}
+
+func scanTag(row scanner) (*entity.Tag, error) {
+	tag := &entity.Tag{}
+	if err := row.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.Version, &tag.CreatedAt, &tag.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (s *TagStore) getTag(query string, args ...interface{}) (*entity.Tag, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	tag, err := scanTag(s.db.queryRow(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrTagNotFound
+	}
+	return tag, err
+}
+
+func (s *TagStore) Create(tag *entity.Tag) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO tags (user_id, name, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		tag.UserID, tag.Name, now, now,
+	)
+	if err != nil {
+		if s.db.dialect.isUniqueViolation(err) {
+			return entity.ErrTagExists
+		}
+		return err
+	}
+
+	tag.ID = id
+	tag.CreatedAt = now
+	tag.UpdatedAt = now
+	tag.Version = 1
+	return nil
+}
+
+func (s *TagStore) GetByID(id int) (*entity.Tag, error) {
+	return s.getTag("SELECT "+tagColumns+" FROM tags WHERE id = ?", id)
+}
+
+func (s *TagStore) GetByName(userID int, name string) (*entity.Tag, error) {
+	return s.getTag("SELECT "+tagColumns+" FROM tags WHERE user_id = ? AND name = ?", userID, name)
+}
+
+func (s *TagStore) GetByUserID(userID int) ([]*entity.Tag, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, "SELECT "+tagColumns+" FROM tags WHERE user_id = ? ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]*entity.Tag, 0)
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// Update renames a tag. The owner can't be changed.
+func (s *TagStore) Update(tag *entity.Tag) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	err := s.db.queryRow(ctx,
+		"UPDATE tags SET name = ?, updated_at = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?) RETURNING "+tagColumns,
+		tag.Name, now, tag.ID, tag.Version, tag.Version,
+	).Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.Version, &tag.CreatedAt, &tag.UpdatedAt)
+	if err == sql.ErrNoRows {
+		if _, err := s.GetByID(tag.ID); err != nil {
+			return err
+		}
+		return entity.ErrVersionConflict
+	}
+	if err != nil {
+		if s.db.dialect.isUniqueViolation(err) {
+			return entity.ErrTagExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *TagStore) Delete(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "DELETE FROM tags WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrTagNotFound
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"todoapp/entity"
+)
+
+const todoItemColumns = "id, title, description, completed, position, todo_id, parent_item_id, user_id, version, created_at, updated_at, deleted_at, start_at, due_at, all_day, priority, tags, rrule, series_id, occurrence"
+
+type TodoItemStore struct {
+	db *DB
+}
+
+var _ entity.TodoItemStore = (*TodoItemStore)(nil)
+
+func NewTodoItemStore(db *DB) *TodoItemStore {
+	return &TodoItemStore{db: db}
+}
+
+func scanTodoItem(row scanner) (*entity.TodoItem, error) {
+	item := &entity.TodoItem{}
+	var parentItemID, seriesID sql.NullInt64
+	var deletedAt, startAt, dueAt, occurrence sql.NullTime
+	var tags string
+	if err := row.Scan(&item.ID, &item.Title, &item.Description, &item.Completed, &item.Position, &item.TodoID, &parentItemID, &item.UserID, &item.Version, &item.CreatedAt, &item.UpdatedAt, &deletedAt, &startAt, &dueAt, &item.AllDay, &item.Priority, &tags, &item.RRule, &seriesID, &occurrence); err != nil {
+		return nil, err
+	}
+	item.Tags = splitTags(tags)
+	if parentItemID.Valid {
+		parent := int(parentItemID.Int64)
+		item.ParentItemID = &parent
+	}
+	if deletedAt.Valid {
+		item.DeletedAt = &deletedAt.Time
+	}
+	item.StartAt, item.DueAt = utcTime(startAt), utcTime(dueAt)
+	item.SeriesID, item.Occurrence = nullInt(seriesID), utcTime(occurrence)
+	return item, nil
+}
+
+func (s *TodoItemStore) getItem(query string, args ...interface{}) (*entity.TodoItem, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	item, err := scanTodoItem(s.db.queryRow(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrTodoItemNotFound
+	}
+	return item, err
+}
+
+func (s *TodoItemStore) queryItems(query string, args ...interface{}) ([]*entity.TodoItem, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*entity.TodoItem
+	for rows.Next() {
+		item, err := scanTodoItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Create appends the item after its siblings unless it already has a
+// position. Deleted items count, so a restored item doesn't share its rank.
+func (s *TodoItemStore) Create(item *entity.TodoItem) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	if item.Position == "" {
+		parent := 0
+		if item.ParentItemID != nil {
+			parent = *item.ParentItemID
+		}
+		var last string
+		if err := s.db.queryRow(ctx, "SELECT COALESCE(MAX(position), '') FROM todo_items WHERE todo_id = ? AND COALESCE(parent_item_id, 0) = ?", item.TodoID, parent).Scan(&last); err != nil {
+			return err
+		}
+		item.Position = entity.PositionAfter(last)
+	}
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO todo_items (title, description, completed, position, todo_id, parent_item_id, user_id, start_at, due_at, all_day, priority, tags, rrule, series_id, occurrence, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		item.Title, item.Description, item.Completed, item.Position, item.TodoID, item.ParentItemID, item.UserID, item.StartAt, item.DueAt, item.AllDay, item.Priority, joinTags(item.Tags), item.RRule, item.SeriesID, item.Occurrence, now, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	item.ID = id
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	item.DeletedAt = nil
+	item.Version = 1
+	return nil
+}
+
+func (s *TodoItemStore) GetByID(id int) (*entity.TodoItem, error) {
+	return s.getItem("SELECT "+todoItemColumns+" FROM todo_items WHERE id = ? AND deleted_at IS NULL", id)
+}
+
+func (s *TodoItemStore) GetByIDWithDeleted(id int) (*entity.TodoItem, error) {
+	return s.getItem("SELECT "+todoItemColumns+" FROM todo_items WHERE id = ?", id)
+}
+
+func (s *TodoItemStore) GetByIDsWithDeleted(ids []int) ([]*entity.TodoItem, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(ids)
+	return s.queryItems("SELECT "+todoItemColumns+" FROM todo_items WHERE id IN ("+placeholders+")", args...)
+}
+
+func (s *TodoItemStore) GetByTodoID(todoID int) ([]*entity.TodoItem, error) {
+	return s.queryItems("SELECT "+todoItemColumns+" FROM todo_items WHERE todo_id = ? AND deleted_at IS NULL ORDER BY position, id", todoID)
+}
+
+func (s *TodoItemStore) GetByTodoIDWithDeleted(todoID int) ([]*entity.TodoItem, error) {
+	return s.queryItems("SELECT "+todoItemColumns+" FROM todo_items WHERE todo_id = ? ORDER BY position, id", todoID)
+}
+
+func (s *TodoItemStore) GetDeletedBefore(before time.Time) ([]*entity.TodoItem, error) {
+	return s.queryItems("SELECT "+todoItemColumns+" FROM todo_items WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY id", before)
+}
+
+func (s *TodoItemStore) GetRecurring() ([]*entity.TodoItem, error) {
+	return s.queryItems("SELECT " + todoItemColumns + " FROM todo_items WHERE rrule <> '' AND deleted_at IS NULL ORDER BY id")
+}
+
+func (s *TodoItemStore) Update(item *entity.TodoItem) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	err := s.db.queryRow(ctx,
+		"UPDATE todo_items SET title = ?, description = ?, completed = ?, position = ?, todo_id = ?, parent_item_id = ?, user_id = ?, start_at = ?, due_at = ?, all_day = ?, priority = ?, tags = ?, rrule = ?, series_id = ?, occurrence = ?, updated_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?) RETURNING version",
+		item.Title, item.Description, item.Completed, item.Position, item.TodoID, item.ParentItemID, item.UserID, item.StartAt, item.DueAt, item.AllDay, item.Priority, joinTags(item.Tags), item.RRule, item.SeriesID, item.Occurrence, now, item.ID, item.Version, item.Version,
+	).Scan(&item.Version)
+	if err == sql.ErrNoRows {
+		if _, err := s.GetByID(item.ID); err != nil {
+			return err
+		}
+		return entity.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	item.UpdatedAt = now
+	return nil
+}
+
+func (s *TodoItemStore) Delete(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "UPDATE todo_items SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrTodoItemNotFound
+	}
+	return nil
+}
+
+func (s *TodoItemStore) Restore(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "UPDATE todo_items SET deleted_at = NULL, updated_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrTodoItemNotFound
+	}
+	return nil
+}
+
+func (s *TodoItemStore) Purge(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "DELETE FROM todo_items WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrTodoItemNotFound
+	}
+	return nil
+}
+
+// SetTags replaces the tags of an item, whether or not it is deleted.
+func (s *TodoItemStore) SetTags(id int, tags []string) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "UPDATE todo_items SET tags = ?, updated_at = ?, version = version + 1 WHERE id = ?", joinTags(tags), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrTodoItemNotFound
+	}
+	return nil
+}
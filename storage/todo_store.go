@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"todoapp/entity"
+)
+
+const todoColumns = "id, title, description, user_id, workspace_id, completion_pct, version, created_at, updated_at, deleted_at, start_at, due_at, all_day, priority, tags, rrule, series_id, occurrence"
+
+type TodoStore struct {
+	db *DB
+}
+
+var _ entity.TodoStore = (*TodoStore)(nil)
+
+func NewTodoStore(db *DB) *TodoStore {
+	return &TodoStore{db: db}
+}
+
+func scanTodo(row scanner) (*entity.Todo, error) {
+	todo := &entity.Todo{}
+	var deletedAt, startAt, dueAt, occurrence sql.NullTime
+	var seriesID sql.NullInt64
+	var tags string
+	if err := row.Scan(&todo.ID, &todo.Title, &todo.Description, &todo.UserID, &todo.WorkspaceID, &todo.CompletionPct, &todo.Version, &todo.CreatedAt, &todo.UpdatedAt, &deletedAt, &startAt, &dueAt, &todo.AllDay, &todo.Priority, &tags, &todo.RRule, &seriesID, &occurrence); err != nil {
+		return nil, err
+	}
+	todo.Tags = splitTags(tags)
+	if deletedAt.Valid {
+		todo.DeletedAt = &deletedAt.Time
+	}
+	todo.StartAt, todo.DueAt = utcTime(startAt), utcTime(dueAt)
+	todo.SeriesID, todo.Occurrence = nullInt(seriesID), utcTime(occurrence)
+	return todo, nil
+}
+
+func nullInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	i := int(n.Int64)
+	return &i
+}
+
+// utcTime returns a nullable column as a time in UTC. All-day dates are
+// stored as midnight UTC and must not be shifted to the session's zone.
+func utcTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+func (s *TodoStore) getTodo(query string, args ...interface{}) (*entity.Todo, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	todo, err := scanTodo(s.db.queryRow(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrTodoNotFound
+	}
+	return todo, err
+}
+
+func (s *TodoStore) queryTodos(query string, args ...interface{}) ([]*entity.Todo, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var todos []*entity.Todo
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+	return todos, rows.Err()
+}
+
+func (s *TodoStore) Create(todo *entity.Todo) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO todos (title, description, user_id, workspace_id, completion_pct, start_at, due_at, all_day, priority, tags, rrule, series_id, occurrence, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		todo.Title, todo.Description, todo.UserID, todo.WorkspaceID, todo.CompletionPct, todo.StartAt, todo.DueAt, todo.AllDay, todo.Priority, joinTags(todo.Tags), todo.RRule, todo.SeriesID, todo.Occurrence, now, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	todo.ID = id
+	todo.CreatedAt = now
+	todo.UpdatedAt = now
+	todo.DeletedAt = nil
+	todo.Version = 1
+	return nil
+}
+
+func (s *TodoStore) GetByID(id int) (*entity.Todo, error) {
+	return s.getTodo("SELECT "+todoColumns+" FROM todos WHERE id = ? AND deleted_at IS NULL", id)
+}
+
+func (s *TodoStore) GetByIDWithDeleted(id int) (*entity.Todo, error) {
+	return s.getTodo("SELECT "+todoColumns+" FROM todos WHERE id = ?", id)
+}
+
+func (s *TodoStore) GetByIDsWithDeleted(ids []int) ([]*entity.Todo, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inList(ids)
+	return s.queryTodos("SELECT "+todoColumns+" FROM todos WHERE id IN ("+placeholders+")", args...)
+}
+
+func (s *TodoStore) GetByIDInWorkspace(id, workspaceID int) (*entity.Todo, error) {
+	return s.getTodo("SELECT "+todoColumns+" FROM todos WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL", id, workspaceID)
+}
+
+func (s *TodoStore) GetByIDInWorkspaceWithDeleted(id, workspaceID int) (*entity.Todo, error) {
+	return s.getTodo("SELECT "+todoColumns+" FROM todos WHERE id = ? AND workspace_id = ?", id, workspaceID)
+}
+
+func (s *TodoStore) GetByWorkspaceID(workspaceID int) ([]*entity.Todo, error) {
+	return s.queryTodos("SELECT "+todoColumns+" FROM todos WHERE workspace_id = ? AND deleted_at IS NULL ORDER BY id", workspaceID)
+}
+
+func (s *TodoStore) GetByWorkspaceIDWithDeleted(workspaceID int) ([]*entity.Todo, error) {
+	return s.queryTodos("SELECT "+todoColumns+" FROM todos WHERE workspace_id = ? ORDER BY id", workspaceID)
+}
+
+func (s *TodoStore) GetByUserID(workspaceID, userID int) ([]*entity.Todo, error) {
+	return s.queryTodos("SELECT "+todoColumns+" FROM todos WHERE workspace_id = ? AND user_id = ? AND deleted_at IS NULL ORDER BY id", workspaceID, userID)
+}
+
+func (s *TodoStore) GetByUserIDWithDeleted(workspaceID, userID int) ([]*entity.Todo, error) {
+	return s.queryTodos("SELECT "+todoColumns+" FROM todos WHERE workspace_id = ? AND user_id = ? ORDER BY id", workspaceID, userID)
+}
+
+func (s *TodoStore) GetDeletedBefore(before time.Time) ([]*entity.Todo, error) {
+	return s.queryTodos("SELECT "+todoColumns+" FROM todos WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY id", before)
+}
+
+func (s *TodoStore) GetRecurring() ([]*entity.Todo, error) {
+	return s.queryTodos("SELECT " + todoColumns + " FROM todos WHERE rrule <> '' AND deleted_at IS NULL ORDER BY id")
+}
+
+// Update bumps the version in the same statement that checks it, so of two
+// writers holding the same Version only the first one succeeds.
+func (s *TodoStore) Update(todo *entity.Todo) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	err := s.db.queryRow(ctx,
+		"UPDATE todos SET title = ?, description = ?, user_id = ?, start_at = ?, due_at = ?, all_day = ?, priority = ?, tags = ?, rrule = ?, series_id = ?, occurrence = ?, updated_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?) RETURNING version, completion_pct",
+		todo.Title, todo.Description, todo.UserID, todo.StartAt, todo.DueAt, todo.AllDay, todo.Priority, joinTags(todo.Tags), todo.RRule, todo.SeriesID, todo.Occurrence, now, todo.ID, todo.Version, todo.Version,
+	).Scan(&todo.Version, &todo.CompletionPct)
+	if err == sql.ErrNoRows {
+		if _, err := s.GetByID(todo.ID); err != nil {
+			return err
+		}
+		return entity.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	todo.UpdatedAt = now
+	return nil
+}
+
+// Delete soft-deletes the todo and, in the same transaction, its live items,
+// giving them the todo's DeletedAt (see entity/lifecycle.go).
+func (s *TodoStore) Delete(id int) error {
+	return s.db.inTx(func(db *DB) error {
+		n, err := deleteTodos(db, "id = ?", id)
+		if err == nil && n == 0 {
+			return entity.ErrTodoNotFound
+		}
+		return err
+	})
+}
+
+// deleteTodos soft-deletes the live todos matching where, and their items.
+// It returns the number of todos deleted.
+func deleteTodos(db *DB, where string, args ...interface{}) (int64, error) {
+	ctx, cancel := db.context()
+	defer cancel()
+
+	now := time.Now()
+	_, err := db.exec(ctx,
+		"UPDATE todo_items SET deleted_at = ?, version = version + 1 WHERE deleted_at IS NULL AND todo_id IN (SELECT id FROM todos WHERE deleted_at IS NULL AND "+where+")",
+		append([]interface{}{now}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.exec(ctx, "UPDATE todos SET deleted_at = ?, version = version + 1 WHERE deleted_at IS NULL AND "+where,
+		append([]interface{}{now}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *TodoStore) Restore(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "UPDATE todos SET deleted_at = NULL, updated_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrTodoNotFound
+	}
+	return nil
+}
+
+func (s *TodoStore) Purge(id int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "DELETE FROM todos WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrTodoNotFound
+	}
+	return nil
+}
+
+// SetTags replaces the tags of a todo, whether or not it is deleted.
+func (s *TodoStore) SetTags(id int, tags []string) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "UPDATE todos SET tags = ?, updated_at = ?, version = version + 1 WHERE id = ?", joinTags(tags), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrTodoNotFound
+	}
+	return nil
+}
+
+// UpdateCompletionPct counts the items with SQL on the store's own connection,
+// so inside a UnitOfWork it sees the transaction's uncommitted item changes.
+// The store argument is only there to satisfy entity.TodoStore.
+func (s *TodoStore) UpdateCompletionPct(todoID int, _ entity.TodoItemStore) error {
+	// Lock the todo row first so that concurrent transactions recomputing the
+	// same todo see each other's committed item changes.
+	if _, err := s.getTodo("SELECT "+todoColumns+" FROM todos WHERE id = ?"+s.db.dialect.forUpdate, todoID); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	// Only leaves count; see entity/item_tree.go.
+	var total, completed int
+	err := s.db.queryRow(ctx,
+		"SELECT COUNT(*), COUNT(CASE WHEN completed THEN 1 END) FROM todo_items i WHERE todo_id = ? AND deleted_at IS NULL"+
+			" AND NOT EXISTS (SELECT 1 FROM todo_items c WHERE c.parent_item_id = i.id AND c.deleted_at IS NULL)",
+		todoID,
+	).Scan(&total, &completed)
+	if err != nil {
+		return err
+	}
+
+	if total == 0 {
+		_, err := s.db.exec(ctx, "UPDATE todos SET completion_pct = 0 WHERE id = ?", todoID)
+		return err
+	}
+
+	pct := float64(completed) / float64(total) * 100
+	_, err = s.db.exec(ctx, "UPDATE todos SET completion_pct = ?, updated_at = ? WHERE id = ?", pct, time.Now(), todoID)
+	return err
+}
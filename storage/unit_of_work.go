@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"todoapp/entity"
+)
+
+// UnitOfWork runs changes to any of the stores in this package inside a
+// single database transaction.
+type UnitOfWork struct {
+	db *DB
+}
+
+var _ entity.UnitOfWork = (*UnitOfWork)(nil)
+
+func NewUnitOfWork(db *DB) *UnitOfWork {
+	return &UnitOfWork{db: db}
+}
+
+type sqlTx struct {
+	users         *UserStore
+	todos         *TodoStore
+	items         *TodoItemStore
+	tags          *TagStore
+	comments      *CommentStore
+	attachments   *AttachmentStore
+	collaborators *CollaboratorStore
+	workspaces    *WorkspaceStore
+}
+
+func (tx *sqlTx) Users() entity.UserStore {
+	return tx.users
+}
+
+func (tx *sqlTx) Todos() entity.TodoStore {
+	return tx.todos
+}
+
+func (tx *sqlTx) Items() entity.TodoItemStore {
+	return tx.items
+}
+
+func (tx *sqlTx) Tags() entity.TagStore {
+	return tx.tags
+}
+
+func (tx *sqlTx) Comments() entity.CommentStore {
+	return tx.comments
+}
+
+func (tx *sqlTx) Attachments() entity.AttachmentStore {
+	return tx.attachments
+}
+
+func (tx *sqlTx) Collaborators() entity.CollaboratorStore {
+	return tx.collaborators
+}
+
+func (tx *sqlTx) Workspaces() entity.WorkspaceStore {
+	return tx.workspaces
+}
+
+func (u *UnitOfWork) Do(fn func(tx entity.Tx) error) error {
+	return u.db.inTx(func(txDB *DB) error {
+		return fn(&sqlTx{
+			users:         NewUserStore(txDB),
+			todos:         NewTodoStore(txDB),
+			items:         NewTodoItemStore(txDB),
+			tags:          NewTagStore(txDB),
+			comments:      NewCommentStore(txDB),
+			attachments:   NewAttachmentStore(txDB),
+			collaborators: NewCollaboratorStore(txDB),
+			workspaces:    NewWorkspaceStore(txDB),
+		})
+	})
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"todoapp/entity"
+)
+
+const userColumns = "id, username, password, role, time_zone, version, created_at, updated_at"
+
+type UserStore struct {
+	db *DB
+}
+
+var _ entity.UserStore = (*UserStore)(nil)
+
+func NewUserStore(db *DB) *UserStore {
+	return &UserStore{db: db}
+}
+
+func scanUser(row scanner) (*entity.User, error) {
+	user := &entity.User{}
+	if err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.TimeZone, &user.Version, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserStore) Create(user *entity.User) error {
+	if err := user.SetPassword(user.Password); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO users (username, password, role, time_zone, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		user.Username, user.Password, user.Role, user.TimeZone, now, now,
+	)
+	if err != nil {
+		if s.db.dialect.isUniqueViolation(err) {
+			return entity.ErrUsernameExists
+		}
+		return err
+	}
+
+	user.ID = id
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.Version = 1
+	return nil
+}
+
+func (s *UserStore) GetByID(id int) (*entity.User, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	user, err := scanUser(s.db.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrUserNotFound
+	}
+	return user, err
+}
+
+func (s *UserStore) GetByUsername(username string) (*entity.User, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	user, err := scanUser(s.db.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE username = ?", username))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrUserNotFound
+	}
+	return user, err
+}
+
+func (s *UserStore) GetAll() ([]*entity.User, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, "SELECT "+userColumns+" FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*entity.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *UserStore) Update(user *entity.User) error {
+	existing, err := s.GetByID(user.ID)
+	if err != nil {
+		return err
+	}
+
+	password := existing.Password
+	if user.Password != "" {
+		if err := user.SetPassword(user.Password); err != nil {
+			return err
+		}
+		password = user.Password
+	}
+
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	err = s.db.queryRow(ctx,
+		"UPDATE users SET username = ?, password = ?, role = ?, time_zone = ?, updated_at = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?) RETURNING version",
+		user.Username, password, user.Role, user.TimeZone, now, user.ID, user.Version, user.Version,
+	).Scan(&user.Version)
+	if err == sql.ErrNoRows {
+		if _, err := s.GetByID(user.ID); err != nil {
+			return err
+		}
+		return entity.ErrVersionConflict
+	}
+	if err != nil {
+		if s.db.dialect.isUniqueViolation(err) {
+			return entity.ErrUsernameExists
+		}
+		return err
+	}
+
+	user.Password = password
+	user.UpdatedAt = now
+	return nil
+}
+
+// Delete removes the user and applies policy to their todos in the same
+// transaction (see entity/lifecycle.go).
+func (s *UserStore) Delete(id int, policy entity.UserDeletePolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	return s.db.inTx(func(db *DB) error {
+		ctx, cancel := db.context()
+		defer cancel()
+
+		// Lock the user row so the todos can't change hands underneath us.
+		var exists int
+		err := db.queryRow(ctx, "SELECT 1 FROM users WHERE id = ?"+db.dialect.forUpdate, id).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return entity.ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		switch policy.Action {
+		case entity.UserDeleteBlock:
+			var live int
+			if err := db.queryRow(ctx, "SELECT COUNT(*) FROM todos WHERE user_id = ? AND deleted_at IS NULL", id).Scan(&live); err != nil {
+				return err
+			}
+			if live > 0 {
+				return entity.ErrUserHasTodos
+			}
+
+		case entity.UserDeleteCascade:
+			if _, err := deleteTodos(db, "user_id = ?", id); err != nil {
+				return err
+			}
+
+		case entity.UserDeleteReassign:
+			err := db.queryRow(ctx, "SELECT 1 FROM users WHERE id = ?", policy.ReassignTo).Scan(&exists)
+			if err == sql.ErrNoRows || policy.ReassignTo == id {
+				return entity.ErrInvalidReassignTarget
+			}
+			if err != nil {
+				return err
+			}
+
+			// The new owner doesn't need a share of their own todos.
+			if _, err := db.exec(ctx, "DELETE FROM collaborators WHERE user_id = ? AND todo_id IN (SELECT id FROM todos WHERE user_id = ?)", policy.ReassignTo, id); err != nil {
+				return err
+			}
+			now := time.Now()
+			// The new owner joins the workspaces of the todos as a member,
+			// unless they are in them already.
+			if _, err := db.exec(ctx,
+				"INSERT INTO workspace_members (workspace_id, user_id, role, created_at, updated_at)"+
+					" SELECT DISTINCT workspace_id, ?, ?, ?, ? FROM todos WHERE user_id = ?"+
+					" AND workspace_id IN (SELECT id FROM workspaces)"+
+					" AND workspace_id NOT IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)",
+				policy.ReassignTo, entity.WorkspaceRoleMember, now, now, id, policy.ReassignTo,
+			); err != nil {
+				return err
+			}
+			if _, err := db.exec(ctx, "UPDATE todos SET user_id = ?, updated_at = ?, version = version + 1 WHERE user_id = ?", policy.ReassignTo, now, id); err != nil {
+				return err
+			}
+			if _, err := db.exec(ctx, "UPDATE todo_items SET user_id = ?, updated_at = ?, version = version + 1 WHERE user_id = ?", policy.ReassignTo, now, id); err != nil {
+				return err
+			}
+			// The tags go with the todos, except those the new owner already
+			// has by name; the records keep carrying the name either way.
+			if _, err := db.exec(ctx, "UPDATE tags SET user_id = ?, updated_at = ?, version = version + 1 WHERE user_id = ? AND name NOT IN (SELECT name FROM tags WHERE user_id = ?)", policy.ReassignTo, now, id, policy.ReassignTo); err != nil {
+				return err
+			}
+		}
+
+		if _, err := db.exec(ctx, "DELETE FROM tags WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		if _, err := db.exec(ctx, "DELETE FROM collaborators WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		if _, err := db.exec(ctx, "DELETE FROM workspace_members WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		_, err = db.exec(ctx, "DELETE FROM users WHERE id = ?", id)
+		return err
+	})
+}
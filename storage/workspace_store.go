@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"todoapp/entity"
+)
+
+const (
+	workspaceColumns = "id, name, version, created_at, updated_at"
+	memberColumns    = "id, workspace_id, user_id, role, version, created_at, updated_at"
+)
+
+type WorkspaceStore struct {
+	db *DB
+}
+
+var _ entity.WorkspaceStore = (*WorkspaceStore)(nil)
+
+func NewWorkspaceStore(db *DB) *WorkspaceStore {
+	return &WorkspaceStore{db: db}
+}
+
+func scanWorkspace(row scanner) (*entity.Workspace, error) {
+	workspace := &entity.Workspace{}
+	if err := row.Scan(&workspace.ID, &workspace.Name, &workspace.Version, &workspace.CreatedAt, &workspace.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+func scanMember(row scanner) (*entity.WorkspaceMember, error) {
+	member := &entity.WorkspaceMember{}
+	if err := row.Scan(&member.ID, &member.WorkspaceID, &member.UserID, &member.Role, &member.Version, &member.CreatedAt, &member.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (s *WorkspaceStore) listWorkspaces(query string, args ...interface{}) ([]*entity.Workspace, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := make([]*entity.Workspace, 0)
+	for rows.Next() {
+		workspace, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+func (s *WorkspaceStore) Create(workspace *entity.Workspace) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO workspaces (name, created_at, updated_at) VALUES (?, ?, ?)",
+		workspace.Name, now, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	workspace.ID = id
+	workspace.CreatedAt = now
+	workspace.UpdatedAt = now
+	workspace.Version = 1
+	return nil
+}
+
+func (s *WorkspaceStore) GetByID(id int) (*entity.Workspace, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	workspace, err := scanWorkspace(s.db.queryRow(ctx, "SELECT "+workspaceColumns+" FROM workspaces WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrWorkspaceNotFound
+	}
+	return workspace, err
+}
+
+func (s *WorkspaceStore) GetAll() ([]*entity.Workspace, error) {
+	return s.listWorkspaces("SELECT " + workspaceColumns + " FROM workspaces ORDER BY id")
+}
+
+func (s *WorkspaceStore) GetByUserID(userID int) ([]*entity.Workspace, error) {
+	return s.listWorkspaces("SELECT "+workspaceColumns+" FROM workspaces WHERE id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?) ORDER BY id", userID)
+}
+
+// Update saves the name of a workspace.
+func (s *WorkspaceStore) Update(workspace *entity.Workspace) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	updated, err := scanWorkspace(s.db.queryRow(ctx,
+		"UPDATE workspaces SET name = ?, updated_at = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?) RETURNING "+workspaceColumns,
+		workspace.Name, time.Now(), workspace.ID, workspace.Version, workspace.Version,
+	))
+	if err == sql.ErrNoRows {
+		if _, err := s.GetByID(workspace.ID); err != nil {
+			return err
+		}
+		return entity.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	*workspace = *updated
+	return nil
+}
+
+// Delete removes the workspace and its members in one transaction.
+func (s *WorkspaceStore) Delete(id int) error {
+	return s.db.inTx(func(db *DB) error {
+		ctx, cancel := db.context()
+		defer cancel()
+
+		if _, err := db.exec(ctx, "DELETE FROM workspace_members WHERE workspace_id = ?", id); err != nil {
+			return err
+		}
+		res, err := db.exec(ctx, "DELETE FROM workspaces WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return entity.ErrWorkspaceNotFound
+		}
+		return nil
+	})
+}
+
+func (s *WorkspaceStore) AddMember(member *entity.WorkspaceMember) error {
+	if _, err := s.GetByID(member.WorkspaceID); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	now := time.Now()
+	id, err := s.db.insert(ctx,
+		"INSERT INTO workspace_members (workspace_id, user_id, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		member.WorkspaceID, member.UserID, member.Role, now, now,
+	)
+	if err != nil {
+		if s.db.dialect.isUniqueViolation(err) {
+			return entity.ErrMemberExists
+		}
+		return err
+	}
+
+	member.ID = id
+	member.CreatedAt = now
+	member.UpdatedAt = now
+	member.Version = 1
+	return nil
+}
+
+func (s *WorkspaceStore) GetMember(workspaceID, userID int) (*entity.WorkspaceMember, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	member, err := scanMember(s.db.queryRow(ctx, "SELECT "+memberColumns+" FROM workspace_members WHERE workspace_id = ? AND user_id = ?", workspaceID, userID))
+	if err == sql.ErrNoRows {
+		return nil, entity.ErrMemberNotFound
+	}
+	return member, err
+}
+
+func (s *WorkspaceStore) GetMembers(workspaceID int) ([]*entity.WorkspaceMember, error) {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	rows, err := s.db.query(ctx, "SELECT "+memberColumns+" FROM workspace_members WHERE workspace_id = ? ORDER BY id", workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]*entity.WorkspaceMember, 0)
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// UpdateMember saves the role of a member.
+func (s *WorkspaceStore) UpdateMember(member *entity.WorkspaceMember) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	updated, err := scanMember(s.db.queryRow(ctx,
+		"UPDATE workspace_members SET role = ?, updated_at = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?) RETURNING "+memberColumns,
+		member.Role, time.Now(), member.ID, member.Version, member.Version,
+	))
+	if err == sql.ErrNoRows {
+		var exists int
+		if err := s.db.queryRow(ctx, "SELECT 1 FROM workspace_members WHERE id = ?", member.ID).Scan(&exists); err == sql.ErrNoRows {
+			return entity.ErrMemberNotFound
+		}
+		return entity.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	*member = *updated
+	return nil
+}
+
+func (s *WorkspaceStore) RemoveMember(workspaceID, userID int) error {
+	ctx, cancel := s.db.context()
+	defer cancel()
+
+	res, err := s.db.exec(ctx, "DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?", workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.ErrMemberNotFound
+	}
+	return nil
+}